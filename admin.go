@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// TransacaoExportada representa uma linha do export NDJSON de transações
+type TransacaoExportada struct {
+	ID          int64     `json:"id"`
+	ClienteID   int       `json:"cliente_id"`
+	Valor       Centavos  `json:"valor"`
+	Tipo        string    `json:"tipo"`
+	Descricao   string    `json:"descricao"`
+	Categoria   *string   `json:"categoria,omitempty"`
+	RealizadaEm time.Time `json:"realizada_em"`
+}
+
+// adminAuth protege as rotas administrativas com as credenciais de rbac.go:
+// ADMIN_TOKEN, ADMIN_CHAVES ou um JWT. Sem nenhuma configurada as rotas
+// ficam desabilitadas. O papel exigido pelo método fica no adminAuth; as
+// rotas que exigem mais usam requireRole.
+func adminAuth(c fiber.Ctx) error {
+	if getEnv("ADMIN_TOKEN", "") == "" && len(*adminKeys.Load()) == 0 && getEnv("ADMIN_JWT_SEGREDO", "") == "" {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+
+	provided, found := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	current := roleNone
+	if found {
+		current = credentialRole(c.UserContext(), provided)
+	}
+	if current == roleNone {
+		log.Printf("Admin access denied: %s %s with invalid credentials", c.Method(), c.Path())
+		return c.SendStatus(fiber.StatusUnauthorized)
+	}
+	if required := methodRole(c.Method()); current < required {
+		return denyRole(c, current, required)
+	}
+	c.Locals(roleKey{}, current)
+	return c.Next()
+}
+
+// handleReloadConfig relê CONFIG_FILE e reaplica as configurações
+// recarregáveis nesta instância, como o SIGHUP.
+func handleReloadConfig(c fiber.Ctx) error {
+	if err := reloadConfig(); err != nil {
+		return sendProblem(c, Problem{
+			Status: fiber.StatusUnprocessableEntity,
+			Codigo: "CONFIGURACAO_INVALIDA",
+			Detail: err.Error(),
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleExportTransactions transmite a tabela de transações em NDJSON, em
+// ordem de id. O export pode ser retomado a partir do último id recebido
+// com ?after_id=.
+func handleExportTransactions(c fiber.Ctx) error {
+	afterId, err := strconv.ParseInt(c.Query("after_id", "0"), 10, 64)
+	if err != nil || afterId < 0 {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	// o stream é escrito depois que o handler retorna, com a App e o tenant
+	// da requisição mas sem o cancelamento dela
+	parent := context.WithoutCancel(c.UserContext())
+	c.Set("Content-Type", "application/x-ndjson")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithCancel(parent)
+		defer cancel()
+
+		rows, err := poolFor(ctx).Query(ctx, `
+			SELECT id, cliente_id, valor, tipo, descricao, categoria, realizada_em
+			FROM transacoes WHERE id > $1
+			ORDER BY id`, afterId)
+		if err != nil {
+			log.Print("Error exporting transactions: ", err)
+			return
+		}
+		defer rows.Close()
+
+		encoder := json.NewEncoder(w)
+		count := 0
+		for rows.Next() {
+			var t TransacaoExportada
+			err := rows.Scan(&t.ID, &t.ClienteID, &t.Valor, &t.Tipo, &t.Descricao, &t.Categoria, &t.RealizadaEm)
+			if err != nil {
+				log.Print("Error exporting transactions: ", err)
+				return
+			}
+			if err := encoder.Encode(t); err != nil {
+				return
+			}
+			count++
+			if count%1000 == 0 {
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		}
+		if err := rows.Err(); err != nil {
+			log.Print("Error exporting transactions: ", err)
+		}
+		w.Flush()
+	})
+	return nil
+}
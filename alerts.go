@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// balanceAlertsEnabled faz cada transação verificar os alertas de saldo do
+// cliente na mesma transação do banco. Desligado por padrão porque custa uma
+// consulta a mais por escrita.
+var balanceAlertsEnabled = getEnvBool("ALERTAS_SALDO_ENABLED", false)
+
+// AlertaSaldo é um limiar de saldo cadastrado pelo cliente. O alerta dispara
+// quando uma transação leva o saldo de um lado ao outro do limiar, na
+// direção escolhida, e fica silenciado por IntervaloSegundos depois disso.
+type AlertaSaldo struct {
+	ID                int       `json:"id"`
+	Limiar            *Centavos `json:"limiar" validate:"required"`
+	Direcao           string    `json:"direcao" validate:"omitempty,oneof=abaixo acima"`
+	IntervaloSegundos *int      `json:"intervalo_segundos" validate:"omitempty,gte=0"`
+}
+
+// SaldoLimiarCruzado representa os dados do evento emitido quando um alerta dispara
+type SaldoLimiarCruzado struct {
+	AlertaID      int      `json:"alerta_id"`
+	Limiar        Centavos `json:"limiar"`
+	Direcao       string   `json:"direcao"`
+	SaldoAnterior Centavos `json:"saldo_anterior"`
+	Saldo         Centavos `json:"saldo"`
+}
+
+// checkBalanceAlerts marca e devolve os alertas cruzados pela transação que
+// levou o saldo a balance. O intervalo é verificado e atualizado no mesmo
+// UPDATE, então escritas concorrentes não disparam o alerta duas vezes.
+func checkBalanceAlerts(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest, balance Balance) ([]Evento, error) {
+	var previous Centavos
+	var err error
+	if transaction.Tipo == "d" {
+		previous, err = balance.Saldo.Add(transaction.Valor)
+	} else {
+		previous, err = balance.Saldo.Sub(transaction.Valor)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		UPDATE alertas_saldo SET ultimo_disparo = NOW()
+		WHERE cliente_id = $1
+		AND ((direcao = 'abaixo' AND $2 >= limiar AND $3 < limiar)
+			OR (direcao = 'acima' AND $2 <= limiar AND $3 > limiar))
+		AND (ultimo_disparo IS NULL OR ultimo_disparo + intervalo_segundos * INTERVAL '1 second' <= NOW())
+		RETURNING id, limiar, direcao`,
+		clientId, previous, balance.Saldo)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Evento, error) {
+		crossed := SaldoLimiarCruzado{SaldoAnterior: previous, Saldo: balance.Saldo}
+		err := row.Scan(&crossed.AlertaID, &crossed.Limiar, &crossed.Direcao)
+		return Evento{
+			Tipo:      "saldo_limiar_cruzado",
+			Tenant:    tenantFrom(ctx),
+			ClienteID: clientId,
+			Dados:     crossed,
+		}, err
+	})
+}
+
+func handleListBalanceAlerts(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	rows, err := poolFor(c.UserContext()).Query(c.UserContext(), `
+		SELECT id, limiar, direcao, intervalo_segundos
+		FROM alertas_saldo WHERE cliente_id = $1
+		ORDER BY id`, clientId)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	alerts, err := pgx.CollectRows(rows, pgx.RowToStructByPos[AlertaSaldo])
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(alerts)
+}
+
+func handleCreateBalanceAlert(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	alert := new(AlertaSaldo)
+	if err := bindJSON(c, alert); err != nil {
+		return sendBindError(c, err)
+	}
+	if alert.Direcao == "" {
+		alert.Direcao = "abaixo"
+	}
+	if alert.IntervaloSegundos == nil {
+		interval := getEnvInt("ALERTAS_SALDO_INTERVALO_PADRAO", 900)
+		alert.IntervaloSegundos = &interval
+	}
+
+	err = poolFor(c.UserContext()).QueryRow(c.UserContext(), `
+		INSERT INTO alertas_saldo (cliente_id, limiar, direcao, intervalo_segundos)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`,
+		clientId, alert.Limiar, alert.Direcao, alert.IntervaloSegundos).Scan(&alert.ID)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	c.Location(versionedPath(c, "/clientes/"+strconv.Itoa(clientId)+"/alertas/"+strconv.Itoa(alert.ID)))
+	return c.Status(fiber.StatusCreated).JSON(alert)
+}
+
+func handleDeleteBalanceAlert(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	alertId, err := c.ParamsInt("alerta_id")
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	tag, err := poolFor(c.UserContext()).Exec(c.UserContext(), `
+		DELETE FROM alertas_saldo WHERE cliente_id = $1 AND id = $2`,
+		clientId, alertId)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if tag.RowsAffected() == 0 {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// App reúne as dependências do serviço: os pools do Postgres (o padrão e o
+// de cada tenant), o Storage, o relógio e o logger. serve monta uma App com
+// newApp e a leva no contexto de cada requisição e de cada job (withApp), de
+// onde poolFor, storageFor e nowFor a tiram, então várias Apps podem
+// conviver no mesmo processo, cada uma com o seu banco. O que ainda é de
+// processo (caches, flags, o hub de eventos) continua em variáveis do pacote.
+type App struct {
+	config      AppConfig
+	pool        *pgxpool.Pool
+	tenantPools map[string]*pgxpool.Pool
+	storage     Storage
+	// redis é o redisStorage dentro de storage, se houver, para as escritas
+	// que não passam pelo Storage descartarem o saldo em cache
+	redis   *redisStorage
+	clock   Clock
+	logger  *log.Logger
+	clients clientDirectory
+}
+
+// AppConfig escolhe o armazenamento da App
+type AppConfig struct {
+	// Storage é postgres ou sqlite (STORAGE)
+	Storage    string
+	SQLitePath string
+	// RedisURL põe o Redis na frente do Storage quando preenchido
+	RedisURL string
+	// WALPath liga o diário local de escritas (journal.go) com o Postgres
+	WALPath string
+}
+
+func loadAppConfig() AppConfig {
+	return AppConfig{
+		Storage:    getEnv("STORAGE", "postgres"),
+		SQLitePath: getEnv("SQLITE_PATH", "rinha.db"),
+		RedisURL:   getEnv("REDIS_URL", ""),
+		WALPath:    getEnv("WAL_PATH", ""),
+	}
+}
+
+// currentApp é a App do processo: a do serve, a dos subcomandos que se
+// conectam por connectPostgres e a usada quando o contexto não traz uma
+var currentApp = &App{
+	tenantPools: map[string]*pgxpool.Pool{},
+	clock:       newClock(),
+	logger:      log.Default(),
+}
+
+// newApp abre o armazenamento de config. Com o Postgres, o pool padrão e os
+// dos tenants ficam na App e o Storage usa os mesmos pools.
+func newApp(ctx context.Context, config AppConfig, clock Clock, logger *log.Logger) (*App, error) {
+	app := &App{
+		config:      config,
+		tenantPools: map[string]*pgxpool.Pool{},
+		clock:       clock,
+		logger:      logger,
+	}
+
+	var err error
+	switch config.Storage {
+	case "sqlite":
+		app.storage, err = newSQLiteStorage(ctx, config.SQLitePath)
+		if err != nil {
+			return nil, fmt.Errorf("opening sqlite database: %w", err)
+		}
+	case "postgres":
+		app.pool, app.tenantPools, err = openPostgres(ctx)
+		if err != nil {
+			return nil, err
+		}
+		app.storage = postgresStorage{pool: app.pool}
+	default:
+		return nil, fmt.Errorf("unknown STORAGE %q", config.Storage)
+	}
+	if config.RedisURL != "" {
+		app.redis, err = newRedisStorage(ctx, app.storage, config.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to redis: %w", err)
+		}
+		app.storage = app.redis
+	}
+	if serverTimingEnabled {
+		app.storage = timedStorage{app.storage}
+	}
+	if config.WALPath != "" && app.pool != nil {
+		app.storage, err = newJournalStorage(app.storage, config.WALPath)
+		if err != nil {
+			return nil, fmt.Errorf("opening write-ahead journal: %w", err)
+		}
+	}
+	return app, nil
+}
+
+// contextMiddleware leva a App no contexto das requisições
+func (a *App) contextMiddleware(c fiber.Ctx) error {
+	c.SetUserContext(withApp(c.UserContext(), a))
+	return c.Next()
+}
+
+type appKey struct{}
+
+func withApp(ctx context.Context, app *App) context.Context {
+	return context.WithValue(ctx, appKey{}, app)
+}
+
+// appFrom devolve a App do contexto, ou currentApp
+func appFrom(ctx context.Context) *App {
+	if app, ok := ctx.Value(appKey{}).(*App); ok {
+		return app
+	}
+	return currentApp
+}
+
+// storageFor devolve o Storage da App do contexto
+func storageFor(ctx context.Context) Storage {
+	return appFrom(ctx).storage
+}
+
+// nowFor devolve o instante atual pelo relógio da App do contexto
+func nowFor(ctx context.Context) time.Time {
+	return appFrom(ctx).clock.Now()
+}
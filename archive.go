@@ -0,0 +1,182 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// ArchiveConfig define quais transações são arquivadas e com que frequência
+type ArchiveConfig struct {
+	MaxAge    time.Duration
+	Interval  time.Duration
+	BatchSize int
+}
+
+func loadArchiveConfig() ArchiveConfig {
+	return ArchiveConfig{
+		MaxAge:    getEnvDuration("ARQUIVO_IDADE", 90*24*time.Hour),
+		Interval:  getEnvDuration("ARQUIVO_INTERVALO", time.Hour),
+		BatchSize: getEnvInt("ARQUIVO_LOTE", 5000),
+	}
+}
+
+// TransacaoArquivada é uma linha de transacoes no arquivo: a linha inteira,
+// para que o arquivo substitua o que saiu do banco
+type TransacaoArquivada struct {
+	TransacaoExportada
+	SaldoApos      *Centavos       `json:"saldo_apos,omitempty"`
+	EstornoDe      *int64          `json:"estorno_de,omitempty"`
+	ValorEstornado Centavos        `json:"valor_estornado"`
+	Seq            *int64          `json:"seq,omitempty"`
+	Metadata       json.RawMessage `json:"metadata,omitempty"`
+	FraudeDecisao  *string         `json:"fraude_decisao,omitempty"`
+	FraudeRegra    *string         `json:"fraude_regra,omitempty"`
+}
+
+// runArchiver move periodicamente as transações mais antigas que MaxAge para
+// o destino configurado em ARQUIVO_PATH ou ARQUIVO_S3_*, mantendo a tabela
+// consultada pelo extrato pequena.
+func runArchiver(ctx context.Context, config ArchiveConfig) {
+	store, err := newBlobStore("ARQUIVO")
+	if err != nil {
+		log.Print("Archiver disabled: ", err)
+		return
+	}
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				archived, err := archiveBatch(ctx, store, config)
+				if err != nil {
+					log.Print("Error archiving transactions: ", err)
+					break
+				}
+				if archived < config.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// archiveBatch grava um lote em NDJSON comprimido e só remove as linhas do
+// banco depois que o arquivo foi enviado. O nome do arquivo é derivado do
+// intervalo de ids, então repetir um lote que falhou no commit sobrescreve o
+// mesmo objeto.
+func archiveBatch(ctx context.Context, store blobStore, config ArchiveConfig) (int, error) {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// transações ainda referenciadas por estornos, parcelamentos,
+	// autorizações ou entradas ficam no banco, e com PARTIDAS_DOBRADAS
+	// ligada isso inclui todas as que têm entradas
+	condition := `realizada_em < $1
+		AND NOT EXISTS (SELECT 1 FROM transacoes e WHERE e.estorno_de = t.id)
+		AND NOT EXISTS (SELECT 1 FROM transacoes_parcelas p WHERE p.transacao_id = t.id)
+		AND NOT EXISTS (SELECT 1 FROM parcelamentos p WHERE p.transacao_id = t.id)
+		AND NOT EXISTS (SELECT 1 FROM autorizacoes a WHERE a.transacao_id = t.id)
+		AND NOT EXISTS (SELECT 1 FROM entradas e WHERE e.transacao_id = t.id)`
+	// no modo eventsourcing só transações já projetadas podem sair do banco
+	if concurrencyMode == concurrencyEventSourcing {
+		condition += " AND id <= (SELECT projetado_ate FROM clientes WHERE id = cliente_id)"
+	}
+	cutoff := nowFor(ctx).Add(-config.MaxAge)
+	rows, err := tx.Query(ctx, `
+		SELECT id, cliente_id, valor, tipo, descricao, categoria, realizada_em,
+			saldo_apos, estorno_de, valor_estornado, seq, metadata, fraude_decisao, fraude_regra
+		FROM transacoes t WHERE `+condition+`
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`, cutoff, config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp("", "arquivo-*.ndjson.gz")
+	if err != nil {
+		rows.Close()
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	gz := gzip.NewWriter(tmp)
+	encoder := json.NewEncoder(gz)
+	var ids []int64
+	var first TransacaoArquivada
+	for rows.Next() {
+		var t TransacaoArquivada
+		err := rows.Scan(&t.ID, &t.ClienteID, &t.Valor, &t.Tipo, &t.Descricao, &t.Categoria, &t.RealizadaEm,
+			&t.SaldoApos, &t.EstornoDe, &t.ValorEstornado, &t.Seq, &t.Metadata, &t.FraudeDecisao, &t.FraudeRegra)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if len(ids) == 0 {
+			first = t
+		}
+		if err := encoder.Encode(t); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, t.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	key := tenantKeyPrefix(ctx) + fmt.Sprintf("transacoes/%s/%d-%d.ndjson.gz",
+		first.RealizadaEm.Format("2006/01"), ids[0], ids[len(ids)-1])
+	if err := store.Put(ctx, key, tmp, size, "application/gzip"); err != nil {
+		return 0, err
+	}
+
+	// o efeito das transações removidas vai para saldo_arquivado, mantendo
+	// possível recalcular o saldo a partir do que resta em transacoes
+	_, err = tx.Exec(ctx, `
+		WITH arquivadas AS (
+			DELETE FROM transacoes WHERE id = ANY($1)
+			RETURNING cliente_id, CASE WHEN tipo = 'c' THEN valor ELSE -valor END AS valor
+		)
+		UPDATE clientes c SET saldo_arquivado = c.saldo_arquivado + a.total
+		FROM (SELECT cliente_id, SUM(valor) AS total FROM arquivadas GROUP BY cliente_id) a
+		WHERE c.id = a.cliente_id`, ids)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	log.Printf("Archived %d transactions to %s", len(ids), store.Location(key))
+	return len(ids), nil
+}
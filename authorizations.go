@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// Autorizações (pré-autorizações de cartão): POST .../autorizacoes reserva o
+// valor em clientes.reservado, que reduz o limite disponível para os débitos
+// de todas as estratégias sem alterar o saldo. A captura libera a reserva e
+// registra o débito na mesma transação; o cancelamento e a expiração
+// (AUTORIZACAO_VALIDADE) só liberam a reserva. Toda mudança em reservado
+// incrementa versao, para que o modo optimistic a veja como conflito; os
+// demais modos refazem a conferência do limite na escrita do saldo. O modo
+// eventsourcing não tem autorizações, já que clientes.saldo ali é uma
+// projeção atrasada.
+var (
+	ErrAutorizacaoIndisponivel  = errors.New("autorizações não disponíveis no modo eventsourcing")
+	ErrAutorizacaoNaoEncontrada = errors.New("autorização não encontrada")
+	ErrAutorizacaoEncerrada     = errors.New("autorização já capturada, cancelada ou expirada")
+	ErrCapturaAcimaDoValor      = errors.New("captura maior que o valor autorizado")
+)
+
+// Status de uma autorização
+const (
+	authorizationPending  = "pendente"
+	authorizationCaptured = "capturada"
+	authorizationReleased = "cancelada"
+	authorizationExpired  = "expirada"
+)
+
+// AutorizacaoRequest é o corpo de POST .../autorizacoes. ValidadeSegundos
+// substitui AUTORIZACAO_VALIDADE.
+type AutorizacaoRequest struct {
+	Valor            Centavos `json:"valor" validate:"gt=0,valor_maximo"`
+	Descricao        string   `json:"descricao" validate:"required,max=10,descricao,descricao_permitida"`
+	Categoria        string   `json:"categoria,omitempty" validate:"omitempty,max=30"`
+	ValidadeSegundos int      `json:"validade_segundos,omitempty" validate:"omitempty,gt=0"`
+}
+
+// CapturaRequest é o corpo opcional da captura; sem valor, captura tudo
+type CapturaRequest struct {
+	Valor Centavos `json:"valor" validate:"omitempty,gt=0"`
+}
+
+type Autorizacao struct {
+	ID        int       `json:"id"`
+	Valor     Centavos  `json:"valor"`
+	Descricao string    `json:"descricao"`
+	Categoria string    `json:"categoria,omitempty"`
+	Status    string    `json:"status"`
+	CriadaEm  Timestamp `json:"criada_em"`
+	ExpiraEm  Timestamp `json:"expira_em"`
+	// ValorCapturado e TransacaoID são preenchidos na captura
+	ValorCapturado *Centavos `json:"valor_capturado,omitempty"`
+	TransacaoID    *int64    `json:"transacao_id,omitempty"`
+	// Saldo e Limite são os do cliente após a operação
+	Saldo     *Centavos `json:"saldo,omitempty"`
+	Limite    *Centavos `json:"limite,omitempty"`
+	Reservado *Centavos `json:"reservado,omitempty"`
+}
+
+const authorizationColumns = "id, valor, descricao, COALESCE(categoria, ''), status, criada_em, expira_em, valor_capturado, transacao_id"
+
+func scanAuthorization(row pgx.Row, authorization *Autorizacao) error {
+	return row.Scan(&authorization.ID, &authorization.Valor, &authorization.Descricao, &authorization.Categoria,
+		&authorization.Status, &authorization.CriadaEm, &authorization.ExpiraEm, &authorization.ValorCapturado, &authorization.TransacaoID)
+}
+
+func sendAuthorizationError(c fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrAutorizacaoNaoEncontrada):
+		return sendProblem(c, Problem{
+			Status: fiber.StatusNotFound,
+			Codigo: "AUTORIZACAO_NAO_ENCONTRADA",
+			Detail: err.Error(),
+		})
+	case errors.Is(err, ErrAutorizacaoEncerrada):
+		return sendProblem(c, Problem{
+			Status: fiber.StatusConflict,
+			Codigo: "AUTORIZACAO_ENCERRADA",
+			Detail: err.Error(),
+		})
+	case errors.Is(err, ErrAutorizacaoIndisponivel), errors.Is(err, ErrCapturaAcimaDoValor):
+		return sendProblem(c, Problem{
+			Status: fiber.StatusUnprocessableEntity,
+			Codigo: "AUTORIZACAO_INVALIDA",
+			Detail: err.Error(),
+		})
+	case errors.Is(err, ErrLimiteExcedido):
+		return sendProblem(c, Problem{
+			Status: fiber.StatusUnprocessableEntity,
+			Codigo: "LIMITE_EXCEDIDO",
+			Detail: err.Error(),
+		})
+	case errors.Is(err, ErrClienteBloqueado):
+		return sendProblem(c, Problem{
+			Status: fiber.StatusForbidden,
+			Codigo: "CLIENTE_BLOQUEADO",
+			Detail: err.Error(),
+		})
+	}
+	return c.SendStatus(fiber.ErrInternalServerError.Code)
+}
+
+func authorizationIdParam(c fiber.Ctx) (int, error) {
+	return strconv.Atoi(c.Params("autorizacao_id"))
+}
+
+func handleCreateAuthorization(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	request := new(AutorizacaoRequest)
+	if err := bindJSON(c, request); err != nil {
+		return sendBindError(c, err)
+	}
+	if concurrencyMode == concurrencyEventSourcing {
+		return sendAuthorizationError(c, ErrAutorizacaoIndisponivel)
+	}
+
+	validity := getEnvDuration("AUTORIZACAO_VALIDADE", 7*24*time.Hour)
+	if request.ValidadeSegundos > 0 {
+		validity = time.Duration(request.ValidadeSegundos) * time.Second
+	}
+
+	ctx := c.UserContext()
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	defer tx.Rollback(ctx)
+
+	// a linha do cliente fica bloqueada até o commit, serializando a reserva
+	// com os débitos
+	var balance Balance
+	err = tx.QueryRow(ctx, `
+		UPDATE clientes SET reservado = reservado + $2, versao = versao + 1
+		WHERE id = $1 AND saldo + limite - reservado - $2 >= 0 AND NOT bloqueado
+		RETURNING saldo, limite, reservado`, clientId, request.Valor).
+		Scan(&balance.Saldo, &balance.Limite, &balance.Reservado)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var blocked bool
+		if err := tx.QueryRow(ctx, "SELECT bloqueado FROM clientes WHERE id = $1", clientId).Scan(&blocked); err == nil && blocked {
+			return sendAuthorizationError(c, ErrClienteBloqueado)
+		}
+		return sendAuthorizationError(c, ErrLimiteExcedido)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+
+	var authorization Autorizacao
+	err = scanAuthorization(tx.QueryRow(ctx, `
+		INSERT INTO autorizacoes (cliente_id, valor, descricao, categoria, expira_em)
+		VALUES ($1, $2, $3, NULLIF($4, ''), (NOW() AT TIME ZONE 'UTC') + $5::interval)
+		RETURNING `+authorizationColumns,
+		clientId, request.Valor, request.Descricao, request.Categoria, validity), &authorization)
+	if isPgError(err, "23503") {
+		return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+
+	authorization.Saldo, authorization.Limite, authorization.Reservado = &balance.Saldo, &balance.Limite, &balance.Reservado
+	return c.Status(fiber.StatusCreated).JSON(authorization)
+}
+
+func handleListAuthorizations(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	ctx := c.UserContext()
+	rows, err := poolFor(ctx).Query(ctx, `
+		SELECT `+authorizationColumns+` FROM autorizacoes
+		WHERE cliente_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY id DESC
+		LIMIT 100`, clientId, c.Query("status"))
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	authorizations, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Autorizacao, error) {
+		var authorization Autorizacao
+		err := scanAuthorization(row, &authorization)
+		return authorization, err
+	})
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(authorizations)
+}
+
+// handleCaptureAuthorization converte a autorização em débito. Uma captura
+// parcial libera o restante da reserva.
+func handleCaptureAuthorization(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	authorizationId, err := authorizationIdParam(c)
+	if err != nil {
+		return sendAuthorizationError(c, ErrAutorizacaoNaoEncontrada)
+	}
+	request := new(CapturaRequest)
+	if len(c.Body()) > 0 {
+		if err := bindJSON(c, request); err != nil {
+			return sendBindError(c, err)
+		}
+	}
+
+	ctx := c.UserContext()
+	authorization, err := closeAuthorization(ctx, clientId, authorizationId, authorizationCaptured, request.Valor)
+	statements.Invalidate(statementClient{tenant: tenantFrom(ctx), clientId: clientId})
+	if err != nil {
+		return sendAuthorizationError(c, err)
+	}
+	return c.JSON(authorization)
+}
+
+func handleReleaseAuthorization(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	authorizationId, err := authorizationIdParam(c)
+	if err != nil {
+		return sendAuthorizationError(c, ErrAutorizacaoNaoEncontrada)
+	}
+
+	authorization, err := closeAuthorization(c.UserContext(), clientId, authorizationId, authorizationReleased, 0)
+	if err != nil {
+		return sendAuthorizationError(c, err)
+	}
+	return c.JSON(authorization)
+}
+
+// closeAuthorization libera a reserva de uma autorização pendente e, na
+// captura, registra o débito de amount (ou do valor autorizado)
+func closeAuthorization(ctx context.Context, clientId, authorizationId int, status string, amount Centavos) (Autorizacao, error) {
+	var authorization Autorizacao
+
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return authorization, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = scanAuthorization(tx.QueryRow(ctx, `
+		SELECT `+authorizationColumns+` FROM autorizacoes
+		WHERE cliente_id = $1 AND id = $2
+		FOR UPDATE`, clientId, authorizationId), &authorization)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return authorization, ErrAutorizacaoNaoEncontrada
+	}
+	if err != nil {
+		return authorization, err
+	}
+	if authorization.Status != authorizationPending || !authorization.ExpiraEm.After(nowFor(ctx)) {
+		return authorization, ErrAutorizacaoEncerrada
+	}
+	if amount == 0 {
+		amount = authorization.Valor
+	}
+	if amount > authorization.Valor {
+		return authorization, ErrCapturaAcimaDoValor
+	}
+
+	var balance Balance
+	err = tx.QueryRow(ctx, `
+		UPDATE clientes SET reservado = reservado - $2, versao = versao + 1 WHERE id = $1
+		RETURNING saldo, limite, reservado`, clientId, authorization.Valor).
+		Scan(&balance.Saldo, &balance.Limite, &balance.Reservado)
+	if err != nil {
+		return authorization, err
+	}
+
+	if status == authorizationCaptured {
+		// sem a reserva, o débito sempre cabe no limite que ela garantia
+		debit := &TransacaoRequest{
+			Valor:     amount,
+			Tipo:      "d",
+			Descricao: authorization.Descricao,
+			Categoria: authorization.Categoria,
+		}
+		captured, err := createTransaction(ctx, tx, clientId, debit)
+		if err != nil {
+			return authorization, err
+		}
+		balance.Saldo, balance.Limite = captured.Saldo, captured.Limite
+		authorization.TransacaoID = &debit.ID
+		authorization.ValorCapturado = &amount
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE autorizacoes SET status = $2, valor_capturado = $3, transacao_id = $4, encerrada_em = NOW()
+		WHERE id = $1`, authorizationId, status, authorization.ValorCapturado, authorization.TransacaoID)
+	if err != nil {
+		return authorization, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return authorization, err
+	}
+	if status == authorizationCaptured {
+		forgetCachedBalance(ctx, clientId)
+	}
+
+	authorization.Status = status
+	authorization.Saldo, authorization.Limite, authorization.Reservado = &balance.Saldo, &balance.Limite, &balance.Reservado
+	return authorization, nil
+}
+
+// runAuthorizationExpirer libera periodicamente as reservas das
+// autorizações vencidas
+func runAuthorizationExpirer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tag, err := poolFor(ctx).Exec(ctx, `
+				WITH expiradas AS (
+					UPDATE autorizacoes SET status = $1, encerrada_em = NOW()
+					WHERE status = $2 AND expira_em <= NOW() AT TIME ZONE 'UTC'
+					RETURNING cliente_id, valor
+				), por_cliente AS (
+					SELECT cliente_id, SUM(valor) AS valor FROM expiradas GROUP BY cliente_id
+				)
+				UPDATE clientes c SET reservado = c.reservado - p.valor, versao = c.versao + 1
+				FROM por_cliente p WHERE c.id = p.cliente_id`, authorizationExpired, authorizationPending)
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					log.Print("Error expiring authorizations: ", err)
+				}
+				continue
+			}
+			if tag.RowsAffected() > 0 {
+				debugf("Released expired authorizations of %d clients", tag.RowsAffected())
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// backupTables são as tabelas copiadas, na ordem em que uma restauração com
+// as chaves estrangeiras ligadas precisa carregá-las: cada tabela vem depois
+// das que ela referencia
+var backupTables = []string{
+	"clientes",
+	"categorias",
+	"transacoes",
+	"limites_categoria",
+	"limites_diarios",
+	"parcelamentos",
+	"transacoes_parcelas",
+	"agendamentos",
+	"agendamento_execucoes",
+	"autorizacoes",
+}
+
+// BackupStatus representa o estado do último backup disparado nesta instância
+type BackupStatus struct {
+	Status      string     `json:"status"`
+	IniciadoEm  time.Time  `json:"iniciado_em"`
+	ConcluidoEm *time.Time `json:"concluido_em,omitempty"`
+	Arquivos    []string   `json:"arquivos,omitempty"`
+	Erro        string     `json:"erro,omitempty"`
+}
+
+var backupState struct {
+	sync.Mutex
+	last *BackupStatus
+}
+
+// runBackup copia as tabelas para o destino configurado (BACKUP_PATH ou
+// BACKUP_S3_*). Todas as tabelas são lidas na mesma transação
+// REPEATABLE READ, garantindo um snapshot consistente entre elas.
+func runBackup(ctx context.Context) ([]string, error) {
+	store, err := newBlobStore("BACKUP")
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := poolFor(ctx).BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	prefix := tenantKeyPrefix(ctx) + "backup-" + nowFor(ctx).UTC().Format("20060102T150405Z")
+	var files []string
+	for _, table := range backupTables {
+		key := prefix + "/" + table + ".csv"
+		if err := backupTable(ctx, tx, store, table, key); err != nil {
+			return files, err
+		}
+		files = append(files, store.Location(key))
+	}
+	return files, tx.Commit(ctx)
+}
+
+func backupTable(ctx context.Context, tx pgx.Tx, store blobStore, table, key string) error {
+	tmp, err := os.CreateTemp("", "backup-*.csv")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	_, err = tx.Conn().PgConn().CopyTo(ctx, tmp, "COPY "+table+" TO STDOUT WITH (FORMAT csv, HEADER)")
+	if err != nil {
+		return err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return store.Put(ctx, key, tmp, size, "text/csv")
+}
+
+func handleStartBackup(c fiber.Ctx) error {
+	backupState.Lock()
+	defer backupState.Unlock()
+
+	if backupState.last != nil && backupState.last.Status == "executando" {
+		return sendProblem(c, Problem{
+			Status: fiber.StatusConflict,
+			Codigo: "BACKUP_EM_ANDAMENTO",
+			Detail: "backup iniciado em " + backupState.last.IniciadoEm.Format(time.RFC3339),
+		})
+	}
+
+	ctx := context.WithoutCancel(c.UserContext())
+	status := &BackupStatus{Status: "executando", IniciadoEm: nowFor(ctx).UTC()}
+	backupState.last = status
+	response := *status
+
+	go func() {
+		files, err := runBackup(ctx)
+		finished := nowFor(ctx).UTC()
+
+		backupState.Lock()
+		defer backupState.Unlock()
+		status.ConcluidoEm = &finished
+		status.Arquivos = files
+		if err != nil {
+			log.Print("Error running backup: ", err)
+			status.Status = "falhou"
+			status.Erro = err.Error()
+			return
+		}
+		status.Status = "concluido"
+	}()
+
+	return c.Status(fiber.StatusAccepted).JSON(response)
+}
+
+func handleBackupStatus(c fiber.Ctx) error {
+	backupState.Lock()
+	defer backupState.Unlock()
+
+	if backupState.last == nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.JSON(backupState.last)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/binder"
+)
+
+// Integração com o Bind do fiber. Os corpos passam por bindJSON e os
+// parâmetros de consulta por bindQuery, ambos validados pelo
+// structValidator. Centavos e Tipo têm conversores próprios nos binders de
+// consulta, formulário e cabeçalhos, então um filtro em centavos é lido com
+// as mesmas regras (ParseCentavos) do valor do corpo.
+
+// Tipo é o tipo da transação: crédito ou débito
+type Tipo string
+
+const (
+	TipoCredito Tipo = "c"
+	TipoDebito  Tipo = "d"
+)
+
+func (t Tipo) Valid() bool {
+	return t == TipoCredito || t == TipoDebito
+}
+
+func init() {
+	binder.SetParserDecoder(binder.ParserConfig{
+		IgnoreUnknownKeys: true,
+		ZeroEmpty:         true,
+		ParserType: []binder.ParserType{
+			{
+				Customtype: Centavos(0),
+				Converter: func(value string) reflect.Value {
+					amount, err := ParseCentavos(value)
+					if err != nil {
+						return reflect.Value{}
+					}
+					return reflect.ValueOf(amount)
+				},
+			},
+			{
+				// a validação do tipo fica com o structValidator, como no corpo
+				Customtype: Tipo(""),
+				Converter: func(value string) reflect.Value {
+					return reflect.ValueOf(Tipo(value))
+				},
+			},
+		},
+	})
+}
+
+// bindQuery decodifica e valida os parâmetros de consulta em out, pelas
+// tags query
+func bindQuery(c fiber.Ctx, out any) error {
+	return c.Bind().Query(out)
+}
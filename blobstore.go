@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// blobStore é o destino de arquivos gerados pela aplicação (backups,
+// arquivamento), seja um diretório local ou um bucket compatível com S3.
+type blobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Location(key string) string
+}
+
+type localBlobStore struct {
+	dir string
+}
+
+func (s localBlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	target := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(target + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), target)
+}
+
+func (s localBlobStore) Location(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+type s3BlobStore struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (s s3BlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, path.Join(s.prefix, key), r, size,
+		minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s s3BlobStore) Location(key string) string {
+	return "s3://" + s.bucket + "/" + path.Join(s.prefix, key)
+}
+
+// blobPresigner é implementado pelos destinos que geram links temporários
+// de leitura direta, sem passar pela API
+type blobPresigner interface {
+	PresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+func (s s3BlobStore) PresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, path.Join(s.prefix, key), expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// newBlobStore cria o destino configurado pelas variáveis com o prefixo
+// informado, ex.: BACKUP_S3_BUCKET ou BACKUP_PATH. O S3 tem precedência
+// quando um bucket estiver configurado.
+func newBlobStore(prefix string) (blobStore, error) {
+	if bucket := getEnv(prefix+"_S3_BUCKET", ""); bucket != "" {
+		endpoint := getEnv(prefix+"_S3_ENDPOINT", "s3.amazonaws.com")
+		client, err := minio.New(endpoint, &minio.Options{
+			Creds: credentials.NewStaticV4(
+				getEnv(prefix+"_S3_ACCESS_KEY", ""),
+				getEnv(prefix+"_S3_SECRET_KEY", ""),
+				""),
+			Secure: getEnvBool(prefix+"_S3_SSL", true),
+			Region: getEnv(prefix+"_S3_REGION", ""),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s3BlobStore{
+			client: client,
+			bucket: bucket,
+			prefix: getEnv(prefix+"_S3_PREFIX", ""),
+		}, nil
+	}
+
+	dir := getEnv(prefix+"_PATH", "")
+	if dir == "" {
+		return nil, errors.New(prefix + "_PATH or " + prefix + "_S3_BUCKET must be set")
+	}
+	return localBlobStore{dir: dir}, nil
+}
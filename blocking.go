@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+var ErrClienteBloqueado = errors.New("cliente bloqueado para novas transações")
+
+// sqlStateClienteBloqueado é o código levantado por bloqueio_trigger
+const sqlStateClienteBloqueado = "RB001"
+
+// handleBlockClient marca ou desmarca clientes.bloqueado. Clientes
+// bloqueados recebem 403 em novas transações, inclusive agendadas, mas
+// continuam consultando o extrato.
+func handleBlockClient(blocked bool) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		clientId, err := clientIdParam(c)
+		if err != nil {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+
+		ctx := c.UserContext()
+		tag, err := poolFor(ctx).Exec(ctx, "UPDATE clientes SET bloqueado = $2 WHERE id = $1", clientId, blocked)
+		if err != nil {
+			return c.SendStatus(fiber.ErrInternalServerError.Code)
+		}
+		if tag.RowsAffected() == 0 {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// configureBusSink adiciona ao relay do outbox a publicação dos eventos
+// transacao_criada no barramento escolhido em BUS (nats ou kafka), no
+// subject/tópico BUS_TOPICO. Os eventos são publicados em JSON, com o id do
+// outbox para deduplicação; no Kafka a chave é o cliente, preservando a
+// ordem das transações de cada cliente na mesma partição.
+func configureBusSink() error {
+	kind := getEnv("BUS", "")
+	if kind == "" {
+		return nil
+	}
+	if !outboxEnabled {
+		return fmt.Errorf("BUS=%s requires OUTBOX_ENABLED", kind)
+	}
+	topic := getEnv("BUS_TOPICO", "transacoes.criadas")
+
+	var publish func(ctx context.Context, event Evento, data []byte) error
+	switch kind {
+	case "nats":
+		conn, err := nats.Connect(getEnv("BUS_URL", nats.DefaultURL), nats.Name("rinha-de-backend-2024-q1"))
+		if err != nil {
+			return err
+		}
+		publish = func(ctx context.Context, event Evento, data []byte) error {
+			if err := conn.Publish(topic, data); err != nil {
+				return err
+			}
+			// o flush confirma que o servidor recebeu a mensagem antes de o
+			// relay marcar o evento como publicado
+			return conn.FlushTimeout(5 * time.Second)
+		}
+	case "kafka":
+		writer := &kafka.Writer{
+			Addr:                   kafka.TCP(strings.Split(getEnv("BUS_URL", "localhost:9092"), ",")...),
+			Topic:                  topic,
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+		}
+		publish = func(ctx context.Context, event Evento, data []byte) error {
+			return writer.WriteMessages(ctx, kafka.Message{
+				Key:   []byte(strconv.Itoa(event.ClienteID)),
+				Value: data,
+			})
+		}
+	default:
+		return fmt.Errorf("unknown BUS %q", kind)
+	}
+
+	outboxSinks = append(outboxSinks, func(ctx context.Context, event Evento) error {
+		if event.Tipo != "transacao_criada" {
+			return nil
+		}
+		data, err := jsonMarshal(event)
+		if err != nil {
+			return err
+		}
+		return publish(ctx, event, data)
+	})
+	return nil
+}
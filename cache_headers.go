@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// statementMaxAge é o max-age do extrato para caches intermediários
+// (EXTRATO_MAX_AGE). Zero envia no-cache, obrigando a revalidar a cada
+// leitura.
+var statementMaxAge atomic.Int64
+
+func init() {
+	onReload(func() {
+		statementMaxAge.Store(int64(max(getEnvDuration("EXTRATO_MAX_AGE", time.Second), 0)))
+	})
+}
+
+// noStore impede que proxies guardem a resposta, inclusive as de erro
+func noStore(c fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Next()
+}
+
+// setStatementCacheHeaders envia o Cache-Control do extrato e, se conhecido,
+// o Last-Modified com a data da transação mais recente
+func setStatementCacheHeaders(x exchange, lastModified time.Time) {
+	if maxAge := time.Duration(statementMaxAge.Load()); maxAge > 0 {
+		x.SetHeader(fiber.HeaderCacheControl, "max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	} else {
+		x.SetHeader(fiber.HeaderCacheControl, "no-cache")
+	}
+	if !lastModified.IsZero() {
+		x.SetHeader(fiber.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+}
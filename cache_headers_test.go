@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheHeaders(t *testing.T) {
+	previous := statementMaxAge.Load()
+	t.Cleanup(func() { statementMaxAge.Store(previous) })
+
+	for _, stack := range []string{httpStackFiber, httpStackNetHTTP} {
+		t.Run(stack, func(t *testing.T) {
+			httpClient, baseURL := testClient(sqliteTestApp(t), stack)
+			statement := func() *http.Response {
+				resp, err := httpClient.Get(baseURL + "/clientes/1/extrato")
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				return resp
+			}
+
+			statementMaxAge.Store(int64(5 * time.Second))
+			if resp := statement(); resp.Header.Get("Last-Modified") != "" {
+				t.Errorf("Last-Modified %q without transactions", resp.Header.Get("Last-Modified"))
+			}
+
+			// o Last-Modified tem resolução de segundos
+			before := time.Now().Truncate(time.Second)
+			body := strings.NewReader(`{"valor": 100, "tipo": "c", "descricao": "cache"}`)
+			resp, err := httpClient.Post(baseURL+"/clientes/1/transacoes", "application/json", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("POST status %d", resp.StatusCode)
+			}
+			if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+				t.Errorf("POST Cache-Control %q, want no-store", got)
+			}
+
+			resp = statement()
+			if got := resp.Header.Get("Cache-Control"); got != "max-age=5" {
+				t.Errorf("extrato Cache-Control %q, want max-age=5", got)
+			}
+			lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+			if err != nil {
+				t.Fatalf("Last-Modified %q: %v", resp.Header.Get("Last-Modified"), err)
+			}
+			if lastModified.Before(before) || lastModified.After(time.Now()) {
+				t.Errorf("Last-Modified %s is not the time of the transaction", lastModified)
+			}
+
+			statementMaxAge.Store(0)
+			if got := statement().Header.Get("Cache-Control"); got != "no-cache" {
+				t.Errorf("extrato Cache-Control %q with EXTRATO_MAX_AGE=0, want no-cache", got)
+			}
+		})
+	}
+}
+
+// TestStatementTotalsFlag liga e desliga totais_por_categoria com o cache do
+// extrato ativo: cada estado da flag tem a sua entrada no cache
+func TestStatementTotalsFlag(t *testing.T) {
+	previousFlags, previousTTL := envFlags.Load(), time.Duration(statements.ttl.Load())
+	statements.SetTTL(time.Minute)
+	t.Cleanup(func() {
+		envFlags.Store(previousFlags)
+		statements.SetTTL(previousTTL)
+	})
+	setTotals := func(enabled bool) {
+		envFlags.Store(&map[string]bool{"totais_por_categoria": enabled})
+	}
+
+	app := sqliteTestApp(t)
+	if _, err := app.storage.(*sqliteStorage).db.Exec("INSERT INTO categorias (nome) VALUES ('mercado')"); err != nil {
+		t.Fatal(err)
+	}
+	httpClient, baseURL := testClient(app, httpStackFiber)
+	body := strings.NewReader(`{"valor": 100, "tipo": "c", "descricao": "totais", "categoria": "mercado"}`)
+	resp, err := httpClient.Post(baseURL+"/clientes/1/transacoes", "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST status %d", resp.StatusCode)
+	}
+	totals := func() int {
+		t.Helper()
+		resp, err := httpClient.Get(baseURL + "/clientes/1/extrato")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var statement TransactionLog
+		if err := json.NewDecoder(resp.Body).Decode(&statement); err != nil {
+			t.Fatal(err)
+		}
+		return len(statement.TotaisPorCategoria)
+	}
+
+	for _, enabled := range []bool{true, false, true} {
+		setTotals(enabled)
+		// a segunda leitura vem do cache
+		for range 2 {
+			if got := totals(); (got > 0) != enabled {
+				t.Errorf("totais_por_categoria=%v: %d totals", enabled, got)
+			}
+		}
+	}
+}
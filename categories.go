@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Categoria representa uma categoria que pode ser atribuída a transações
+type Categoria struct {
+	Nome      string `json:"nome"`
+	Descricao string `json:"descricao"`
+}
+
+// TotalCategoria representa os totais de créditos e débitos de uma categoria no extrato
+type TotalCategoria struct {
+	Categoria string   `json:"categoria"`
+	Creditos  Centavos `json:"creditos"`
+	Debitos   Centavos `json:"debitos"`
+}
+
+func validateCategoryName(name string) error {
+	length := utf8.RuneCountInString(name)
+	if length < 1 || length > 30 {
+		return errors.New("categoria inválida")
+	}
+	return nil
+}
+
+func isPgError(err error, code string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
+func categoryTotals(ctx context.Context, db dbtx, clientId int, category string) ([]TotalCategoria, error) {
+	rows, err := db.Query(ctx, `
+		SELECT categoria,
+			COALESCE(SUM(valor) FILTER (WHERE tipo = 'c'), 0),
+			COALESCE(SUM(valor) FILTER (WHERE tipo = 'd'), 0)
+		FROM transacoes
+		WHERE cliente_id = $1 AND categoria IS NOT NULL AND ($2 = '' OR categoria = $2)
+		GROUP BY categoria
+		ORDER BY categoria`, clientId, category)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (TotalCategoria, error) {
+		var total TotalCategoria
+		err := row.Scan(&total.Categoria, &total.Creditos, &total.Debitos)
+		return total, err
+	})
+}
+
+func handleListCategories(c fiber.Ctx) error {
+	rows, err := poolFor(c.UserContext()).Query(c.UserContext(), `
+		SELECT nome, descricao FROM categorias ORDER BY nome`)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	categories, err := pgx.CollectRows(rows, pgx.RowToStructByPos[Categoria])
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(categories)
+}
+
+func handleGetCategory(c fiber.Ctx) error {
+	var category Categoria
+	err := poolFor(c.UserContext()).QueryRow(c.UserContext(), `
+		SELECT nome, descricao FROM categorias WHERE nome = $1`,
+		c.Params("nome")).Scan(&category.Nome, &category.Descricao)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(category)
+}
+
+func handleCreateCategory(c fiber.Ctx) error {
+	category := new(Categoria)
+	if err := bindJSON(c, category); err != nil {
+		return sendBindError(c, err)
+	}
+	if err := validateCategoryName(category.Nome); err != nil {
+		return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+	}
+
+	_, err := poolFor(c.UserContext()).Exec(c.UserContext(), `
+		INSERT INTO categorias (nome, descricao) VALUES ($1, $2)`,
+		category.Nome, category.Descricao)
+	if isPgError(err, "23505") {
+		return c.SendStatus(fiber.StatusConflict)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.Status(fiber.StatusCreated).JSON(category)
+}
+
+func handleUpdateCategory(c fiber.Ctx) error {
+	category := new(Categoria)
+	if err := bindJSON(c, category); err != nil {
+		return sendBindError(c, err)
+	}
+	if category.Nome == "" {
+		category.Nome = c.Params("nome")
+	}
+	if err := validateCategoryName(category.Nome); err != nil {
+		return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+	}
+
+	tag, err := poolFor(c.UserContext()).Exec(c.UserContext(), `
+		UPDATE categorias SET nome = $2, descricao = $3 WHERE nome = $1`,
+		c.Params("nome"), category.Nome, category.Descricao)
+	if isPgError(err, "23505") {
+		return c.SendStatus(fiber.StatusConflict)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if tag.RowsAffected() == 0 {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.JSON(category)
+}
+
+func handleDeleteCategory(c fiber.Ctx) error {
+	tag, err := poolFor(c.UserContext()).Exec(c.UserContext(), `
+		DELETE FROM categorias WHERE nome = $1`, c.Params("nome"))
+	// categorias já usadas por transações não podem ser removidas
+	if isPgError(err, "23503") {
+		return c.SendStatus(fiber.StatusConflict)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if tag.RowsAffected() == 0 {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// chaosEnabled liga a injeção de falhas (CHAOS_ENABLED), para verificar
+// retentativas e degradação antes da rodada oficial. Nunca deve ser ligado em
+// produção. As taxas, entre 0 e 1, são recarregáveis:
+//
+//   - CHAOS_LATENCIA_TAXA: requisições que esperam até CHAOS_LATENCIA
+//   - CHAOS_ERRO_TAXA: requisições respondidas com 500 sem chegar ao handler
+//   - CHAOS_DB_TAXA: conexões do Postgres fechadas ao serem retiradas do
+//     pool, fazendo a consulta seguinte falhar como uma conexão derrubada
+var chaosEnabled = getEnvBool("CHAOS_ENABLED", false)
+
+var chaosInjected = expvar.NewMap("chaos_injected")
+
+type chaosConfig struct {
+	latencyRate float64
+	latency     time.Duration
+	errorRate   float64
+	dbDropRate  float64
+}
+
+var chaos atomic.Pointer[chaosConfig]
+
+func init() {
+	onReload(func() {
+		chaos.Store(&chaosConfig{
+			latencyRate: getEnvFloat("CHAOS_LATENCIA_TAXA", 0),
+			latency:     getEnvDuration("CHAOS_LATENCIA", 200*time.Millisecond),
+			errorRate:   getEnvFloat("CHAOS_ERRO_TAXA", 0),
+			dbDropRate:  getEnvFloat("CHAOS_DB_TAXA", 0),
+		})
+	})
+}
+
+// chaosMiddleware injeta latência e erros 500 nas requisições
+func chaosMiddleware(c fiber.Ctx) error {
+	config := chaos.Load()
+	if config.latencyRate > 0 && config.latency > 0 && rand.Float64() < config.latencyRate {
+		chaosInjected.Add("latencia", 1)
+		time.Sleep(rand.N(config.latency))
+	}
+	if config.errorRate > 0 && rand.Float64() < config.errorRate {
+		chaosInjected.Add("erro", 1)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.Next()
+}
+
+// chaosBeforeAcquire é o BeforeAcquire do pool com CHAOS_ENABLED. A conexão
+// fechada ainda é entregue, então a falha aparece para quem a usa; o pool a
+// descarta na devolução.
+func chaosBeforeAcquire(ctx context.Context, conn *pgx.Conn) bool {
+	config := chaos.Load()
+	if config.dbDropRate > 0 && rand.Float64() < config.dbDropRate {
+		chaosInjected.Add("db", 1)
+		conn.Close(ctx)
+	}
+	return true
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// command representa um subcomando do binário
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "run the HTTP API and background jobs (default)", serve},
+	{"migrate", "create the database schema if it does not exist", migrate},
+	{"seed", "insert the default clients; -reset also clears all transactions", seed},
+	{"check-config", "validate the environment configuration without connecting", checkConfig},
+	{"reconcile", "compare balances with the transaction log; -fix corrects drift", reconcile},
+	{"backup", "write a backup of clientes and transacoes", backup},
+	{"rebuild", "rebuild clientes.saldo by replaying transacoes", rebuild},
+	{"partition", "convert transacoes to monthly partitions and create the upcoming ones", partition},
+	{"ingest", "bulk load historical transactions from a file or stdin with COPY", ingest},
+	{"spec", "run the rinha API contract checks against a running server", runSpec},
+	{"proxy", "round-robin HTTP proxy in front of PROXY_UPSTREAMS, replacing nginx", proxy},
+}
+
+// defaultClients são os clientes da rinha, os mesmos inseridos pelo script.sql
+var defaultClients = []struct {
+	id     int
+	nome   string
+	limite Centavos
+}{
+	{1, "o barato sai caro", 1000 * 100},
+	{2, "zan corp ltda", 800 * 100},
+	{3, "les cruders", 10000 * 100},
+	{4, "padaria joia de cocaia", 100000 * 100},
+	{5, "kid mais", 5000 * 100},
+}
+
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		switch {
+		// -backup e -rebuild eram flags antes dos subcomandos; -proxy segue
+		// o mesmo formato
+		case args[0] == "-backup" || args[0] == "-rebuild" || args[0] == "-proxy":
+			name, args = args[0][1:], args[1:]
+		case !strings.HasPrefix(args[0], "-"):
+			name, args = args[0], args[1:]
+		}
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(args); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\ncommands:\n", name)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-13s %s\n", cmd.name, cmd.usage)
+	}
+	os.Exit(2)
+}
+
+func requirePostgres(name string) error {
+	if kind := getEnv("STORAGE", "postgres"); kind != "postgres" {
+		return fmt.Errorf("%s requires STORAGE=postgres, got %q", name, kind)
+	}
+	connectPostgres()
+	return nil
+}
+
+func migrate(args []string) error {
+	flag.NewFlagSet("migrate", flag.ExitOnError).Parse(args)
+	if err := requirePostgres("migrate"); err != nil {
+		return err
+	}
+
+	// os schemas dos tenants já são criados por connectPostgres
+	ctx := context.Background()
+	var exists bool
+	err := poolFor(ctx).QueryRow(ctx, "SELECT to_regclass('clientes') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		log.Print("Schema already exists")
+		return nil
+	}
+	if _, err := poolFor(ctx).Exec(ctx, schemaScript); err != nil {
+		return err
+	}
+	log.Print("Schema created")
+	return nil
+}
+
+func seed(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	reset := flags.Bool("reset", false, "delete all transactions and zero the balances first")
+	flags.Parse(args)
+	if err := requirePostgres("seed"); err != nil {
+		return err
+	}
+
+	for _, ctx := range tenantContexts(context.Background()) {
+		if err := seedClients(ctx, *reset); err != nil {
+			return err
+		}
+		log.Printf("Seeded %d clients in %q", len(defaultClients), tenantKeyPrefix(ctx))
+	}
+	return nil
+}
+
+func seedClients(ctx context.Context, reset bool) error {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if reset {
+		_, err := tx.Exec(ctx, `
+			TRUNCATE transacoes, transacoes_parcelas, autorizacoes, agendamento_execucoes, agendamentos, parcelamentos, limites_categoria, limites_diarios, debitos_diarios, alertas_saldo, outbox, estatisticas_minuto, entradas, notificacoes_extrato, extratos_mensais, webhooks_extrato, encerramentos`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE clientes SET saldo = 0, versao = 0, saldo_arquivado = 0, projetado_ate = 0, reservado = 0`)
+		if err != nil {
+			return err
+		}
+	}
+
+	batch := &pgx.Batch{}
+	for _, client := range defaultClients {
+		batch.Queue(`
+			INSERT INTO clientes (id, nome, limite) VALUES ($1, $2, $3)
+			ON CONFLICT (id) DO NOTHING`, client.id, client.nome, client.limite)
+	}
+	batch.Queue("SELECT setval(pg_get_serial_sequence('clientes', 'id'), (SELECT MAX(id) FROM clientes))")
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// checkConfig valida as variáveis de ambiente sem abrir conexões, reportando
+// todos os problemas encontrados de uma vez.
+func checkConfig(args []string) error {
+	flag.NewFlagSet("check-config", flag.ExitOnError).Parse(args)
+
+	var problems []string
+	storageKind := getEnv("STORAGE", "postgres")
+	switch storageKind {
+	case "postgres":
+		for _, name := range []string{"POSTGRES_HOST", "POSTGRES_USER", "POSTGRES_DB"} {
+			if lookupEnv(name) == "" {
+				problems = append(problems, name+" is not set")
+			}
+		}
+	case "sqlite":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown STORAGE %q", storageKind))
+	}
+
+	if err := configureConcurrency(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := validateHTTPStack(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, tenant := range strings.Split(getEnv("TENANTS", ""), ",") {
+		if tenant = strings.TrimSpace(tenant); tenant != "" && !tenantNamePattern.MatchString(tenant) {
+			problems = append(problems, fmt.Sprintf("invalid tenant name %q", tenant))
+		}
+	}
+	switch bus := getEnv("BUS", ""); bus {
+	case "":
+	case "nats", "kafka":
+		if !outboxEnabled {
+			problems = append(problems, fmt.Sprintf("BUS=%s requires OUTBOX_ENABLED", bus))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown BUS %q", bus))
+	}
+	if lookupEnv("BACKUP_PATH") != "" || lookupEnv("BACKUP_S3_BUCKET") != "" {
+		if _, err := newBlobStore("BACKUP"); err != nil {
+			problems = append(problems, "BACKUP: "+err.Error())
+		}
+	}
+	if getEnvBool("ARQUIVO_ENABLED", false) {
+		if _, err := newBlobStore("ARQUIVO"); err != nil {
+			problems = append(problems, "ARQUIVO: "+err.Error())
+		}
+	}
+	if doubleEntryEnabled && storageKind != "postgres" {
+		problems = append(problems, "PARTIDAS_DOBRADAS requires STORAGE=postgres")
+	}
+	if path := lookupEnv("FRAUDE_REGRAS"); path != "" {
+		if _, err := loadFraudRules(path); err != nil {
+			problems = append(problems, "FRAUDE_REGRAS: "+err.Error())
+		}
+	}
+	if lookupEnv("ADMIN_TOKEN") == "" {
+		log.Print("Warning: ADMIN_TOKEN is not set, admin routes will answer 403")
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		return fmt.Errorf("%d configuration problems found", len(problems))
+	}
+	fmt.Printf("configuration OK (storage=%s, concurrency=%s)\n", storageKind, concurrencyMode)
+	return nil
+}
+
+func reconcile(args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "correct the balances that drifted")
+	flags.Parse(args)
+	if err := requirePostgres("reconcile"); err != nil {
+		return err
+	}
+
+	var pending int
+	for _, ctx := range tenantContexts(context.Background()) {
+		report, err := reconcileBalances(ctx, *fix)
+		if err != nil {
+			return err
+		}
+		data, err := jsonMarshal(report)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		for _, d := range report.Divergencias {
+			if !d.Corrigida {
+				pending++
+			}
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d balances drifted", pending)
+	}
+	return nil
+}
+
+func backup(args []string) error {
+	flag.NewFlagSet("backup", flag.ExitOnError).Parse(args)
+	if err := requirePostgres("backup"); err != nil {
+		return err
+	}
+
+	for _, ctx := range tenantContexts(context.Background()) {
+		files, err := runBackup(ctx)
+		if err != nil {
+			return fmt.Errorf("error running backup: %w", err)
+		}
+		for _, file := range files {
+			log.Print("Backup written to ", file)
+		}
+	}
+	return nil
+}
+
+func rebuild(args []string) error {
+	flag.NewFlagSet("rebuild", flag.ExitOnError).Parse(args)
+	if err := requirePostgres("rebuild"); err != nil {
+		return err
+	}
+
+	for _, ctx := range tenantContexts(context.Background()) {
+		rebuilt, err := rebuildProjection(ctx)
+		if err != nil {
+			return fmt.Errorf("error rebuilding balances: %w", err)
+		}
+		log.Printf("Rebuilt balances of %d clients in %q", rebuilt, tenantKeyPrefix(ctx))
+	}
+	return nil
+}
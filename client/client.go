@@ -0,0 +1,206 @@
+// Package client é um cliente HTTP tipado para a API de transações, com
+// retentativas e suporte a context.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client faz chamadas à API em BaseURL. É seguro para uso concorrente.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient usa httpClient no lugar de um http.Client com timeout de 5s
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries define quantas vezes uma chamada é repetida e a espera antes
+// da primeira repetição, que dobra a cada tentativa
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		retries:    3,
+		backoff:    50 * time.Millisecond,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Transacao é o corpo de CriarTransacao. Valor é em centavos.
+type Transacao struct {
+	Valor     int64  `json:"valor"`
+	Tipo      string `json:"tipo"`
+	Descricao string `json:"descricao"`
+	Categoria string `json:"categoria,omitempty"`
+}
+
+// TransacaoCriada é a resposta de CriarTransacao
+type TransacaoCriada struct {
+	ID                 int64   `json:"id"`
+	Saldo              int64   `json:"saldo"`
+	Limite             int64   `json:"limite"`
+	LimiteUtilizadoPct float64 `json:"limite_utilizado_pct"`
+}
+
+type Extrato struct {
+	Saldo struct {
+		Total              int64     `json:"total"`
+		DataExtrato        time.Time `json:"data_extrato"`
+		Limite             int64     `json:"limite"`
+		LimiteUtilizadoPct float64   `json:"limite_utilizado_pct"`
+	} `json:"saldo"`
+	UltimasTransacoes []TransacaoExtrato `json:"ultimas_transacoes"`
+	// ProximaPagina é o cursor das transações seguintes, vazio na última
+	// página; ver ObterPaginaExtrato
+	ProximaPagina string `json:"proxima_pagina,omitempty"`
+}
+
+type TransacaoExtrato struct {
+	ID          int64     `json:"id"`
+	Valor       int64     `json:"valor"`
+	Tipo        string    `json:"tipo"`
+	Descricao   string    `json:"descricao"`
+	Categoria   *string   `json:"categoria,omitempty"`
+	RealizadaEm time.Time `json:"realizada_em"`
+	SaldoApos   *int64    `json:"saldo_apos,omitempty"`
+	Seq         *int64    `json:"seq,omitempty"`
+}
+
+// Erro é uma resposta de erro da API (problem+json). Codigo identifica o
+// erro, como LIMITE_EXCEDIDO.
+type Erro struct {
+	Status int    `json:"status"`
+	Codigo string `json:"codigo"`
+	Detail string `json:"detail"`
+	Erros  []struct {
+		Campo    string `json:"campo"`
+		Codigo   string `json:"codigo"`
+		Mensagem string `json:"mensagem"`
+	} `json:"erros"`
+}
+
+func (e *Erro) Error() string {
+	if e.Codigo == "" {
+		return fmt.Sprintf("status %d: %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("status %d: %s: %s", e.Status, e.Codigo, e.Detail)
+}
+
+// CriarTransacao registra uma transação para o cliente. Como a API não é
+// idempotente, só são repetidas as tentativas que certamente não chegaram a
+// gravar: falhas de conexão e conflitos de concorrência.
+func (c *Client) CriarTransacao(ctx context.Context, clienteID int, transacao Transacao) (TransacaoCriada, error) {
+	var response TransacaoCriada
+	body, err := json.Marshal(transacao)
+	if err != nil {
+		return response, err
+	}
+	path := "/clientes/" + strconv.Itoa(clienteID) + "/transacoes"
+	err = c.do(ctx, http.MethodPost, path, body, &response, func(err error) bool {
+		var apiErr *Erro
+		if errors.As(err, &apiErr) {
+			return apiErr.Codigo == "CONFLITO_CONCORRENCIA"
+		}
+		var opErr *net.OpError
+		return errors.As(err, &opErr) && opErr.Op == "dial"
+	})
+	return response, err
+}
+
+// ObterExtrato devolve o saldo e as últimas transações do cliente
+func (c *Client) ObterExtrato(ctx context.Context, clienteID int) (Extrato, error) {
+	return c.ObterPaginaExtrato(ctx, clienteID, "")
+}
+
+// ObterPaginaExtrato devolve o extrato com as transações anteriores ao
+// cursor, o ProximaPagina de uma página anterior; sem cursor, é a primeira
+func (c *Client) ObterPaginaExtrato(ctx context.Context, clienteID int, cursor string) (Extrato, error) {
+	var response Extrato
+	path := "/clientes/" + strconv.Itoa(clienteID) + "/extrato"
+	if cursor != "" {
+		path += "?antes_de=" + url.QueryEscape(cursor)
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &response, func(err error) bool {
+		var apiErr *Erro
+		if errors.As(err, &apiErr) {
+			return apiErr.Status >= 500
+		}
+		return ctx.Err() == nil
+	})
+	return response, err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out any, retryable func(error) bool) error {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, method, path, body, out)
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Erro{Status: resp.StatusCode}
+		// o corpo só é problem+json quando a API o gerou; respostas do
+		// balanceador ficam só com o status
+		if data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10)); err == nil {
+			json.Unmarshal(data, apiErr)
+			apiErr.Status = resp.StatusCode
+		}
+		return apiErr
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Cadastro de clientes: as rotas /clientes/:id só atendem os ids que
+// existem na tabela clientes de cada tenant, incluindo os criados pela
+// importação. Os ids ficam em memória, porque o id é validado em toda
+// requisição; o serve os carrega na subida, de novo logo após cada
+// importação e a cada CLIENTES_INTERVALO (30s por padrão), para ver os
+// clientes importados por outra instância. Até a primeira carga valem os
+// clientes do script.sql.
+
+// clientDirectory guarda os ids de clientes de cada tenant ("" sem tenancy)
+type clientDirectory struct {
+	ids atomic.Pointer[map[string]map[int]struct{}]
+}
+
+func (d *clientDirectory) has(tenant string, id int) bool {
+	ids := d.ids.Load()
+	if ids == nil {
+		for _, client := range defaultClients {
+			if client.id == id {
+				return true
+			}
+		}
+		return false
+	}
+	_, ok := (*ids)[tenant][id]
+	return ok
+}
+
+// list devolve os ids do tenant em ordem crescente
+func (d *clientDirectory) list(tenant string) []int {
+	ids := d.ids.Load()
+	if ids == nil {
+		list := make([]int, 0, len(defaultClients))
+		for _, client := range defaultClients {
+			list = append(list, client.id)
+		}
+		return list
+	}
+	list := make([]int, 0, len((*ids)[tenant]))
+	for id := range (*ids)[tenant] {
+		list = append(list, id)
+	}
+	slices.Sort(list)
+	return list
+}
+
+// loadClients relê os ids de clientes de todos os tenants da App do contexto
+func loadClients(ctx context.Context) error {
+	app := appFrom(ctx)
+	loaded := map[string]map[int]struct{}{}
+	for _, ctx := range tenantContexts(ctx) {
+		ids, err := app.storage.ClientIDs(ctx)
+		if err != nil {
+			return err
+		}
+		set := make(map[int]struct{}, len(ids))
+		for _, id := range ids {
+			set[id] = struct{}{}
+		}
+		loaded[tenantFrom(ctx)] = set
+	}
+	app.clients.ids.Store(&loaded)
+	return nil
+}
+
+func runClientsRefresher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := loadClients(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Print("Error loading clients: ", err)
+			}
+		}
+	}
+}
+
+var errClienteNaoExiste = errors.New("Cliente não existe.")
+
+// clientExists diz se o cliente existe no tenant do contexto
+func clientExists(ctx context.Context, id int) error {
+	if appFrom(ctx).clients.has(tenantFrom(ctx), id) {
+		return nil
+	}
+	return errClienteNaoExiste
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClientExists(t *testing.T) {
+	app := sqliteTestApp(t)
+	ctx := withApp(context.Background(), app)
+	if err := clientExists(ctx, 5); err != nil {
+		t.Fatalf("client 5 before the first load: %v", err)
+	}
+
+	db := app.storage.(*sqliteStorage).db
+	if _, err := db.Exec("INSERT INTO clientes (id, nome, limite) VALUES (42, 'importado', 100)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientExists(ctx, 42); err == nil {
+		t.Fatal("client 42 exists before loading the clients")
+	}
+	if err := loadClients(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 5, 42} {
+		if err := clientExists(ctx, id); err != nil {
+			t.Errorf("client %d: %v", id, err)
+		}
+	}
+	for _, id := range []int{0, 6, 43} {
+		if err := clientExists(ctx, id); err == nil {
+			t.Errorf("client %d should not exist", id)
+		}
+	}
+}
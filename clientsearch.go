@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// clientSearchOrders são as ordenações aceitas em ?ordenar=, com - na frente
+// para a ordem decrescente. O id desempata todas elas, para que as páginas
+// não repitam nem pulem clientes.
+var clientSearchOrders = map[string]string{
+	"id":     "id",
+	"nome":   "lower(nome)",
+	"limite": "limite",
+	"saldo":  "saldo",
+}
+
+// clientSearchPageSize é o por_pagina padrão; o máximo, 100, fica na tag
+// de BuscaClientes
+const clientSearchPageSize = 20
+
+// BuscaClientes são os parâmetros de consulta de GET /clientes
+type BuscaClientes struct {
+	Nome      string    `query:"nome"`
+	Documento string    `query:"documento"`
+	LimiteMin *Centavos `query:"limite_min"`
+	SaldoMax  *Centavos `query:"saldo_max"`
+	Ordenar   string    `query:"ordenar"`
+	Page      *int      `query:"page" validate:"omitempty,min=1"`
+	PorPagina *int      `query:"por_pagina" validate:"omitempty,min=1,max=100"`
+}
+
+// handleSearchClients busca clientes para os operadores, com os filtros
+// ?nome= (prefixo, sem diferenciar maiúsculas), ?documento=, ?limite_min= e
+// ?saldo_max= (em centavos), paginados por ?page= e ?por_pagina=. O total de
+// clientes encontrados vai em X-Total-Count. nome e documento usam os índices
+// de clientes; saldo não é indexado para não impedir os HOT updates da
+// escrita, e saldo_max filtra sobre os clientes que sobram.
+func handleSearchClients(c fiber.Ctx) error {
+	params := BuscaClientes{Ordenar: "id"}
+	if err := bindQuery(c, &params); err != nil {
+		return sendQueryError(c, err)
+	}
+
+	var query strings.Builder
+	var args []any
+	query.WriteString(" FROM clientes WHERE TRUE")
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		query.WriteString(" AND ")
+		query.WriteString(strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	if params.Nome != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(params.Nome))
+		addCondition("lower(nome) LIKE ?", escaped+"%")
+	}
+	if params.Documento != "" {
+		addCondition("documento = ?", normalizeDocument(params.Documento))
+	}
+	if params.LimiteMin != nil {
+		addCondition("limite >= ?", *params.LimiteMin)
+	}
+	if params.SaldoMax != nil {
+		addCondition("saldo <= ?", *params.SaldoMax)
+	}
+
+	order, descending := strings.CutPrefix(params.Ordenar, "-")
+	column, ok := clientSearchOrders[order]
+	if !ok {
+		return sendProblem(c, Problem{
+			Status: fiber.StatusBadRequest,
+			Codigo: "ORDENACAO_INVALIDA",
+			Detail: "ordenar deve ser id, nome, limite ou saldo, com - para a ordem decrescente",
+		})
+	}
+	direction := " ASC"
+	if descending {
+		direction = " DESC"
+	}
+
+	page, pageSize := 1, clientSearchPageSize
+	if params.Page != nil {
+		page = *params.Page
+	}
+	if params.PorPagina != nil {
+		pageSize = *params.PorPagina
+	}
+
+	// a contagem e a página vêm do mesmo snapshot
+	ctx := c.UserContext()
+	tx, err := poolFor(ctx).BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	defer tx.Rollback(ctx)
+
+	var total int64
+	if err := tx.QueryRow(ctx, "SELECT COUNT(*)"+query.String(), args...).Scan(&total); err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+
+	query.WriteString(" ORDER BY " + column + direction + ", id" + direction)
+	query.WriteString(" LIMIT " + strconv.Itoa(pageSize) + " OFFSET " + strconv.Itoa((page-1)*pageSize))
+	rows, err := tx.Query(ctx, "SELECT id, nome, documento, email, limite, criado_em, saldo"+query.String(), args...)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	clients, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ClienteEncontrado, error) {
+		var client ClienteEncontrado
+		err := row.Scan(&client.ID, &client.Nome, &client.Documento, &client.Email, &client.Limite, &client.CriadoEm, &client.Saldo)
+		return client, err
+	})
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+
+	c.Set("X-Total-Count", strconv.FormatInt(total, 10))
+	return c.JSON(clients)
+}
+
+// ClienteEncontrado é um resultado da busca de clientes: o perfil e o saldo
+type ClienteEncontrado struct {
+	PerfilCliente
+	Saldo Centavos `json:"saldo"`
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Clock é a fonte do instante atual dos handlers e dos jobs de domínio. As
+// medições de duração, os prazos e a manutenção (partições, backups, eleição
+// de líder) continuam com time.Now. O NOW() do Postgres não passa por aqui.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock é o relógio do sistema; com utc, Now devolve o instante em UTC
+// (RELOGIO_UTC, padrão), como o banco grava os timestamps
+type systemClock struct {
+	utc bool
+}
+
+func (c systemClock) Now() time.Time {
+	if c.utc {
+		return time.Now().UTC()
+	}
+	return time.Now()
+}
+
+// fixedClock devolve sempre o mesmo instante, para que o extrato e os
+// demais timestamps sejam reproduzíveis em testes (RELOGIO_FIXO, RFC 3339)
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+// newClock monta o relógio de RELOGIO_UTC e RELOGIO_FIXO; os testes passam
+// um fixedClock direto para newApp
+func newClock() Clock {
+	utc := getEnvBool("RELOGIO_UTC", true)
+	if value := getEnv("RELOGIO_FIXO", ""); value != "" {
+		now, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			log.Print("Invalid RELOGIO_FIXO, using the system clock: ", err)
+			return systemClock{utc: utc}
+		}
+		if utc {
+			now = now.UTC()
+		}
+		return fixedClock{now: now}
+	}
+	return systemClock{utc: utc}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock é um relógio que o teste adianta com Set
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+func TestStatementTimestamps(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 23, 59, 59, 500000000, time.UTC)
+	clock := &fakeClock{now: start}
+	httpClient, baseURL := testClient(sqliteTestAppWithClock(t, clock), httpStackFiber)
+	get := func(path string, out any) {
+		t.Helper()
+		resp, err := httpClient.Get(baseURL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+	credit := func(descricao string) {
+		t.Helper()
+		body := strings.NewReader(`{"valor": 100, "tipo": "c", "descricao": "` + descricao + `"}`)
+		resp, err := httpClient.Post(baseURL+"/clientes/1/transacoes", "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST status %d", resp.StatusCode)
+		}
+	}
+
+	credit("janeiro")
+	next := start.Add(time.Second)
+	clock.Set(next)
+	credit("fevereiro")
+
+	var statement struct {
+		Saldo struct {
+			DataExtrato time.Time `json:"data_extrato"`
+		} `json:"saldo"`
+		UltimasTransacoes []struct {
+			Descricao   string    `json:"descricao"`
+			RealizadaEm time.Time `json:"realizada_em"`
+		} `json:"ultimas_transacoes"`
+	}
+	later := next.Add(time.Hour)
+	clock.Set(later)
+	get("/clientes/1/extrato", &statement)
+	if !statement.Saldo.DataExtrato.Equal(later) {
+		t.Errorf("data_extrato %s, want %s", statement.Saldo.DataExtrato, later)
+	}
+	want := []struct {
+		descricao   string
+		realizadaEm time.Time
+	}{{"fevereiro", next}, {"janeiro", start}}
+	if len(statement.UltimasTransacoes) != len(want) {
+		t.Fatalf("%d transactions, want %d", len(statement.UltimasTransacoes), len(want))
+	}
+	for i, transaction := range statement.UltimasTransacoes {
+		if transaction.Descricao != want[i].descricao || !transaction.RealizadaEm.Equal(want[i].realizadaEm) {
+			t.Errorf("transaction %d: %s at %s, want %s at %s",
+				i, transaction.Descricao, transaction.RealizadaEm, want[i].descricao, want[i].realizadaEm)
+		}
+	}
+
+	// o resumo sem periodo é o do mês do relógio, e o crédito de meio
+	// segundo antes da virada fica em janeiro
+	summaries := []struct {
+		query   string
+		periodo string
+	}{{"", "2024-02"}, {"?periodo=2024-01", "2024-01"}}
+	for _, test := range summaries {
+		var summary Resumo
+		get("/clientes/1/resumo"+test.query, &summary)
+		if summary.Periodo != test.periodo || summary.Quantidade != 1 || summary.Creditos != 100 {
+			t.Errorf("resumo%s: %+v, want one credit of 100 in %s", test.query, summary, test.periodo)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// Encerramento de conta (POST /clientes/:id/encerramento, só com o
+// Postgres), para os pedidos de eliminação da LGPD. O encerramento bloqueia
+// o cliente para novas transações, desativa os agendamentos e grava em
+// ENCERRAMENTO_PATH ou ENCERRAMENTO_S3_* uma exportação completa dos dados
+// (cadastro, saldo, transações, autorizações e agendamentos) em JSON
+// comprimido, para ser entregue ao titular; as transações já movidas pelo
+// arquivamento estão nos arquivos de ARQUIVO_PATH. Passada a retenção
+// (ENCERRAMENTO_RETENCAO), o job de anonimização apaga nome, documento,
+// email e o metadata das transações; os valores continuam, para os
+// registros financeiros. Repetir o pedido devolve o encerramento existente e
+// refaz a exportação se ela tiver falhado.
+
+var ErrExportacaoIndisponivel = errors.New("destino da exportação não configurado")
+
+// anonymizedName substitui o nome dos clientes anonimizados
+const anonymizedName = "anonimizado"
+
+// Encerramento é o corpo de POST e GET /clientes/:id/encerramento
+type Encerramento struct {
+	ClienteID     int        `json:"cliente_id"`
+	SolicitadoEm  Timestamp  `json:"solicitado_em"`
+	AnonimizarEm  Timestamp  `json:"anonimizar_em"`
+	AnonimizadoEm *Timestamp `json:"anonimizado_em,omitempty"`
+	// Exportacao é o local do arquivo; Link, um link temporário para ele
+	// quando o destino é o S3
+	Exportacao string `json:"exportacao,omitempty"`
+	Link       string `json:"link,omitempty"`
+}
+
+// ExportacaoCliente é o conteúdo do arquivo de exportação
+type ExportacaoCliente struct {
+	GeradoEm     Timestamp     `json:"gerado_em"`
+	Perfil       PerfilCliente `json:"perfil"`
+	Saldo        Balance       `json:"saldo"`
+	Transacoes   []Transacao   `json:"transacoes"`
+	Autorizacoes []Autorizacao `json:"autorizacoes"`
+	Agendamentos []Agendamento `json:"agendamentos"`
+}
+
+func handleCloseAccount(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	store, err := newBlobStore("ENCERRAMENTO")
+	if err != nil {
+		return sendProblem(c, Problem{
+			Status: fiber.StatusServiceUnavailable,
+			Codigo: "EXPORTACAO_INDISPONIVEL",
+			Detail: ErrExportacaoIndisponivel.Error(),
+		})
+	}
+
+	ctx := c.UserContext()
+	closure, created, err := closeAccount(ctx, clientId)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	statements.Invalidate(statementClient{tenant: tenantFrom(ctx), clientId: clientId})
+
+	if closure.Exportacao == "" {
+		key, err := exportClientData(ctx, store, clientId)
+		if err != nil {
+			log.Printf("Error exporting data of client %d: %v", clientId, err)
+			return c.SendStatus(fiber.ErrInternalServerError.Code)
+		}
+		closure.Exportacao = store.Location(key)
+		_, err = poolFor(ctx).Exec(ctx, `
+			UPDATE encerramentos SET exportacao = $2 WHERE cliente_id = $1`, clientId, closure.Exportacao)
+		if err != nil {
+			return c.SendStatus(fiber.ErrInternalServerError.Code)
+		}
+	}
+	closureLink(ctx, store, &closure)
+
+	if created {
+		c.Status(fiber.StatusCreated)
+	}
+	return c.JSON(closure)
+}
+
+func handleGetClosure(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	ctx := c.UserContext()
+	closure, err := getClosure(ctx, poolFor(ctx), clientId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if store, err := newBlobStore("ENCERRAMENTO"); err == nil {
+		closureLink(ctx, store, &closure)
+	}
+	return c.JSON(closure)
+}
+
+// closeAccount registra o encerramento, se ainda não houver um, e bloqueia o
+// cliente; created diz se o encerramento é novo
+func closeAccount(ctx context.Context, clientId int) (Encerramento, bool, error) {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return Encerramento{}, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := nowFor(ctx).UTC()
+	retention := getEnvDuration("ENCERRAMENTO_RETENCAO", 30*24*time.Hour)
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO encerramentos (cliente_id, solicitado_em, anonimizar_em)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (cliente_id) DO NOTHING`, clientId, now, now.Add(retention))
+	if err != nil {
+		return Encerramento{}, false, err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE clientes SET bloqueado = TRUE WHERE id = $1", clientId); err != nil {
+		return Encerramento{}, false, err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE agendamentos SET ativo = FALSE WHERE cliente_id = $1 AND ativo", clientId); err != nil {
+		return Encerramento{}, false, err
+	}
+
+	closure, err := getClosure(ctx, tx, clientId)
+	if err != nil {
+		return closure, false, err
+	}
+	return closure, tag.RowsAffected() == 1, tx.Commit(ctx)
+}
+
+func getClosure(ctx context.Context, db dbtx, clientId int) (Encerramento, error) {
+	closure := Encerramento{ClienteID: clientId}
+	var anonymized *time.Time
+	var location *string
+	err := db.QueryRow(ctx, `
+		SELECT solicitado_em, anonimizar_em, anonimizado_em, exportacao
+		FROM encerramentos WHERE cliente_id = $1`, clientId).
+		Scan(&closure.SolicitadoEm.Time, &closure.AnonimizarEm.Time, &anonymized, &location)
+	if anonymized != nil {
+		closure.AnonimizadoEm = &Timestamp{*anonymized}
+	}
+	if location != nil {
+		closure.Exportacao = *location
+	}
+	return closure, err
+}
+
+// closureLink preenche o link temporário da exportação quando o destino
+// gera links pré-assinados
+func closureLink(ctx context.Context, store blobStore, closure *Encerramento) {
+	presigner, ok := store.(blobPresigner)
+	if !ok || closure.Exportacao == "" {
+		return
+	}
+	link, err := presigner.PresignedURL(ctx, closureKey(ctx, closure.ClienteID), getEnvDuration("ENCERRAMENTO_LINK_TTL", 24*time.Hour))
+	if err != nil {
+		log.Printf("Error signing export link of client %d: %v", closure.ClienteID, err)
+		return
+	}
+	closure.Link = link
+}
+
+// closureKey é o nome do arquivo de exportação do cliente; uma nova
+// exportação sobrescreve a anterior
+func closureKey(ctx context.Context, clientId int) string {
+	return tenantKeyPrefix(ctx) + "encerramentos/cliente-" + strconv.Itoa(clientId) + ".json.gz"
+}
+
+// exportClientData lê os dados do cliente num único snapshot e grava a
+// exportação, devolvendo a chave do arquivo
+func exportClientData(ctx context.Context, store blobStore, clientId int) (string, error) {
+	tx, err := poolFor(ctx).BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	export := ExportacaoCliente{GeradoEm: Timestamp{nowFor(ctx).UTC()}}
+	if export.Perfil, err = getClient(ctx, tx, clientId); err != nil {
+		return "", err
+	}
+	if export.Saldo, err = getBalance(ctx, tx, clientId); err != nil {
+		return "", err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, valor, tipo, descricao, categoria, realizada_em, saldo_apos, seq, estorno_de, NULLIF(valor_estornado, 0), metadata
+		FROM transacoes WHERE cliente_id = $1 ORDER BY realizada_em, seq`, clientId)
+	if err != nil {
+		return "", err
+	}
+	if export.Transacoes, err = pgx.CollectRows(rows, scanTransaction); err != nil {
+		return "", err
+	}
+
+	rows, err = tx.Query(ctx, `
+		SELECT `+authorizationColumns+` FROM autorizacoes WHERE cliente_id = $1 ORDER BY id`, clientId)
+	if err != nil {
+		return "", err
+	}
+	export.Autorizacoes, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Autorizacao, error) {
+		var authorization Autorizacao
+		err := scanAuthorization(row, &authorization)
+		return authorization, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	rows, err = tx.Query(ctx, `
+		SELECT id, valor, tipo, descricao, COALESCE(categoria, ''), COALESCE(recorrencia, ''), proxima_execucao, ativo,
+			parcelamento_id, parcela
+		FROM agendamentos WHERE cliente_id = $1 ORDER BY id`, clientId)
+	if err != nil {
+		return "", err
+	}
+	export.Agendamentos, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Agendamento, error) {
+		var schedule Agendamento
+		err := row.Scan(&schedule.ID, &schedule.Valor, &schedule.Tipo, &schedule.Descricao, &schedule.Categoria,
+			&schedule.Recorrencia, &schedule.ProximaExecucao, &schedule.Ativo, &schedule.ParcelamentoID, &schedule.Parcela)
+		return schedule, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(export); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	key := closureKey(ctx, clientId)
+	return key, store.Put(ctx, key, &body, int64(body.Len()), "application/gzip")
+}
+
+// runAnonymizer anonimiza, a cada interval, os clientes encerrados cuja
+// retenção terminou
+func runAnonymizer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			anonymized, err := anonymizeClosedClients(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Print("Error anonymizing closed clients: ", err)
+			}
+			if anonymized > 0 {
+				log.Printf("Anonymized %d closed clients", anonymized)
+			}
+		}
+	}
+}
+
+func anonymizeClosedClients(ctx context.Context) (int, error) {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := nowFor(ctx).UTC()
+	rows, err := tx.Query(ctx, `
+		UPDATE encerramentos SET anonimizado_em = $1
+		WHERE anonimizar_em <= $1 AND anonimizado_em IS NULL
+		RETURNING cliente_id`, now)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE clientes SET nome = $2, documento = NULL, email = NULL
+		WHERE id = ANY($1)`, ids, anonymizedName)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE transacoes SET metadata = NULL
+		WHERE cliente_id = ANY($1) AND metadata IS NOT NULL`, ids)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		statements.Invalidate(statementClient{tenant: tenantFrom(ctx), clientId: id})
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,135 @@
+// Comando e2e sobe a topologia do docker-compose.yml (as duas instâncias da
+// API, o balanceador e o Postgres), roda as verificações de contrato e de
+// concorrência do pacote spec contra o balanceador e derruba tudo no fim,
+// com os volumes:
+//
+//	go run ./cmd/e2e
+//
+// Precisa do docker com o plugin compose. Com -keep, a pilha continua de pé
+// depois da execução, para investigar uma falha; as últimas linhas dos logs
+// dos serviços são impressas sempre que algo falha.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"time"
+
+	"rinha-de-backend-2024-q1/spec"
+)
+
+func main() {
+	composeFile := flag.String("compose", "docker-compose.yml", "compose file describing the stack")
+	project := flag.String("project", "rinha-e2e", "compose project name")
+	url := flag.String("url", "http://localhost:9999", "load balancer URL the checks run against")
+	wait := flag.Duration("wait", 3*time.Minute, "how long to wait for the stack to become ready")
+	timeout := flag.Duration("timeout", 2*time.Minute, "timeout for the checks")
+	workers := flag.Int("concorrencia", 20, "parallel writers in the concurrency check; 0 skips it")
+	keep := flag.Bool("keep", false, "leave the stack running after the checks")
+	flag.Parse()
+
+	// Ctrl-C interrompe as verificações, mas a pilha ainda é derrubada
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	stack := composeStack{file: *composeFile, project: *project}
+	err := run(ctx, stack, *url, *wait, *timeout, *workers)
+	if err != nil {
+		stack.logs()
+	}
+	if !*keep {
+		if err := stack.compose(context.Background(), "down", "-v", "--remove-orphans"); err != nil {
+			log.Print("Error tearing down the stack: ", err)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, stack composeStack, url string, wait, timeout time.Duration, workers int) error {
+	log.Printf("Starting %s as project %s", stack.file, stack.project)
+	if err := stack.compose(ctx, "up", "-d", "--build"); err != nil {
+		return fmt.Errorf("starting the stack: %w", err)
+	}
+	if err := waitReady(ctx, url, wait); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	results := spec.Run(ctx, url, 1)
+	if workers > 0 {
+		results = append(results, spec.RunConcurrency(ctx, url, 2, workers, 25)...)
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", result.Name, result.Err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", result.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// waitReady espera o extrato responder 200 pelo balanceador duas vezes
+// seguidas, uma para cada instância atrás dele
+func waitReady(ctx context.Context, url string, wait time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	httpClient := &http.Client{Timeout: 2 * time.Second}
+	ready := 0
+	for ready < 2 {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/clientes/1/extrato", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := httpClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if err == nil && resp.StatusCode == http.StatusOK {
+			ready++
+			continue
+		}
+		ready = 0
+		select {
+		case <-ctx.Done():
+			return errors.New("stack not ready after " + wait.String())
+		case <-time.After(time.Second):
+		}
+	}
+	log.Print("Stack is ready")
+	return nil
+}
+
+type composeStack struct {
+	file    string
+	project string
+}
+
+func (s composeStack) compose(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "docker", append([]string{"compose", "-f", s.file, "-p", s.project}, args...)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// logs imprime o fim dos logs de cada serviço
+func (s composeStack) logs() {
+	if err := s.compose(context.Background(), "logs", "--tail", "50"); err != nil {
+		log.Print("Error reading the stack logs: ", err)
+	}
+}
@@ -0,0 +1,256 @@
+// Comando loadgen gera carga parecida com a do teste da rinha (créditos,
+// débitos e extratos dos cinco clientes) contra a API e imprime a vazão e
+// os status a cada -report:
+//
+//	go run ./cmd/loadgen -url http://localhost:9999 -requests 100000
+//
+// Com -soak, é o teste de vazamento: depois de -warmup requisições, e de
+// novo ao fim da carga e de -settle de repouso, pede à instância uma
+// amostra pós-GC (POST /admin/memoria/amostra, com o token de -token) e
+// falha se as goroutines cresceram mais que -goroutines-tolerance ou o
+// heap_alloc mais que a fração -heap-tolerance. Atrás de um balanceador, a
+// amostra é de uma das instâncias; para um resultado por instância, aponte
+// -url para ela. Um soak longo é -soak -requests 1000000.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type options struct {
+	url         string
+	requests    int64
+	workers     int
+	report      time.Duration
+	soak        bool
+	token       string
+	warmup      int64
+	settle      time.Duration
+	goroutines  int
+	heapAllowed float64
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.url, "url", "http://localhost:9999", "base URL of the API")
+	flag.Int64Var(&o.requests, "requests", 10000, "total requests")
+	flag.IntVar(&o.workers, "concorrencia", 50, "concurrent connections")
+	flag.DurationVar(&o.report, "report", 10*time.Second, "progress report interval")
+	flag.BoolVar(&o.soak, "soak", false, "check goroutine and heap growth on the instance after the load")
+	flag.StringVar(&o.token, "token", os.Getenv("ADMIN_TOKEN"), "admin token used by -soak")
+	flag.Int64Var(&o.warmup, "warmup", 1000, "requests before the -soak baseline")
+	flag.DurationVar(&o.settle, "settle", 10*time.Second, "idle time before the final -soak sample")
+	flag.IntVar(&o.goroutines, "goroutines-tolerance", 50, "goroutines the instance may gain during -soak")
+	flag.Float64Var(&o.heapAllowed, "heap-tolerance", 0.5, "fraction heap_alloc may grow during -soak")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, o); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type generator struct {
+	url      string
+	http     *http.Client
+	sent     atomic.Int64
+	failures atomic.Int64
+	statuses [600]atomic.Int64
+}
+
+func run(ctx context.Context, o options) error {
+	g := &generator{
+		url: o.url,
+		http: &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: &http.Transport{MaxIdleConnsPerHost: o.workers},
+		},
+	}
+
+	var baseline sample
+	if o.soak {
+		log.Printf("Warming up with %d requests", o.warmup)
+		g.load(ctx, o.warmup, o.workers, 0)
+		var err error
+		if baseline, err = g.sample(ctx, o.token); err != nil {
+			return fmt.Errorf("taking the baseline sample: %w", err)
+		}
+		log.Printf("Baseline: %s", baseline)
+		g.reset()
+	}
+
+	start := time.Now()
+	g.load(ctx, o.requests, o.workers, o.report)
+	elapsed := time.Since(start)
+	log.Printf("Sent %d requests in %s (%.0f req/s), %d failed to connect",
+		g.sent.Load(), elapsed.Round(time.Millisecond), float64(g.sent.Load())/elapsed.Seconds(), g.failures.Load())
+	g.printStatuses()
+	if ctx.Err() != nil || !o.soak {
+		return ctx.Err()
+	}
+
+	log.Printf("Load finished; waiting %s before the final sample", o.settle)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(o.settle):
+	}
+	final, err := g.sample(ctx, o.token)
+	if err != nil {
+		return fmt.Errorf("taking the final sample: %w", err)
+	}
+	log.Printf("Final:    %s", final)
+
+	var leaks []error
+	if grown := final.Goroutines - baseline.Goroutines; grown > o.goroutines {
+		leaks = append(leaks, fmt.Errorf("goroutines grew by %d (tolerance %d)", grown, o.goroutines))
+	}
+	if limit := float64(baseline.HeapAlloc) * (1 + o.heapAllowed); float64(final.HeapAlloc) > limit {
+		leaks = append(leaks, fmt.Errorf("heap_alloc grew from %d to %d bytes (tolerance %.0f%%)",
+			baseline.HeapAlloc, final.HeapAlloc, o.heapAllowed*100))
+	}
+	if err := errors.Join(leaks...); err != nil {
+		return fmt.Errorf("possible leak: %w", err)
+	}
+	log.Print("No leak detected")
+	return nil
+}
+
+// load envia requests requisições com workers em paralelo; com report, imprime
+// o progresso a cada report
+func (g *generator) load(ctx context.Context, requests int64, workers int, report time.Duration) {
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && next.Add(1) <= requests {
+				g.request(ctx)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	if report <= 0 {
+		<-done
+		return
+	}
+	ticker := time.NewTicker(report)
+	defer ticker.Stop()
+	last := g.sent.Load()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			sent := g.sent.Load()
+			log.Printf("%d/%d requests, %.0f req/s", min(next.Load(), requests), requests, float64(sent-last)/report.Seconds())
+			last = sent
+		}
+	}
+}
+
+// request envia uma requisição da mistura da rinha: 40% créditos, 40%
+// débitos e 20% extratos, de um cliente sorteado
+func (g *generator) request(ctx context.Context) {
+	clientID := strconv.Itoa(rand.IntN(5) + 1)
+	var req *http.Request
+	var err error
+	switch n := rand.IntN(10); {
+	case n < 8:
+		tipo := "c"
+		if n%2 == 1 {
+			tipo = "d"
+		}
+		body := fmt.Sprintf(`{"valor": %d, "tipo": "%s", "descricao": "loadgen"}`, rand.IntN(10000)+1, tipo)
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, g.url+"/clientes/"+clientID+"/transacoes", bytes.NewBufferString(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	default:
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, g.url+"/clientes/"+clientID+"/extrato", nil)
+	}
+	if err != nil {
+		g.failures.Add(1)
+		return
+	}
+
+	g.sent.Add(1)
+	resp, err := g.http.Do(req)
+	if err != nil {
+		g.failures.Add(1)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < len(g.statuses) {
+		g.statuses[resp.StatusCode].Add(1)
+	}
+}
+
+// reset zera os contadores, para que o relatório não inclua o aquecimento
+func (g *generator) reset() {
+	g.sent.Store(0)
+	g.failures.Store(0)
+	for status := range g.statuses {
+		g.statuses[status].Store(0)
+	}
+}
+
+func (g *generator) printStatuses() {
+	for status := range g.statuses {
+		if count := g.statuses[status].Load(); count > 0 {
+			fmt.Printf("  %d: %d\n", status, count)
+		}
+	}
+}
+
+// sample é a resposta de POST /admin/memoria/amostra
+type sample struct {
+	Goroutines  int    `json:"goroutines"`
+	HeapAlloc   uint64 `json:"heap_alloc"`
+	HeapObjects uint64 `json:"heap_objects"`
+}
+
+func (s sample) String() string {
+	return fmt.Sprintf("%d goroutines, heap_alloc %d bytes, %d heap objects", s.Goroutines, s.HeapAlloc, s.HeapObjects)
+}
+
+func (g *generator) sample(ctx context.Context, token string) (sample, error) {
+	var s sample
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url+"/admin/memoria/amostra", nil)
+	if err != nil {
+		return s, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := g.http.Do(req)
+	if err != nil {
+		return s, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return s, json.NewDecoder(resp.Body).Decode(&s)
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"rinha-de-backend-2024-q1/pg"
+)
+
+// Estratégias de concorrência para aplicar transações ao saldo do cliente,
+// selecionadas por CONCURRENCY_MODE.
+const (
+	// concurrencyTrigger delega a validação e a atualização do saldo ao
+	// gatilho reconcile_amount_trigger.
+	concurrencyTrigger = "trigger"
+	// concurrencyCTE valida o limite, atualiza o saldo e registra a transação
+	// em uma única instrução (insertTransactionCTE), sem passar pelo gatilho;
+	// compare os dois com BenchmarkInsertTransaction.
+	concurrencyCTE = "cte"
+	// concurrencyOptimistic lê o saldo e a versão do cliente e aplica a
+	// atualização com UPDATE ... WHERE versao = $n, repetindo em caso de conflito.
+	concurrencyOptimistic = "optimistic"
+	// concurrencyForUpdate bloqueia a linha do cliente com SELECT ... FOR UPDATE
+	// antes de validar e aplicar a transação.
+	concurrencyForUpdate = "forupdate"
+	// concurrencyAdvisory serializa as escritas de cada cliente com
+	// pg_advisory_xact_lock(schema, cliente_id), sem bloquear a linha em
+	// clientes para os leitores do extrato.
+	concurrencyAdvisory = "advisory"
+	// concurrencyEventSourcing trata transacoes como fonte da verdade e
+	// clientes.saldo como projeção mantida pelo projetor (eventsourcing.go).
+	concurrencyEventSourcing = "eventsourcing"
+	// concurrencyCheck aplica a transação sem validar o limite e deixa a
+	// constraint saldo_dentro_do_limite de clientes recusar o saldo
+	// inválido (insertTransactionCheck).
+	concurrencyCheck = "check"
+)
+
+// balanceConstraint é a constraint CHECK de clientes com a regra do limite
+const balanceConstraint = "saldo_dentro_do_limite"
+
+var ErrConflitoConcorrencia = errors.New("conflito de concorrência: tentativas esgotadas")
+
+var (
+	concurrencyMode       = concurrencyTrigger
+	optimisticMaxRetries  atomic.Int64
+	optimisticConflicts   = expvar.NewInt("optimistic_conflicts")
+	optimisticExhaustions = expvar.NewInt("optimistic_retries_exhausted")
+	lockWait              = expvar.NewMap("lock_wait")
+)
+
+func configureConcurrency() error {
+	mode := getEnv("CONCURRENCY_MODE", concurrencyTrigger)
+	switch mode {
+	case concurrencyTrigger, concurrencyCTE, concurrencyOptimistic, concurrencyForUpdate, concurrencyAdvisory, concurrencyEventSourcing, concurrencyCheck:
+		concurrencyMode = mode
+	default:
+		return fmt.Errorf("unknown CONCURRENCY_MODE %q", mode)
+	}
+	return nil
+}
+
+func init() {
+	onReload(func() {
+		optimisticMaxRetries.Store(int64(getEnvInt("OPTIMISTIC_MAX_RETRIES", 5)))
+	})
+}
+
+// recordLockWait acumula o tempo gasto esperando pelo bloqueio do cliente,
+// exposto em /debug/vars para comparar as estratégias.
+func recordLockWait(mode string, d time.Duration) {
+	lockWait.Add(mode+"_count", 1)
+	lockWait.Add(mode+"_total_ns", d.Nanoseconds())
+}
+
+// skipReconcileTrigger faz o gatilho ignorar os inserts da transação atual,
+// para as estratégias que atualizam o saldo na própria aplicação.
+func skipReconcileTrigger(ctx context.Context, db dbtx) error {
+	return pg.New(db).SkipReconcileTrigger(ctx)
+}
+
+// insertTransactionCTE faz em uma ida ao banco o que o modo trigger faz em
+// duas (o insert com o gatilho e a leitura do saldo): o UPDATE só atinge o
+// cliente se o limite permitir, e o INSERT é alimentado pela linha que ele
+// devolveu. Sem linha, o limite foi excedido, já que clientIdParam garante
+// que o cliente existe. O set_config em aplicado desliga o gatilho de
+// reconciliação para o insert da mesma instrução.
+func insertTransactionCTE(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	var balance Balance
+	delta := transaction.Valor
+	if transaction.Tipo == "d" {
+		delta = -delta
+	}
+
+	row, err := pg.New(db).InsertTransactionCTE(ctx, pg.InsertTransactionCTEParams{
+		Delta:         int64(delta),
+		ClienteID:     int32(clientId),
+		Valor:         int64(transaction.Valor),
+		Tipo:          string(transaction.Tipo),
+		Descricao:     transaction.Descricao,
+		Categoria:     transaction.Categoria,
+		FraudeDecisao: transaction.FraudeDecisao,
+		FraudeRegra:   transaction.FraudeRegra,
+		Metadata:      transaction.Metadata,
+	})
+	transaction.ID, balance.Saldo, balance.Limite = int64(row.ID), Centavos(row.Saldo), Centavos(row.Limite)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return balance, ErrLimiteExcedido
+	}
+	// saldo + delta fora do intervalo de BIGINT
+	if isPgError(err, "22003") {
+		return balance, ErrValorOverflow
+	}
+	return balance, err
+}
+
+// insertTransactionCheck é o insertTransactionCTE sem a condição do limite
+// no UPDATE: o saldo é sempre atualizado e a violação de
+// saldo_dentro_do_limite (23514) vira ErrLimiteExcedido. A linha do cliente
+// fica bloqueada pelo UPDATE até o commit, e a constraint é verificada
+// sobre o saldo já atualizado, então débitos concorrentes não passam do
+// limite juntos. Como o erro aborta a transação em que a instrução roda,
+// quem chama não pode continuar usando-a depois da recusa.
+func insertTransactionCheck(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	var balance Balance
+	delta := transaction.Valor
+	if transaction.Tipo == "d" {
+		delta = -delta
+	}
+
+	row, err := pg.New(db).InsertTransactionCheck(ctx, pg.InsertTransactionCheckParams{
+		Delta:         int64(delta),
+		ClienteID:     int32(clientId),
+		Valor:         int64(transaction.Valor),
+		Tipo:          string(transaction.Tipo),
+		Descricao:     transaction.Descricao,
+		Categoria:     transaction.Categoria,
+		FraudeDecisao: transaction.FraudeDecisao,
+		FraudeRegra:   transaction.FraudeRegra,
+		Metadata:      transaction.Metadata,
+	})
+	transaction.ID, balance.Saldo, balance.Limite = int64(row.ID), Centavos(row.Saldo), Centavos(row.Limite)
+	if isBalanceConstraintViolation(err) {
+		return balance, ErrLimiteExcedido
+	}
+	if isPgError(err, "22003") {
+		return balance, ErrValorOverflow
+	}
+	return balance, err
+}
+
+// isBalanceConstraintViolation diz se err é a recusa de saldo_dentro_do_limite
+func isBalanceConstraintViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23514" && pgErr.ConstraintName == balanceConstraint
+}
+
+func insertTransactionOptimistic(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	maxRetries := int(optimisticMaxRetries.Load())
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if attempt > 0 {
+			optimisticConflicts.Add(1)
+			// a espera termina com a requisição, cancelada pelo routeTimeout
+			timer := time.NewTimer(time.Duration(attempt) * time.Millisecond)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return Balance{}, ctx.Err()
+			}
+		}
+
+		balance, applied, err := tryOptimisticUpdate(ctx, db, clientId, transaction)
+		if err != nil || applied {
+			return balance, err
+		}
+	}
+	optimisticExhaustions.Add(1)
+	return Balance{}, ErrConflitoConcorrencia
+}
+
+func tryOptimisticUpdate(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, bool, error) {
+	var balance Balance
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return balance, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	queries := pg.New(tx)
+	current, err := queries.GetVersionedBalance(ctx, int32(clientId))
+	if err != nil {
+		return balance, false, err
+	}
+	balance = Balance{Saldo: Centavos(current.Saldo), Limite: Centavos(current.Limite), Reservado: Centavos(current.Reservado)}
+
+	balance.Saldo, err = applyToBalance(balance, transaction)
+	if err != nil {
+		return balance, false, err
+	}
+
+	seq, err := queries.SetVersionedBalance(ctx, pg.SetVersionedBalanceParams{
+		Saldo:  int64(balance.Saldo),
+		ID:     int32(clientId),
+		Versao: current.Versao,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return balance, false, nil
+	}
+	if err != nil {
+		return balance, false, err
+	}
+
+	if err := insertLedgerEntry(ctx, tx, clientId, transaction, balance.Saldo, seq); err != nil {
+		return balance, false, err
+	}
+	return balance, true, tx.Commit(ctx)
+}
+
+func insertTransactionForUpdate(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	var balance Balance
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return balance, err
+	}
+	defer tx.Rollback(ctx)
+
+	start := time.Now()
+	locked, err := pg.New(tx).LockReservedBalance(ctx, int32(clientId))
+	recordLockWait(concurrencyForUpdate, time.Since(start))
+	if err != nil {
+		return balance, err
+	}
+	balance = Balance{Saldo: Centavos(locked.Saldo), Limite: Centavos(locked.Limite), Reservado: Centavos(locked.Reservado)}
+
+	if err := applyAndRecord(ctx, tx, clientId, &balance, transaction); err != nil {
+		return balance, err
+	}
+	return balance, tx.Commit(ctx)
+}
+
+func insertTransactionAdvisory(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	var balance Balance
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return balance, err
+	}
+	defer tx.Rollback(ctx)
+
+	queries := pg.New(tx)
+	start := time.Now()
+	// o schema entra na chave porque os TENANTS dividem o mesmo banco, e o
+	// cliente 1 de um tenant não deve esperar pelo de outro
+	err = queries.LockClientAdvisory(ctx, int32(clientId))
+	recordLockWait(concurrencyAdvisory, time.Since(start))
+	if err != nil {
+		return balance, err
+	}
+
+	current, err := queries.GetReservedBalance(ctx, int32(clientId))
+	if err != nil {
+		return balance, err
+	}
+	balance = Balance{Saldo: Centavos(current.Saldo), Limite: Centavos(current.Limite), Reservado: Centavos(current.Reservado)}
+
+	if err := applyAndRecord(ctx, tx, clientId, &balance, transaction); err != nil {
+		return balance, err
+	}
+	return balance, tx.Commit(ctx)
+}
+
+// applyAndRecord aplica a transação ao saldo lido sob o bloqueio da
+// estratégia em uso e registra a entrada no extrato. O saldo lido recusa de
+// cara os débitos acima do limite, mas a escrita é relativa e refaz a
+// conferência: as reservas das autorizações e o ingest não tomam o bloqueio
+// da estratégia e podem ter mudado o cliente desde a leitura.
+func applyAndRecord(ctx context.Context, tx dbtx, clientId int, balance *Balance, transaction *TransacaoRequest) error {
+	if _, err := applyToBalance(*balance, transaction); err != nil {
+		return err
+	}
+	delta := transaction.Valor
+	if transaction.Tipo == "d" {
+		delta = -delta
+	}
+
+	applied, err := pg.New(tx).ApplyBalanceDelta(ctx, pg.ApplyBalanceDeltaParams{
+		Delta: int64(delta),
+		ID:    int32(clientId),
+	})
+	if errors.Is(err, pgx.ErrNoRows) || isBalanceConstraintViolation(err) {
+		return ErrLimiteExcedido
+	}
+	if isPgError(err, "22003") {
+		return ErrValorOverflow
+	}
+	if err != nil {
+		return err
+	}
+	balance.Saldo = Centavos(applied.Saldo)
+	return insertLedgerEntry(ctx, tx, clientId, transaction, balance.Saldo, applied.UltimaSeq)
+}
+
+// applyToBalance calcula o novo saldo após a transação, validando o limite
+// menos o que está reservado por autorizações
+func applyToBalance(balance Balance, transaction *TransacaoRequest) (Centavos, error) {
+	var saldo Centavos
+	var err error
+	if transaction.Tipo == "d" {
+		saldo, err = balance.Saldo.Sub(transaction.Valor)
+	} else {
+		saldo, err = balance.Saldo.Add(transaction.Valor)
+	}
+	if err != nil {
+		return balance.Saldo, err
+	}
+	if saldo < balance.Reservado-balance.Limite {
+		return balance.Saldo, ErrLimiteExcedido
+	}
+	return saldo, nil
+}
+
+// insertLedgerEntry registra a transação sem que o gatilho altere o saldo,
+// que já foi atualizado pela estratégia em uso e resultou em saldoApos e na
+// posição seq.
+func insertLedgerEntry(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest, saldoApos Centavos, seq int64) error {
+	if err := skipReconcileTrigger(ctx, db); err != nil {
+		return err
+	}
+	id, err := pg.New(db).InsertLedgerEntry(ctx, pg.InsertLedgerEntryParams{
+		Valor:         int64(transaction.Valor),
+		Tipo:          string(transaction.Tipo),
+		Descricao:     transaction.Descricao,
+		ClienteID:     int32(clientId),
+		Categoria:     transaction.Categoria,
+		SaldoApos:     int64(saldoApos),
+		Seq:           seq,
+		FraudeDecisao: transaction.FraudeDecisao,
+		FraudeRegra:   transaction.FraudeRegra,
+		Metadata:      transaction.Metadata,
+	})
+	transaction.ID = int64(id)
+	return err
+}
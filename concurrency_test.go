@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkInsertTransaction compara as estratégias de CONCURRENCY_MODE
+// (menos eventsourcing, que depende do projetor), com um escritor e com
+// escritores disputando o mesmo cliente. As tentativas esgotadas do modo
+// optimistic não falham o benchmark; aparecem em conflitos/op:
+//
+//	POSTGRES_HOST=localhost ... go test -run '^$' -bench InsertTransaction
+func BenchmarkInsertTransaction(b *testing.B) {
+	app := postgresTestApp(b)
+	ctx := withApp(context.Background(), app)
+	defer func(mode string) { concurrencyMode = mode }(concurrencyMode)
+
+	// créditos e débitos de mesmo valor alternados, para o saldo ficar perto
+	// de zero e nenhum débito esbarrar no limite
+	var conflicts atomic.Int64
+	write := func(b *testing.B, i int) {
+		tipo := TipoCredito
+		if i%2 == 1 {
+			tipo = TipoDebito
+		}
+		transaction := &TransacaoRequest{Valor: 1, Tipo: tipo, Descricao: "bench"}
+		_, err := insertTransaction(ctx, app.pool, 1, transaction)
+		if errors.Is(err, ErrConflitoConcorrencia) {
+			conflicts.Add(1)
+		} else if err != nil {
+			b.Error(err)
+		}
+	}
+	modes := []string{concurrencyTrigger, concurrencyCTE, concurrencyOptimistic, concurrencyForUpdate, concurrencyAdvisory, concurrencyCheck}
+	for _, mode := range modes {
+		concurrencyMode = mode
+		b.Run(mode, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				write(b, i)
+			}
+		})
+		b.Run(mode+"/paralelo", func(b *testing.B) {
+			conflicts.Store(0)
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					write(b, i)
+				}
+			})
+			b.ReportMetric(float64(conflicts.Load())/float64(b.N), "conflitos/op")
+		})
+	}
+}
+
+// TestDebitAfterConcurrentHold reserva quase todo o limite do cliente depois
+// que um débito já leu o saldo, como uma autorização que passa entre a
+// leitura e a escrita dos modos advisory e forupdate, e confere que o débito
+// é recusado como limite excedido
+func TestDebitAfterConcurrentHold(t *testing.T) {
+	app := postgresTestApp(t)
+	ctx := context.Background()
+
+	tx, err := app.pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	var balance Balance
+	err = tx.QueryRow(ctx, "SELECT saldo, limite, reservado FROM clientes WHERE id = 1").
+		Scan(&balance.Saldo, &balance.Limite, &balance.Reservado)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hold := balance.Saldo + balance.Limite - balance.Reservado
+	if _, err := app.pool.Exec(ctx, "UPDATE clientes SET reservado = reservado + $1 WHERE id = 1", hold); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		app.pool.Exec(context.Background(), "UPDATE clientes SET reservado = reservado - $1 WHERE id = 1", hold)
+	})
+
+	debit := &TransacaoRequest{Valor: 1, Tipo: TipoDebito, Descricao: "reserva"}
+	if err := applyAndRecord(ctx, tx, 1, &balance, debit); !errors.Is(err, ErrLimiteExcedido) {
+		t.Fatalf("debit after the hold: %v, want ErrLimiteExcedido", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// configFile guarda os valores lidos de CONFIG_FILE (linhas CHAVE=valor), que
+// têm precedência sobre as variáveis de ambiente. Ao contrário do ambiente do
+// processo, o arquivo pode ser relido em execução por reloadConfig.
+var configFile = newConfigFile()
+
+func newConfigFile() *atomic.Pointer[map[string]string] {
+	values := &atomic.Pointer[map[string]string]{}
+	if loaded, err := readConfigFile(os.Getenv("CONFIG_FILE")); err != nil {
+		log.Print("Error reading CONFIG_FILE: ", err)
+	} else {
+		values.Store(&loaded)
+	}
+	return values
+}
+
+func readConfigFile(path string) (map[string]string, error) {
+	values := make(map[string]string)
+	if path == "" {
+		return values, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", path, line)
+		}
+		values[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return values, scanner.Err()
+}
+
+func lookupEnv(key string) string {
+	configKeysRead.Store(key, struct{}{})
+	if values := configFile.Load(); values != nil {
+		if value, ok := (*values)[key]; ok {
+			return value
+		}
+	}
+	return os.Getenv(key)
+}
+
+func getEnv(key, fallback string) string {
+	if value := lookupEnv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := lookupEnv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using %d", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := lookupEnv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using %t", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value := lookupEnv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using %g", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := lookupEnv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using %s", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+var reloadHooks struct {
+	sync.Mutex
+	hooks []func()
+}
+
+// onReload aplica apply imediatamente e de novo a cada recarga da
+// configuração. Só as configurações registradas aqui mudam sem reiniciar.
+func onReload(apply func()) {
+	apply()
+	reloadHooks.Lock()
+	reloadHooks.hooks = append(reloadHooks.hooks, apply)
+	reloadHooks.Unlock()
+}
+
+// reloadConfig relê CONFIG_FILE e reaplica as configurações recarregáveis.
+// Um arquivo inválido mantém os valores atuais.
+func reloadConfig() error {
+	values, err := readConfigFile(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return err
+	}
+	configFile.Store(&values)
+
+	reloadHooks.Lock()
+	defer reloadHooks.Unlock()
+	for _, apply := range reloadHooks.hooks {
+		apply()
+	}
+	log.Print("Configuration reloaded")
+	return nil
+}
+
+// reloadOnSIGHUP recarrega a configuração a cada SIGHUP recebido
+func reloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := reloadConfig(); err != nil {
+				log.Print("Error reloading configuration: ", err)
+			}
+		}
+	}()
+}
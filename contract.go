@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"rinha-de-backend-2024-q1/spec"
+)
+
+// runSpec verifica o contrato da rinha contra um servidor em execução, com
+// qualquer STORAGE, e falha se alguma verificação não passar
+func runSpec(args []string) error {
+	flags := flag.NewFlagSet("spec", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:8080", "base URL of the running API")
+	clientID := flags.Int("cliente", 1, "client id used by the checks; its balance is changed")
+	timeout := flags.Duration("timeout", time.Minute, "timeout for the whole run")
+	workers := flags.Int("concorrencia", 0, "also run the concurrency check with this many parallel writers")
+	flags.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	failed := 0
+	results := spec.Run(ctx, *url, *clientID)
+	if *workers > 0 {
+		results = append(results, spec.RunConcurrency(ctx, *url, *clientID, *workers, 25)...)
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", result.Name, result.Err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", result.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
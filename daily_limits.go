@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// Limite diário de débitos (LIMITE_DIARIO_ENABLED, só com o Postgres). O
+// limite de cada cliente fica em limites_diarios e o total debitado no dia
+// em debitos_diarios, uma linha por cliente e dia (CURRENT_DATE do banco). O
+// contador é somado na mesma transação do débito; o upsert bloqueia a linha
+// do dia, então débitos concorrentes do cliente não passam do limite juntos,
+// e um débito recusado depois (pelo limite de crédito, por exemplo) desfaz a
+// soma com o rollback. Clientes sem limite não pagam o upsert, e as linhas
+// dos dias anteriores ficam como histórico.
+
+var dailyLimitsEnabled = getEnvBool("LIMITE_DIARIO_ENABLED", false)
+
+var ErrLimiteDiarioExcedido = errors.New("limite diário de débitos excedido")
+
+// LimiteDiario é o corpo de PUT /clientes/:id/limite-diario
+type LimiteDiario struct {
+	Limite *Centavos `json:"limite" validate:"required,gte=0"`
+}
+
+// LimiteDiarioResponse é o corpo de GET /clientes/:id/limite-diario
+type LimiteDiarioResponse struct {
+	Limite       Centavos `json:"limite"`
+	DebitadoHoje Centavos `json:"debitado_hoje"`
+	Disponivel   Centavos `json:"disponivel"`
+}
+
+// createCappedDebit aplica um débito somando-o ao total do dia do cliente e
+// o recusa com ErrLimiteDiarioExcedido se o total passar do limite
+func createCappedDebit(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Balance{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var limit Centavos
+	err = tx.QueryRow(ctx, `
+		SELECT limite FROM limites_diarios WHERE cliente_id = $1`, clientId).Scan(&limit)
+	if errors.Is(err, pgx.ErrNoRows) {
+		tx.Rollback(ctx)
+		return applyDebit(ctx, db, clientId, transaction)
+	}
+	if err != nil {
+		return Balance{}, err
+	}
+
+	var total Centavos
+	err = tx.QueryRow(ctx, `
+		INSERT INTO debitos_diarios (cliente_id, dia, total)
+		VALUES ($1, CURRENT_DATE, $2)
+		ON CONFLICT (cliente_id, dia)
+		DO UPDATE SET total = debitos_diarios.total + EXCLUDED.total
+		RETURNING total`, clientId, transaction.Valor).Scan(&total)
+	if err != nil {
+		return Balance{}, err
+	}
+	if total > limit {
+		return Balance{}, ErrLimiteDiarioExcedido
+	}
+
+	balance, err := applyDebit(ctx, tx, clientId, transaction)
+	if err != nil {
+		return Balance{}, err
+	}
+	return balance, tx.Commit(ctx)
+}
+
+func applyDebit(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	if transaction.Categoria != "" {
+		return createCategorizedDebit(ctx, db, clientId, transaction)
+	}
+	return insertTransaction(ctx, db, clientId, transaction)
+}
+
+func handleGetDailyLimit(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	response, err := dailyLimit(c.UserContext(), poolFor(c.UserContext()), clientId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(response)
+}
+
+// dailyLimit lê o limite diário do cliente e o total debitado hoje; sem
+// limite, devolve pgx.ErrNoRows
+func dailyLimit(ctx context.Context, db dbtx, clientId int) (LimiteDiarioResponse, error) {
+	var limit LimiteDiarioResponse
+	err := db.QueryRow(ctx, `
+		SELECT l.limite, COALESCE(d.total, 0)
+		FROM limites_diarios l
+		LEFT JOIN debitos_diarios d ON d.cliente_id = l.cliente_id AND d.dia = CURRENT_DATE
+		WHERE l.cliente_id = $1`, clientId).Scan(&limit.Limite, &limit.DebitadoHoje)
+	limit.Disponivel = max(limit.Limite-limit.DebitadoHoje, 0)
+	return limit, err
+}
+
+func handleSetDailyLimit(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	limit := new(LimiteDiario)
+	if err := bindJSON(c, limit); err != nil {
+		return sendBindError(c, err)
+	}
+
+	_, err = poolFor(c.UserContext()).Exec(c.UserContext(), `
+		INSERT INTO limites_diarios (cliente_id, limite)
+		VALUES ($1, $2)
+		ON CONFLICT (cliente_id) DO UPDATE SET limite = EXCLUDED.limite`,
+		clientId, limit.Limite)
+	if isPgError(err, "23503") {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(limit)
+}
+
+func handleDeleteDailyLimit(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	tag, err := poolFor(c.UserContext()).Exec(c.UserContext(), `
+		DELETE FROM limites_diarios WHERE cliente_id = $1`, clientId)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if tag.RowsAffected() == 0 {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
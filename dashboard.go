@@ -0,0 +1,164 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"expvar"
+	"html/template"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Painel de operação (GET /admin/dashboard): uma página HTML com o saldo
+// dos clientes, as últimas transações e os contadores de requisições e do
+// expvar, recarregada a cada DASHBOARD_INTERVALO, para acompanhar a
+// instância durante o teste sem abrir o psql. Os números são os desta
+// instância. Como o navegador não manda o Bearer token, ?token= o grava num
+// cookie restrito ao painel.
+
+//go:embed templates/dashboard.html
+var dashboardFiles embed.FS
+
+var dashboardTemplate = template.Must(template.ParseFS(dashboardFiles, "templates/dashboard.html"))
+
+var (
+	httpRequests = expvar.NewMap("http_requests")
+	// httpStatusClasses são as chaves de httpRequests por status/100
+	httpStatusClasses = [...]string{"", "1xx", "2xx", "3xx", "4xx", "5xx"}
+)
+
+// requestMetricsMiddleware conta as requisições por classe de status e soma
+// as durações em httpRequests
+func requestMetricsMiddleware(c fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+	status := c.Response().StatusCode()
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	} else if err != nil {
+		status = fiber.StatusInternalServerError
+	}
+	httpRequests.Add("total", 1)
+	if class := status / 100; class > 0 && class < len(httpStatusClasses) {
+		httpRequests.Add(httpStatusClasses[class], 1)
+	}
+	httpRequests.Add("duracao_us", time.Since(start).Microseconds())
+	return err
+}
+
+type dashboardData struct {
+	GeradoEm    time.Time
+	Intervalo   int
+	Clientes    []dashboardClient
+	Transacoes  []dashboardTransaction
+	Requisicoes dashboardRequests
+	Metricas    []dashboardMetric
+}
+
+type dashboardClient struct {
+	ID           int
+	Saldo        Centavos
+	Limite       Centavos
+	UtilizadoPct float64
+	Erro         string
+}
+
+type dashboardTransaction struct {
+	ClienteID int
+	Transacao
+}
+
+type dashboardRequests struct {
+	Total     int64
+	PorClasse []dashboardMetric
+	// MediaMs é a duração média das requisições desde a subida
+	MediaMs float64
+}
+
+type dashboardMetric struct {
+	Nome  string
+	Valor string
+}
+
+// dashboardHiddenVars são as variáveis do expvar grandes demais para o painel
+var dashboardHiddenVars = map[string]bool{"memstats": true, "cmdline": true, "http_requests": true}
+
+// dashboardAuth aceita o ADMIN_TOKEN também no cookie admin_token, que o
+// navegador reenvia a cada recarga; ?token= grava o cookie e redireciona
+// para a página sem o token na URL
+func dashboardAuth(c fiber.Ctx) error {
+	if token := c.Query("token"); token != "" {
+		c.Cookie(&fiber.Cookie{
+			Name:     "admin_token",
+			Value:    token,
+			Path:     "/admin/dashboard",
+			HTTPOnly: true,
+			SameSite: fiber.CookieSameSiteStrictMode,
+		})
+		return c.Redirect().To("/admin/dashboard")
+	}
+	if c.Get(fiber.HeaderAuthorization) == "" {
+		if token := c.Cookies("admin_token"); token != "" {
+			c.Request().Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		}
+	}
+	return adminAuth(c)
+}
+
+func handleDashboard(c fiber.Ctx) error {
+	ctx := c.UserContext()
+	data := dashboardData{
+		GeradoEm:  nowFor(ctx),
+		Intervalo: int(getEnvDuration("DASHBOARD_INTERVALO", 5*time.Second).Seconds()),
+	}
+
+	storage := storageFor(ctx)
+	for _, id := range appFrom(ctx).clients.list(tenantFrom(ctx)) {
+		client := dashboardClient{ID: id}
+		balance, err := storage.GetBalance(ctx, id)
+		if err != nil {
+			client.Erro = err.Error()
+			data.Clientes = append(data.Clientes, client)
+			continue
+		}
+		client.Saldo, client.Limite = balance.Saldo, balance.Limite
+		client.UtilizadoPct = limitUtilization(balance)
+		data.Clientes = append(data.Clientes, client)
+
+		transactions, err := storage.ListTransactions(ctx, id, TransactionFilter{Limite: 20})
+		if err != nil {
+			continue
+		}
+		for _, transaction := range transactions {
+			data.Transacoes = append(data.Transacoes, dashboardTransaction{ClienteID: id, Transacao: transaction})
+		}
+	}
+	sort.Slice(data.Transacoes, func(i, j int) bool {
+		return data.Transacoes[i].RealizadaEm.After(data.Transacoes[j].RealizadaEm.Time)
+	})
+	data.Transacoes = data.Transacoes[:min(len(data.Transacoes), 20)]
+
+	if total, ok := httpRequests.Get("total").(*expvar.Int); ok {
+		data.Requisicoes.Total = total.Value()
+	}
+	for _, class := range httpStatusClasses[1:] {
+		if count, ok := httpRequests.Get(class).(*expvar.Int); ok {
+			data.Requisicoes.PorClasse = append(data.Requisicoes.PorClasse, dashboardMetric{class, count.String()})
+		}
+	}
+	if duration, ok := httpRequests.Get("duracao_us").(*expvar.Int); ok && data.Requisicoes.Total > 0 {
+		data.Requisicoes.MediaMs = float64(duration.Value()) / float64(data.Requisicoes.Total) / 1000
+	}
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !dashboardHiddenVars[kv.Key] {
+			data.Metricas = append(data.Metricas, dashboardMetric{kv.Key, kv.Value.String()})
+		}
+	})
+
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return dashboardTemplate.Execute(c.Response().BodyWriter(), data)
+}
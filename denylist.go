@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Filtro de descrições (DESCRICAO_FILTRO_ENABLED), exigido pelo compliance
+// de um parceiro: transações e autorizações cuja descricao tenha um termo
+// bloqueado são recusadas com 422 DESCRICAO_BLOQUEADA, na validação do
+// corpo. A descrição é comparada em minúsculas e sem acentos: os termos
+// (denylist/descricao.txt e os cadastrados como termo) valem palavra a
+// palavra, e as expressões (DESCRICAO_FILTRO_REGEX e as cadastradas como
+// regex) sobre o texto todo. Os padrões cadastrados em
+// /admin/descricao/bloqueios ficam em bloqueios_descricao, no schema padrão,
+// e valem para todos os tenants; a instância que recebe a alteração a aplica
+// na hora, e as demais na releitura a cada DESCRICAO_FILTRO_INTERVALO.
+
+var descriptionFilterEnabled = getEnvBool("DESCRICAO_FILTRO_ENABLED", false)
+
+//go:embed denylist/descricao.txt
+var embeddedDenylist string
+
+// descriptionFilter é um snapshot dos termos e expressões em vigor
+type descriptionFilter struct {
+	terms    map[string]bool
+	patterns []*regexp.Regexp
+}
+
+var (
+	// configFilter vem do arquivo embutido e do ambiente; dbFilter, da tabela
+	configFilter atomic.Pointer[descriptionFilter]
+	dbFilter     atomic.Pointer[descriptionFilter]
+)
+
+func init() {
+	dbFilter.Store(&descriptionFilter{})
+	onReload(func() {
+		filter := &descriptionFilter{terms: map[string]bool{}}
+		for _, line := range strings.Split(embeddedDenylist, "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				filter.terms[foldDescription(line)] = true
+			}
+		}
+		if expr := getEnv("DESCRICAO_FILTRO_REGEX", ""); expr != "" {
+			pattern, err := regexp.Compile(expr)
+			if err != nil {
+				log.Printf("Invalid DESCRICAO_FILTRO_REGEX (%v), ignoring", err)
+			} else {
+				filter.patterns = append(filter.patterns, pattern)
+			}
+		}
+		configFilter.Store(filter)
+	})
+}
+
+// foldDescription passa a descrição para minúsculas e tira os acentos
+func foldDescription(descricao string) string {
+	var folded strings.Builder
+	for _, r := range norm.NFD.String(descricao) {
+		if !unicode.Is(unicode.Mn, r) {
+			folded.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return folded.String()
+}
+
+func (f *descriptionFilter) blocks(folded string, words []string) bool {
+	for _, word := range words {
+		if f.terms[word] {
+			return true
+		}
+	}
+	for _, pattern := range f.patterns {
+		if pattern.MatchString(folded) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedDescription diz se a descrição passa pelo filtro; com o filtro
+// desligado, todas passam
+func allowedDescription(descricao string) bool {
+	if !descriptionFilterEnabled {
+		return true
+	}
+	folded := foldDescription(descricao)
+	words := strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return !configFilter.Load().blocks(folded, words) && !dbFilter.Load().blocks(folded, words)
+}
+
+// BloqueioDescricao é um padrão cadastrado em /admin/descricao/bloqueios
+type BloqueioDescricao struct {
+	ID       int       `json:"id"`
+	Padrao   string    `json:"padrao"`
+	Regex    bool      `json:"regex"`
+	CriadoEm Timestamp `json:"criado_em"`
+}
+
+// BloqueioDescricaoRequest é o corpo de POST /admin/descricao/bloqueios. Sem
+// regex, o padrão é um termo, comparado sem acentos e sem diferenciar
+// maiúsculas.
+type BloqueioDescricaoRequest struct {
+	Padrao string `json:"padrao" validate:"required,max=200"`
+	Regex  bool   `json:"regex"`
+}
+
+// compileBlocks transforma os padrões cadastrados num filtro
+func compileBlocks(blocks []BloqueioDescricao) (*descriptionFilter, error) {
+	filter := &descriptionFilter{terms: map[string]bool{}}
+	for _, block := range blocks {
+		if !block.Regex {
+			filter.terms[foldDescription(block.Padrao)] = true
+			continue
+		}
+		pattern, err := regexp.Compile(block.Padrao)
+		if err != nil {
+			return nil, err
+		}
+		filter.patterns = append(filter.patterns, pattern)
+	}
+	return filter, nil
+}
+
+func listDescriptionBlocks(ctx context.Context) ([]BloqueioDescricao, error) {
+	rows, err := appFrom(ctx).pool.Query(ctx, `
+		SELECT id, padrao, regex, criado_em FROM bloqueios_descricao ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (BloqueioDescricao, error) {
+		var block BloqueioDescricao
+		err := row.Scan(&block.ID, &block.Padrao, &block.Regex, &block.CriadoEm.Time)
+		return block, err
+	})
+}
+
+// loadDescriptionBlocks relê bloqueios_descricao do schema padrão, mesmo
+// numa requisição com X-Tenant
+func loadDescriptionBlocks(ctx context.Context) error {
+	blocks, err := listDescriptionBlocks(ctx)
+	if err != nil {
+		return err
+	}
+	filter, err := compileBlocks(blocks)
+	if err != nil {
+		return err
+	}
+	dbFilter.Store(filter)
+	return nil
+}
+
+func runDescriptionBlocksRefresher(ctx context.Context, interval time.Duration) {
+	if err := loadDescriptionBlocks(ctx); err != nil {
+		log.Print("Error loading description blocks: ", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := loadDescriptionBlocks(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Print("Error loading description blocks: ", err)
+			}
+		}
+	}
+}
+
+func handleListDescriptionBlocks(c fiber.Ctx) error {
+	blocks, err := listDescriptionBlocks(c.UserContext())
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(blocks)
+}
+
+func handleAddDescriptionBlock(c fiber.Ctx) error {
+	request := new(BloqueioDescricaoRequest)
+	if err := bindJSON(c, request); err != nil {
+		return sendBindError(c, err)
+	}
+	if request.Regex {
+		if _, err := regexp.Compile(request.Padrao); err != nil {
+			return sendProblem(c, Problem{
+				Status: fiber.StatusUnprocessableEntity,
+				Codigo: "PADRAO_INVALIDO",
+				Detail: "padrao não é uma expressão regular válida: " + err.Error(),
+			})
+		}
+	}
+
+	ctx := c.UserContext()
+	block := BloqueioDescricao{Padrao: request.Padrao, Regex: request.Regex}
+	err := appFrom(ctx).pool.QueryRow(ctx, `
+		INSERT INTO bloqueios_descricao (padrao, regex) VALUES ($1, $2)
+		RETURNING id, criado_em`, request.Padrao, request.Regex).Scan(&block.ID, &block.CriadoEm.Time)
+	if isPgError(err, "23505") {
+		return sendProblem(c, Problem{
+			Status: fiber.StatusConflict,
+			Codigo: "PADRAO_DUPLICADO",
+			Detail: "o padrão já está cadastrado",
+		})
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if err := loadDescriptionBlocks(ctx); err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.Status(fiber.StatusCreated).JSON(block)
+}
+
+func handleDeleteDescriptionBlock(c fiber.Ctx) error {
+	id, err := c.ParamsInt("bloqueio_id")
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	ctx := c.UserContext()
+	tag, err := appFrom(ctx).pool.Exec(ctx, "DELETE FROM bloqueios_descricao WHERE id = $1", id)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if tag.RowsAffected() == 0 {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err := loadDescriptionBlocks(ctx); err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalização de descricao. Antes de validado, o texto perde os espaços
+// das pontas e, com DESCRICAO_NFC (padrão), passa para a forma NFC, então
+// "é" conta como um caractere venha ele composto ou como "e" + acento. O
+// limite de 10 caracteres continua o do teste; descricaoMaxBytes é o mesmo
+// limite em bytes do CHECK das tabelas, para que o banco recuse o que a API
+// recusaria mesmo nas escritas que não passam por ela (ingest).
+
+const descricaoMaxBytes = 40
+
+var descricaoNFC = getEnvBool("DESCRICAO_NFC", true)
+
+// normalizer é implementado pelos corpos que ajustam os campos antes da
+// validação, ver structValidator.ValidateStruct
+type normalizer interface {
+	normalize()
+}
+
+func normalizeDescription(descricao string) string {
+	descricao = strings.TrimSpace(descricao)
+	if descricaoNFC {
+		descricao = norm.NFC.String(descricao)
+	}
+	return descricao
+}
+
+// validDescription recusa UTF-8 inválido, caracteres de controle e textos
+// acima de descricaoMaxBytes
+func validDescription(descricao string) bool {
+	if len(descricao) > descricaoMaxBytes || !utf8.ValidString(descricao) {
+		return false
+	}
+	for _, r := range descricao {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *TransacaoRequest) normalize() {
+	t.Descricao = normalizeDescription(t.Descricao)
+}
+
+func (a *AutorizacaoRequest) normalize() {
+	a.Descricao = normalizeDescription(a.Descricao)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/felixge/fgprof"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("gc", expvar.Func(gcStats))
+}
+
+func gcStats() any {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return map[string]any{
+		"heap_alloc":      m.HeapAlloc,
+		"heap_inuse":      m.HeapInuse,
+		"heap_objects":    m.HeapObjects,
+		"total_alloc":     m.TotalAlloc,
+		"mallocs":         m.Mallocs,
+		"num_gc":          m.NumGC,
+		"pause_total_ns":  m.PauseTotalNs,
+		"last_pause_ns":   m.PauseNs[(m.NumGC+255)%256],
+		"gc_cpu_fraction": m.GCCPUFraction,
+		"next_gc":         m.NextGC,
+	}
+}
+
+func poolStats(pool *pgxpool.Pool) any {
+	if pool == nil {
+		return nil
+	}
+	stat := pool.Stat()
+	return map[string]any{
+		"acquire_count":              stat.AcquireCount(),
+		"acquire_duration_ns":        stat.AcquireDuration().Nanoseconds(),
+		"acquired_conns":             stat.AcquiredConns(),
+		"canceled_acquire_count":     stat.CanceledAcquireCount(),
+		"constructing_conns":         stat.ConstructingConns(),
+		"empty_acquire_count":        stat.EmptyAcquireCount(),
+		"idle_conns":                 stat.IdleConns(),
+		"max_conns":                  stat.MaxConns(),
+		"total_conns":                stat.TotalConns(),
+		"new_conns_count":            stat.NewConnsCount(),
+		"max_lifetime_destroy_count": stat.MaxLifetimeDestroyCount(),
+		"max_idle_destroy_count":     stat.MaxIdleDestroyCount(),
+	}
+}
+
+// startDiagnostics expõe pprof, fgprof, expvar e as requisições lentas em um
+// servidor HTTP separado (DEBUG_ADDR), fora do fiber, para não interferir nas
+// rotas da API. Se DEBUG_USER e DEBUG_PASSWORD estiverem definidos, exige
+// basic auth.
+func startDiagnostics(a *App, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/fgprof", fgprof.Handler())
+	mux.HandleFunc("/debug/vars", handleVars)
+	mux.HandleFunc("/debug/slow", handleSlowRequests)
+
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r.WithContext(withApp(r.Context(), a)))
+	})
+	user, password := getEnv("DEBUG_USER", ""), getEnv("DEBUG_PASSWORD", "")
+	if user != "" && password != "" {
+		handler = basicAuth(handler, user, password)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		log.Print("Diagnostics listening on ", addr)
+		if err := server.ListenAndServe(); err != nil {
+			log.Print("Diagnostics server stopped: ", err)
+		}
+	}()
+}
+
+// handleVars é o expvar.Handler com as estatísticas do pool da App da
+// requisição, que não cabem em uma expvar global
+func handleVars(w http.ResponseWriter, r *http.Request) {
+	pool, err := json.Marshal(poolStats(appFrom(r.Context()).pool))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n%q: %s", "pool", pool)
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, ",\n%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
+
+func basicAuth(next http.Handler, user, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(u), []byte(user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="diagnostics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var errorReportingEnabled bool
+
+// setupErrorReporting habilita o envio de erros ao Sentry quando SENTRY_DSN
+// estiver definido. Ambiente e release seguem SENTRY_ENVIRONMENT e
+// SENTRY_RELEASE. A função devolvida esvazia a fila de eventos na saída.
+func setupErrorReporting() (func(), error) {
+	dsn := getEnv("SENTRY_DSN", "")
+	if dsn == "" {
+		return func() {}, nil
+	}
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: getEnv("SENTRY_ENVIRONMENT", ""),
+		Release:     getEnv("SENTRY_RELEASE", ""),
+	})
+	if err != nil {
+		return nil, err
+	}
+	errorReportingEnabled = true
+	return func() { sentry.Flush(2 * time.Second) }, nil
+}
+
+// errorReportingMiddleware associa à requisição um hub do Sentry com o
+// método, a URL, a rota e o tenant, para que os erros capturados pelo
+// tracer do banco e pelo recover carreguem esse contexto. Respostas 5xx
+// sem nenhum evento capturado viram um evento próprio.
+func errorReportingMiddleware(c fiber.Ctx) error {
+	hub := sentry.CurrentHub().Clone()
+	request := &sentry.Request{
+		URL:         c.BaseURL() + c.Path(),
+		Method:      c.Method(),
+		QueryString: string(c.Request().URI().QueryString()),
+	}
+	hub.Scope().AddEventProcessor(func(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+		event.Request = request
+		return event
+	})
+	if tenant := tenantFrom(c.UserContext()); tenant != "" {
+		hub.Scope().SetTag("tenant", tenant)
+	}
+	c.SetUserContext(sentry.SetHubOnContext(c.UserContext(), hub))
+
+	err := c.Next()
+	hub.Scope().SetTag("route", c.Route().Path)
+
+	var fiberErr *fiber.Error
+	switch {
+	case err != nil && !errors.As(err, &fiberErr):
+		hub.CaptureException(err)
+	case hub.LastEventID() != "":
+	case c.Response().StatusCode() >= fiber.StatusInternalServerError:
+		hub.CaptureMessage(fmt.Sprintf("%d %s %s", c.Response().StatusCode(), c.Method(), c.Route().Path))
+	}
+	return err
+}
+
+// reportPanic envia ao Sentry um panic recuperado durante a requisição
+func reportPanic(c fiber.Ctx, recovered any) {
+	if hub := sentry.GetHubFromContext(c.UserContext()); hub != nil {
+		hub.RecoverWithContext(c.UserContext(), recovered)
+	}
+}
+
+// errorReportingTracer captura as falhas de consultas ao banco. Erros que
+// fazem parte do fluxo normal (limite excedido pelo gatilho, violações de
+// chave tratadas pelos handlers, cancelamentos) são ignorados.
+type errorReportingTracer struct{}
+
+func (errorReportingTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (errorReportingTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if data.Err == nil || errors.Is(data.Err, context.Canceled) || errors.Is(data.Err, context.DeadlineExceeded) {
+		return
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(data.Err, &pgErr) {
+		switch pgErr.Code {
+		case "P0001", "23503", "23505", "23514":
+			return
+		}
+	}
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil {
+		hub = sentry.CurrentHub()
+	}
+	hub.CaptureException(data.Err)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Evento representa uma notificação emitida para um cliente, entregue via
+// webhook e para os assinantes do stream SSE da instância local.
+type Evento struct {
+	ID        int64     `json:"id,omitempty"`
+	Tipo      string    `json:"tipo"`
+	Tenant    string    `json:"tenant,omitempty"`
+	ClienteID int       `json:"cliente_id"`
+	Dados     any       `json:"dados"`
+	CriadoEm  time.Time `json:"criado_em"`
+}
+
+// eventTopic identifica os assinantes de um cliente dentro de um tenant
+type eventTopic struct {
+	tenant   string
+	clientId int
+}
+
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[eventTopic]map[chan Evento]struct{}
+}
+
+var events = &eventHub{subscribers: make(map[eventTopic]map[chan Evento]struct{})}
+
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+func (h *eventHub) Subscribe(tenant string, clientId int) (<-chan Evento, func()) {
+	ch := make(chan Evento, 16)
+	topic := eventTopic{tenant: tenant, clientId: clientId}
+
+	h.mu.Lock()
+	if h.subscribers[topic] == nil {
+		h.subscribers[topic] = make(map[chan Evento]struct{})
+	}
+	h.subscribers[topic][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers[topic], ch)
+		if len(h.subscribers[topic]) == 0 {
+			delete(h.subscribers, topic)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// Publish entrega o evento aos assinantes locais sem bloquear (assinantes
+// lentos perdem eventos) e o envia ao webhook configurado em
+// ALERTAS_WEBHOOK_URL. Com o outbox habilitado, o webhook fica a cargo do
+// relay e o evento deve ter sido gravado com enqueueEvent.
+func (h *eventHub) Publish(ctx context.Context, event Evento) {
+	if event.CriadoEm.IsZero() {
+		event.CriadoEm = nowFor(ctx)
+	}
+
+	h.broadcast(event)
+
+	if url := getEnv("ALERTAS_WEBHOOK_URL", ""); url != "" && !outboxEnabled {
+		go sendWebhook(url, event)
+	}
+}
+
+// broadcast entrega o evento só aos assinantes locais
+func (h *eventHub) broadcast(event Evento) {
+	h.mu.Lock()
+	for ch := range h.subscribers[eventTopic{tenant: event.Tenant, clientId: event.ClienteID}] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	h.mu.Unlock()
+}
+
+func sendWebhook(url string, event Evento) {
+	if err := postWebhook(context.Background(), url, event); err != nil {
+		log.Print("Error sending webhook: ", err)
+	}
+}
+
+// postWebhook envia o evento e falha para respostas fora da faixa 2xx. Eventos
+// vindos do outbox levam o id no header Idempotency-Key.
+func postWebhook(ctx context.Context, url string, event Evento) error {
+	return postSignedWebhook(ctx, url, "", event)
+}
+
+// postSignedWebhook é o postWebhook com, quando secret não é vazio, a
+// assinatura HMAC-SHA256 de "<X-Rinha-Timestamp>.<corpo>" no header
+// X-Rinha-Assinatura, para que o destino confira a origem e recuse reenvios
+// antigos.
+func postSignedWebhook(ctx context.Context, url, secret string, event Evento) error {
+	body, err := jsonMarshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if event.ID != 0 {
+		req.Header.Set("Idempotency-Key", strconv.FormatInt(event.ID, 10))
+	}
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		req.Header.Set("X-Rinha-Timestamp", timestamp)
+		req.Header.Set("X-Rinha-Assinatura", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d for event %s", resp.StatusCode, event.Tipo)
+	}
+	return nil
+}
+
+func handleEventStream(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+
+	tenant := tenantFrom(c.UserContext())
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ch, unsubscribe := events.Subscribe(tenant, clientId)
+		defer unsubscribe()
+
+		keepalive := time.NewTicker(15 * time.Second)
+		defer keepalive.Stop()
+
+		for {
+			select {
+			case event := <-ch:
+				data, err := jsonMarshal(event)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Tipo, data)
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"rinha-de-backend-2024-q1/pg"
+)
+
+// No modo eventsourcing a tabela transacoes é a fonte da verdade:
+// clientes.saldo é só uma projeção das transações com id até
+// clientes.projetado_ate, mantida pelo projetor em background. Leituras e
+// validações de limite somam à projeção as transações ainda não projetadas,
+// então nunca dependem do atraso do projetor.
+//
+// As escritas de cada cliente são serializadas por pg_advisory_xact_lock e
+// o id é gerado dentro do bloqueio, de modo que, para um mesmo cliente, os
+// ids ficam visíveis em ordem crescente e o projetor nunca pula uma transação.
+
+var projectedTransactions = expvar.NewInt("projected_transactions")
+
+func insertTransactionEventSourced(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	var balance Balance
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return balance, err
+	}
+	defer tx.Rollback(ctx)
+
+	queries := pg.New(tx)
+	start := time.Now()
+	err = queries.LockClientAdvisory(ctx, int32(clientId))
+	recordLockWait(concurrencyEventSourcing, time.Since(start))
+	if err != nil {
+		return balance, err
+	}
+
+	// o saldo projetado mais o efeito das transações que o projetor ainda
+	// não aplicou, no mesmo snapshot
+	ledger, err := queries.GetLedgerBalance(ctx, int32(clientId))
+	if err != nil {
+		return balance, err
+	}
+	balance = Balance{Saldo: Centavos(ledger.Saldo), Limite: Centavos(ledger.Limite)}
+
+	balance.Saldo, err = applyToBalance(balance, transaction)
+	if err != nil {
+		return balance, err
+	}
+	// o saldo fica com o projetor, mas a posição no extrato é atribuída aqui,
+	// sob o mesmo bloqueio
+	seq, err := queries.NextSeq(ctx, int32(clientId))
+	if err != nil {
+		return balance, err
+	}
+	if err := insertLedgerEntry(ctx, tx, clientId, transaction, balance.Saldo, seq); err != nil {
+		return balance, err
+	}
+	return balance, tx.Commit(ctx)
+}
+
+// projectBalances aplica à projeção as transações novas de todos os
+// clientes. A condição sobre projetado_ate descarta a atualização quando
+// outra instância projetou o mesmo cliente entre a leitura e o UPDATE.
+func projectBalances(ctx context.Context) error {
+	var projected int64
+	err := poolFor(ctx).QueryRow(ctx, `
+		WITH projetados AS (
+			UPDATE clientes c SET saldo = c.saldo + d.delta, projetado_ate = d.ultimo
+			FROM (
+				SELECT t.cliente_id, p.projetado_ate AS desde,
+					SUM(CASE WHEN t.tipo = 'c' THEN t.valor ELSE -t.valor END) AS delta,
+					MAX(t.id) AS ultimo,
+					COUNT(*) AS novas
+				FROM transacoes t
+				JOIN clientes p ON p.id = t.cliente_id
+				WHERE t.id > p.projetado_ate
+				GROUP BY t.cliente_id, p.projetado_ate
+			) d
+			WHERE c.id = d.cliente_id AND c.projetado_ate = d.desde
+			RETURNING d.novas
+		)
+		SELECT COALESCE(SUM(novas), 0) FROM projetados`).Scan(&projected)
+	if err != nil {
+		return err
+	}
+	projectedTransactions.Add(projected)
+	return nil
+}
+
+// runProjector mantém clientes.saldo atualizado a cada PROJETOR_INTERVALO
+func runProjector(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := projectBalances(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Print("Error projecting balances: ", err)
+			}
+		}
+	}
+}
+
+// rebuildProjection descarta a projeção e a recalcula repetindo todas as
+// transações de cada cliente. Cada cliente é reconstruído com o mesmo
+// bloqueio usado pelas escritas, então a API pode continuar no ar.
+func rebuildProjection(ctx context.Context) (int, error) {
+	rows, err := poolFor(ctx).Query(ctx, "SELECT id FROM clientes ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := rebuildClientProjection(ctx, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+func rebuildClientProjection(ctx context.Context, clientId int) error {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := pg.New(tx).LockClientAdvisory(ctx, int32(clientId)); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE clientes c SET
+			saldo = c.saldo_arquivado + COALESCE(l.total, 0),
+			projetado_ate = COALESCE(l.ultimo, c.projetado_ate)
+		FROM (
+			SELECT SUM(CASE WHEN tipo = 'c' THEN valor ELSE -valor END) AS total, MAX(id) AS ultimo
+			FROM transacoes WHERE cliente_id = $1
+		) l
+		WHERE c.id = $1`, clientId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func handleRebuildProjection(c fiber.Ctx) error {
+	rebuilt, err := rebuildProjection(c.UserContext())
+	if err != nil {
+		log.Print("Error rebuilding balances: ", err)
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(fiber.Map{"clientes_reconstruidos": rebuilt})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Failover entre instâncias do Postgres. POSTGRES_HOST aceita uma lista
+// separada por vírgulas (host ou host:porta); com mais de um host o pgx
+// tenta cada um na ordem e, com target_session_attrs=read-write, só aceita
+// conexões com o primário. As conexões que caem com o primário antigo são
+// descartadas pelo health check do pool e as novas vão para o primário
+// atual. runPrimaryMonitor cobre o caso em que o primário antigo continua de
+// pé como réplica, e as conexões abertas com ele só falhariam nas escritas.
+
+// postgresHostParams monta os parâmetros host, port e target_session_attrs
+// da DSN a partir de POSTGRES_HOST
+func postgresHostParams() string {
+	var hosts, ports []string
+	for _, entry := range strings.Split(os.Getenv("POSTGRES_HOST"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil {
+			host, port = entry, "5432"
+		}
+		hosts = append(hosts, host)
+		ports = append(ports, port)
+	}
+	if len(hosts) == 0 {
+		return "host="
+	}
+
+	params := "host=" + strings.Join(hosts, ",") + " port=" + strings.Join(ports, ",")
+	if len(hosts) > 1 {
+		params += " target_session_attrs=" + getEnv("POSTGRES_TARGET_SESSION_ATTRS", "read-write")
+	}
+	return params
+}
+
+// failoverEnabled diz se POSTGRES_HOST lista mais de um host
+func failoverEnabled() bool {
+	return strings.Contains(strings.Trim(os.Getenv("POSTGRES_HOST"), ", "), ",")
+}
+
+// runPrimaryMonitor verifica a cada interval (DB_FAILOVER_INTERVALO) se o
+// pool do contexto ainda está ligado ao primário. Quando o servidor entrou
+// em recuperação, isto é, virou réplica, o pool é reiniciado e as próximas
+// conexões passam de novo pela lista de hosts.
+func runPrimaryMonitor(ctx context.Context, interval time.Duration) {
+	pool := poolFor(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		primary, addr := checkPrimary(ctx, pool)
+		if primary {
+			continue
+		}
+		log.Printf("Database %s is no longer the primary, reconnecting pool %q", addr, tenantKeyPrefix(ctx))
+		pool.Reset()
+		if err := waitForDatabase(ctx, pool); err != nil {
+			log.Print("Error reconnecting to the primary: ", err)
+		}
+	}
+}
+
+// checkPrimary diz se uma conexão do pool está com um primário e com qual
+// servidor. Erros de conexão contam como primário: as conexões mortas já
+// são trocadas pelo health check, e reiniciar o pool nelas só derrubaria as
+// boas.
+func checkPrimary(ctx context.Context, pool *pgxpool.Pool) (bool, string) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return true, ""
+	}
+	defer conn.Release()
+	addr := conn.Conn().PgConn().Conn().RemoteAddr().String()
+	var inRecovery bool
+	if err := conn.QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		return true, addr
+	}
+	return !inRecovery, addr
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v3"
+)
+
+// Leitura incremental das transações de um cliente por seq
+// (GET /clientes/:id/transacoes?desde_seq=). O seq de cada transação é
+// atribuído junto com a atualização do saldo, com a linha do cliente
+// bloqueada até o commit, então uma transação de seq n+1 só fica visível
+// depois da de seq n: um consumidor que guarda a ultima_seq recebida e pede
+// a partir dela não perde nem repete transações. Transações sem seq
+// (importadas ou anteriores à coluna) não aparecem.
+
+// ConsultaTransacoes são os parâmetros de GET /clientes/:id/transacoes
+type ConsultaTransacoes struct {
+	DesdeSeq *int64 `query:"desde_seq" validate:"required,min=0"`
+	Limite   int    `query:"limite" validate:"omitempty,min=1,max=100"`
+}
+
+// TransacoesDesde é o corpo de GET /clientes/:id/transacoes
+type TransacoesDesde struct {
+	Transacoes []Transacao `json:"transacoes"`
+	// UltimaSeq é o desde_seq do próximo pedido; igual ao pedido quando não
+	// há transações novas
+	UltimaSeq int64 `json:"ultima_seq"`
+	TemMais   bool  `json:"tem_mais"`
+}
+
+func handleTransactionsSince(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	params := new(ConsultaTransacoes)
+	if err := bindQuery(c, params); err != nil {
+		return sendQueryError(c, err)
+	}
+
+	filter := TransactionFilter{Limite: params.Limite, DesdeSeq: params.DesdeSeq}
+	transactions, err := storageFor(c.UserContext()).ListTransactions(c.UserContext(), clientId, filter)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+
+	response := TransacoesDesde{
+		Transacoes: transactions,
+		UltimaSeq:  *params.DesdeSeq,
+		TemMais:    len(transactions) == filter.pageLimit(),
+	}
+	if len(transactions) > 0 {
+		response.UltimaSeq = *transactions[len(transactions)-1].Seq
+	}
+	return c.JSON(response)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Feature flags consultadas pelos handlers. O valor efetivo de cada flag vem,
+// em ordem de precedência, da tabela feature_flags do tenant (com
+// FEATURE_FLAGS_DB), de FEATURE_<NOME> no ambiente ou no CONFIG_FILE, e por
+// fim do padrão abaixo. As consultas leem só um snapshot em memória: a tabela
+// é relida a cada FEATURE_FLAGS_INTERVALO e o ambiente a cada recarga da
+// configuração.
+var featureDefaults = map[string]bool{
+	// extrato_cache liga o cache de extratos renderizados (EXTRATO_CACHE_TTL)
+	"extrato_cache": true,
+	// totais_por_categoria inclui os totais por categoria no extrato
+	"totais_por_categoria": true,
+	// redis_cache lê o saldo do cache do Redis (REDIS_URL); o bloqueio
+	// distribuído continua ativo
+	"redis_cache": true,
+}
+
+var (
+	envFlags atomic.Pointer[map[string]bool]
+	// dbFlags guarda as flags lidas do banco por tenant
+	dbFlags   atomic.Pointer[map[string]map[string]bool]
+	dbFlagsMu sync.Mutex
+)
+
+func init() {
+	onReload(func() {
+		flags := make(map[string]bool)
+		for name := range featureDefaults {
+			key := "FEATURE_" + strings.ToUpper(name)
+			value := lookupEnv(key)
+			if value == "" {
+				continue
+			}
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				log.Printf("Invalid value for %s (%q), ignoring", key, value)
+				continue
+			}
+			flags[name] = enabled
+		}
+		envFlags.Store(&flags)
+	})
+	dbFlags.Store(&map[string]map[string]bool{})
+}
+
+// featureEnabled informa se a flag está ligada para o tenant do contexto
+func featureEnabled(ctx context.Context, name string) bool {
+	enabled, _ := featureValue(tenantFrom(ctx), name)
+	return enabled
+}
+
+func featureValue(tenant, name string) (bool, string) {
+	if enabled, ok := (*dbFlags.Load())[tenant][name]; ok {
+		return enabled, "banco"
+	}
+	if enabled, ok := (*envFlags.Load())[name]; ok {
+		return enabled, "ambiente"
+	}
+	return featureDefaults[name], "padrao"
+}
+
+// loadDBFlags relê a tabela feature_flags do tenant do contexto
+func loadDBFlags(ctx context.Context) error {
+	rows, err := poolFor(ctx).Query(ctx, "SELECT nome, ativo FROM feature_flags")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	flags := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return err
+		}
+		flags[name] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	dbFlagsMu.Lock()
+	defer dbFlagsMu.Unlock()
+	current := *dbFlags.Load()
+	updated := make(map[string]map[string]bool, len(current)+1)
+	for tenant, tenantFlags := range current {
+		updated[tenant] = tenantFlags
+	}
+	updated[tenantFrom(ctx)] = flags
+	dbFlags.Store(&updated)
+	return nil
+}
+
+func runFeatureFlagsRefresher(ctx context.Context, interval time.Duration) {
+	if err := loadDBFlags(ctx); err != nil {
+		log.Print("Error loading feature flags: ", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := loadDBFlags(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Print("Error loading feature flags: ", err)
+			}
+		}
+	}
+}
+
+// FeatureFlag representa o valor efetivo de uma flag e de onde ele veio
+type FeatureFlag struct {
+	Nome   string `json:"nome"`
+	Ativo  bool   `json:"ativo"`
+	Origem string `json:"origem"`
+}
+
+type FeatureFlagRequest struct {
+	Ativo *bool `json:"ativo" validate:"required"`
+}
+
+func handleListFeatureFlags(c fiber.Ctx) error {
+	tenant := tenantFrom(c.UserContext())
+	flags := make([]FeatureFlag, 0, len(featureDefaults))
+	for name := range featureDefaults {
+		enabled, origin := featureValue(tenant, name)
+		flags = append(flags, FeatureFlag{Nome: name, Ativo: enabled, Origem: origin})
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Nome < flags[j].Nome })
+	return c.JSON(flags)
+}
+
+// handleSetFeatureFlag grava a flag no banco do tenant. A instância que
+// recebe a requisição aplica o valor na hora; as demais, na próxima
+// releitura.
+func handleSetFeatureFlag(c fiber.Ctx) error {
+	name := c.Params("nome")
+	if _, ok := featureDefaults[name]; !ok {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	request := new(FeatureFlagRequest)
+	if err := bindJSON(c, request); err != nil {
+		return sendBindError(c, err)
+	}
+
+	ctx := c.UserContext()
+	_, err := poolFor(ctx).Exec(ctx, `
+		INSERT INTO feature_flags (nome, ativo) VALUES ($1, $2)
+		ON CONFLICT (nome) DO UPDATE SET ativo = EXCLUDED.ativo, atualizado_em = NOW()`,
+		name, *request.Ativo)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if err := loadDBFlags(ctx); err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleDeleteFeatureFlag remove a flag do banco, voltando ao valor do
+// ambiente ou ao padrão
+func handleDeleteFeatureFlag(c fiber.Ctx) error {
+	name := c.Params("nome")
+	if _, ok := featureDefaults[name]; !ok {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	ctx := c.UserContext()
+	if _, err := poolFor(ctx).Exec(ctx, "DELETE FROM feature_flags WHERE nome = $1", name); err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if err := loadDBFlags(ctx); err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
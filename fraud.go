@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrFraudeSuspeita = errors.New("transação recusada por suspeita de fraude")
+
+// Decisões possíveis de um FraudChecker, em ordem crescente de gravidade
+const (
+	fraudAllow  = "permitir"
+	fraudFlag   = "sinalizar"
+	fraudReject = "rejeitar"
+)
+
+// FraudDecision é o resultado da análise de uma transação. Regra é o nome
+// da regra que determinou a decisão, vazio quando nenhuma disparou.
+type FraudDecision struct {
+	Acao  string
+	Regra string
+}
+
+// FraudChecker analisa uma transação antes de ela ser registrada. db é a
+// mesma conexão ou transação usada na escrita.
+type FraudChecker interface {
+	Check(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (FraudDecision, error)
+}
+
+// fraudChecker é nil quando FRAUDE_REGRAS não está definido
+var fraudChecker FraudChecker
+
+var fraudDecisions = expvar.NewMap("fraud_decisions")
+
+// FraudRule é uma regra do arquivo FRAUDE_REGRAS. Tipo velocidade dispara
+// quando o cliente passa de Debitos débitos em Janela; tipo pico, quando o
+// débito passa de Fator vezes a média dos últimos Amostra débitos e de
+// ValorMinimo.
+type FraudRule struct {
+	Nome        string        `yaml:"nome"`
+	Tipo        string        `yaml:"tipo"`
+	Acao        string        `yaml:"acao"`
+	Debitos     int           `yaml:"debitos"`
+	Janela      time.Duration `yaml:"janela"`
+	Fator       float64       `yaml:"fator"`
+	Amostra     int           `yaml:"amostra"`
+	ValorMinimo Centavos      `yaml:"valor_minimo"`
+}
+
+// fraudRules é o FraudChecker padrão: avalia todas as regras e fica com a
+// decisão mais grave
+type fraudRules []FraudRule
+
+func configureFraudChecker() error {
+	path := getEnv("FRAUDE_REGRAS", "")
+	if path == "" {
+		return nil
+	}
+	rules, err := loadFraudRules(path)
+	if err != nil {
+		return err
+	}
+	fraudChecker = rules
+	return nil
+}
+
+func loadFraudRules(path string) (fraudRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Regras []FraudRule `yaml:"regras"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i, rule := range config.Regras {
+		if rule.Nome == "" {
+			return nil, fmt.Errorf("%s: rule %d has no nome", path, i+1)
+		}
+		if rule.Acao != fraudFlag && rule.Acao != fraudReject {
+			return nil, fmt.Errorf("%s: rule %q: acao must be %s or %s", path, rule.Nome, fraudFlag, fraudReject)
+		}
+		switch rule.Tipo {
+		case "velocidade":
+			if rule.Debitos < 1 || rule.Janela <= 0 {
+				return nil, fmt.Errorf("%s: rule %q needs debitos and janela", path, rule.Nome)
+			}
+		case "pico":
+			if rule.Fator <= 0 || rule.Amostra < 1 {
+				return nil, fmt.Errorf("%s: rule %q needs fator and amostra", path, rule.Nome)
+			}
+		default:
+			return nil, fmt.Errorf("%s: rule %q has unknown tipo %q", path, rule.Nome, rule.Tipo)
+		}
+	}
+	return config.Regras, nil
+}
+
+func (rules fraudRules) Check(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (FraudDecision, error) {
+	decision := FraudDecision{Acao: fraudAllow}
+	// as regras atuais só olham débitos
+	if transaction.Tipo != "d" {
+		return decision, nil
+	}
+
+	for _, rule := range rules {
+		if decision.Acao == fraudReject {
+			break
+		}
+		if rule.Acao == decision.Acao {
+			continue
+		}
+		hit, err := rule.matches(ctx, db, clientId, transaction)
+		if err != nil {
+			return decision, fmt.Errorf("fraud rule %q: %w", rule.Nome, err)
+		}
+		if hit {
+			decision = FraudDecision{Acao: rule.Acao, Regra: rule.Nome}
+		}
+	}
+	return decision, nil
+}
+
+func (rule FraudRule) matches(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (bool, error) {
+	switch rule.Tipo {
+	case "velocidade":
+		var debits int
+		err := db.QueryRow(ctx, `
+			SELECT COUNT(*) FROM transacoes
+			WHERE cliente_id = $1 AND tipo = 'd' AND realizada_em >= NOW() - $2::interval`,
+			clientId, rule.Janela).Scan(&debits)
+		// a transação atual conta como mais um débito
+		return debits+1 > rule.Debitos, err
+	case "pico":
+		if transaction.Valor < rule.ValorMinimo {
+			return false, nil
+		}
+		var average *float64
+		err := db.QueryRow(ctx, `
+			SELECT AVG(valor) FROM (
+				SELECT valor FROM transacoes
+				WHERE cliente_id = $1 AND tipo = 'd'
+				ORDER BY realizada_em DESC LIMIT $2
+			) recentes`, clientId, rule.Amostra).Scan(&average)
+		// sem histórico não há pico para comparar
+		if err != nil || average == nil {
+			return false, err
+		}
+		return float64(transaction.Valor) > rule.Fator**average, nil
+	}
+	return false, nil
+}
+
+// checkFraud aplica fraudChecker à transação, guardando nela a decisão e a
+// regra para que sejam gravadas junto com a transação
+func checkFraud(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) error {
+	decision, err := fraudChecker.Check(ctx, db, clientId, transaction)
+	if err != nil {
+		return err
+	}
+	fraudDecisions.Add(decision.Acao, 1)
+	if decision.Acao == fraudReject {
+		return fmt.Errorf("%w (regra %s)", ErrFraudeSuspeita, decision.Regra)
+	}
+	transaction.FraudeDecisao = decision.Acao
+	transaction.FraudeRegra = decision.Regra
+	return nil
+}
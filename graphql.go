@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// GraphQLRequest representa o corpo de uma requisição ao endpoint /graphql
+type GraphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+var centavosScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Centavos",
+	Description: "Valor monetário inteiro em centavos",
+	Serialize: func(value any) any {
+		switch v := value.(type) {
+		case Centavos:
+			return int64(v)
+		case *Centavos:
+			if v == nil {
+				return nil
+			}
+			return int64(*v)
+		}
+		return nil
+	},
+	ParseValue: func(value any) any {
+		switch v := value.(type) {
+		case int:
+			return Centavos(v)
+		case float64:
+			if v != float64(int64(v)) {
+				return nil
+			}
+			return Centavos(v)
+		}
+		return nil
+	},
+	ParseLiteral: func(valueAST ast.Value) any {
+		if v, ok := valueAST.(*ast.IntValue); ok {
+			parsed, err := ParseCentavos(v.Value)
+			if err != nil {
+				return nil
+			}
+			return parsed
+		}
+		return nil
+	},
+})
+
+var transacaoType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Transacao",
+	Fields: graphql.Fields{
+		"valor":     &graphql.Field{Type: graphql.NewNonNull(centavosScalar)},
+		"tipo":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"descricao": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"categoria": &graphql.Field{Type: graphql.String},
+		"realizada_em": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.DateTime),
+		},
+		// cursor é o valor de antes_de para as transações seguintes a esta
+		"cursor": &graphql.Field{Type: graphql.String},
+	},
+})
+
+var saldoType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Saldo",
+	Fields: graphql.Fields{
+		"total":        &graphql.Field{Type: graphql.NewNonNull(centavosScalar)},
+		"limite":       &graphql.Field{Type: graphql.NewNonNull(centavosScalar)},
+		"data_extrato": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+	},
+})
+
+var transactionFilterArgs = graphql.FieldConfigArgument{
+	"tipo":      &graphql.ArgumentConfig{Type: graphql.String},
+	"categoria": &graphql.ArgumentConfig{Type: graphql.String},
+	"desde":     &graphql.ArgumentConfig{Type: graphql.DateTime},
+	"ate":       &graphql.ArgumentConfig{Type: graphql.DateTime},
+	"primeiros": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+	"antes_de":  &graphql.ArgumentConfig{Type: graphql.String},
+}
+
+// graphqlCliente é a fonte dos resolvers do tipo Cliente; saldo e transações
+// só são consultados se o campo correspondente for selecionado.
+type graphqlCliente struct {
+	ID int
+}
+
+var clienteType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Cliente",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(graphqlCliente).ID, nil
+			},
+		},
+		"saldo": &graphql.Field{
+			Type: graphql.NewNonNull(saldoType),
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				return resolveBalance(p.Context, p.Source.(graphqlCliente).ID)
+			},
+		},
+		"transacoes": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(transacaoType))),
+			Args: transactionFilterArgs,
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				return resolveTransactions(p.Context, p.Source.(graphqlCliente).ID, p.Args)
+			},
+		},
+	},
+})
+
+var graphqlSchema graphql.Schema
+
+func init() {
+	clientArg := graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+	}
+	transactionArgs := graphql.FieldConfigArgument{
+		"cliente_id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+	}
+	for name, arg := range transactionFilterArgs {
+		transactionArgs[name] = arg
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"cliente": &graphql.Field{
+				Type: clienteType,
+				Args: clientArg,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					id := p.Args["id"].(int)
+					if err := clientExists(p.Context, id); err != nil {
+						return nil, err
+					}
+					return graphqlCliente{ID: id}, nil
+				},
+			},
+			"saldo": &graphql.Field{
+				Type: saldoType,
+				Args: clientArg,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return resolveBalance(p.Context, p.Args["id"].(int))
+				},
+			},
+			"transacoes": &graphql.Field{
+				Type: graphql.NewList(graphql.NewNonNull(transacaoType)),
+				Args: transactionArgs,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return resolveTransactions(p.Context, p.Args["cliente_id"].(int), p.Args)
+				},
+			},
+		},
+	})
+
+	var err error
+	graphqlSchema, err = graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func resolveBalance(ctx context.Context, clientId int) (map[string]any, error) {
+	if err := clientExists(ctx, clientId); err != nil {
+		return nil, err
+	}
+	balance, err := storageFor(ctx).GetBalance(ctx, clientId)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"total":        balance.Saldo,
+		"limite":       balance.Limite,
+		"data_extrato": nowFor(ctx),
+	}, nil
+}
+
+func resolveTransactions(ctx context.Context, clientId int, args map[string]any) ([]map[string]any, error) {
+	if err := clientExists(ctx, clientId); err != nil {
+		return nil, err
+	}
+
+	filter := TransactionFilter{}
+	filter.Tipo, _ = args["tipo"].(string)
+	filter.Categoria, _ = args["categoria"].(string)
+	filter.Limite, _ = args["primeiros"].(int)
+	if value, ok := args["antes_de"].(string); ok {
+		cursor, err := ParseExtratoCursor(value)
+		if err != nil {
+			return nil, err
+		}
+		filter.AntesDe = &cursor
+	}
+	if desde, ok := args["desde"].(time.Time); ok {
+		filter.Desde = &desde
+	}
+	if ate, ok := args["ate"].(time.Time); ok {
+		filter.Ate = &ate
+	}
+	if filter.Limite < 1 || filter.Limite > 100 {
+		return nil, errors.New("paginação inválida: primeiros deve estar entre 1 e 100")
+	}
+
+	transactions, err := storageFor(ctx).ListTransactions(ctx, clientId, filter)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]any, len(transactions))
+	for i, t := range transactions {
+		result[i] = map[string]any{
+			"valor":        t.Valor,
+			"tipo":         t.Tipo,
+			"descricao":    t.Descricao,
+			"categoria":    t.Categoria,
+			"realizada_em": t.RealizadaEm.Time,
+		}
+		if t.Seq != nil {
+			result[i]["cursor"] = ExtratoCursor{RealizadaEm: t.RealizadaEm.Time, Seq: *t.Seq}.String()
+		}
+	}
+	return result, nil
+}
+
+func handleGraphQL(c fiber.Ctx) error {
+	request := new(GraphQLRequest)
+	if c.Method() == fiber.MethodGet {
+		request.Query = c.Query("query")
+		request.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := jsonUnmarshal([]byte(variables), &request.Variables); err != nil {
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+		}
+	} else if err := bindJSON(c, request); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if request.Query == "" {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         graphqlSchema,
+		RequestString:  request.Query,
+		VariableValues: request.Variables,
+		OperationName:  request.OperationName,
+		Context:        c.UserContext(),
+	})
+	return c.JSON(result)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// sqliteTestApp abre uma App sobre um SQLite novo no diretório temporário
+// do teste, com os clientes da rinha
+func sqliteTestApp(tb testing.TB) *App {
+	tb.Helper()
+	return sqliteTestAppWithClock(tb, newClock())
+}
+
+// sqliteTestAppWithClock é o sqliteTestApp com o relógio clock
+func sqliteTestAppWithClock(tb testing.TB, clock Clock) *App {
+	tb.Helper()
+	config := AppConfig{Storage: "sqlite", SQLitePath: filepath.Join(tb.TempDir(), "rinha.db")}
+	app, err := newApp(context.Background(), config, clock, log.Default())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { app.storage.(*sqliteStorage).db.Close() })
+	return app
+}
+
+// postgresTestApp abre uma App sobre o Postgres do ambiente (POSTGRES_HOST e
+// as demais variáveis do serve, com o script.sql aplicado), ou pula o teste
+// quando não há um configurado
+func postgresTestApp(tb testing.TB) *App {
+	tb.Helper()
+	if os.Getenv("POSTGRES_HOST") == "" {
+		tb.Skip("POSTGRES_HOST not set")
+	}
+	app, err := newApp(context.Background(), AppConfig{Storage: "postgres"}, newClock(), log.Default())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(app.pool.Close)
+	return app
+}
+
+// fiberTransport atende as requisições de um http.Client pelo app.Test, sem
+// abrir um socket
+type fiberTransport struct {
+	app *fiber.App
+}
+
+func (t fiberTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.app.Test(req, -1)
+}
+
+// handlerTransport atende as requisições de um http.Client por um
+// http.Handler, também sem socket
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// no servidor o corpo nunca é nil
+	if req.Body == nil {
+		req = req.Clone(req.Context())
+		req.Body = http.NoBody
+	}
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, req)
+	return recorder.Result(), nil
+}
+
+// testClient devolve um http.Client que fala com o app do serve montado
+// sobre a na pilha stack (ver HTTP_STACK), e a URL base a usar com ele
+func testClient(a *App, stack string) (*http.Client, string) {
+	app := newHTTPApp(a)
+	if stack == httpStackNetHTTP {
+		return &http.Client{Transport: handlerTransport{netHTTPRouter(app, a)}}, "http://rinha.test"
+	}
+	return &http.Client{Transport: fiberTransport{app}}, "http://rinha.test"
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/adaptor"
+)
+
+// Pilha HTTP (HTTP_STACK): fiber, o padrão, atende tudo pelo fasthttp;
+// nethttp atende por um http.Server da biblioteca padrão com um roteador
+// chi, para comparar as pilhas no teste de carga e ter uma saída caso o
+// fasthttp ou o fiber v3 deem problema.
+//
+// As rotas do teste da rinha (POST /transacoes e GET /extrato, com e sem
+// /v1) são handlers neutros, escritos sobre a interface exchange em vez do
+// fiber.Ctx, e rodam nativamente nas duas pilhas: no fiber via fiberHandler,
+// com os middlewares de sempre; no chi via netHTTPHandler, com a App e o
+// tenant no contexto, o no-store, o limite de corpo, os TIMEOUT_*, o
+// versionamento, o JSON Schema e a recuperação de panics como middlewares do
+// chi. Nessa pilha, os middlewares que existem só no fiber (métricas,
+// tradução para outros idiomas, relatório de erros, chaos) não passam por
+// essas rotas. As demais rotas ainda usam o fiber.Ctx; no nethttp
+// elas seguem para o app do fiber pelo adaptador, que copia a requisição e a
+// resposta inteiras, então as respostas em stream (SSE em /eventos, o export
+// NDJSON) só chegam no fim e o /ws não funciona. Portar uma rota é trocar o
+// fiber.Ctx do handler por exchange e registrá-la em netHTTPRouter.
+
+const (
+	httpStackFiber   = "fiber"
+	httpStackNetHTTP = "nethttp"
+)
+
+var httpStack = getEnv("HTTP_STACK", httpStackFiber)
+
+func validateHTTPStack() error {
+	switch httpStack {
+	case httpStackFiber, httpStackNetHTTP:
+		return nil
+	}
+	return fmt.Errorf("unknown HTTP_STACK %q", httpStack)
+}
+
+// exchange é a requisição e a resposta vistas por um handler neutro
+type exchange interface {
+	Context() context.Context
+	// Path é o caminho com a query string, o instance dos problemas
+	Path() string
+	Param(name string) string
+	Query(name string) string
+	Queries() map[string]string
+	Header(name string) string
+	Body() []byte
+	Locale() string
+	SetHeader(name, value string)
+	Send(status int, contentType string, body []byte) error
+}
+
+// stackHandler é um handler que roda nas duas pilhas
+type stackHandler func(x exchange) error
+
+func sendJSONTo(x exchange, status int, value any) error {
+	body, err := jsonMarshal(value)
+	if err != nil {
+		return err
+	}
+	return x.Send(status, fiber.MIMEApplicationJSON, body)
+}
+
+// sendProblemTo é o sendProblem dos handlers neutros
+func sendProblemTo(x exchange, problem Problem) error {
+	problem.complete(x.Path(), x.Locale())
+	body, err := jsonMarshal(problem)
+	if err != nil {
+		return err
+	}
+	return x.Send(problem.Status, problemContentType, body)
+}
+
+// fiberExchange é a exchange de uma requisição do fiber
+type fiberExchange struct {
+	c fiber.Ctx
+}
+
+func fiberHandler(handler stackHandler) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return handler(fiberExchange{c})
+	}
+}
+
+func (x fiberExchange) Context() context.Context   { return x.c.UserContext() }
+func (x fiberExchange) Path() string               { return x.c.OriginalURL() }
+func (x fiberExchange) Param(name string) string   { return x.c.Params(name) }
+func (x fiberExchange) Query(name string) string   { return x.c.Query(name) }
+func (x fiberExchange) Queries() map[string]string { return x.c.Queries() }
+func (x fiberExchange) Header(name string) string  { return x.c.Get(name) }
+func (x fiberExchange) Body() []byte               { return x.c.Body() }
+func (x fiberExchange) Locale() string             { return localeFrom(x.c) }
+func (x fiberExchange) SetHeader(name, value string) {
+	x.c.Set(name, value)
+}
+
+func (x fiberExchange) Send(status int, contentType string, body []byte) error {
+	x.c.Status(status)
+	x.c.Set(fiber.HeaderContentType, contentType)
+	return x.c.Send(body)
+}
+
+// netHTTPExchange é a exchange de uma requisição do net/http; o corpo já
+// vem lido por netHTTPLimitBody
+type netHTTPExchange struct {
+	w     http.ResponseWriter
+	r     *http.Request
+	body  []byte
+	query url.Values
+	// sent diz se a resposta já foi escrita, para netHTTPHandler não
+	// responder de novo a um erro depois dela
+	sent bool
+}
+
+func (x *netHTTPExchange) Context() context.Context { return x.r.Context() }
+func (x *netHTTPExchange) Path() string             { return x.r.URL.RequestURI() }
+func (x *netHTTPExchange) Param(name string) string { return chi.URLParam(x.r, name) }
+func (x *netHTTPExchange) Query(name string) string { return x.query.Get(name) }
+func (x *netHTTPExchange) Header(name string) string {
+	return x.r.Header.Get(name)
+}
+func (x *netHTTPExchange) Body() []byte { return x.body }
+
+// Locale é sempre o padrão: a tradução é um middleware do fiber
+func (x *netHTTPExchange) Locale() string { return defaultLocale }
+
+func (x *netHTTPExchange) Queries() map[string]string {
+	queries := make(map[string]string, len(x.query))
+	for name, values := range x.query {
+		queries[name] = values[0]
+	}
+	return queries
+}
+
+func (x *netHTTPExchange) SetHeader(name, value string) {
+	x.w.Header().Set(name, value)
+}
+
+func (x *netHTTPExchange) Send(status int, contentType string, body []byte) error {
+	x.sent = true
+	x.w.Header().Set(fiber.HeaderContentType, contentType)
+	x.w.WriteHeader(status)
+	_, err := x.w.Write(body)
+	return err
+}
+
+// netHTTPHandler serve um handler neutro pelo net/http; um erro devolvido
+// pelo handler vira 500, como no problemErrorHandler, a menos que a resposta
+// já tenha sido escrita
+func netHTTPHandler(handler stackHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		x := &netHTTPExchange{w: w, r: r, body: netHTTPBody(r), query: r.URL.Query()}
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				panics.Add(1)
+				log.Printf("Panic handling %s %s: %v\n%s", r.Method, r.URL.RequestURI(), recovered, debug.Stack())
+				if !x.sent {
+					sendProblemTo(x, Problem{Status: fiber.StatusInternalServerError})
+				}
+			}
+		}()
+
+		if err := handler(x); err != nil {
+			log.Printf("Error handling %s %s: %v", r.Method, r.URL.RequestURI(), err)
+			if !x.sent {
+				sendProblemTo(x, Problem{Status: fiber.StatusInternalServerError})
+			}
+		}
+	}
+}
+
+type netHTTPBodyKey struct{}
+
+// netHTTPBody é o corpo lido por netHTTPLimitBody
+func netHTTPBody(r *http.Request) []byte {
+	body, _ := r.Context().Value(netHTTPBodyKey{}).([]byte)
+	return body
+}
+
+// netHTTPLimitBody é o limitBody do chi: lê até limit bytes do corpo, que
+// segue no contexto para os próximos middlewares e o handler, e recusa com
+// 413 o que passar disso
+func netHTTPLimitBody(limit *atomic.Int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit.Load()))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				sendProblemTo(&netHTTPExchange{w: w, r: r}, Problem{Status: fiber.StatusRequestEntityTooLarge})
+				return
+			}
+			if err != nil {
+				log.Printf("Error reading %s %s: %v", r.Method, r.URL.RequestURI(), err)
+				sendProblemTo(&netHTTPExchange{w: w, r: r}, Problem{Status: fiber.StatusBadRequest})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), netHTTPBodyKey{}, body)))
+		})
+	}
+}
+
+// netHTTPSchema é o validateSchema do chi; vem depois de netHTTPLimitBody
+func netHTTPSchema(name string) func(http.Handler) http.Handler {
+	schema, ok := requestSchemas[name]
+	if !ok {
+		panic("unknown request schema " + name)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			x := &netHTTPExchange{w: w, r: r, body: netHTTPBody(r)}
+			ok, err := schema.check(x)
+			if err != nil {
+				log.Printf("Error handling %s %s: %v", r.Method, r.URL.RequestURI(), err)
+				if !x.sent {
+					sendProblemTo(x, Problem{Status: fiber.StatusInternalServerError})
+				}
+				return
+			}
+			if ok {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// netHTTPVersioning é o apiVersionMiddleware do chi
+func netHTTPVersioning(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, _ := checkAPIVersion(&netHTTPExchange{w: w, r: r}, r.URL.Path); ok {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// netHTTPTimeout é o routeTimeout do chi. A resposta fica em um
+// bufferedResponse até o handler terminar, para ser trocada pelo 503 quando
+// ele falhou depois do prazo.
+func netHTTPTimeout(route string) func(http.Handler) http.Handler {
+	timeout := routeTimeoutFor(route)
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			buffered := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+			next.ServeHTTP(buffered, r.WithContext(ctx))
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && !successStatus(buffered.status) {
+				requestTimeouts.Add(route, 1)
+				sendProblemTo(&netHTTPExchange{w: w, r: r}, timeoutProblem(timeout))
+				return
+			}
+			w.WriteHeader(buffered.status)
+			w.Write(buffered.body.Bytes())
+		})
+	}
+}
+
+// bufferedResponse é um http.ResponseWriter que guarda o status e o corpo;
+// os cabeçalhos vão direto para os da resposta de verdade
+type bufferedResponse struct {
+	header http.Header
+	status int
+	wrote  bool
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if !b.wrote {
+		b.status, b.wrote = status, true
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.wrote = true
+	return b.body.Write(p)
+}
+
+// netHTTPContext leva a App e o tenant no contexto das requisições, como o
+// contextMiddleware e o tenantMiddleware do fiber
+func (a *App) netHTTPContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := withApp(r.Context(), a)
+		if len(a.tenantPools) > 0 {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			tenant := requestTenant(r.Header.Get(tenantHeader), host)
+			if _, ok := a.tenantPools[tenant]; !ok {
+				x := &netHTTPExchange{w: w, r: r}
+				sendProblemTo(x, invalidTenantProblem)
+				return
+			}
+			ctx = withTenant(ctx, tenant)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func netHTTPNoStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(fiber.HeaderCacheControl, "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// netHTTPBodyLimit é o BODY_LIMIT das rotas neutras no nethttp; ao contrário
+// do BodyLimit do fiber.Config, que só é lido na partida, ele acompanha o
+// reload
+var netHTTPBodyLimit atomic.Int64
+
+func init() {
+	onReload(func() {
+		netHTTPBodyLimit.Store(int64(getEnvInt("BODY_LIMIT", 16*1024)))
+	})
+}
+
+// netHTTPRouter monta no chi as rotas neutras; o resto vai para o fiber
+func netHTTPRouter(app *fiber.App, a *App) http.Handler {
+	router := chi.NewRouter()
+	fallback := adaptor.FiberApp(app)
+	router.NotFound(fallback)
+	router.MethodNotAllowed(fallback)
+
+	for _, prefix := range []string{"", "/v1"} {
+		router.Route(prefix+"/clientes/{id}", func(router chi.Router) {
+			router.Use(a.netHTTPContext)
+			if prefix == "" {
+				router.Use(netHTTPVersioning)
+			}
+			router.With(netHTTPTimeout("EXTRATO"), netHTTPLimitBody(&netHTTPBodyLimit)).
+				Get("/extrato", netHTTPHandler(handleTransactionLog))
+			router.With(netHTTPNoStore, netHTTPTimeout("TRANSACOES"), netHTTPLimitBody(&transactionBodyLimit), netHTTPSchema("transacao")).
+				Post("/transacoes", netHTTPHandler(handleTransactions))
+		})
+	}
+	return router
+}
+
+// listen atende em addr pela pilha de HTTP_STACK; onListen roda quando o
+// endereço já aceita conexões
+func listen(app *fiber.App, a *App, addr string, onListen func()) error {
+	if err := validateHTTPStack(); err != nil {
+		return err
+	}
+	if httpStack == httpStackFiber {
+		app.Hooks().OnListen(func(fiber.ListenData) error {
+			onListen()
+			return nil
+		})
+		return app.Listen(addr)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	// os mesmos limites do fiber.Config do serve
+	server := &http.Server{
+		Handler:           netHTTPRouter(app, a),
+		ReadHeaderTimeout: getEnvDuration("READ_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("READ_TIMEOUT", 5*time.Second),
+		IdleTimeout:       getEnvDuration("IDLE_TIMEOUT", time.Minute),
+	}
+	log.Print("Serving with net/http on ", listener.Addr())
+	onListen()
+	return server.Serve(listener)
+}
+
+// requestTenant escolhe o tenant pelo header X-Tenant ou, sem ele, pelo
+// primeiro rótulo do host
+func requestTenant(header, host string) string {
+	if header != "" {
+		return header
+	}
+	tenant, _, _ := strings.Cut(host, ".")
+	return tenant
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestStackMiddlewares confere que as rotas da rinha passam pelo
+// versionamento e pelo JSON Schema nas duas pilhas
+func TestStackMiddlewares(t *testing.T) {
+	previous := jsonSchemaEnabled.Load()
+	jsonSchemaEnabled.Store(true)
+	t.Cleanup(func() { jsonSchemaEnabled.Store(previous) })
+
+	for _, stack := range []string{httpStackFiber, httpStackNetHTTP} {
+		t.Run(stack, func(t *testing.T) {
+			httpClient, baseURL := testClient(sqliteTestApp(t), stack)
+			get := func(path, accept string) *http.Response {
+				t.Helper()
+				req, _ := http.NewRequest("GET", baseURL+path, nil)
+				if accept != "" {
+					req.Header.Set("Accept", accept)
+				}
+				resp, err := httpClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				return resp
+			}
+
+			resp := get("/clientes/1/extrato", "")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("GET legacy extrato: status %d", resp.StatusCode)
+			}
+			if got, want := resp.Header.Get("Link"), `</v1/clientes/1/extrato>; rel="successor-version"`; got != want {
+				t.Errorf("legacy Link %q, want %q", got, want)
+			}
+			if legacyDeprecation != "" && resp.Header.Get("Deprecation") != legacyDeprecation {
+				t.Errorf("legacy Deprecation %q, want %q", resp.Header.Get("Deprecation"), legacyDeprecation)
+			}
+			if got := get("/v1/clientes/1/extrato", "").Header.Get("Deprecation"); got != "" {
+				t.Errorf("/v1 Deprecation %q, want none", got)
+			}
+			if got := get("/clientes/1/extrato", "application/vnd.rinha.v9+json").StatusCode; got != http.StatusNotAcceptable {
+				t.Errorf("unknown version: status %d, want 406", got)
+			}
+
+			body := strings.NewReader(`{"valor": 100, "tipo": "c", "descricao": "schema", "extra": 1}`)
+			resp, err := httpClient.Post(baseURL+"/clientes/1/transacoes", "application/json", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var problem Problem
+			if err := json.NewDecoder(resp.Body).Decode(&problem); err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusUnprocessableEntity || problem.Codigo != "CAMPO_DESCONHECIDO" {
+				t.Errorf("unknown field: status %d codigo %q, want 422 CAMPO_DESCONHECIDO", resp.StatusCode, problem.Codigo)
+			}
+		})
+	}
+}
+
+// TestNetHTTPHandlerError confere que um erro devolvido depois da resposta
+// não gera uma segunda
+func TestNetHTTPHandlerError(t *testing.T) {
+	handler := netHTTPHandler(func(x exchange) error {
+		x.Send(http.StatusAccepted, "text/plain", []byte("ok"))
+		return errors.New("late failure")
+	})
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusAccepted || recorder.Body.String() != "ok" {
+		t.Errorf("status %d body %q, want 202 ok", recorder.Code, recorder.Body.String())
+	}
+}
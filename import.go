@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxImportErrors limita os erros listados na resposta da importação; o
+// total continua em TotalErros.
+const maxImportErrors = 100
+
+// ClienteImportado representa uma linha da importação de clientes. Sem id,
+// o cliente recebe o próximo id da sequência.
+type ClienteImportado struct {
+	ID     *int     `json:"id,omitempty"`
+	Nome   string   `json:"nome"`
+	Limite Centavos `json:"limite"`
+}
+
+type ErroImportacao struct {
+	Linha int    `json:"linha"`
+	Erro  string `json:"erro"`
+}
+
+type Importacao struct {
+	Importados int64            `json:"importados"`
+	Erros      []ErroImportacao `json:"erros"`
+	TotalErros int              `json:"total_erros"`
+}
+
+func (i *Importacao) addError(line int, err error) {
+	i.TotalErros++
+	if len(i.Erros) < maxImportErrors {
+		i.Erros = append(i.Erros, ErroImportacao{Linha: line, Erro: err.Error()})
+	}
+}
+
+// requestBodyReader devolve o corpo da requisição, lido em streaming se ele
+// não coube em BODY_LIMIT.
+func requestBodyReader(c fiber.Ctx) io.Reader {
+	if c.Request().IsBodyStream() {
+		return c.Request().BodyStream()
+	}
+	return bytes.NewReader(c.Body())
+}
+
+// handleImportClients cria clientes em lote a partir de um CSV (cabeçalho
+// com nome, limite e, opcionalmente, id) ou de NDJSON, conforme o
+// Content-Type. As linhas são validadas enquanto o corpo é lido e enviadas
+// ao banco com COPY para uma tabela temporária; ids já existentes também
+// são reportados como erro da linha, sem abortar a importação.
+func handleImportClients(c fiber.Ctx) error {
+	var next func() (int, ClienteImportado, error)
+	switch contentType := c.Get(fiber.HeaderContentType); {
+	case strings.HasPrefix(contentType, "text/csv"):
+		next = csvClients(requestBodyReader(c))
+	case strings.HasPrefix(contentType, "application/x-ndjson"):
+		next = ndjsonClients(requestBodyReader(c))
+	default:
+		return c.SendStatus(fiber.StatusUnsupportedMediaType)
+	}
+
+	report, err := importClients(c.UserContext(), next)
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) || errors.Is(err, bufio.ErrTooLong) {
+		return sendProblem(c, Problem{
+			Status: fiber.StatusBadRequest,
+			Codigo: "IMPORTACAO_INVALIDA",
+			Detail: err.Error(),
+		})
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	// os clientes importados passam a ser atendidos já nesta instância
+	if err := loadClients(c.UserContext()); err != nil {
+		log.Print("Error loading clients: ", err)
+	}
+	return c.JSON(report)
+}
+
+func importClients(ctx context.Context, next func() (int, ClienteImportado, error)) (Importacao, error) {
+	report := Importacao{Erros: []ErroImportacao{}}
+
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		CREATE TEMP TABLE clientes_importados (
+			linha INTEGER NOT NULL,
+			id INTEGER,
+			nome VARCHAR(50) NOT NULL,
+			limite BIGINT NOT NULL
+		) ON COMMIT DROP`)
+	if err != nil {
+		return report, err
+	}
+
+	seen := make(map[int]int)
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"clientes_importados"}, []string{"linha", "id", "nome", "limite"},
+		pgx.CopyFromFunc(func() ([]any, error) {
+			for {
+				line, client, err := next()
+				if err == io.EOF {
+					return nil, nil
+				}
+				if err == nil {
+					err = validateImportedClient(client, seen, line)
+				}
+				var rowErr importRowError
+				if errors.As(err, &rowErr) {
+					report.addError(line, rowErr.err)
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				return []any{line, client.ID, client.Nome, client.Limite}, nil
+			}
+		}))
+	if err != nil {
+		return report, err
+	}
+
+	// a sequência avança além dos ids informados, para que os ids gerados
+	// não colidam com eles
+	_, err = tx.Exec(ctx, `
+		SELECT setval(pg_get_serial_sequence('clientes', 'id'), GREATEST(
+			(SELECT MAX(id) FROM clientes), (SELECT MAX(id) FROM clientes_importados)))`)
+	if err != nil {
+		return report, err
+	}
+
+	// a CTE de INSERT só é visível via RETURNING, então as linhas em
+	// conflito são as que não aparecem em inseridos
+	rows, err := tx.Query(ctx, `
+		WITH inseridos AS (
+			INSERT INTO clientes (id, nome, limite)
+			SELECT COALESCE(id, nextval(pg_get_serial_sequence('clientes', 'id'))), nome, limite
+			FROM clientes_importados
+			ORDER BY linha
+			ON CONFLICT (id) DO NOTHING
+			RETURNING id
+		)
+		SELECT i.linha, i.id, (SELECT COUNT(*) FROM inseridos)
+		FROM clientes_importados i
+		WHERE i.id IS NOT NULL AND i.id NOT IN (SELECT id FROM inseridos)
+		UNION ALL
+		SELECT 0, NULL, (SELECT COUNT(*) FROM inseridos)
+		ORDER BY 1`)
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		var line int
+		var id *int
+		if err := rows.Scan(&line, &id, &report.Importados); err != nil {
+			return report, err
+		}
+		if id != nil {
+			report.addError(line, fmt.Errorf("cliente %d já existe", *id))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	return report, tx.Commit(ctx)
+}
+
+// importRowError é um erro de uma linha da importação, que é reportado sem
+// interromper as demais
+type importRowError struct {
+	err error
+}
+
+func (e importRowError) Error() string {
+	return e.err.Error()
+}
+
+func validateImportedClient(client ClienteImportado, seen map[int]int, line int) error {
+	switch {
+	case client.ID != nil && *client.ID <= 0:
+		return importRowError{errors.New("id deve ser positivo")}
+	case client.Nome == "":
+		return importRowError{errors.New("nome é obrigatório")}
+	case utf8.RuneCountInString(client.Nome) > 50:
+		return importRowError{errors.New("nome deve ter no máximo 50 caracteres")}
+	case client.Limite < 0:
+		return importRowError{errors.New("limite não pode ser negativo")}
+	}
+	if client.ID != nil {
+		if first, ok := seen[*client.ID]; ok {
+			return importRowError{fmt.Errorf("id %d repetido na linha %d", *client.ID, first)}
+		}
+		seen[*client.ID] = line
+	}
+	return nil
+}
+
+func ndjsonClients(r io.Reader) func() (int, ClienteImportado, error) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	return func() (int, ClienteImportado, error) {
+		var client ClienteImportado
+		for scanner.Scan() {
+			line++
+			data := bytes.TrimSpace(scanner.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+			if err := jsonUnmarshal(data, &client); err != nil {
+				return line, client, importRowError{err}
+			}
+			return line, client, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return line, client, err
+		}
+		return line, client, io.EOF
+	}
+}
+
+func csvClients(r io.Reader) func() (int, ClienteImportado, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	columns := map[string]int{}
+
+	return func() (int, ClienteImportado, error) {
+		var client ClienteImportado
+		if len(columns) == 0 {
+			header, err := reader.Read()
+			if err == io.EOF {
+				return 0, client, io.EOF
+			}
+			if err != nil {
+				return 1, client, err
+			}
+			for i, name := range header {
+				columns[strings.ToLower(strings.TrimSpace(name))] = i
+			}
+			_, hasNome := columns["nome"]
+			_, hasLimite := columns["limite"]
+			if !hasNome || !hasLimite {
+				return 1, client, &csv.ParseError{StartLine: 1, Line: 1, Err: errors.New("header must have nome and limite columns")}
+			}
+		}
+
+		record, err := reader.Read()
+		if err != nil {
+			return 0, client, err
+		}
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		if id := field("id"); id != "" {
+			parsed, err := strconv.Atoi(id)
+			if err != nil {
+				return line, client, importRowError{fmt.Errorf("id inválido %q", id)}
+			}
+			client.ID = &parsed
+		}
+		client.Nome = field("nome")
+		if client.Limite, err = ParseCentavos(field("limite")); err != nil {
+			return line, client, importRowError{err}
+		}
+		return line, client, nil
+	}
+}
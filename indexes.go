@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"rinha-de-backend-2024-q1/pg"
+)
+
+// debugLogging habilita os logs de diagnóstico (LOG_LEVEL=debug)
+var debugLogging = strings.EqualFold(getEnv("LOG_LEVEL", "info"), "debug")
+
+func debugf(format string, args ...any) {
+	if debugLogging {
+		log.Printf("DEBUG "+format, args...)
+	}
+}
+
+// statementIndexPattern reconhece, em pg_indexes.indexdef, os índices que
+// atendem o extrato: o geral ou um parcial de um cliente
+var statementIndexPattern = regexp.MustCompile(`USING btree \(cliente_id, realizada_em DESC(?:, seq DESC)?\)(?: WHERE \(cliente_id = (\d+)\))?$`)
+
+// hotQueries são as consultas cujo plano é registrado na subida; um Seq Scan
+// em transacoes indica que um índice sumiu ou deixou de ser usado
+var hotQueries = []struct {
+	name  string
+	query string
+}{
+	{"extrato", pg.ListRecentTransactionsSQL},
+	{"saldo", pg.GetBalanceSQL},
+}
+
+// checkIndexes verifica, na subida, se todo cliente tem um índice
+// (cliente_id, realizada_em DESC) para o extrato: o script.sql só cria os
+// parciais dos 5 clientes da rinha, e clientes criados depois ficariam com o
+// extrato em Seq Scan. Com INDICES_CRIAR o índice geral é criado.
+func checkIndexes(ctx context.Context) {
+	create := getEnvBool("INDICES_CRIAR", false)
+	for _, ctx := range tenantContexts(ctx) {
+		if err := checkStatementIndex(ctx, create); err != nil {
+			log.Printf("Error checking indexes for tenant %q: %v", tenantFrom(ctx), err)
+		}
+		if debugLogging {
+			for _, hot := range hotQueries {
+				explainQuery(ctx, hot.name, hot.query)
+			}
+		}
+	}
+}
+
+func checkStatementIndex(ctx context.Context, create bool) error {
+	pool := poolFor(ctx)
+	rows, err := pool.Query(ctx, `
+		SELECT indexdef FROM pg_indexes
+		WHERE schemaname = current_schema() AND tablename = 'transacoes'`)
+	if err != nil {
+		return err
+	}
+	covered := map[int]bool{}
+	general := false
+	for rows.Next() {
+		var definition string
+		if err := rows.Scan(&definition); err != nil {
+			rows.Close()
+			return err
+		}
+		match := statementIndexPattern.FindStringSubmatch(definition)
+		switch {
+		case match == nil:
+		case match[1] == "":
+			general = true
+		default:
+			id, _ := strconv.Atoi(match[1])
+			covered[id] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if general {
+		return nil
+	}
+
+	ids, err := clientIds(ctx, pool)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, id := range ids {
+		if !covered[id] {
+			missing = append(missing, strconv.Itoa(id))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if !create {
+		log.Printf("Clients %s have no (cliente_id, realizada_em DESC) index on transacoes; set INDICES_CRIAR=true to create it",
+			strings.Join(missing, ", "))
+		return nil
+	}
+
+	// CONCURRENTLY não é aceito em tabelas particionadas
+	partitioned, err := isPartitioned(ctx, pool)
+	if err != nil {
+		return err
+	}
+	concurrently := "CONCURRENTLY "
+	if partitioned {
+		concurrently = ""
+	}
+	log.Printf("Creating index indice_transacoes_extrato for clients %s", strings.Join(missing, ", "))
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		"CREATE INDEX %sIF NOT EXISTS indice_transacoes_extrato ON transacoes (cliente_id, realizada_em DESC, seq DESC)", concurrently))
+	return err
+}
+
+func clientIds(ctx context.Context, db dbtx) ([]int, error) {
+	rows, err := db.Query(ctx, "SELECT id FROM clientes ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func explainQuery(ctx context.Context, name, query string) {
+	rows, err := poolFor(ctx).Query(ctx, "EXPLAIN "+query, 1)
+	if err != nil {
+		log.Printf("Error explaining %s query: %v", name, err)
+		return
+	}
+	var plan []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			rows.Close()
+			log.Printf("Error explaining %s query: %v", name, err)
+			return
+		}
+		plan = append(plan, line)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error explaining %s query: %v", name, err)
+		return
+	}
+
+	joined := strings.Join(plan, "\n")
+	if strings.Contains(joined, "Seq Scan on transacoes") {
+		log.Printf("Query plan regression for %s: sequential scan on transacoes", name)
+	}
+	debugf("Plan for %s query (tenant %q):\n%s", name, tenantFrom(ctx), joined)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TransacaoHistorica é uma linha da ingestão de transações, no mesmo formato
+// do export NDJSON. O id informado é ignorado: as transações recebem novos
+// ids, depois dos já existentes.
+type TransacaoHistorica struct {
+	ClienteID   int        `json:"cliente_id"`
+	Valor       Centavos   `json:"valor"`
+	Tipo        string     `json:"tipo"`
+	Descricao   string     `json:"descricao"`
+	Categoria   *string    `json:"categoria,omitempty"`
+	RealizadaEm *time.Time `json:"realizada_em,omitempty"`
+}
+
+// ingest carrega transações históricas com COPY, em lotes de -batch linhas
+// confirmados um a um. O gatilho de saldo é desligado durante o COPY e cada
+// lote soma seu efeito aos saldos dos clientes de uma vez, sem validar
+// limites: o histórico é aceito como veio. Um lote com erro é desfeito e
+// interrompe a ingestão; os anteriores permanecem.
+//
+// No modo eventsourcing a ingestão deve rodar com a API parada, porque o COPY
+// não passa pelo bloqueio por cliente que garante ao projetor a ordem dos ids.
+func ingest(args []string) error {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	batchSize := flags.Int("batch", 50000, "transactions per committed batch")
+	format := flags.String("format", "ndjson", "input format: ndjson or csv")
+	tenant := flags.String("tenant", "", "tenant schema to load into")
+	flags.Parse(args)
+	if err := requirePostgres("ingest"); err != nil {
+		return err
+	}
+
+	input := io.Reader(os.Stdin)
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	var next func() (int, TransacaoHistorica, error)
+	switch *format {
+	case "ndjson":
+		next = ndjsonTransactions(input)
+	case "csv":
+		next = csvTransactions(input)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	ctx := withTenant(context.Background(), *tenant)
+	if *tenant != "" && appFrom(ctx).tenantPools[*tenant] == nil {
+		return fmt.Errorf("unknown tenant %q", *tenant)
+	}
+
+	start := time.Now()
+	var total int64
+	for {
+		ingested, err := ingestBatch(ctx, next, *batchSize)
+		total += ingested
+		if err != nil {
+			return fmt.Errorf("after %d transactions: %w", total, err)
+		}
+		if ingested == 0 {
+			break
+		}
+		elapsed := time.Since(start)
+		log.Printf("Ingested %d transactions (%.0f/s)", total, float64(total)/elapsed.Seconds())
+	}
+	log.Printf("Ingestion finished: %d transactions in %s", total, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func ingestBatch(ctx context.Context, next func() (int, TransacaoHistorica, error), batchSize int) (int64, error) {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := skipReconcileTrigger(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	deltas := make(map[int]Centavos)
+	rows := 0
+	copied, err := tx.CopyFrom(ctx, pgx.Identifier{"transacoes"},
+		[]string{"cliente_id", "valor", "tipo", "descricao", "categoria", "realizada_em"},
+		pgx.CopyFromFunc(func() ([]any, error) {
+			if rows == batchSize {
+				return nil, nil
+			}
+			line, t, err := next()
+			if err == io.EOF {
+				return nil, nil
+			}
+			if err == nil {
+				t.Descricao = normalizeDescription(t.Descricao)
+				err = validateHistoricalTransaction(t)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			rows++
+
+			delta := t.Valor
+			if t.Tipo == "d" {
+				delta = -delta
+			}
+			if deltas[t.ClienteID], err = deltas[t.ClienteID].Add(delta); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			realizadaEm := nowFor(ctx)
+			if t.RealizadaEm != nil {
+				realizadaEm = t.RealizadaEm.UTC()
+			}
+			return []any{t.ClienteID, t.Valor, t.Tipo, t.Descricao, t.Categoria, realizadaEm}, nil
+		}))
+	if err != nil || copied == 0 {
+		return 0, err
+	}
+
+	// no modo eventsourcing o projetor incorpora as novas transações
+	if concurrencyMode != concurrencyEventSourcing {
+		ids := make([]int, 0, len(deltas))
+		values := make([]int64, 0, len(deltas))
+		for id, delta := range deltas {
+			ids = append(ids, id)
+			values = append(values, int64(delta))
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE clientes c SET saldo = c.saldo + d.delta, versao = c.versao + 1
+			FROM unnest($1::int[], $2::bigint[]) AS d(id, delta)
+			WHERE c.id = d.id`, ids, values)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return copied, tx.Commit(ctx)
+}
+
+func validateHistoricalTransaction(t TransacaoHistorica) error {
+	switch {
+	case t.ClienteID <= 0:
+		return errors.New("cliente_id must be positive")
+	case t.Valor <= 0:
+		return errors.New("valor must be positive")
+	case t.Tipo != "c" && t.Tipo != "d":
+		return fmt.Errorf("invalid tipo %q", t.Tipo)
+	case t.Descricao == "":
+		return errors.New("descricao is required")
+	case !validDescription(t.Descricao):
+		return fmt.Errorf("invalid descricao %q", t.Descricao)
+	}
+	return nil
+}
+
+func ndjsonTransactions(r io.Reader) func() (int, TransacaoHistorica, error) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	return func() (int, TransacaoHistorica, error) {
+		var t TransacaoHistorica
+		for scanner.Scan() {
+			line++
+			data := scanner.Bytes()
+			if len(strings.TrimSpace(string(data))) == 0 {
+				continue
+			}
+			return line, t, jsonUnmarshal(data, &t)
+		}
+		if err := scanner.Err(); err != nil {
+			return line, t, err
+		}
+		return line, t, io.EOF
+	}
+}
+
+// csvTransactions lê um CSV com cabeçalho cliente_id, valor, tipo,
+// descricao e, opcionalmente, categoria e realizada_em (RFC 3339)
+func csvTransactions(r io.Reader) func() (int, TransacaoHistorica, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	var columns map[string]int
+
+	return func() (int, TransacaoHistorica, error) {
+		var t TransacaoHistorica
+		if columns == nil {
+			header, err := reader.Read()
+			if err != nil {
+				return 1, t, err
+			}
+			columns = make(map[string]int, len(header))
+			for i, name := range header {
+				columns[strings.ToLower(strings.TrimSpace(name))] = i
+			}
+			for _, name := range []string{"cliente_id", "valor", "tipo", "descricao"} {
+				if _, ok := columns[name]; !ok {
+					return 1, t, fmt.Errorf("header is missing the %s column", name)
+				}
+			}
+		}
+
+		record, err := reader.Read()
+		if err != nil {
+			return 0, t, err
+		}
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		if t.ClienteID, err = strconv.Atoi(field("cliente_id")); err != nil {
+			return line, t, fmt.Errorf("invalid cliente_id: %w", err)
+		}
+		if t.Valor, err = ParseCentavos(field("valor")); err != nil {
+			return line, t, err
+		}
+		t.Tipo = field("tipo")
+		t.Descricao = field("descricao")
+		if category := field("categoria"); category != "" {
+			t.Categoria = &category
+		}
+		if realizadaEm := field("realizada_em"); realizadaEm != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, realizadaEm)
+			if err != nil {
+				return line, t, fmt.Errorf("invalid realizada_em: %w", err)
+			}
+			t.RealizadaEm = &parsed
+		}
+		return line, t, nil
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrParcelamentoIndisponivel = errors.New("parcelamento não habilitado")
+	ErrParcelasApenasDebito     = errors.New("só débitos podem ser parcelados")
+	ErrParcelasAcimaDoValor     = errors.New("o valor não cobre um centavo por parcela")
+)
+
+// installmentsEnabled aceita parcelas em POST /transacoes e mostra os
+// parcelamentos em aberto no extrato (PARCELAMENTO_ENABLED, só com o
+// Postgres). Desligado, o extrato não paga a consulta extra.
+var installmentsEnabled = getEnvBool("PARCELAMENTO_ENABLED", false)
+
+// Parcelamento é um débito parcelado com parcelas ainda por vencer. TransacaoID
+// é a transação da primeira parcela, que identifica o parcelamento.
+type Parcelamento struct {
+	ID             int        `json:"id"`
+	TransacaoID    int64      `json:"transacao_id"`
+	Descricao      string     `json:"descricao"`
+	ValorTotal     Centavos   `json:"valor_total"`
+	Parcelas       int        `json:"parcelas"`
+	Pagas          []int64    `json:"transacoes_pagas"`
+	Restantes      int        `json:"parcelas_restantes"`
+	ValorRestante  Centavos   `json:"valor_restante"`
+	ProximaParcela *time.Time `json:"proxima_parcela,omitempty"`
+}
+
+// createInstallments divide o débito em transaction.Parcelas parcelas
+// mensais: a primeira, com o resto da divisão, é aplicada agora e as demais
+// viram agendamentos executados por runScheduler, que as registra em
+// transacoes_parcelas. Só a primeira parcela passa pelo limite agora; uma
+// parcela futura sem limite fica como rejeitada em agendamento_execucoes.
+func createInstallments(ctx context.Context, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	var balance Balance
+	if !installmentsEnabled || poolFor(ctx) == nil {
+		return balance, ErrParcelamentoIndisponivel
+	}
+	if transaction.Tipo != "d" {
+		return balance, ErrParcelasApenasDebito
+	}
+	// com menos de um centavo por parcela, as parcelas futuras seriam zero
+	if transaction.Valor < Centavos(transaction.Parcelas) {
+		return balance, ErrParcelasAcimaDoValor
+	}
+
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return balance, err
+	}
+	defer tx.Rollback(ctx)
+
+	total := transaction.Valor
+	installments := Centavos(transaction.Parcelas)
+	installment := total / installments
+	transaction.Valor = installment + total%installments
+	balance, err = createTransaction(ctx, tx, clientId, transaction)
+	if err != nil {
+		return balance, err
+	}
+
+	var id int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO parcelamentos (cliente_id, transacao_id, descricao, valor_total, parcelas)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`,
+		clientId, transaction.ID, transaction.Descricao, total, transaction.Parcelas).Scan(&id)
+	if err != nil {
+		return balance, err
+	}
+	if err := recordInstallment(ctx, tx, transaction.ID, id, 1); err != nil {
+		return balance, err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO agendamentos
+		(cliente_id, valor, tipo, descricao, categoria, proxima_execucao, parcelamento_id, parcela)
+		SELECT $1, $2, 'd', $3, NULLIF($4, ''), (NOW() AT TIME ZONE 'UTC') + make_interval(months => n - 1), $5, n
+		FROM generate_series(2, $6::int) n`,
+		clientId, installment, transaction.Descricao, transaction.Categoria, id, transaction.Parcelas)
+	if err != nil {
+		return balance, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return balance, err
+	}
+	forgetCachedBalance(ctx, clientId)
+	return balance, nil
+}
+
+func recordInstallment(ctx context.Context, db dbtx, transactionId int64, installmentPlanId, installment int) error {
+	_, err := db.Exec(ctx, `
+		INSERT INTO transacoes_parcelas (transacao_id, parcelamento_id, parcela) VALUES ($1, $2, $3)`,
+		transactionId, installmentPlanId, installment)
+	return err
+}
+
+// openInstallments lista os parcelamentos do cliente com parcelas a vencer
+func openInstallments(ctx context.Context, db dbtx, clientId int) ([]Parcelamento, error) {
+	rows, err := db.Query(ctx, `
+		SELECT p.id, p.transacao_id, p.descricao, p.valor_total, p.parcelas,
+			ARRAY(SELECT tp.transacao_id::bigint FROM transacoes_parcelas tp
+				WHERE tp.parcelamento_id = p.id ORDER BY tp.parcela),
+			COUNT(a.id), COALESCE(SUM(a.valor), 0)::bigint, MIN(a.proxima_execucao)
+		FROM parcelamentos p
+		JOIN agendamentos a ON a.parcelamento_id = p.id AND a.ativo
+		WHERE p.cliente_id = $1
+		GROUP BY p.id
+		ORDER BY p.id`, clientId)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Parcelamento, error) {
+		var plan Parcelamento
+		err := row.Scan(&plan.ID, &plan.TransacaoID, &plan.Descricao, &plan.ValorTotal, &plan.Parcelas,
+			&plan.Pagas, &plan.Restantes, &plan.ValorRestante, &plan.ProximaParcela)
+		return plan, err
+	})
+}
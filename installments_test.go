@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestInstallmentsBelowOneCentEach(t *testing.T) {
+	previous := installmentsEnabled
+	installmentsEnabled = true
+	t.Cleanup(func() { installmentsEnabled = previous })
+
+	httpClient, baseURL := testClient(postgresTestApp(t), httpStackFiber)
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"valor": 3, "tipo": "d", "descricao": "parcelas", "parcelas": 4}`, http.StatusUnprocessableEntity},
+		{`{"valor": 4, "tipo": "d", "descricao": "parcelas", "parcelas": 4}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		resp, err := httpClient.Post(baseURL+"/clientes/1/transacoes", "application/json", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var problem Problem
+		json.NewDecoder(resp.Body).Decode(&problem)
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.body, resp.StatusCode, test.status)
+		}
+		if test.status != http.StatusOK && problem.Codigo != "PARCELAS_ACIMA_DO_VALOR" {
+			t.Errorf("%s: codigo %q, want PARCELAS_ACIMA_DO_VALOR", test.body, problem.Codigo)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Diário local de escritas (WAL_PATH, só com o Postgres). Quando o banco não
+// aceita conexões, as transações de clientes cujo último saldo conhecido
+// pela instância as comporta são gravadas em WAL_PATH/wal.log, com fsync, e
+// respondidas com 202 Accepted; runReplayer as aplica no banco, na ordem em
+// que foram aceitas, assim que ele volta. Enquanto um cliente tem entradas
+// pendentes, as escritas seguintes dele também vão para o diário, para que
+// nenhuma passe à frente das anteriores.
+//
+// O saldo conhecido é o desta instância: escritas feitas por outras durante a
+// queda não entram na conta, e o limite, as categorias e a fraude só são
+// conferidos de fato no replay. Entradas recusadas nesse momento são
+// descartadas e contadas em journal.rejected.
+
+var (
+	journalMetrics   = expvar.NewMap("journal")
+	journalReplayLag = new(expvar.Float)
+)
+
+func init() {
+	journalMetrics.Set("replay_lag_seconds", journalReplayLag)
+}
+
+// journalEntry é uma linha do wal.log
+type journalEntry struct {
+	Seq       int64            `json:"seq"`
+	Tenant    string           `json:"tenant,omitempty"`
+	ClienteID int              `json:"cliente_id"`
+	Transacao TransacaoRequest `json:"transacao"`
+	AceitaEm  time.Time        `json:"aceita_em"`
+}
+
+type journalClient struct {
+	tenant   string
+	clientId int
+}
+
+// journalStorage decora o Storage com o diário. wal.pos guarda o seq da
+// última entrada aplicada; o wal.log só é esvaziado quando todas foram
+// aplicadas, e os seqs nunca recomeçam, então um wal.pos mais novo que o
+// wal.log não faz entradas novas serem puladas.
+type journalStorage struct {
+	Storage
+	dir        string
+	maxEntries int
+
+	mu       sync.Mutex
+	file     *os.File
+	seq      int64
+	replayed int64
+	pending  map[journalClient]int
+	// balances é o último saldo conhecido de cada cliente, já com as
+	// entradas pendentes do diário
+	balances map[journalClient]Balance
+	// version muda a cada alteração de balances, para o snapshot
+	// (journal_snapshot.go) só ser gravado quando há o que gravar
+	version  int64
+	snapshot *sql.DB
+}
+
+func newJournalStorage(inner Storage, dir string) (*journalStorage, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	s := &journalStorage{
+		Storage:    inner,
+		dir:        dir,
+		maxEntries: getEnvInt("WAL_MAX_ENTRADAS", 10000),
+		pending:    map[journalClient]int{},
+		balances:   map[journalClient]Balance{},
+	}
+
+	if data, err := os.ReadFile(s.posPath()); err == nil {
+		s.replayed, _ = strconv.ParseInt(string(bytes.TrimSpace(data)), 10, 64)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	s.seq = s.replayed
+	entries, err := s.readEntries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		s.seq = max(s.seq, entry.Seq)
+		s.pending[journalClient{entry.Tenant, entry.ClienteID}]++
+	}
+	if len(entries) > 0 {
+		log.Printf("Write-ahead journal has %d pending transactions", len(entries))
+	}
+	if balanceSnapshotEnabled {
+		ctx := context.Background()
+		if err := s.openSnapshot(ctx); err != nil {
+			return nil, err
+		}
+		if err := s.restoreSnapshot(ctx, entries, getEnvDuration("SALDOS_SNAPSHOT_VALIDADE", 5*time.Minute)); err != nil {
+			log.Print("Error restoring balance snapshot: ", err)
+		}
+	}
+
+	if err := s.dropPartialEntry(); err != nil {
+		return nil, err
+	}
+	s.file, err = os.OpenFile(s.logPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	journalMetrics.Set("pending", expvar.Func(func() any {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.seq - s.replayed
+	}))
+	return s, nil
+}
+
+func (s *journalStorage) logPath() string { return filepath.Join(s.dir, "wal.log") }
+
+func (s *journalStorage) posPath() string { return filepath.Join(s.dir, "wal.pos") }
+
+// isDatabaseUnavailable diz se err garante que a escrita não chegou ao
+// banco porque ele está fora do ar
+func isDatabaseUnavailable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &connectErr) || pgconn.SafeToRetry(err)
+}
+
+func (s *journalStorage) CreateTransaction(ctx context.Context, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	key := journalClient{tenantFrom(ctx), clientId}
+	s.mu.Lock()
+	pending := s.pending[key] > 0
+	s.mu.Unlock()
+
+	if !pending {
+		balance, err := s.Storage.CreateTransaction(ctx, clientId, transaction)
+		if err == nil {
+			s.remember(key, balance)
+			return balance, nil
+		}
+		if !isDatabaseUnavailable(ctx, err) {
+			return balance, err
+		}
+		log.Print("Database unavailable, journaling transaction: ", err)
+	}
+	return s.append(key, transaction, nowFor(ctx))
+}
+
+func (s *journalStorage) GetBalance(ctx context.Context, clientId int) (Balance, error) {
+	balance, err := s.Storage.GetBalance(ctx, clientId)
+	if err == nil {
+		s.remember(journalClient{tenantFrom(ctx), clientId}, balance)
+	}
+	return balance, err
+}
+
+func (s *journalStorage) Statement(ctx context.Context, clientId int, filter TransactionFilter) (Extrato, error) {
+	statement, err := s.Storage.Statement(ctx, clientId, filter)
+	if err == nil {
+		s.remember(journalClient{tenantFrom(ctx), clientId}, statement.Saldo)
+	}
+	return statement, err
+}
+
+// remember guarda o saldo lido do banco, a não ser que o cliente tenha
+// entradas pendentes, já contadas no saldo guardado
+func (s *journalStorage) remember(key journalClient, balance Balance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if known, ok := s.balances[key]; s.pending[key] == 0 && (!ok || known != balance) {
+		s.balances[key] = balance
+		s.version++
+	}
+}
+
+// append grava a transação no diário se o saldo conhecido do cliente a
+// comporta. Sem saldo conhecido ou com o diário cheio, a transação é
+// recusada com ErrConflitoConcorrencia, para ser repetida depois.
+func (s *journalStorage) append(key journalClient, transaction *TransacaoRequest, now time.Time) (Balance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance, ok := s.balances[key]
+	if !ok || s.seq-s.replayed >= int64(s.maxEntries) {
+		return Balance{}, ErrConflitoConcorrencia
+	}
+	if transaction.Tipo == "d" {
+		if balance.Saldo-balance.Reservado-transaction.Valor < -balance.Limite {
+			return Balance{}, ErrLimiteExcedido
+		}
+		balance.Saldo -= transaction.Valor
+	} else {
+		balance.Saldo += transaction.Valor
+	}
+
+	entry := journalEntry{
+		Seq:       s.seq + 1,
+		Tenant:    key.tenant,
+		ClienteID: key.clientId,
+		Transacao: *transaction,
+		AceitaEm:  now,
+	}
+	line, err := jsonMarshal(entry)
+	if err != nil {
+		return Balance{}, err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return Balance{}, err
+	}
+	if err := s.file.Sync(); err != nil {
+		return Balance{}, err
+	}
+
+	s.seq = entry.Seq
+	s.pending[key]++
+	s.balances[key] = balance
+	s.version++
+	transaction.Pendente = true
+	journalMetrics.Add("appended", 1)
+	return balance, nil
+}
+
+// readEntries lê as entradas ainda não aplicadas. Uma última linha sem \n é
+// uma escrita interrompida, que não chegou a ser confirmada ao cliente.
+func (s *journalStorage) readEntries() ([]journalEntry, error) {
+	data, err := os.ReadFile(s.logPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []journalEntry
+	for {
+		line, rest, found := bytes.Cut(data, []byte{'\n'})
+		if !found {
+			break
+		}
+		data = rest
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, err
+		}
+		if entry.Seq > s.replayed {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// dropPartialEntry corta do wal.log a escrita interrompida que readEntries
+// ignora, para que a próxima entrada não seja gravada na mesma linha
+func (s *journalStorage) dropPartialEntry() error {
+	data, err := os.ReadFile(s.logPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return nil
+	}
+	log.Print("Dropping an interrupted write at the end of the write-ahead journal")
+	return os.Truncate(s.logPath(), int64(bytes.LastIndexByte(data, '\n')+1))
+}
+
+// runReplayer aplica as entradas pendentes a cada interval (WAL_INTERVALO).
+// Não depende da eleição de líder: cada instância tem o seu diário.
+func (s *journalStorage) runReplayer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.replay(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Print("Error replaying write-ahead journal: ", err)
+			}
+		}
+	}
+}
+
+// replay aplica as entradas na ordem do diário e para na primeira que
+// encontrar o banco fora do ar, para manter a ordem de cada cliente
+func (s *journalStorage) replay(ctx context.Context) error {
+	s.mu.Lock()
+	idle := s.seq == s.replayed
+	s.mu.Unlock()
+	if idle {
+		return nil
+	}
+
+	entries, err := s.readEntries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryCtx := withTenant(ctx, entry.Tenant)
+		key := journalClient{entry.Tenant, entry.ClienteID}
+		balance, err := s.Storage.CreateTransaction(entryCtx, entry.ClienteID, &entry.Transacao)
+		if err != nil && isDatabaseUnavailable(entryCtx, err) {
+			return nil
+		}
+		if err != nil && ctx.Err() != nil {
+			return err
+		}
+		statements.Invalidate(statementClient{tenant: entry.Tenant, clientId: entry.ClienteID})
+		if err := s.savePosition(entry.Seq); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.replayed = entry.Seq
+		s.pending[key]--
+		if s.pending[key] == 0 {
+			delete(s.pending, key)
+			// sem entradas à frente, o saldo do banco é o atual
+			if err == nil {
+				s.balances[key] = balance
+			} else {
+				delete(s.balances, key)
+			}
+			s.version++
+		}
+		s.mu.Unlock()
+
+		journalReplayLag.Set(time.Since(entry.AceitaEm).Seconds())
+		if err != nil {
+			journalMetrics.Add("rejected", 1)
+			log.Printf("Journaled transaction %d for client %d rejected on replay: %v", entry.Seq, entry.ClienteID, err)
+			continue
+		}
+		journalMetrics.Add("replayed", 1)
+	}
+	return s.compact()
+}
+
+// savePosition grava em wal.pos o seq da última entrada aplicada
+func (s *journalStorage) savePosition(seq int64) error {
+	f, err := os.OpenFile(s.posPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(strconv.AppendInt(nil, seq, 10)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// compact esvazia o wal.log quando todas as entradas foram aplicadas
+func (s *journalStorage) compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seq != s.replayed {
+		return nil
+	}
+	return s.file.Truncate(0)
+}
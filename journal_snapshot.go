@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// Réplica dos saldos do diário (SALDOS_SNAPSHOT_ENABLED, com WAL_PATH): a
+// cada SALDOS_SNAPSHOT_INTERVALO os últimos saldos conhecidos pela instância
+// e o seq do diário são copiados para a tabela saldos_snapshot de um SQLite
+// em WAL_PATH/saldos.db, numa única transação. Na subida, um snapshot com
+// menos de SALDOS_SNAPSHOT_VALIDADE repovoa os saldos sem esperar leituras
+// do banco, e as entradas do wal.log posteriores ao seq gravado são somadas
+// a ele; assim uma instância reiniciada com o Postgres fora do ar volta a
+// aceitar escritas no diário. Como o snapshot e o seq são gravados juntos, um
+// crash no meio da gravação deixa o snapshot anterior inteiro, e as entradas
+// nunca são contadas duas vezes.
+
+var balanceSnapshotEnabled = getEnvBool("SALDOS_SNAPSHOT_ENABLED", false)
+
+const balanceSnapshotSchema = `
+CREATE TABLE IF NOT EXISTS saldos_snapshot (
+	tenant TEXT NOT NULL,
+	cliente_id INTEGER NOT NULL,
+	saldo INTEGER NOT NULL,
+	limite INTEGER NOT NULL,
+	reservado INTEGER NOT NULL,
+	PRIMARY KEY (tenant, cliente_id)
+);
+CREATE TABLE IF NOT EXISTS saldos_snapshot_checkpoint (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	diario_seq INTEGER NOT NULL,
+	gravado_em INTEGER NOT NULL
+);`
+
+func (s *journalStorage) snapshotPath() string { return filepath.Join(s.dir, "saldos.db") }
+
+// openSnapshot abre o SQLite do snapshot e cria as tabelas, se preciso
+func (s *journalStorage) openSnapshot(ctx context.Context) error {
+	db, err := sql.Open("sqlite", "file:"+s.snapshotPath()+
+		"?_pragma=journal_mode(WAL)"+
+		"&_pragma=busy_timeout(5000)"+
+		"&_pragma=synchronous(FULL)"+
+		"&_txlock=immediate")
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, balanceSnapshotSchema); err != nil {
+		db.Close()
+		return err
+	}
+	s.snapshot = db
+	return nil
+}
+
+// restoreSnapshot carrega o snapshot, se ainda for válido, e soma a ele as
+// entradas pendentes gravadas depois do seq do snapshot. Chamada antes de a
+// instância aceitar requisições, sem o lock.
+func (s *journalStorage) restoreSnapshot(ctx context.Context, entries []journalEntry, validity time.Duration) error {
+	var seq, savedAt int64
+	err := s.snapshot.QueryRowContext(ctx,
+		"SELECT diario_seq, gravado_em FROM saldos_snapshot_checkpoint WHERE id = 1").Scan(&seq, &savedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	age := time.Since(time.UnixMilli(savedAt))
+	if age > validity {
+		log.Printf("Balance snapshot is %s old, ignoring", age.Round(time.Second))
+		return nil
+	}
+
+	rows, err := s.snapshot.QueryContext(ctx, "SELECT tenant, cliente_id, saldo, limite, reservado FROM saldos_snapshot")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key journalClient
+		var balance Balance
+		if err := rows.Scan(&key.tenant, &key.clientId, &balance.Saldo, &balance.Limite, &balance.Reservado); err != nil {
+			return err
+		}
+		s.balances[key] = balance
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// as entradas até seq já estão nos saldos do snapshot
+	for _, entry := range entries {
+		key := journalClient{entry.Tenant, entry.ClienteID}
+		balance, ok := s.balances[key]
+		if entry.Seq <= seq || !ok {
+			continue
+		}
+		if entry.Transacao.Tipo == "d" {
+			balance.Saldo -= entry.Transacao.Valor
+		} else {
+			balance.Saldo += entry.Transacao.Valor
+		}
+		s.balances[key] = balance
+	}
+	log.Printf("Restored %d balances from a snapshot taken %s ago", len(s.balances), age.Round(time.Second))
+	journalMetrics.Add("snapshot_restored", int64(len(s.balances)))
+	return nil
+}
+
+// runSnapshotter grava o snapshot a cada interval (SALDOS_SNAPSHOT_INTERVALO),
+// quando os saldos mudaram desde a última gravação, e uma última vez ao sair
+func (s *journalStorage) runSnapshotter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var saved int64
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.saveSnapshot(context.WithoutCancel(ctx), &saved); err != nil {
+				log.Print("Error saving balance snapshot: ", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.saveSnapshot(ctx, &saved); err != nil && !errors.Is(err, context.Canceled) {
+				log.Print("Error saving balance snapshot: ", err)
+			}
+		}
+	}
+}
+
+// saveSnapshot troca o snapshot pelos saldos atuais, numa transação; saved é
+// a versão dos saldos da última gravação
+func (s *journalStorage) saveSnapshot(ctx context.Context, saved *int64) error {
+	s.mu.Lock()
+	if s.version == *saved {
+		s.mu.Unlock()
+		return nil
+	}
+	version, seq := s.version, s.seq
+	balances := make(map[journalClient]Balance, len(s.balances))
+	for key, balance := range s.balances {
+		balances[key] = balance
+	}
+	s.mu.Unlock()
+
+	tx, err := s.snapshot.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM saldos_snapshot"); err != nil {
+		return err
+	}
+	insert, err := tx.PrepareContext(ctx,
+		"INSERT INTO saldos_snapshot (tenant, cliente_id, saldo, limite, reservado) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for key, balance := range balances {
+		if _, err := insert.ExecContext(ctx, key.tenant, key.clientId, balance.Saldo, balance.Limite, balance.Reservado); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO saldos_snapshot_checkpoint (id, diario_seq, gravado_em) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET diario_seq = excluded.diario_seq, gravado_em = excluded.gravado_em`,
+		seq, time.Now().UnixMilli()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	*saved = version
+	journalMetrics.Add("snapshots", 1)
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+// errUnavailable faz o journalStorage tratar o banco como fora do ar
+type errUnavailable struct{}
+
+func (errUnavailable) Error() string     { return "database unavailable" }
+func (errUnavailable) SafeToRetry() bool { return true }
+
+// flakyStorage é um Storage que cai e volta quando o teste manda
+type flakyStorage struct {
+	Storage
+	down atomic.Bool
+}
+
+func (s *flakyStorage) CreateTransaction(ctx context.Context, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	if s.down.Load() {
+		return Balance{}, errUnavailable{}
+	}
+	return s.Storage.CreateTransaction(ctx, clientId, transaction)
+}
+
+// TestJournalSnapshotCrash derruba o diário em pontos diferentes da gravação
+// do snapshot e do replay e confere que, depois de restaurar o snapshot e
+// aplicar o que faltava, o saldo conhecido e o do banco contam cada entrada
+// uma única vez
+func TestJournalSnapshotCrash(t *testing.T) {
+	previous := balanceSnapshotEnabled
+	balanceSnapshotEnabled = true
+	t.Cleanup(func() { balanceSnapshotEnabled = previous })
+
+	credit := func(valor Centavos) *TransacaoRequest {
+		return &TransacaoRequest{Valor: valor, Tipo: TipoCredito, Descricao: "diario"}
+	}
+	debit := func(valor Centavos) *TransacaoRequest {
+		return &TransacaoRequest{Valor: valor, Tipo: TipoDebito, Descricao: "diario"}
+	}
+
+	tests := []struct {
+		name string
+		// crash recebe o diário com o banco fora do ar e o deixa no estado
+		// em que a instância morreu
+		crash func(t *testing.T, ctx context.Context, s *journalStorage)
+	}{
+		{"entradas depois do snapshot", func(t *testing.T, ctx context.Context, s *journalStorage) {
+			journalTransactions(t, ctx, s, credit(100), credit(200))
+			saveTestSnapshot(t, s)
+			journalTransactions(t, ctx, s, debit(50))
+		}},
+		{"replay parcial depois do snapshot", func(t *testing.T, ctx context.Context, s *journalStorage) {
+			journalTransactions(t, ctx, s, credit(100), credit(200), debit(50))
+			saveTestSnapshot(t, s)
+			// o replay aplicou a primeira entrada e gravou o wal.pos dela
+			if _, err := s.Storage.(*flakyStorage).Storage.CreateTransaction(ctx, 1, credit(100)); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.savePosition(1); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"wal.log truncado", func(t *testing.T, ctx context.Context, s *journalStorage) {
+			journalTransactions(t, ctx, s, credit(100), credit(200))
+			saveTestSnapshot(t, s)
+			journalTransactions(t, ctx, s, debit(50))
+			// uma quarta escrita interrompida no meio, nunca confirmada
+			if _, err := s.file.WriteString(`{"seq":4,"cliente_id":1,"transacao":{"valor":`); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := sqliteTestApp(t)
+			ctx := withApp(context.Background(), app)
+			inner := &flakyStorage{Storage: app.storage}
+			dir := t.TempDir()
+			s := openJournal(t, inner, dir)
+			if _, err := s.GetBalance(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+
+			inner.down.Store(true)
+			test.crash(t, ctx, s)
+			s.file.Close()
+			s.snapshot.Close()
+
+			s = openJournal(t, inner, dir)
+			if got := s.balances[journalClient{"", 1}].Saldo; got != 250 {
+				t.Errorf("restored balance %d, want 250", got)
+			}
+			// depois de uma escrita interrompida, o diário continua legível
+			journalTransactions(t, ctx, s, credit(10))
+			inner.down.Store(false)
+			if err := s.replay(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if pending := s.seq - s.replayed; pending != 0 {
+				t.Errorf("%d entries still pending", pending)
+			}
+			balance, err := inner.GetBalance(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance.Saldo != 260 {
+				t.Errorf("database balance %d after the replay, want 260", balance.Saldo)
+			}
+			if got := s.balances[journalClient{"", 1}].Saldo; got != 260 {
+				t.Errorf("known balance %d after the replay, want 260", got)
+			}
+		})
+	}
+}
+
+func openJournal(t *testing.T, inner Storage, dir string) *journalStorage {
+	t.Helper()
+	s, err := newJournalStorage(inner, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.file.Close()
+		s.snapshot.Close()
+	})
+	return s
+}
+
+// journalTransactions grava as transações no diário, com o banco fora do ar
+func journalTransactions(t *testing.T, ctx context.Context, s *journalStorage, transactions ...*TransacaoRequest) {
+	t.Helper()
+	for _, transaction := range transactions {
+		if _, err := s.CreateTransaction(ctx, 1, transaction); err != nil {
+			t.Fatal(err)
+		}
+		if !transaction.Pendente {
+			t.Fatal("transaction was not journaled")
+		}
+	}
+}
+
+func saveTestSnapshot(t *testing.T, s *journalStorage) {
+	t.Helper()
+	var saved int64
+	if err := s.saveSnapshot(context.Background(), &saved); err != nil {
+		t.Fatal(err)
+	}
+}
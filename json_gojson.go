@@ -0,0 +1,10 @@
+//go:build gojson
+
+package main
+
+import "github.com/goccy/go-json"
+
+var (
+	jsonMarshal   = json.Marshal
+	jsonUnmarshal = json.Unmarshal
+)
@@ -0,0 +1,16 @@
+//go:build sonic && !gojson
+
+package main
+
+import "github.com/bytedance/sonic"
+
+// O loader do sonic depende do layout interno do runtime e só compila com
+// as versões do Go que ele conhece (a v1.11 parava no go1.22): ao subir o Go
+// da imagem, suba o sonic junto.
+//
+// ConfigStd mantém a compatibilidade com encoding/json (ordenação de
+// chaves, escape de HTML e validação de UTF-8).
+var (
+	jsonMarshal   = sonic.ConfigStd.Marshal
+	jsonUnmarshal = sonic.ConfigStd.Unmarshal
+)
@@ -0,0 +1,12 @@
+//go:build !gojson && !sonic
+
+package main
+
+import "encoding/json"
+
+// Codec JSON usado pelo fiber e pelo restante da aplicação. Compile com
+// -tags gojson ou -tags sonic para trocar a implementação.
+var (
+	jsonMarshal   = json.Marshal
+	jsonUnmarshal = json.Unmarshal
+)
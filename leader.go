@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// leaderElectionEnabled faz os jobs periódicos (agendamentos, arquivamento,
+// projeção, outbox e reconciliação) rodarem só na instância líder de cada
+// tenant (LIDER_ENABLED). A liderança é um pg_try_advisory_lock de sessão
+// mantido numa conexão própria, fora do pool: se a instância cai, o Postgres
+// libera o bloqueio e outra assume na próxima tentativa.
+var leaderElectionEnabled = getEnvBool("LIDER_ENABLED", true)
+
+var (
+	leaders   = map[string]*leaderElector{}
+	leadersMu sync.Mutex
+	hostname  = func() string {
+		name, _ := os.Hostname()
+		return name
+	}()
+)
+
+// leaderElector disputa a liderança de um tenant. Enquanto é líder, term é
+// um contexto cancelado quando a liderança se perde.
+type leaderElector struct {
+	tenant string
+
+	mu       sync.Mutex
+	term     context.Context
+	cancel   context.CancelFunc
+	acquired chan struct{}
+	since    time.Time
+}
+
+func leaderFor(ctx context.Context) *leaderElector {
+	tenant := tenantFrom(ctx)
+	leadersMu.Lock()
+	defer leadersMu.Unlock()
+	elector := leaders[tenant]
+	if elector == nil {
+		elector = &leaderElector{tenant: tenant, acquired: make(chan struct{})}
+		leaders[tenant] = elector
+	}
+	return elector
+}
+
+// runAsLeader executa job sempre que esta instância é a líder do tenant do
+// contexto. job recebe um contexto cancelado quando a liderança se perde e
+// deve retornar nesse caso; ele volta a rodar se a liderança for retomada.
+func runAsLeader(ctx context.Context, name string, job func(ctx context.Context)) {
+	if !leaderElectionEnabled {
+		job(ctx)
+		return
+	}
+	elector := leaderFor(ctx)
+	for {
+		term, err := elector.wait(ctx)
+		if err != nil {
+			return
+		}
+		log.Printf("Running %s as leader", name)
+		job(term)
+	}
+}
+
+func (e *leaderElector) wait(ctx context.Context) (context.Context, error) {
+	for {
+		e.mu.Lock()
+		term, acquired := e.term, e.acquired
+		e.mu.Unlock()
+		if term != nil && term.Err() == nil {
+			return term, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-acquired:
+		}
+	}
+}
+
+// run tenta obter a liderança a cada interval e, depois de obtida, confirma
+// a cada interval que a conexão que segura o bloqueio continua viva. Uma
+// conexão derrubada sem aviso só é percebida no próximo ciclo, então outra
+// instância pode assumir até interval antes desta parar os jobs; os jobs
+// usam SKIP LOCKED e toleram essa sobreposição.
+func (e *leaderElector) run(ctx context.Context, interval time.Duration) {
+	var conn *pgx.Conn
+	defer func() {
+		e.step(false)
+		if conn != nil {
+			conn.Close(context.Background())
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		leader, err := e.campaign(ctx, &conn)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Print("Error checking leadership: ", err)
+		}
+		if err != nil && conn != nil {
+			conn.Close(context.Background())
+			conn = nil
+		}
+		e.step(leader)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *leaderElector) campaign(ctx context.Context, conn **pgx.Conn) (bool, error) {
+	if *conn == nil {
+		connected, err := pgx.ConnectConfig(ctx, poolFor(ctx).Config().ConnConfig)
+		if err != nil {
+			return false, err
+		}
+		*conn = connected
+	}
+
+	e.mu.Lock()
+	leader := e.term != nil
+	e.mu.Unlock()
+	if leader {
+		_, err := (*conn).Exec(ctx, "SELECT 1")
+		return err == nil, err
+	}
+
+	var acquired bool
+	err := (*conn).QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtext('rinha.lider.' || $1))", e.tenant).
+		Scan(&acquired)
+	return acquired, err
+}
+
+// step registra o resultado de uma tentativa, acordando os jobs quando a
+// liderança é obtida e cancelando-os quando ela se perde
+func (e *leaderElector) step(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch {
+	case leader && e.term == nil:
+		e.term, e.cancel = context.WithCancel(withTenant(context.Background(), e.tenant))
+		e.since = time.Now().UTC()
+		close(e.acquired)
+		log.Printf("Became leader for tenant %q", e.tenant)
+	case !leader && e.term != nil:
+		e.cancel()
+		e.term, e.cancel = nil, nil
+		e.acquired = make(chan struct{})
+		log.Printf("Lost leadership for tenant %q", e.tenant)
+	}
+}
+
+// StatusLider representa a liderança desta instância em um tenant
+type StatusLider struct {
+	Tenant    string     `json:"tenant"`
+	Instancia string     `json:"instancia"`
+	Lider     bool       `json:"lider"`
+	Desde     *time.Time `json:"desde,omitempty"`
+}
+
+func handleLeaderStatus(c fiber.Ctx) error {
+	if !leaderElectionEnabled {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	leadersMu.Lock()
+	statuses := make([]StatusLider, 0, len(leaders))
+	for tenant, elector := range leaders {
+		status := StatusLider{Tenant: tenant, Instancia: hostname}
+		elector.mu.Lock()
+		if elector.term != nil {
+			since := elector.since
+			status.Lider = true
+			status.Desde = &since
+		}
+		elector.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	leadersMu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Tenant < statuses[j].Tenant })
+	return c.JSON(statuses)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// doubleEntryEnabled liga as partidas dobradas (PARTIDAS_DOBRADAS): toda
+// transação registrada grava, pelo gatilho partidas_trigger, uma entrada na
+// conta do cliente e a contrapartida na conta da casa (id 0), e o gatilho
+// partidas_balanceadas recusa no commit as transações cujas entradas não
+// somam zero. O modelo de transacoes e clientes.saldo não muda; entradas é a
+// base para produtos que movimentem outras contas.
+var doubleEntryEnabled = getEnvBool("PARTIDAS_DOBRADAS", false)
+
+// configureDoubleEntry liga o gatilho nas conexões do pool, que os pools
+// dos tenants herdam da configuração base
+func configureDoubleEntry(config *pgxpool.Config) {
+	if doubleEntryEnabled {
+		config.ConnConfig.RuntimeParams["rinha.partidas_dobradas"] = "on"
+	}
+}
+
+// SaldoConta é o saldo de uma conta pelas entradas gravadas
+type SaldoConta struct {
+	ContaID   int      `json:"conta_id"`
+	Nome      string   `json:"nome"`
+	ClienteID *int     `json:"cliente_id,omitempty"`
+	Saldo     Centavos `json:"saldo"`
+	Entradas  int64    `json:"entradas"`
+}
+
+// BalancoPartidas lista os saldos das contas; Total é a soma de todos eles,
+// que é sempre zero se o invariante das partidas vale
+type BalancoPartidas struct {
+	Contas []SaldoConta `json:"contas"`
+	Total  Centavos     `json:"total"`
+}
+
+func handleLedgerBalances(c fiber.Ctx) error {
+	ctx := c.UserContext()
+	rows, err := poolFor(ctx).Query(ctx, `
+		SELECT c.id, c.nome, c.cliente_id, COALESCE(SUM(e.valor), 0)::bigint, COUNT(e.id)
+		FROM contas c
+		LEFT JOIN entradas e ON e.conta_id = c.id
+		GROUP BY c.id
+		ORDER BY c.id`)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	accounts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (SaldoConta, error) {
+		var account SaldoConta
+		err := row.Scan(&account.ContaID, &account.Nome, &account.ClienteID, &account.Saldo, &account.Entradas)
+		return account, err
+	})
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+
+	report := BalancoPartidas{Contas: accounts}
+	for _, account := range accounts {
+		if report.Total, err = report.Total.Add(account.Saldo); err != nil {
+			return c.SendStatus(fiber.ErrInternalServerError.Code)
+		}
+	}
+	return c.JSON(report)
+}
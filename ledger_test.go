@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestDoubleEntryBalances passa um crédito, um débito, o estorno do débito e
+// a captura de uma autorização pelas rotas e confere o invariante das
+// partidas dobradas: as entradas de cada transação, e portanto todas elas,
+// somam zero, e a conta do cliente acompanha clientes.saldo
+func TestDoubleEntryBalances(t *testing.T) {
+	previous := doubleEntryEnabled
+	doubleEntryEnabled = true
+	t.Cleanup(func() { doubleEntryEnabled = previous })
+
+	app := postgresTestApp(t)
+	ctx := context.Background()
+	httpClient, baseURL := testClient(app, httpStackFiber)
+	post := func(path, body string, out any) {
+		t.Helper()
+		resp, err := httpClient.Post(baseURL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			t.Fatalf("POST %s: status %d", path, resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+	}
+	ledger := func() (accountBalance, clientBalance Centavos, entries int) {
+		t.Helper()
+		err := app.pool.QueryRow(ctx, `
+			SELECT COALESCE(SUM(e.valor), 0)::bigint, (SELECT saldo FROM clientes WHERE id = 1), COUNT(e.id)
+			FROM contas c LEFT JOIN entradas e ON e.conta_id = c.id
+			WHERE c.cliente_id = 1`).Scan(&accountBalance, &clientBalance, &entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return accountBalance, clientBalance, entries
+	}
+
+	accountBefore, clientBefore, entriesBefore := ledger()
+
+	var credit, debit TransacaoResponse
+	post("/clientes/1/transacoes", `{"valor": 1000, "tipo": "c", "descricao": "credito"}`, &credit)
+	post("/clientes/1/transacoes", `{"valor": 400, "tipo": "d", "descricao": "debito"}`, &debit)
+	var refund Estorno
+	post("/clientes/1/transacoes/"+strconv.FormatInt(debit.ID, 10)+"/estorno", `{"valor": 150}`, &refund)
+	var authorization, captured Autorizacao
+	post("/clientes/1/autorizacoes", `{"valor": 300, "descricao": "autoriza"}`, &authorization)
+	post("/clientes/1/autorizacoes/"+strconv.Itoa(authorization.ID)+"/captura", `{"valor": 250}`, &captured)
+
+	var unbalanced int
+	err := app.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT transacao_id FROM entradas GROUP BY transacao_id HAVING SUM(valor) <> 0
+		) desbalanceadas`).Scan(&unbalanced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unbalanced > 0 {
+		t.Errorf("%d transactions have entries that do not sum to zero", unbalanced)
+	}
+	var total Centavos
+	if err := app.pool.QueryRow(ctx, "SELECT COALESCE(SUM(valor), 0)::bigint FROM entradas").Scan(&total); err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 {
+		t.Errorf("SUM(valor) of entradas is %d, want 0", total)
+	}
+
+	accountAfter, clientAfter, entriesAfter := ledger()
+	// crédito, débito, estorno e captura, com a contrapartida na casa
+	if entries := entriesAfter - entriesBefore; entries != 4 {
+		t.Errorf("%d entries on the client account, want 4", entries)
+	}
+	if want := clientAfter - clientBefore; accountAfter-accountBefore != want {
+		t.Errorf("client account moved %d, clientes.saldo moved %d", accountAfter-accountBefore, want)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrLimiteCategoriaExcedido = errors.New("limite mensal da categoria excedido")
+
+// LimiteCategoria representa os limites mensais de gastos de um cliente em uma categoria.
+// Débitos acima do limite suave são aceitos mas geram um alerta; acima do
+// limite rígido são rejeitados.
+type LimiteCategoria struct {
+	Categoria    string    `json:"categoria"`
+	LimiteSuave  *Centavos `json:"limite_suave" validate:"required_without=LimiteRigido,omitempty,gte=0"`
+	LimiteRigido *Centavos `json:"limite_rigido" validate:"omitempty,gte=0"`
+}
+
+// AlertaLimiteCategoria representa os dados do evento emitido quando o limite suave é ultrapassado
+type AlertaLimiteCategoria struct {
+	Categoria   string   `json:"categoria"`
+	GastoMensal Centavos `json:"gasto_mensal"`
+	LimiteSuave Centavos `json:"limite_suave"`
+}
+
+// createCategorizedDebit aplica um débito categorizado verificando os
+// limites mensais da categoria. A linha do limite é bloqueada durante a
+// transação para que débitos concorrentes não ultrapassem o limite rígido.
+func createCategorizedDebit(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Balance{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var limit LimiteCategoria
+	err = tx.QueryRow(ctx, `
+		SELECT categoria, limite_suave, limite_rigido
+		FROM limites_categoria
+		WHERE cliente_id = $1 AND categoria = $2
+		FOR UPDATE`, clientId, transaction.Categoria).
+		Scan(&limit.Categoria, &limit.LimiteSuave, &limit.LimiteRigido)
+	if errors.Is(err, pgx.ErrNoRows) {
+		tx.Rollback(ctx)
+		return insertTransaction(ctx, db, clientId, transaction)
+	}
+	if err != nil {
+		return Balance{}, err
+	}
+
+	spent, err := monthlyCategorySpending(ctx, tx, clientId, transaction.Categoria)
+	if err != nil {
+		return Balance{}, err
+	}
+	spent, err = spent.Add(transaction.Valor)
+	if err != nil {
+		return Balance{}, err
+	}
+
+	if limit.LimiteRigido != nil && spent > *limit.LimiteRigido {
+		return Balance{}, ErrLimiteCategoriaExcedido
+	}
+
+	response, err := insertTransaction(ctx, tx, clientId, transaction)
+	if err != nil {
+		return Balance{}, err
+	}
+
+	var alert *Evento
+	if limit.LimiteSuave != nil && spent > *limit.LimiteSuave {
+		alert = &Evento{
+			Tipo:      "limite_categoria_ultrapassado",
+			Tenant:    tenantFrom(ctx),
+			ClienteID: clientId,
+			Dados: AlertaLimiteCategoria{
+				Categoria:   transaction.Categoria,
+				GastoMensal: spent,
+				LimiteSuave: *limit.LimiteSuave,
+			},
+		}
+		if outboxEnabled {
+			if err := enqueueEvent(ctx, tx, *alert); err != nil {
+				return Balance{}, err
+			}
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Balance{}, err
+	}
+
+	if alert != nil {
+		events.Publish(ctx, *alert)
+	}
+	return response, nil
+}
+
+// monthlyCategorySpending soma os débitos do cliente na categoria no mês
+// corrente
+func monthlyCategorySpending(ctx context.Context, db dbtx, clientId int, category string) (Centavos, error) {
+	var spent Centavos
+	err := db.QueryRow(ctx, `
+		SELECT COALESCE(SUM(valor), 0)
+		FROM transacoes
+		WHERE cliente_id = $1 AND categoria = $2 AND tipo = 'd'
+		AND realizada_em >= date_trunc('month', NOW())`,
+		clientId, category).Scan(&spent)
+	return spent, err
+}
+
+func handleListCategoryLimits(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	rows, err := poolFor(c.UserContext()).Query(c.UserContext(), `
+		SELECT categoria, limite_suave, limite_rigido
+		FROM limites_categoria WHERE cliente_id = $1
+		ORDER BY categoria`, clientId)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	limits, err := pgx.CollectRows(rows, pgx.RowToStructByPos[LimiteCategoria])
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(limits)
+}
+
+func handleSetCategoryLimit(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	limit := new(LimiteCategoria)
+	if err := bindJSON(c, limit); err != nil {
+		return sendBindError(c, err)
+	}
+	limit.Categoria = c.Params("categoria")
+
+	_, err = poolFor(c.UserContext()).Exec(c.UserContext(), `
+		INSERT INTO limites_categoria (cliente_id, categoria, limite_suave, limite_rigido)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (cliente_id, categoria)
+		DO UPDATE SET limite_suave = EXCLUDED.limite_suave, limite_rigido = EXCLUDED.limite_rigido`,
+		clientId, limit.Categoria, limit.LimiteSuave, limit.LimiteRigido)
+	if isPgError(err, "23503") {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(limit)
+}
+
+func handleDeleteCategoryLimit(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	tag, err := poolFor(c.UserContext()).Exec(c.UserContext(), `
+		DELETE FROM limites_categoria WHERE cliente_id = $1 AND categoria = $2`,
+		clientId, c.Params("categoria"))
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if tag.RowsAffected() == 0 {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Localização das respostas. pt-BR é o idioma padrão e o formato do teste da
+// rinha, que não muda; com Accept-Language: en as respostas da API pública e
+// os problemas trocam os nomes dos campos e as mensagens de erro pelos do
+// catálogo em locales/en-US.json. Os corpos das requisições continuam com os
+// campos em português, e os valores (tipo "c"/"d", status) não são traduzidos.
+//
+// Um novo idioma é um novo catálogo em locales/ e uma oferta em
+// localeOffers, com o prefixo de Accept-Language que o escolhe.
+
+const defaultLocale = "pt-BR"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalog traduz os nomes dos campos JSON e as mensagens dos problemas,
+// indexadas pelo codigo
+type catalog struct {
+	Campos    map[string]string `json:"campos"`
+	Mensagens map[string]string `json:"mensagens"`
+}
+
+var catalogs = loadCatalogs()
+
+// localeOffers associa o prefixo de Accept-Language ao catálogo; o primeiro é
+// o usado quando o cabeçalho não vem
+var localeOffers = []struct{ prefix, locale string }{
+	{"pt", defaultLocale},
+	{"en", "en-US"},
+}
+
+func loadCatalogs() map[string]catalog {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := make(map[string]catalog, len(entries))
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		var messages catalog
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic("locales/" + entry.Name() + ": " + err.Error())
+		}
+		catalogs[strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))] = messages
+	}
+	return catalogs
+}
+
+// requestLocale devolve o idioma pedido em Accept-Language, ou pt-BR
+func requestLocale(c fiber.Ctx) string {
+	offers := make([]string, len(localeOffers))
+	for i, offer := range localeOffers {
+		offers[i] = offer.prefix
+	}
+	accepted := c.AcceptsLanguages(offers...)
+	for _, offer := range localeOffers {
+		if offer.prefix == accepted {
+			return offer.locale
+		}
+	}
+	return defaultLocale
+}
+
+// localizeProblem troca as mensagens do problema pelas do catálogo do
+// idioma. Códigos sem tradução mantêm a mensagem em português.
+func localizeProblem(locale string, problem *Problem) {
+	messages, ok := catalogs[locale]
+	if !ok {
+		return
+	}
+	if message, ok := messages.Mensagens[problem.Codigo]; ok && problem.Detail != "" {
+		problem.Detail = message
+	}
+	for i := range problem.Erros {
+		if message, ok := messages.Mensagens[problem.Erros[i].Codigo]; ok {
+			problem.Erros[i].Mensagem = message
+		}
+	}
+	// com um só campo o detail é a mensagem dele, como em sendFieldErrors
+	if len(problem.Erros) == 1 {
+		problem.Detail = problem.Erros[0].Mensagem
+	}
+}
+
+// localizationMiddleware fica antes de problemMiddleware para ver os
+// problemas que ele gera. Todas as respostas variam por Accept-Language;
+// fora do idioma padrão, os corpos JSON da API pública e os problemas têm os
+// campos renomeados pelo catálogo. Respostas em stream e as rotas de
+// administração ficam como estão.
+func localizationMiddleware(c fiber.Ctx) error {
+	c.Vary(fiber.HeaderAcceptLanguage)
+	locale := requestLocale(c)
+	if locale == defaultLocale {
+		return c.Next()
+	}
+	c.Locals(localeKey{}, locale)
+
+	if err := c.Next(); err != nil {
+		// os erros retornados só viram resposta no ErrorHandler, depois deste
+		// middleware, então o problema é montado aqui
+		if err := problemErrorHandler(c, err); err != nil {
+			return err
+		}
+	}
+
+	response := c.Response()
+	if response.IsBodyStream() {
+		return nil
+	}
+	contentType := string(response.Header.ContentType())
+	isProblem := strings.HasPrefix(contentType, problemContentType)
+	if !isProblem && !(strings.HasPrefix(contentType, fiber.MIMEApplicationJSON) && isVersionedResource(unversionedPath(c.Path()))) {
+		return nil
+	}
+	body, err := renameFields(response.Body(), catalogs[locale].Campos)
+	if err != nil {
+		return nil
+	}
+	response.SetBodyRaw(body)
+	c.Set(fiber.HeaderContentLanguage, locale)
+	return nil
+}
+
+type localeKey struct{}
+
+// localeFrom devolve o idioma guardado por localizationMiddleware
+func localeFrom(c fiber.Ctx) string {
+	if locale, ok := c.Locals(localeKey{}).(string); ok {
+		return locale
+	}
+	return defaultLocale
+}
+
+// unversionedPath tira o prefixo /v<N> do caminho
+func unversionedPath(path string) string {
+	if prefix, _ := versionPrefix(path); prefix != "" {
+		return strings.TrimPrefix(path, prefix)
+	}
+	return path
+}
+
+// verbatimFields são os campos com dados do cliente, cujos nomes internos
+// não são traduzidos
+var verbatimFields = map[string]bool{"metadata": true}
+
+// jsonFrame é um objeto ou array aberto durante renameFields
+type jsonFrame struct {
+	object    bool
+	expectKey bool
+	first     bool
+	verbatim  bool
+}
+
+// renameFields reescreve o JSON trocando os nomes dos campos pelos de
+// names, token a token, sem mudar a ordem dos campos nem os valores. O
+// conteúdo dos verbatimFields fica como está.
+func renameFields(body []byte, names map[string]string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var out bytes.Buffer
+	out.Grow(len(body) + len(body)/4)
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	writeString := func(s string) {
+		encoder.Encode(s)
+		out.Truncate(out.Len() - 1) // Encode termina com \n
+	}
+
+	var stack []*jsonFrame
+	// verbatimValue marca o valor do campo que acabou de ser escrito como
+	// um dos verbatimFields
+	verbatimValue := false
+	// beginValue escreve a vírgula antes dos elementos de array
+	beginValue := func() {
+		if len(stack) == 0 {
+			return
+		}
+		top := stack[len(stack)-1]
+		if !top.object {
+			if !top.first {
+				out.WriteByte(',')
+			}
+			top.first = false
+		}
+	}
+	// endValue volta o objeto pai a esperar um campo
+	endValue := func() {
+		verbatimValue = false
+		if len(stack) > 0 && stack[len(stack)-1].object {
+			stack[len(stack)-1].expectKey = true
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if key, ok := token.(string); ok && top.object && top.expectKey {
+				if !top.first {
+					out.WriteByte(',')
+				}
+				top.first = false
+				top.expectKey = false
+				verbatimValue = verbatimFields[key]
+				if name, ok := names[key]; ok && !top.verbatim {
+					key = name
+				}
+				writeString(key)
+				out.WriteByte(':')
+				continue
+			}
+		}
+
+		switch value := token.(type) {
+		case json.Delim:
+			switch value {
+			case '{', '[':
+				beginValue()
+				out.WriteByte(byte(value))
+				verbatim := verbatimValue || len(stack) > 0 && stack[len(stack)-1].verbatim
+				verbatimValue = false
+				stack = append(stack, &jsonFrame{object: value == '{', expectKey: value == '{', first: true, verbatim: verbatim})
+			default:
+				out.WriteByte(byte(value))
+				stack = stack[:len(stack)-1]
+				endValue()
+			}
+		case string:
+			beginValue()
+			writeString(value)
+			endValue()
+		case json.Number:
+			beginValue()
+			out.WriteString(value.String())
+			endValue()
+		case bool:
+			beginValue()
+			if value {
+				out.WriteString("true")
+			} else {
+				out.WriteString("false")
+			}
+			endValue()
+		case nil:
+			beginValue()
+			out.WriteString("null")
+			endValue()
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -4,197 +4,1004 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
-	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"rinha-de-backend-2024-q1/pg"
 )
 
-var dbpool *pgxpool.Pool
+// serve sobe a API HTTP e os jobs em background
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	flags.Parse(args)
 
-func main() {
-	app := fiber.New()
-	var err error
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatal("Error setting up tracing: ", err)
+	}
+	flushErrors, err := setupErrorReporting()
+	if err != nil {
+		log.Fatal("Error setting up error reporting: ", err)
+	}
+
+	done := startup.step("app")
+	a, err := newApp(context.Background(), loadAppConfig(), newClock(), log.Default())
+	done(err)
+	if err != nil {
+		log.Fatal("Error starting app: ", err)
+	}
+	currentApp = a
+	if a.pool != nil && getEnvBool("SCHEMA_VERIFICAR", true) {
+		done := startup.step("schema")
+		checkSchema(withApp(context.Background(), a))
+		done(nil)
+	}
+	done = startup.step("clientes")
+	err = loadClients(withApp(context.Background(), a))
+	done(err)
+	if err != nil {
+		log.Fatal("Error loading clients: ", err)
+	}
+	if a.pool != nil {
+		done := startup.step("regras de fraude")
+		err := configureFraudChecker()
+		done(err)
+		if err != nil {
+			log.Fatal("Error loading fraud rules: ", err)
+		}
+	}
+	app := newHTTPApp(a)
+
+	background := withApp(context.Background(), a)
+	if interval := getEnvDuration("MEMORIA_INTERVALO", 10*time.Second); interval > 0 {
+		go runMemorySampler(background, interval)
+	}
+
+	reloadOnSIGHUP()
+
+	if a.pool != nil && getEnvBool("INDICES_VERIFICAR", true) {
+		done := startup.step("indices")
+		checkIndexes(background)
+		done(nil)
+	}
+	if a.pool != nil && getEnvBool("DB_WARMUP", true) {
+		done := startup.step("aquecimento")
+		warmUpPools(background)
+		done(nil)
+		startup.markWarmed()
+	}
+
+	if addr := getEnv("DEBUG_ADDR", ""); addr != "" {
+		startDiagnostics(a, addr)
+	}
+
+	if a.pool != nil && getEnvBool("FEATURE_FLAGS_DB", false) {
+		interval := getEnvDuration("FEATURE_FLAGS_INTERVALO", 10*time.Second)
+		for _, ctx := range tenantContexts(background) {
+			go runFeatureFlagsRefresher(ctx, interval)
+		}
+	}
+	go runClientsRefresher(background, getEnvDuration("CLIENTES_INTERVALO", 30*time.Second))
+	if a.pool != nil && descriptionFilterEnabled {
+		go runDescriptionBlocksRefresher(background, getEnvDuration("DESCRICAO_FILTRO_INTERVALO", 30*time.Second))
+	}
+	if a.pool != nil && leaderElectionEnabled {
+		interval := getEnvDuration("LIDER_INTERVALO", 5*time.Second)
+		for _, ctx := range tenantContexts(background) {
+			go leaderFor(ctx).run(ctx, interval)
+		}
+	}
+	if a.pool != nil && getEnvBool("SCHEDULER_ENABLED", true) {
+		for _, ctx := range tenantContexts(background) {
+			go runAsLeader(ctx, "scheduler", func(ctx context.Context) {
+				runScheduler(ctx, getEnvDuration("SCHEDULER_INTERVAL", time.Second))
+			})
+		}
+	}
+	if a.pool != nil && concurrencyMode != concurrencyEventSourcing {
+		interval := getEnvDuration("AUTORIZACOES_INTERVALO", time.Minute)
+		for _, ctx := range tenantContexts(background) {
+			go runAsLeader(ctx, "authorization expirer", func(ctx context.Context) {
+				runAuthorizationExpirer(ctx, interval)
+			})
+		}
+	}
+	if a.pool != nil && getEnvBool("ARQUIVO_ENABLED", false) {
+		for _, ctx := range tenantContexts(background) {
+			go runAsLeader(ctx, "archiver", func(ctx context.Context) {
+				runArchiver(ctx, loadArchiveConfig())
+			})
+		}
+	}
+	if a.pool != nil {
+		interval := getEnvDuration("ENCERRAMENTO_INTERVALO", time.Hour)
+		for _, ctx := range tenantContexts(background) {
+			go runAsLeader(ctx, "anonymizer", func(ctx context.Context) {
+				runAnonymizer(ctx, interval)
+			})
+		}
+	}
+	if a.pool != nil && getEnvBool("PARTICOES_ENABLED", false) {
+		for _, ctx := range tenantContexts(background) {
+			go runAsLeader(ctx, "partition maintenance", func(ctx context.Context) {
+				runPartitionMaintenance(ctx, loadPartitionConfig())
+			})
+		}
+	}
+	if a.pool != nil && concurrencyMode == concurrencyEventSourcing {
+		interval := getEnvDuration("PROJETOR_INTERVALO", 100*time.Millisecond)
+		for _, ctx := range tenantContexts(background) {
+			go runAsLeader(ctx, "projector", func(ctx context.Context) {
+				runProjector(ctx, interval)
+			})
+		}
+	}
+	if a.pool != nil && outboxEnabled {
+		configureOutboxSinks()
+		if err := configureBusSink(); err != nil {
+			log.Fatal("Error configuring message bus: ", err)
+		}
+		interval := getEnvDuration("OUTBOX_INTERVALO", 200*time.Millisecond)
+		batchSize := getEnvInt("OUTBOX_LOTE", 100)
+		retention := getEnvDuration("OUTBOX_RETENCAO", 24*time.Hour)
+		for _, ctx := range tenantContexts(background) {
+			go runAsLeader(ctx, "outbox relay", func(ctx context.Context) {
+				runOutboxRelay(ctx, interval, batchSize, retention)
+			})
+		}
+	}
+	if journal, ok := a.storage.(*journalStorage); ok {
+		go journal.runReplayer(background, getEnvDuration("WAL_INTERVALO", time.Second))
+		if journal.snapshot != nil {
+			go journal.runSnapshotter(background, getEnvDuration("SALDOS_SNAPSHOT_INTERVALO", time.Second))
+		}
+	}
+	if a.pool != nil && failoverEnabled() {
+		interval := getEnvDuration("DB_FAILOVER_INTERVALO", 2*time.Second)
+		for _, ctx := range tenantContexts(background) {
+			go runPrimaryMonitor(ctx, interval)
+		}
+	}
+	if a.pool != nil {
+		interval := getEnvDuration("ESTATISTICAS_INTERVALO", 10*time.Second)
+		for _, ctx := range tenantContexts(background) {
+			go runVelocityPersister(ctx, interval)
+		}
+	}
+	if a.pool != nil && getEnvBool("RECONCILIACAO_ENABLED", false) {
+		interval := getEnvDuration("RECONCILIACAO_INTERVALO", time.Minute)
+		fix := getEnvBool("RECONCILIACAO_CORRIGIR", false)
+		for _, ctx := range tenantContexts(background) {
+			go runAsLeader(ctx, "reconciler", func(ctx context.Context) {
+				runReconciler(ctx, interval, fix)
+			})
+		}
+	}
+
+	if a.pool != nil && statementWebhooksEnabled {
+		store, err := newBlobStore("EXTRATOS")
+		if err != nil {
+			log.Fatal("Error configuring statement storage: ", err)
+		}
+		config := loadStatementWebhookConfig()
+		if local, ok := store.(localBlobStore); ok {
+			app.Get("/extratos/*", handleDownloadStatement(local, config))
+		}
+		for _, ctx := range tenantContexts(background) {
+			go runAsLeader(ctx, "statement notifier", func(ctx context.Context) {
+				runStatementNotifier(ctx, store, config)
+			})
+		}
+	}
+
+	err = listen(app, a, *addr, func() { logStartupReport(a) })
+	shutdownTracing(context.Background())
+	flushErrors()
+	return err
+}
+
+// newHTTPApp monta o app do fiber com os middlewares e as rotas da API
+// sobre a; o serve e os testes usam o mesmo app
+func newHTTPApp(a *App) *fiber.App {
+	bodyLimit := getEnvInt("BODY_LIMIT", 16*1024)
+	app := fiber.New(fiber.Config{
+		JSONEncoder:     jsonMarshal,
+		JSONDecoder:     jsonUnmarshal,
+		StructValidator: structValidatorInstance,
+		ErrorHandler:    problemErrorHandler,
+		// corpos acima de BODY_LIMIT chegam em streaming; limitRequestBody
+		// os recusa com 413, exceto nas rotas de streamedBodyRoutes
+		BodyLimit:         bodyLimit,
+		StreamRequestBody: true,
+		// ReadTimeout cobre a leitura de cabeçalhos e corpo, derrubando
+		// conexões lentas (slowloris); não há WriteTimeout porque o SSE e
+		// o export mantêm a resposta aberta por tempo indeterminado
+		ReadTimeout:    getEnvDuration("READ_TIMEOUT", 5*time.Second),
+		IdleTimeout:    getEnvDuration("IDLE_TIMEOUT", time.Minute),
+		ReadBufferSize: getEnvInt("READ_BUFFER_SIZE", 4096),
+	})
+	app.Use(methodMiddleware)
+	app.Use(requestMetricsMiddleware)
+	if requestLogEnabled {
+		app.Use(requestLogMiddleware)
+	}
+	app.Use(recoverMiddleware)
+	app.Use(localizationMiddleware)
+	app.Use(problemMiddleware)
+	app.Use(limitRequestBody(bodyLimit))
+	if getEnvBool("OTEL_TRACES_ENABLED", false) {
+		app.Use(tracingMiddleware)
+	}
+	if serverTimingEnabled {
+		app.Use(serverTimingMiddleware)
+	}
+	app.Use(a.contextMiddleware)
+	if len(a.tenantPools) > 0 {
+		app.Use(tenantMiddleware)
+	}
+	if errorReportingEnabled {
+		app.Use(errorReportingMiddleware)
+	}
+	if chaosEnabled {
+		a.logger.Print("Chaos fault injection is enabled")
+		app.Use(chaosMiddleware)
+	}
+
+	app.Use(apiVersionMiddleware)
+	// os caminhos sem versão continuam atendendo o teste da rinha
+	registerRoutes(app, a)
+	registerRoutes(app.Group("/v1"), a)
+
+	app.Get("/graphql", handleGraphQL, routeTimeout("GRAPHQL"))
+	app.Post("/graphql", handleGraphQL, routeTimeout("GRAPHQL"))
+	app.Get("/ws", handleWebSocket)
+
+	app.Get("/admin/startup", handleStartupReport, adminAuth)
+	app.Get("/admin/memoria", handleMemoryHistory, adminAuth)
+	app.Post("/admin/memoria/amostra", handleMemorySample, adminAuth)
+	app.Get("/admin/dashboard", handleDashboard, dashboardAuth)
+	if a.pool != nil {
+		registerAdminRoutes(app)
+	}
+	return app
+}
 
-	dsn := fmt.Sprintf("host=%s user=%s dbname=%s password=%s sslmode=disable",
-		os.Getenv("POSTGRES_HOST"),
+// connectPostgres monta currentApp sobre o Postgres, para os subcomandos
+// que usam o banco fora do serve
+func connectPostgres() {
+	app, err := newApp(context.Background(), AppConfig{Storage: "postgres"}, newClock(), log.Default())
+	if err != nil {
+		log.Fatal(err)
+	}
+	currentApp = app
+}
+
+// simpleProtocol troca o modo padrão do pgx por QueryExecModeSimpleProtocol
+// (DB_SIMPLE_PROTOCOL), sem prepared statements nem Describe, para rodar
+// atrás de um pgbouncer em pool_mode=transaction, em que cada transação pode
+// cair numa conexão diferente do servidor. Os parâmetros são interpolados no
+// cliente, o que exige standard_conforming_strings=on. Continuam dependendo
+// de sessão a eleição de líder (o pg_try_advisory_lock de LIDER_ENABLED) e o
+// search_path dos pools de TENANTS; com o pgbouncer, a eleição deve ser
+// desligada ou apontada direto para o Postgres.
+var simpleProtocol = getEnvBool("DB_SIMPLE_PROTOCOL", false)
+
+// openPostgres abre o pool padrão e os pools dos tenants. A App de todos os
+// subcomandos que usam o Postgres passa por aqui, então é também onde o modo
+// de concorrência é validado.
+func openPostgres(ctx context.Context) (*pgxpool.Pool, map[string]*pgxpool.Pool, error) {
+	if err := configureConcurrency(); err != nil {
+		return nil, nil, err
+	}
+
+	dsn := fmt.Sprintf("%s user=%s dbname=%s password=%s sslmode=disable",
+		postgresHostParams(),
 		os.Getenv("POSTGRES_USER"),
 		os.Getenv("POSTGRES_DB"),
 		os.Getenv("POSTGRES_PASSWORD"))
 
-	dbpool, err = pgxpool.New(context.Background(), dsn)
-
+	poolConfig, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		log.Fatal("Error creating pool: ", err)
+		return nil, nil, fmt.Errorf("parsing database config: %w", err)
+	}
+	var tracers queryTracers
+	if getEnvBool("OTEL_TRACES_ENABLED", false) {
+		tracers = append(tracers, dbTracer{})
+	}
+	if errorReportingEnabled {
+		tracers = append(tracers, errorReportingTracer{})
+	}
+	if len(tracers) > 0 {
+		poolConfig.ConnConfig.Tracer = tracers
 	}
 
-	err = dbpool.Ping(context.Background())
+	// conexões quebradas depois da inicialização são descartadas pelo health
+	// check do pool e recriadas no próximo Acquire
+	poolConfig.ConnConfig.ConnectTimeout = getEnvDuration("DB_CONNECT_TIMEOUT", 5*time.Second)
+	poolConfig.HealthCheckPeriod = getEnvDuration("DB_HEALTH_CHECK_PERIOD", 5*time.Second)
+	// por padrão o pool mantém todas as conexões abertas, aquecidas na
+	// subida por warmUpPools
+	poolConfig.MinConns = int32(min(getEnvInt("DB_MIN_CONNS", int(poolConfig.MaxConns)), int(poolConfig.MaxConns)))
+
+	if simpleProtocol {
+		poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+		if leaderElectionEnabled {
+			log.Print("Warning: DB_SIMPLE_PROTOCOL with LIDER_ENABLED; leader election needs a session-pooled connection")
+		}
+	}
+	if chaosEnabled {
+		poolConfig.BeforeAcquire = chaosBeforeAcquire
+	}
+	configureDoubleEntry(poolConfig)
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
-		log.Fatal("Error pinging database: ", err)
+		return nil, nil, fmt.Errorf("creating pool: %w", err)
 	}
 
-	app.Get("/clientes/:id/extrato", handleTransactionLog)
-	app.Post("/clientes/:id/transacoes", handleTransactions)
+	done := startup.step("banco de dados")
+	err = waitForDatabase(ctx, pool)
+	done(err)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pinging database: %w", err)
+	}
 
-	log.Fatal(app.Listen(":8080"))
+	done = startup.step("tenants")
+	tenantPools, err := configureTenants(ctx, pool, poolConfig)
+	done(err)
+	if err != nil {
+		return nil, nil, fmt.Errorf("configuring tenants: %w", err)
+	}
+	return pool, tenantPools, nil
 }
 
-func clientExists(id int) error {
-	if id > 0 && id <= 5 {
-		return nil
+// waitForDatabase repete o ping com backoff exponencial até DB_RETRY_ATTEMPTS
+// tentativas ou DB_RETRY_TIMEOUT, para que a API não entre em crash loop
+// enquanto o Postgres ainda está subindo no docker-compose.
+func waitForDatabase(ctx context.Context, pool *pgxpool.Pool) error {
+	attempts := getEnvInt("DB_RETRY_ATTEMPTS", 30)
+	maxBackoff := getEnvDuration("DB_RETRY_MAX_BACKOFF", 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, getEnvDuration("DB_RETRY_TIMEOUT", time.Minute))
+	defer cancel()
+
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := pool.Ping(ctx)
+		if err == nil || attempt >= attempts {
+			return err
+		}
+		log.Printf("Database not ready (attempt %d/%d), retrying in %s: %v", attempt, attempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
 	}
-	return errors.New("Cliente não existe.")
 }
 
-func handleTransactions(c fiber.Ctx) error {
+// transactionBodyLimit é o tamanho máximo do corpo das rotas de transação,
+// bem menor que o BODY_LIMIT global usado pelo GraphQL e pelas rotas admin.
+var transactionBodyLimit atomic.Int64
 
-	clientId, err := c.ParamsInt("id")
-	err = clientExists(clientId)
-	if err != nil {
-		return c.SendStatus(fiber.StatusNotFound)
+func init() {
+	onReload(func() {
+		transactionBodyLimit.Store(int64(getEnvInt("TRANSACAO_BODY_LIMIT", 1024)))
+	})
+}
+
+// limitBody rejeita com 413 os corpos maiores que limit, pelo Content-Length
+// quando informado e pelo tamanho lido nos demais casos.
+func limitBody(limit *atomic.Int64) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		max := int(limit.Load())
+		if c.Request().Header.ContentLength() > max || len(c.Body()) > max {
+			return fiber.ErrRequestEntityTooLarge
+		}
+		return c.Next()
 	}
+}
 
-	transaction := new(TransacaoRequest)
+// streamedBodyRoutes recebem corpos de qualquer tamanho, lidos em streaming
+// pelo handler
+var streamedBodyRoutes = map[string]bool{
+	"/admin/clientes/import": true,
+}
 
-	if err := json.Unmarshal(c.Body(), &transaction); err != nil {
-		return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+// limitRequestBody recusa corpos acima de limit. Com StreamRequestBody o
+// fasthttp só lê até limit antes de chamar o handler; o restante (ou um
+// corpo chunked) fica em um stream, que aqui é lido até o limite.
+func limitRequestBody(limit int) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if !c.Request().IsBodyStream() || streamedBodyRoutes[c.Path()] {
+			return c.Next()
+		}
+		if c.Request().Header.ContentLength() > limit {
+			return fiber.ErrRequestEntityTooLarge
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request().BodyStream(), int64(limit)+1))
+		if err != nil {
+			return err
+		}
+		if len(body) > limit {
+			return fiber.ErrRequestEntityTooLarge
+		}
+		c.Request().SetBody(body)
+		return c.Next()
 	}
+}
 
-	var length = utf8.RuneCountInString(transaction.Descricao)
-	if length > 10 || length < 1 {
-		return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+// registerRoutes registra as rotas públicas da API em router, usado tanto
+// para /v1 quanto para os caminhos legados, conforme o armazenamento de a
+func registerRoutes(router fiber.Router, a *App) {
+	router.Get("/clientes/:id/extrato", fiberHandler(handleTransactionLog), routeTimeout("EXTRATO"))
+	router.Post("/clientes/:id/transacoes", fiberHandler(handleTransactions), noStore, routeTimeout("TRANSACOES"), limitBody(&transactionBodyLimit), validateSchema("transacao"))
+	router.Get("/clientes/:id/transacoes", handleTransactionsSince, routeTimeout("TRANSACOES"))
+	router.Post("/clientes/:id/transacoes/simular", handleSimulateTransaction, noStore, routeTimeout("TRANSACOES"), limitBody(&transactionBodyLimit), validateSchema("transacao"))
+	router.Get("/clientes/:id/transacoes/:tx_id", handleGetTransaction, routeTimeout("TRANSACOES"))
+	router.Get("/clientes/:id/resumo", handleSummary, routeTimeout("RESUMO"))
+	router.Get("/clientes/:id/estatisticas", handleClientStatistics)
+
+	if a.pool != nil {
+		registerPostgresRoutes(router)
 	}
+}
+
+// registerPostgresRoutes registra as rotas públicas que dependem de
+// recursos exclusivos do Postgres.
+func registerPostgresRoutes(app fiber.Router) {
+	app.Get("/clientes", handleSearchClients, adminAuth)
+	app.Get("/clientes/:id", handleGetClient)
+	app.Patch("/clientes/:id", handlePatchClient, noStore)
+	app.Post("/clientes/:id/transacoes/:tx_id/estorno", handleRefundTransaction, noStore, routeTimeout("TRANSACOES"), limitBody(&transactionBodyLimit))
+	app.Get("/clientes/:id/autorizacoes", handleListAuthorizations)
+	app.Post("/clientes/:id/autorizacoes", handleCreateAuthorization, noStore, limitBody(&transactionBodyLimit))
+	app.Post("/clientes/:id/autorizacoes/:autorizacao_id/captura", handleCaptureAuthorization, noStore, limitBody(&transactionBodyLimit))
+	app.Delete("/clientes/:id/autorizacoes/:autorizacao_id", handleReleaseAuthorization)
+	app.Post("/clientes/:id/transacoes/agendadas", handleScheduleTransaction, noStore, routeTimeout("AGENDADAS"), limitBody(&transactionBodyLimit))
+
+	app.Get("/clientes/:id/eventos", handleEventStream)
+	app.Get("/clientes/:id/limites", handleListCategoryLimits, routeTimeout("LIMITES"))
+	app.Put("/clientes/:id/limites/:categoria", handleSetCategoryLimit)
+	app.Delete("/clientes/:id/limites/:categoria", handleDeleteCategoryLimit)
+	app.Get("/clientes/:id/limite-diario", handleGetDailyLimit)
+	app.Put("/clientes/:id/limite-diario", handleSetDailyLimit)
+	app.Delete("/clientes/:id/limite-diario", handleDeleteDailyLimit)
+	app.Get("/clientes/:id/alertas", handleListBalanceAlerts)
+	app.Post("/clientes/:id/alertas", handleCreateBalanceAlert)
+	app.Delete("/clientes/:id/alertas/:alerta_id", handleDeleteBalanceAlert)
+	app.Get("/clientes/:id/webhook", handleGetStatementWebhook)
+	app.Put("/clientes/:id/webhook", handleSetStatementWebhook, noStore)
+	app.Delete("/clientes/:id/webhook", handleDeleteStatementWebhook)
+	app.Get("/clientes/:id/encerramento", handleGetClosure)
+	app.Post("/clientes/:id/encerramento", handleCloseAccount, noStore)
+
+	app.Get("/categorias", handleListCategories)
+	app.Post("/categorias", handleCreateCategory)
+	app.Get("/categorias/:nome", handleGetCategory)
+	app.Put("/categorias/:nome", handleUpdateCategory)
+	app.Delete("/categorias/:nome", handleDeleteCategory)
+}
 
-	if transaction.Tipo != "c" && transaction.Tipo != "d" {
-		return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+// registerAdminRoutes registra as rotas administrativas, que não são
+// versionadas.
+func registerAdminRoutes(app *fiber.App) {
+	admin := app.Group("/admin", adminAuth)
+	admin.Get("/transacoes/export", handleExportTransactions, requireRole(roleAdmin))
+	admin.Post("/backup", handleStartBackup, requireRole(roleAdmin))
+	admin.Get("/backup", handleBackupStatus)
+	admin.Get("/reconciliacao", handleReconciliation)
+	admin.Post("/reconciliacao", handleReconciliation)
+	admin.Post("/projecao/rebuild", handleRebuildProjection, requireRole(roleAdmin))
+	admin.Post("/config/reload", handleReloadConfig, requireRole(roleAdmin))
+	admin.Post("/clientes/import", handleImportClients, requireRole(roleAdmin))
+	admin.Get("/clientes/utilizacao", handleLimitUtilizationReport)
+	admin.Post("/clientes/:id/bloquear", handleBlockClient(true))
+	admin.Post("/clientes/:id/desbloquear", handleBlockClient(false))
+	admin.Get("/partidas", handleLedgerBalances)
+	admin.Get("/leader", handleLeaderStatus)
+	admin.Get("/flags", handleListFeatureFlags)
+	admin.Put("/flags/:nome", handleSetFeatureFlag, requireRole(roleAdmin))
+	admin.Delete("/flags/:nome", handleDeleteFeatureFlag, requireRole(roleAdmin))
+	admin.Get("/notificacoes/extrato", handleListStatementNotifications)
+	admin.Post("/notificacoes/extrato/:notificacao_id/reenviar", handleRetryStatementNotification)
+	admin.Get("/descricao/bloqueios", handleListDescriptionBlocks)
+	admin.Post("/descricao/bloqueios", handleAddDescriptionBlock)
+	admin.Delete("/descricao/bloqueios/:bloqueio_id", handleDeleteDescriptionBlock)
+}
+
+// clientIdParam lê o :id da rota e valida o cliente. O id é convertido à mão
+// porque ParamsInt embrulha o erro do strconv, alocando a cada id inválido.
+func clientIdParam(c fiber.Ctx) (int, error) {
+	return parseClientID(c.UserContext(), c.Params("id"))
+}
+
+func parseClientID(ctx context.Context, param string) (int, error) {
+	if len(param) == 0 || len(param) > 9 {
+		return 0, errClienteNaoExiste
+	}
+	id := 0
+	for i := 0; i < len(param); i++ {
+		digit := param[i] - '0'
+		if digit > 9 {
+			return 0, errClienteNaoExiste
+		}
+		id = id*10 + int(digit)
 	}
-	_, err = dbpool.Exec(context.Background(), `
-		INSERT INTO transacoes 
-		(valor, tipo, descricao, cliente_id) 
-		VALUES ($1, $2, $3, $4)
-		`,
-		transaction.Valor,
-		transaction.Tipo,
-		transaction.Descricao,
-		clientId)
+	return id, clientExists(ctx, id)
+}
 
+func handleTransactions(x exchange) error {
+	ctx := x.Context()
+	clientId, err := parseClientID(ctx, x.Param("id"))
 	if err != nil {
-		return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+		return sendProblemTo(x, Problem{Status: fiber.StatusNotFound})
 	}
 
-	var response Balance
+	transaction := transactionRequests.Get().(*TransacaoRequest)
+	defer func() {
+		*transaction = TransacaoRequest{}
+		transactionRequests.Put(transaction)
+	}()
 
-	row := dbpool.QueryRow(context.Background(), "SELECT limite, saldo from clientes where id = $1", clientId)
-	row.Scan(&response.Limite, &response.Saldo)
+	_, span := tracer.Start(ctx, "json.decode")
+	err = decodeJSON(ctx, x.Header(fiber.HeaderContentType), x.Body(), transaction)
+	span.End()
+	if err != nil {
+		return sendProblemTo(x, bindErrorProblem(err, x.Body()))
+	}
 
-	jsonResponse, err := json.Marshal(response)
+	response, err := submitTransaction(ctx, clientId, transaction)
+	if err != nil {
+		if problem, ok := transactionProblem(err); ok {
+			return sendProblemTo(x, problem)
+		}
+		return sendProblemTo(x, Problem{Status: fiber.StatusUnprocessableEntity})
+	}
+
+	encoded := timingFrom(ctx).Start(timingEncode)
+	body := TransacaoResponse{
+		ID:                 transaction.ID,
+		Balance:            response,
+		LimiteUtilizadoPct: limitUtilization(response),
+	}.AppendJSON(make([]byte, 0, 96))
+	encoded()
+	status := fiber.StatusOK
+	if transaction.Pendente {
+		status = fiber.StatusAccepted
+	}
+	return x.Send(status, fiber.MIMEApplicationJSON, body)
+}
 
-	c.Response().Header.Set("Content-Type", "application/json")
-	c.Response().SetBody(jsonResponse)
+// submitTransaction registra uma transação já validada; é o caminho comum de
+// POST /transacoes e do /ws
+func submitTransaction(ctx context.Context, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	response, err := writes.Do(ctx, func(ctx context.Context) (Balance, error) {
+		if transaction.Parcelas > 1 {
+			return createInstallments(ctx, clientId, transaction)
+		}
+		return storageFor(ctx).CreateTransaction(ctx, clientId, transaction)
+	})
+	if err != nil {
+		// invalidado mesmo em caso de erro, caso a escrita tenha sido confirmada
+		statements.Invalidate(statementClient{tenant: tenantFrom(ctx), clientId: clientId})
+		return response, err
+	}
+	transactionApplied(ctx, clientId, transaction, response)
+	return response, nil
+}
 
-	return nil
+// transactionApplied são os efeitos de uma transação já confirmada no banco
+// fora dele: o extrato em cache, a velocidade do cliente e os inscritos no
+// saldo. Vale para as transações de POST /transacoes e as dos agendamentos.
+func transactionApplied(ctx context.Context, clientId int, transaction *TransacaoRequest, balance Balance) {
+	tenant := tenantFrom(ctx)
+	statements.Invalidate(statementClient{tenant: tenant, clientId: clientId})
+	velocity.Record(tenant, clientId, transaction, nowFor(ctx))
+	balanceUpdates.Notify(ctx, clientId, balance)
 }
 
-func handleTransactionLog(c fiber.Ctx) error {
-	clientId, err := c.ParamsInt("id")
+// transactionProblem é a resposta de POST /transacoes para as recusas
+// conhecidas de uma transação
+func transactionProblem(err error) (Problem, bool) {
+	switch {
+	case errors.Is(err, ErrConflitoConcorrencia):
+		return Problem{
+			Status: fiber.StatusServiceUnavailable,
+			Codigo: "CONFLITO_CONCORRENCIA",
+			Detail: err.Error(),
+		}, true
+	case errors.Is(err, ErrFilaEscritasCheia):
+		return Problem{
+			Status: fiber.StatusServiceUnavailable,
+			Codigo: "SOBRECARGA",
+			Detail: err.Error(),
+		}, true
+	case errors.Is(err, ErrFraudeSuspeita):
+		return Problem{
+			Status: fiber.StatusUnprocessableEntity,
+			Codigo: "FRAUDE_SUSPEITA",
+			Detail: err.Error(),
+		}, true
+	case errors.Is(err, ErrClienteBloqueado):
+		return Problem{
+			Status: fiber.StatusForbidden,
+			Codigo: "CLIENTE_BLOQUEADO",
+			Detail: err.Error(),
+		}, true
+	case errors.Is(err, ErrParcelamentoIndisponivel), errors.Is(err, ErrParcelasApenasDebito):
+		return Problem{
+			Status: fiber.StatusUnprocessableEntity,
+			Codigo: "PARCELAMENTO_INVALIDO",
+			Detail: err.Error(),
+		}, true
+	case errors.Is(err, ErrParcelasAcimaDoValor):
+		return Problem{
+			Status: fiber.StatusUnprocessableEntity,
+			Codigo: "PARCELAS_ACIMA_DO_VALOR",
+			Detail: err.Error(),
+		}, true
+	case errors.Is(err, ErrLimiteCategoriaExcedido):
+		return Problem{
+			Status: fiber.StatusUnprocessableEntity,
+			Codigo: "LIMITE_CATEGORIA_EXCEDIDO",
+			Detail: err.Error(),
+		}, true
+	case errors.Is(err, ErrLimiteDiarioExcedido):
+		return Problem{
+			Status: fiber.StatusUnprocessableEntity,
+			Codigo: "LIMITE_DIARIO_EXCEDIDO",
+			Detail: err.Error(),
+		}, true
+	case errors.Is(err, ErrLimiteExcedido):
+		return Problem{
+			Status: fiber.StatusUnprocessableEntity,
+			Codigo: "LIMITE_EXCEDIDO",
+			Detail: err.Error(),
+		}, true
+	}
+	return Problem{}, false
+}
 
-	err = clientExists(clientId)
+func handleGetTransaction(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
 	if err != nil {
 		return c.SendStatus(fiber.StatusNotFound)
 	}
-
-	var transactions []Transacao
-	rows, err := dbpool.Query(context.Background(), `
-		SELECT valor, tipo, descricao, realizada_em 
-		FROM transacoes WHERE cliente_id = $1 
-		ORDER BY realizada_em DESC LIMIT 10`, clientId)
+	transactionId, err := strconv.ParseInt(c.Params("tx_id"), 10, 64)
 	if err != nil {
-		return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+		return c.SendStatus(fiber.StatusNotFound)
 	}
 
-	var balance BalanceResponse
-	err = dbpool.QueryRow(context.Background(), `
-		SELECT saldo, limite FROM clientes WHERE ID = $1`,
-		clientId).Scan(&balance.Total, &balance.Limite)
+	transaction, err := storageFor(c.UserContext()).GetTransaction(c.UserContext(), clientId, transactionId)
+	if errors.Is(err, ErrTransacaoNaoEncontrada) {
+		return sendProblem(c, Problem{
+			Status: fiber.StatusNotFound,
+			Codigo: "TRANSACAO_NAO_ENCONTRADA",
+			Detail: err.Error(),
+		})
+	}
 	if err != nil {
 		return c.SendStatus(fiber.ErrInternalServerError.Code)
 	}
-	for rows.Next() {
-		var transaction Transacao
-		err = rows.Scan(
-			&transaction.Valor,
-			&transaction.Tipo,
-			&transaction.Descricao,
-			&transaction.RealizadaEm,
-		)
+	return c.JSON(transaction)
+}
+
+// dbtx é satisfeita tanto pelo pool quanto por uma pgx.Tx, permitindo
+// reutilizar as mesmas operações dentro ou fora de uma transação.
+type dbtx interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+func createTransaction(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	if fraudChecker != nil {
+		if err := checkFraud(ctx, db, clientId, transaction); err != nil {
+			return Balance{}, err
+		}
+	}
+
+	var balance Balance
+	var err error
+	if outboxEnabled || balanceAlertsEnabled {
+		balance, err = createTransactionWithEvents(ctx, db, clientId, transaction)
+	} else {
+		balance, err = applyTransaction(ctx, db, clientId, transaction)
+	}
+	// o gatilho bloqueio_trigger recusa transações de clientes bloqueados
+	if isPgError(err, sqlStateClienteBloqueado) {
+		return balance, ErrClienteBloqueado
+	}
+	return balance, err
+}
+
+func applyTransaction(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	if transaction.Tipo != TipoDebito {
+		return insertTransaction(ctx, db, clientId, transaction)
+	}
+	if dailyLimitsEnabled {
+		return createCappedDebit(ctx, db, clientId, transaction)
+	}
+	return applyDebit(ctx, db, clientId, transaction)
+}
+
+func insertTransaction(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	switch concurrencyMode {
+	case concurrencyCTE:
+		return insertTransactionCTE(ctx, db, clientId, transaction)
+	case concurrencyOptimistic:
+		return insertTransactionOptimistic(ctx, db, clientId, transaction)
+	case concurrencyForUpdate:
+		return insertTransactionForUpdate(ctx, db, clientId, transaction)
+	case concurrencyAdvisory:
+		return insertTransactionAdvisory(ctx, db, clientId, transaction)
+	case concurrencyEventSourcing:
+		return insertTransactionEventSourced(ctx, db, clientId, transaction)
+	case concurrencyCheck:
+		return insertTransactionCheck(ctx, db, clientId, transaction)
+	}
+
+	var response Balance
+
+	queries := pg.New(db)
+	id, err := queries.InsertTransaction(ctx, pg.InsertTransactionParams{
+		Valor:         int64(transaction.Valor),
+		Tipo:          string(transaction.Tipo),
+		Descricao:     transaction.Descricao,
+		ClienteID:     int32(clientId),
+		Categoria:     transaction.Categoria,
+		FraudeDecisao: transaction.FraudeDecisao,
+		FraudeRegra:   transaction.FraudeRegra,
+		Metadata:      transaction.Metadata,
+	})
+	// o gatilho reconcile_amount_trigger levanta RAISE EXCEPTION (P0001) quando o débito excede o limite
+	if isPgError(err, "P0001") {
+		return response, ErrLimiteExcedido
+	}
+	if err != nil {
+		return response, err
+	}
+	transaction.ID = int64(id)
+
+	row, err := queries.GetBalance(ctx, int32(clientId))
+	response.Saldo, response.Limite = Centavos(row.Saldo), Centavos(row.Limite)
+	return response, err
+}
+
+func handleTransactionLog(x exchange) error {
+	ctx := x.Context()
+	clientId, err := parseClientID(ctx, x.Param("id"))
+	if err != nil {
+		return sendProblemTo(x, Problem{Status: fiber.StatusNotFound})
+	}
+
+	cacheKey := statementClient{tenant: tenantFrom(ctx), clientId: clientId}
+	category := x.Query("categoria")
+	metadata := metadataFilter(x.Queries())
+	var before *ExtratoCursor
+	if value := x.Query("antes_de"); value != "" {
+		cursor, err := ParseExtratoCursor(value)
 		if err != nil {
-			return c.SendStatus(fiber.ErrBadRequest.Code)
+			return sendProblemTo(x, queryErrorProblem(err))
 		}
-		transactions = append(transactions, transaction)
+		before = &cursor
+	}
+	withBalance, withTransactions, err := statementFields(x.Query("campos"))
+	if err != nil {
+		return sendProblemTo(x, queryErrorProblem(err))
+	}
+	if !withBalance || !withTransactions {
+		filter := TransactionFilter{Categoria: category, Metadata: metadata, AntesDe: before}
+		return sendPartialStatement(x, clientId, withBalance, filter)
+	}
+	// o cache guarda a primeira página do extrato por categoria, sem os
+	// filtros de metadata
+	totals := featureEnabled(ctx, "totais_por_categoria")
+	variant := statementVariant{category: category, totals: totals}
+	useCache := metadata == "" && before == nil && statements.Enabled() && featureEnabled(ctx, "extrato_cache")
+	var entry cachedStatement
+	var generation uint64
+	var cached bool
+	if useCache {
+		entry, generation, cached = statements.Get(cacheKey, variant)
+	}
+	if cached {
+		setStatementCacheHeaders(x, entry.lastModified)
+		return x.Send(fiber.StatusOK, fiber.MIMEApplicationJSON, entry.body)
 	}
+
+	filter := TransactionFilter{
+		Categoria: category,
+		Metadata:  metadata,
+		AntesDe:   before,
+		SemTotais: !totals,
+	}
+	statement, err := sharedStatement(ctx, clientId, filter)
+	if err != nil {
+		return sendProblemTo(x, Problem{Status: fiber.StatusInternalServerError})
+	}
+
 	finalResponse := TransactionLog{
 		Saldo: BalanceResponse{
-			Total:       balance.Total,
-			Limite:      balance.Limite,
-			DataExtrato: time.Now().UTC(),
+			Total:              statement.Saldo.Saldo,
+			Limite:             statement.Saldo.Limite,
+			LimiteUtilizadoPct: limitUtilization(statement.Saldo),
+			DataExtrato:        Timestamp{nowFor(ctx)},
 		},
-		UltimasTransacoes: transactions,
+		UltimasTransacoes:  statement.Transacoes,
+		TotaisPorCategoria: statement.Totais,
+		Parcelamentos:      statement.Parcelamentos,
+		ProximaPagina:      nextPageCursor(statement.Transacoes, filter),
+	}
+	// com filtro de categoria ou de metadata, ou fora da primeira página, a
+	// transação mais recente do extrato pode não ser a última que alterou o
+	// saldo
+	var lastModified time.Time
+	if category == "" && metadata == "" && before == nil && len(statement.Transacoes) > 0 {
+		lastModified = statement.Transacoes[0].RealizadaEm.Time
 	}
+	setStatementCacheHeaders(x, lastModified)
+	encoded := timingFrom(ctx).Start(timingEncode)
+	body, err := jsonMarshal(finalResponse)
+	encoded()
 	if err != nil {
-		return c.SendStatus(fiber.ErrInternalServerError.Code)
+		return sendProblemTo(x, Problem{Status: fiber.StatusInternalServerError})
+	}
+	if useCache {
+		statements.Set(cacheKey, variant, generation, body, lastModified)
 	}
+	return x.Send(fiber.StatusOK, fiber.MIMEApplicationJSON, body)
+}
 
-	jsonResponse, err := json.Marshal(finalResponse)
-	if err != nil {
-		return c.SendStatus(fiber.ErrInternalServerError.Code)
+// sendPartialStatement responde o extrato com só uma das partes: o saldo,
+// lido sem as transações e os totais, ou as transações, sem o saldo. As
+// respostas parciais não passam pelo cache do extrato.
+func sendPartialStatement(x exchange, clientId int, withBalance bool, filter TransactionFilter) error {
+	ctx := x.Context()
+	if withBalance {
+		balance, err := storageFor(ctx).GetBalance(ctx, clientId)
+		if err != nil {
+			return sendProblemTo(x, Problem{Status: fiber.StatusInternalServerError})
+		}
+		setStatementCacheHeaders(x, time.Time{})
+		return sendJSONTo(x, fiber.StatusOK, fiber.Map{"saldo": BalanceResponse{
+			Total:              balance.Saldo,
+			Limite:             balance.Limite,
+			LimiteUtilizadoPct: limitUtilization(balance),
+			DataExtrato:        Timestamp{nowFor(ctx)},
+		}})
 	}
 
-	c.Response().Header.Set("Content-Type", "application/json")
-	c.Response().SetBody(jsonResponse)
-	return nil
+	transactions, err := storageFor(ctx).ListTransactions(ctx, clientId, filter)
+	if err != nil {
+		return sendProblemTo(x, Problem{Status: fiber.StatusInternalServerError})
+	}
+	var lastModified time.Time
+	if filter.Categoria == "" && filter.Metadata == "" && filter.AntesDe == nil && len(transactions) > 0 {
+		lastModified = transactions[0].RealizadaEm.Time
+	}
+	setStatementCacheHeaders(x, lastModified)
+	response := fiber.Map{"ultimas_transacoes": transactions}
+	if next := nextPageCursor(transactions, filter); next != nil {
+		response["proxima_pagina"] = *next
+	}
+	return sendJSONTo(x, fiber.StatusOK, response)
 }
 
 // Cliente representa a estrutura de dados de um cliente
 type Cliente struct {
 	ID         int         `json:"id"`
-	Limite     int         `json:"limite"`
-	Saldo      int         `json:"saldo"`
+	Limite     Centavos    `json:"limite"`
+	Saldo      Centavos    `json:"saldo"`
 	Transacoes []Transacao `json:"transacoes"`
 }
 
 // Transacao representa a estrutura de dados de uma transação
 type Transacao struct {
-	Valor       int       `json:"valor"`
+	ID          int64     `json:"id"`
+	Valor       Centavos  `json:"valor"`
 	Tipo        string    `json:"tipo"`
 	Descricao   string    `json:"descricao"`
-	RealizadaEm time.Time `json:"realizada_em"`
+	Categoria   *string   `json:"categoria,omitempty"`
+	RealizadaEm Timestamp `json:"realizada_em"`
+	// SaldoApos é o saldo do cliente logo após a transação
+	SaldoApos *Centavos `json:"saldo_apos,omitempty"`
+	// Seq é a posição da transação entre as do cliente; transações
+	// importadas não têm
+	Seq *int64 `json:"seq,omitempty"`
+	// EstornoDe liga um estorno ao débito estornado, que mostra em
+	// ValorEstornado quanto já foi devolvido
+	EstornoDe      *int64    `json:"estorno_de,omitempty"`
+	ValorEstornado *Centavos `json:"valor_estornado,omitempty"`
+	// Metadata é o objeto enviado na criação da transação, como veio
+	Metadata json.RawMessage `json:"metadata,omitempty"`
 }
 
 // TransacaoRequest representa a estrutura de dados de uma requisicao de transação
 type TransacaoRequest struct {
-	Valor     int    `json:"valor"`
-	Tipo      string `json:"tipo"`
-	Descricao string `json:"descricao"`
+	Valor     Centavos `json:"valor" validate:"gt=0,valor_maximo"`
+	Tipo      Tipo     `json:"tipo" validate:"required,tipo"`
+	Descricao string   `json:"descricao" validate:"required,max=10,descricao,descricao_permitida"`
+	Categoria string   `json:"categoria,omitempty" validate:"omitempty,max=30"`
+	// Parcelas divide um débito em parcelas mensais, ver createInstallments
+	Parcelas int `json:"parcelas,omitempty" validate:"omitempty,min=1,max=48"`
+	// Metadata é um objeto JSON livre do integrador (ids de pedido, tags),
+	// limitado a METADATA_MAX_BYTES
+	Metadata json.RawMessage `json:"metadata,omitempty" validate:"omitempty,metadata"`
+	// ID é preenchido com o id gerado ao registrar a transação
+	ID int64 `json:"-"`
+	// FraudeDecisao e FraudeRegra são preenchidas por checkFraud
+	FraudeDecisao string `json:"-"`
+	FraudeRegra   string `json:"-"`
+	// Pendente indica que a transação foi aceita no diário local
+	// (journal.go) e ainda não está no banco
+	Pendente bool `json:"-"`
+}
+
+// transactionRequests reaproveita os corpos decodificados de POST
+// /transacoes entre requisições
+var transactionRequests = sync.Pool{
+	New: func() any { return new(TransacaoRequest) },
+}
+
+// TransacaoResponse representa a resposta de POST /clientes/[id]/transacoes
+type TransacaoResponse struct {
+	ID int64 `json:"id"`
+	Balance
+	LimiteUtilizadoPct float64 `json:"limite_utilizado_pct"`
+}
+
+// AppendJSON serializa a resposta sem reflexão, no mesmo formato das tags
+// json acima
+func (r TransacaoResponse) AppendJSON(dst []byte) []byte {
+	dst = append(dst, `{"id":`...)
+	dst = strconv.AppendInt(dst, r.ID, 10)
+	dst = append(dst, `,"saldo":`...)
+	dst = strconv.AppendInt(dst, int64(r.Saldo), 10)
+	dst = append(dst, `,"limite":`...)
+	dst = strconv.AppendInt(dst, int64(r.Limite), 10)
+	dst = append(dst, `,"limite_utilizado_pct":`...)
+	dst = strconv.AppendFloat(dst, r.LimiteUtilizadoPct, 'f', -1, 64)
+	return append(dst, '}')
 }
 
 type Balance struct {
-	Saldo  int `json:"saldo"`
-	Limite int `json:"limite"`
+	Saldo  Centavos `json:"saldo"`
+	Limite Centavos `json:"limite"`
+	// Reservado é a soma das autorizações pendentes, lida só pelas
+	// estratégias que validam o limite na aplicação
+	Reservado Centavos `json:"-"`
 }
 
 // ExtratoResponse representa a estrutura de dados da resposta do endpoint /clientes/[id]/extrato
 type TransactionLog struct {
-	Saldo             BalanceResponse `json:"saldo"`
-	UltimasTransacoes []Transacao     `json:"ultimas_transacoes"`
+	Saldo              BalanceResponse  `json:"saldo"`
+	UltimasTransacoes  []Transacao      `json:"ultimas_transacoes"`
+	TotaisPorCategoria []TotalCategoria `json:"totais_por_categoria,omitempty"`
+	Parcelamentos      []Parcelamento   `json:"parcelamentos,omitempty"`
+	// ProximaPagina é o valor de ?antes_de para as transações seguintes
+	ProximaPagina *string `json:"proxima_pagina,omitempty"`
 }
 
 // SaldoResponse representa a estrutura de dados do saldo na resposta do extrato
 type BalanceResponse struct {
-	Total       int       `json:"total"`
-	DataExtrato time.Time `json:"data_extrato"`
-	Limite      int       `json:"limite"`
+	Total              Centavos  `json:"total"`
+	DataExtrato        Timestamp `json:"data_extrato"`
+	Limite             Centavos  `json:"limite"`
+	LimiteUtilizadoPct float64   `json:"limite_utilizado_pct"`
 }
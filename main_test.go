@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// benchmarkRoute mede uma rota do app do serve montado sobre a, chamando o
+// handler do fasthttp direto, sem socket nem cópia da requisição
+func benchmarkRoute(b *testing.B, a *App, method, uri, body string) {
+	handler := newHTTPApp(a).Handler()
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	if body != "" {
+		ctx.Request.Header.SetContentType("application/json")
+		ctx.Request.SetBodyString(body)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx.Response.Reset()
+		handler(&ctx)
+		if status := ctx.Response.StatusCode(); status != fasthttp.StatusOK {
+			b.Fatalf("status %d: %s", status, ctx.Response.Body())
+		}
+	}
+}
+
+func BenchmarkHandleTransactions(b *testing.B) {
+	benchmarkRoute(b, sqliteTestApp(b), "POST", "/clientes/1/transacoes", `{"valor": 1, "tipo": "c", "descricao": "bench"}`)
+}
+
+// BenchmarkTransactionLog lê um extrato com a página de 10 transações cheia
+func BenchmarkTransactionLog(b *testing.B) {
+	a := sqliteTestApp(b)
+	ctx := withApp(context.Background(), a)
+	for i := 0; i < 10; i++ {
+		transaction := &TransacaoRequest{Valor: 1, Tipo: TipoCredito, Descricao: "bench"}
+		if _, err := a.storage.CreateTransaction(ctx, 1, transaction); err != nil {
+			b.Fatal(err)
+		}
+	}
+	benchmarkRoute(b, a, "GET", "/clientes/1/extrato", "")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Histórico de memória para testes de soak: a cada MEMORIA_INTERVALO (10s
+// por padrão; zero desliga) a instância guarda o número de goroutines e o
+// heap, mantendo as últimas MEMORIA_AMOSTRAS. GET /admin/memoria devolve a
+// série e POST /admin/memoria/amostra força um GC e tira uma amostra na
+// hora, para comparar o antes e o depois de uma carga sem o lixo ainda não
+// coletado (ver cmd/loadgen -soak). Um vazamento nas filas e caches aparece
+// como goroutines ou heap_alloc que só crescem entre amostras pós-GC.
+
+// AmostraMemoria é uma leitura das goroutines e do heap da instância
+type AmostraMemoria struct {
+	Em          time.Time `json:"em"`
+	Goroutines  int       `json:"goroutines"`
+	HeapAlloc   uint64    `json:"heap_alloc"`
+	HeapInuse   uint64    `json:"heap_inuse"`
+	HeapObjects uint64    `json:"heap_objects"`
+	NumGC       uint32    `json:"num_gc"`
+	// AposGC marca as amostras tiradas logo depois de um GC forçado
+	AposGC bool `json:"apos_gc,omitempty"`
+}
+
+// memoryHistory é um buffer circular das últimas amostras
+type memoryHistory struct {
+	mu      sync.Mutex
+	samples []AmostraMemoria
+	next    int
+	full    bool
+}
+
+var memorySamples = newMemoryHistory(getEnvInt("MEMORIA_AMOSTRAS", 360))
+
+func newMemoryHistory(size int) *memoryHistory {
+	return &memoryHistory{samples: make([]AmostraMemoria, max(size, 1))}
+}
+
+func sampleMemory(afterGC bool) AmostraMemoria {
+	if afterGC {
+		runtime.GC()
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return AmostraMemoria{
+		Em:          time.Now(),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   m.HeapAlloc,
+		HeapInuse:   m.HeapInuse,
+		HeapObjects: m.HeapObjects,
+		NumGC:       m.NumGC,
+		AposGC:      afterGC,
+	}
+}
+
+func (h *memoryHistory) add(sample AmostraMemoria) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+	h.full = h.full || h.next == 0
+}
+
+// list devolve as amostras da mais antiga para a mais recente
+func (h *memoryHistory) list() []AmostraMemoria {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]AmostraMemoria{}, h.samples[:h.next]...)
+	}
+	return append(append([]AmostraMemoria{}, h.samples[h.next:]...), h.samples[:h.next]...)
+}
+
+func runMemorySampler(ctx context.Context, interval time.Duration) {
+	memorySamples.add(sampleMemory(false))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			memorySamples.add(sampleMemory(false))
+		}
+	}
+}
+
+func handleMemoryHistory(c fiber.Ctx) error {
+	return c.JSON(memorySamples.list())
+}
+
+func handleMemorySample(c fiber.Ctx) error {
+	sample := sampleMemory(true)
+	memorySamples.add(sample)
+	return c.JSON(sample)
+}
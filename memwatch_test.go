@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSoak é o cmd/loadgen -soak dentro do processo: a mistura de créditos,
+// débitos e extratos da rinha contra o app do serve sobre o SQLite, com as
+// amostras pós-GC antes e depois da carga. A fila de escritas, o cache e o
+// agrupamento de extratos ficam ligados, e o Redis também, com REDIS_URL,
+// porque são os subsistemas em que um vazamento é mais provável.
+//
+// Por padrão são SOAK_REQUISICOES=10000 requisições, alguns segundos, para
+// caber no go test ./...; o soak do teste de carga é de 1M:
+//
+//	SOAK_REQUISICOES=1000000 go test -run TestSoak -timeout 30m
+//
+// -short pula o teste.
+func TestSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak skipped with -short")
+	}
+	const (
+		warmup         = 1000
+		workers        = 20
+		goroutinesGrow = 10
+		heapGrow       = 0.5
+	)
+	requests := getEnvInt("SOAK_REQUISICOES", 10000)
+
+	previousWrites, previousDedup := writes, statementDedupEnabled
+	previousTTL := time.Duration(statements.ttl.Load())
+	writes = newWriteQueue(4, 4*workers, 0.8, 10*time.Millisecond)
+	statements.SetTTL(time.Second)
+	statementDedupEnabled = true
+	t.Cleanup(func() {
+		writes, statementDedupEnabled = previousWrites, previousDedup
+		statements.SetTTL(previousTTL)
+	})
+
+	app := sqliteTestApp(t)
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		var err error
+		if app.redis, err = newRedisStorage(context.Background(), app.storage, url); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { app.redis.client.Close() })
+		app.storage = app.redis
+	}
+	httpClient, baseURL := testClient(app, httpStackFiber)
+	load := func(requests int) {
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < requests/workers; i++ {
+					if err := soakRequest(httpClient, baseURL); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	load(warmup)
+	baseline := sampleMemory(true)
+	load(requests)
+	final := sampleMemory(true)
+	t.Logf("goroutines %d -> %d, heap_alloc %d -> %d bytes",
+		baseline.Goroutines, final.Goroutines, baseline.HeapAlloc, final.HeapAlloc)
+
+	if grown := final.Goroutines - baseline.Goroutines; grown > goroutinesGrow {
+		t.Errorf("goroutines grew by %d (tolerance %d)", grown, goroutinesGrow)
+	}
+	if limit := float64(baseline.HeapAlloc) * (1 + heapGrow); float64(final.HeapAlloc) > limit {
+		t.Errorf("heap_alloc grew from %d to %d bytes (tolerance %.0f%%)", baseline.HeapAlloc, final.HeapAlloc, heapGrow*100)
+	}
+}
+
+// soakRequest envia uma requisição da mistura do loadgen: 40% créditos, 40%
+// débitos e 20% extratos, de um cliente sorteado
+func soakRequest(httpClient *http.Client, baseURL string) error {
+	clientURL := fmt.Sprintf("%s/clientes/%d", baseURL, rand.IntN(5)+1)
+	var resp *http.Response
+	var err error
+	switch n := rand.IntN(10); {
+	case n < 8:
+		tipo := "c"
+		if n%2 == 1 {
+			tipo = "d"
+		}
+		body := fmt.Sprintf(`{"valor": %d, "tipo": "%s", "descricao": "soak"}`, rand.IntN(10000)+1, tipo)
+		resp, err = httpClient.Post(clientURL+"/transacoes", "application/json", strings.NewReader(body))
+	default:
+		resp, err = httpClient.Get(clientURL + "/extrato")
+	}
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	// 422 é o débito acima do limite, parte da mistura
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnprocessableEntity {
+		return fmt.Errorf("%s %s: status %d", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// maxMetadataSize é o tamanho máximo, em bytes, do objeto metadata de uma
+// transação (METADATA_MAX_BYTES). O corpo inteiro continua limitado por
+// TRANSACAO_BODY_LIMIT.
+var maxMetadataSize atomic.Int64
+
+func init() {
+	onReload(func() {
+		maxMetadataSize.Store(int64(getEnvInt("METADATA_MAX_BYTES", 512)))
+	})
+}
+
+// metadataFilterPrefix marca os parâmetros do extrato que filtram por
+// metadata, como ?metadata.pedido=123
+const metadataFilterPrefix = "metadata."
+
+// validMetadata aceita um objeto JSON de até maxMetadataSize bytes
+func validMetadata(metadata []byte) bool {
+	if int64(len(metadata)) > maxMetadataSize.Load() {
+		return false
+	}
+	trimmed := bytes.TrimSpace(metadata)
+	return len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed)
+}
+
+// metadataFilter monta, dos parâmetros ?metadata.chave=valor, o objeto JSON
+// que as transações do extrato devem conter. Os valores são comparados como
+// texto, então {"pedido": 123} não casa com ?metadata.pedido=123. Sem
+// parâmetros, devolve "".
+func metadataFilter(queries map[string]string) string {
+	filter := map[string]string{}
+	for key, value := range queries {
+		if name, ok := strings.CutPrefix(key, metadataFilterPrefix); ok && name != "" {
+			filter[name] = value
+		}
+	}
+	if len(filter) == 0 {
+		return ""
+	}
+	body, _ := json.Marshal(filter)
+	return string(body)
+}
+
+// metadataFilterPairs devolve as chaves e valores de um filtro montado por
+// metadataFilter, em ordem de chave
+func metadataFilterPairs(filter string) (keys, values []string) {
+	var pairs map[string]string
+	if json.Unmarshal([]byte(filter), &pairs) != nil {
+		return nil, nil
+	}
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values = append(values, pairs[key])
+	}
+	return keys, values
+}
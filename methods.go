@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// methodMiddleware atende HEAD com a rota GET correspondente (o fasthttp
+// descarta o corpo e mantém os cabeçalhos) e OPTIONS com 204 e Allow. O 405
+// do roteador já traz Allow com os métodos registrados; aqui são acrescentados
+// HEAD e OPTIONS. Fica antes dos demais middlewares porque HEAD reinicia o
+// roteamento.
+func methodMiddleware(c fiber.Ctx) error {
+	switch c.Method() {
+	case fiber.MethodHead:
+		c.Method(fiber.MethodGet)
+		return c.RestartRouting()
+	case fiber.MethodGet:
+		return c.Next()
+	}
+
+	err := c.Next()
+	if !errors.Is(err, fiber.ErrMethodNotAllowed) {
+		return err
+	}
+	allow := string(c.Response().Header.Peek(fiber.HeaderAllow))
+	if strings.Contains(allow, fiber.MethodGet) {
+		allow += ", " + fiber.MethodHead
+	}
+	allow += ", " + fiber.MethodOptions
+	c.Set(fiber.HeaderAllow, allow)
+
+	if c.Method() == fiber.MethodOptions {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrValorFracionario = errors.New("valor deve ser um número inteiro de centavos")
+	ErrValorInvalido    = errors.New("valor inválido")
+	ErrValorOverflow    = errors.New("valor fora do intervalo permitido")
+)
+
+// Centavos representa um valor monetário em centavos. Não aceita valores
+// fracionários e as operações aritméticas verificam overflow.
+type Centavos int64
+
+// ParseCentavos converte uma string com um número inteiro de centavos.
+func ParseCentavos(s string) (Centavos, error) {
+	if s == "" {
+		return 0, ErrValorInvalido
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '.' || s[i] == ',' || s[i] == 'e' || s[i] == 'E' {
+			return 0, ErrValorFracionario
+		}
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, ErrValorOverflow
+		}
+		return 0, ErrValorInvalido
+	}
+	return Centavos(v), nil
+}
+
+// String formata o valor em reais, ex.: -1234.56.
+func (v Centavos) String() string {
+	sinal := ""
+	u := uint64(v)
+	if v < 0 {
+		sinal = "-"
+		u = uint64(-(v + 1)) + 1
+	}
+	return fmt.Sprintf("%s%d.%02d", sinal, u/100, u%100)
+}
+
+func (v Centavos) Add(o Centavos) (Centavos, error) {
+	if (o > 0 && v > math.MaxInt64-o) || (o < 0 && v < math.MinInt64-o) {
+		return 0, ErrValorOverflow
+	}
+	return v + o, nil
+}
+
+func (v Centavos) Sub(o Centavos) (Centavos, error) {
+	if (o < 0 && v > math.MaxInt64+o) || (o > 0 && v < math.MinInt64+o) {
+		return 0, ErrValorOverflow
+	}
+	return v - o, nil
+}
+
+func (v Centavos) Neg() (Centavos, error) {
+	if v == math.MinInt64 {
+		return 0, ErrValorOverflow
+	}
+	return -v, nil
+}
+
+// Int64String formata o valor como número inteiro de centavos.
+func (v Centavos) Int64String() string {
+	return strconv.FormatInt(int64(v), 10)
+}
+
+func (v Centavos) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, int64(v), 10), nil
+}
+
+func (v *Centavos) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] == '"' || bytes.Equal(data, []byte("null")) {
+		return ErrValorInvalido
+	}
+	parsed, err := ParseCentavos(string(data))
+	if err != nil {
+		return err
+	}
+	*v = parsed
+	return nil
+}
+
+func (v *Centavos) ScanInt64(n pgtype.Int8) error {
+	if !n.Valid {
+		return ErrValorInvalido
+	}
+	*v = Centavos(n.Int64)
+	return nil
+}
+
+func (v Centavos) Int64Value() (pgtype.Int8, error) {
+	return pgtype.Int8{Int64: int64(v), Valid: true}, nil
+}
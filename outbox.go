@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// outboxEnabled grava os eventos na tabela outbox, na mesma transação que
+// os originou, em vez de enviá-los direto aos destinos externos. O relay só
+// enxerga eventos de transações confirmadas (sem eventos fantasmas) e só os
+// marca como publicados depois de entregues (sem eventos perdidos); a
+// entrega é at-least-once e o id do evento serve para deduplicação.
+var outboxEnabled = getEnvBool("OUTBOX_ENABLED", false)
+
+// TransacaoCriada representa os dados do evento emitido para cada transação confirmada
+type TransacaoCriada struct {
+	ID        int64    `json:"id"`
+	Valor     Centavos `json:"valor"`
+	Tipo      string   `json:"tipo"`
+	Descricao string   `json:"descricao"`
+	Categoria string   `json:"categoria,omitempty"`
+	Saldo     Centavos `json:"saldo"`
+	Limite    Centavos `json:"limite"`
+}
+
+// eventSink entrega um evento do outbox a um destino externo
+type eventSink func(ctx context.Context, event Evento) error
+
+// outboxSinks lista os destinos do relay, configurados na inicialização
+var outboxSinks []eventSink
+
+func configureOutboxSinks() {
+	if url := getEnv("ALERTAS_WEBHOOK_URL", ""); url != "" {
+		outboxSinks = append(outboxSinks, func(ctx context.Context, event Evento) error {
+			return postWebhook(ctx, url, event)
+		})
+	}
+}
+
+func enqueueEvent(ctx context.Context, db dbtx, event Evento) error {
+	data, err := jsonMarshal(event.Dados)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `
+		INSERT INTO outbox (tipo, cliente_id, dados) VALUES ($1, $2, $3)`,
+		event.Tipo, event.ClienteID, string(data))
+	return err
+}
+
+// createTransactionWithEvents aplica a transação e, na mesma transação do
+// banco, registra o evento transacao_criada no outbox e dispara os alertas de
+// saldo cruzados. Os alertas só são publicados depois do commit.
+func createTransactionWithEvents(ctx context.Context, db dbtx, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Balance{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	balance, err := applyTransaction(ctx, tx, clientId, transaction)
+	if err != nil {
+		return balance, err
+	}
+	if outboxEnabled {
+		err = enqueueEvent(ctx, tx, Evento{
+			Tipo:      "transacao_criada",
+			ClienteID: clientId,
+			Dados: TransacaoCriada{
+				ID:        transaction.ID,
+				Valor:     transaction.Valor,
+				Tipo:      string(transaction.Tipo),
+				Descricao: transaction.Descricao,
+				Categoria: transaction.Categoria,
+				Saldo:     balance.Saldo,
+				Limite:    balance.Limite,
+			},
+		})
+		if err != nil {
+			return balance, err
+		}
+	}
+
+	var alerts []Evento
+	if balanceAlertsEnabled {
+		alerts, err = checkBalanceAlerts(ctx, tx, clientId, transaction, balance)
+		if err != nil {
+			return balance, err
+		}
+		if outboxEnabled {
+			for _, alert := range alerts {
+				if err := enqueueEvent(ctx, tx, alert); err != nil {
+					return balance, err
+				}
+			}
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return balance, err
+	}
+
+	for _, alert := range alerts {
+		events.Publish(ctx, alert)
+	}
+	return balance, nil
+}
+
+// relayOutbox publica um lote de eventos pendentes em ordem de id. Um erro
+// de entrega interrompe o lote, e os eventos restantes ficam para o próximo
+// ciclo.
+func relayOutbox(ctx context.Context, batchSize int) (int, error) {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, tipo, cliente_id, dados, criado_em
+		FROM outbox WHERE publicado_em IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED`, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	var pending []Evento
+	for rows.Next() {
+		var event Evento
+		var data json.RawMessage
+		if err := rows.Scan(&event.ID, &event.Tipo, &event.ClienteID, &data, &event.CriadoEm); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		event.Tenant = tenantFrom(ctx)
+		event.Dados = data
+		pending = append(pending, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var published []int64
+	var deliveryErr error
+deliver:
+	for _, event := range pending {
+		for _, sink := range outboxSinks {
+			if deliveryErr = sink(ctx, event); deliveryErr != nil {
+				break deliver
+			}
+		}
+		published = append(published, event.ID)
+	}
+
+	if len(published) > 0 {
+		_, err = tx.Exec(ctx, "UPDATE outbox SET publicado_em = NOW() WHERE id = ANY($1)", published)
+		if err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return len(published), deliveryErr
+}
+
+func purgeOutbox(ctx context.Context, retention time.Duration) error {
+	_, err := poolFor(ctx).Exec(ctx, `
+		DELETE FROM outbox
+		WHERE publicado_em < NOW() - make_interval(secs => $1)`, retention.Seconds())
+	return err
+}
+
+// runOutboxRelay publica os eventos pendentes a cada OUTBOX_INTERVALO e
+// remove os já publicados há mais de OUTBOX_RETENCAO.
+func runOutboxRelay(ctx context.Context, interval time.Duration, batchSize int, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastPurge := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				relayed, err := relayOutbox(ctx, batchSize)
+				if err != nil {
+					if !errors.Is(err, context.Canceled) {
+						log.Print("Error relaying outbox: ", err)
+					}
+					break
+				}
+				if relayed < batchSize {
+					break
+				}
+			}
+			if time.Since(lastPurge) >= time.Hour {
+				lastPurge = time.Now()
+				if err := purgeOutbox(ctx, retention); err != nil {
+					log.Print("Error purging outbox: ", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Particionamento mensal de transacoes por realizada_em. O subcomando
+// partition converte a tabela criada pelo script.sql em particionada,
+// mantendo as linhas existentes numa partição transacoes_legado; a partir
+// daí o job de manutenção (PARTICOES_ENABLED, só na instância líder) cria as
+// partições dos próximos PARTICOES_FUTURAS meses e remove as que terminaram
+// há mais de PARTICOES_RETENCAO meses. Linhas fora das partições criadas caem
+// em transacoes_padrao.
+//
+// O extrato continua lendo só o necessário: os índices parciais por cliente
+// existem em cada partição e o Postgres percorre as partições da mais recente
+// para a mais antiga, parando ao completar o LIMIT.
+type PartitionConfig struct {
+	Future    int
+	Retention int
+	Interval  time.Duration
+}
+
+func loadPartitionConfig() PartitionConfig {
+	return PartitionConfig{
+		Future: max(getEnvInt("PARTICOES_FUTURAS", 3), 1),
+		// zero mantém todas as partições
+		Retention: getEnvInt("PARTICOES_RETENCAO", 0),
+		Interval:  getEnvDuration("PARTICOES_INTERVALO", time.Hour),
+	}
+}
+
+// partitionBoundPattern extrai o limite superior de pg_get_expr(relpartbound)
+var partitionBoundPattern = regexp.MustCompile(`TO \('([^']+)'\)`)
+
+type transactionPartition struct {
+	name  string
+	until time.Time
+}
+
+func partition(args []string) error {
+	flag.NewFlagSet("partition", flag.ExitOnError).Parse(args)
+	if err := requirePostgres("partition"); err != nil {
+		return err
+	}
+
+	config := loadPartitionConfig()
+	for _, ctx := range tenantContexts(context.Background()) {
+		if err := convertToPartitioned(ctx); err != nil {
+			return fmt.Errorf("tenant %q: %w", tenantFrom(ctx), err)
+		}
+		if err := maintainPartitions(ctx, config); err != nil {
+			return fmt.Errorf("tenant %q: %w", tenantFrom(ctx), err)
+		}
+	}
+	return nil
+}
+
+func isPartitioned(ctx context.Context, db dbtx) (bool, error) {
+	var partitioned bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'transacoes'::regclass)`).
+		Scan(&partitioned)
+	return partitioned, err
+}
+
+// convertToPartitioned troca transacoes por uma tabela particionada com as
+// mesmas colunas, índices e gatilhos. A tabela antiga vira a partição
+// transacoes_legado, cobrindo tudo até o fim do mês da transação mais
+// recente; as escritas ficam bloqueadas durante a conversão.
+func convertToPartitioned(ctx context.Context) error {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "LOCK TABLE transacoes IN ACCESS EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+	partitioned, err := isPartitioned(ctx, tx)
+	if err != nil || partitioned {
+		return err
+	}
+
+	var until time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT date_trunc('month', GREATEST(MAX(realizada_em), NOW() AT TIME ZONE 'UTC')) + INTERVAL '1 month'
+		FROM transacoes`).Scan(&until)
+	if err != nil {
+		return err
+	}
+
+	// os índices e os gatilhos passam a ser os da tabela particionada, que os
+	// replica em cada partição
+	statements := []string{
+		"DROP TRIGGER reconcile_amount_trigger ON transacoes",
+		"DROP TRIGGER bloqueio_trigger ON transacoes",
+		"DROP TRIGGER partidas_trigger ON transacoes",
+		`DROP INDEX indice_transacoes_1, indice_transacoes_2, indice_transacoes_3,
+			indice_transacoes_4, indice_transacoes_5, indice_transacoes_seq,
+			indice_transacoes_categoria, indice_transacoes_metadata`,
+		"ALTER TABLE transacoes RENAME TO transacoes_legado",
+		"ALTER INDEX transacoes_pkey RENAME TO transacoes_legado_pkey",
+		`CREATE TABLE transacoes (
+			LIKE transacoes_legado INCLUDING DEFAULTS,
+			PRIMARY KEY (id, realizada_em),
+			CONSTRAINT fk_clientes_transacoes_id
+				FOREIGN KEY (cliente_id) REFERENCES clientes(id),
+			CONSTRAINT fk_categorias_transacoes_nome
+				FOREIGN KEY (categoria) REFERENCES categorias(nome) ON UPDATE CASCADE
+		) PARTITION BY RANGE (realizada_em)`,
+		// a sequência de ids não pode sumir junto com a partição legada
+		"ALTER SEQUENCE transacoes_id_seq OWNED BY transacoes.id",
+		"CREATE INDEX indice_transacoes_1 ON transacoes (cliente_id, realizada_em DESC, seq DESC) WHERE cliente_id = 1",
+		"CREATE INDEX indice_transacoes_2 ON transacoes (cliente_id, realizada_em DESC, seq DESC) WHERE cliente_id = 2",
+		"CREATE INDEX indice_transacoes_3 ON transacoes (cliente_id, realizada_em DESC, seq DESC) WHERE cliente_id = 3",
+		"CREATE INDEX indice_transacoes_4 ON transacoes (cliente_id, realizada_em DESC, seq DESC) WHERE cliente_id = 4",
+		"CREATE INDEX indice_transacoes_5 ON transacoes (cliente_id, realizada_em DESC, seq DESC) WHERE cliente_id = 5",
+		"CREATE INDEX indice_transacoes_seq ON transacoes (cliente_id, seq) WHERE seq IS NOT NULL",
+		"CREATE INDEX indice_transacoes_categoria ON transacoes (cliente_id, categoria) WHERE categoria IS NOT NULL",
+		"CREATE INDEX indice_transacoes_metadata ON transacoes USING GIN (metadata jsonb_path_ops) WHERE metadata IS NOT NULL",
+		`CREATE TRIGGER reconcile_amount_trigger
+			BEFORE INSERT ON transacoes
+			FOR EACH ROW
+			EXECUTE FUNCTION reconcile_amount_trigger_function()`,
+		`CREATE TRIGGER bloqueio_trigger
+			BEFORE INSERT ON transacoes
+			FOR EACH ROW
+			EXECUTE FUNCTION bloqueio_trigger_function()`,
+		`CREATE TRIGGER partidas_trigger
+			AFTER INSERT ON transacoes
+			FOR EACH ROW
+			EXECUTE FUNCTION partidas_trigger_function()`,
+		"ALTER TABLE transacoes ATTACH PARTITION transacoes_legado FOR VALUES FROM (MINVALUE) TO ('" +
+			until.Format(time.DateTime) + "')",
+		"CREATE UNLOGGED TABLE transacoes_padrao PARTITION OF transacoes DEFAULT",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("%s: %w", statement, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	log.Printf("Converted transacoes to a partitioned table; transacoes_legado holds rows until %s", until.Format(time.DateOnly))
+	return nil
+}
+
+func runPartitionMaintenance(ctx context.Context, config PartitionConfig) {
+	partitioned, err := isPartitioned(ctx, poolFor(ctx))
+	if err != nil {
+		log.Print("Error checking partitions: ", err)
+		return
+	}
+	if !partitioned {
+		log.Print("Partition maintenance disabled: transacoes is not partitioned, run the partition command first")
+		return
+	}
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := maintainPartitions(ctx, config); err != nil && !errors.Is(err, context.Canceled) {
+			log.Print("Error maintaining partitions: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func maintainPartitions(ctx context.Context, config PartitionConfig) error {
+	partitions, err := listPartitions(ctx)
+	if err != nil {
+		return err
+	}
+
+	// as novas partições começam onde a última termina
+	var last time.Time
+	for _, p := range partitions {
+		if p.until.After(last) {
+			last = p.until
+		}
+	}
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= config.Future; i++ {
+		from := month.AddDate(0, i, 0)
+		if from.Before(last) {
+			continue
+		}
+		if err := createPartition(ctx, from); err != nil {
+			return err
+		}
+		last = from.AddDate(0, 1, 0)
+	}
+
+	if config.Retention <= 0 {
+		return nil
+	}
+	cutoff := month.AddDate(0, -config.Retention, 0)
+	for _, p := range partitions {
+		if !p.until.After(cutoff) {
+			if err := dropPartition(ctx, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func listPartitions(ctx context.Context) ([]transactionPartition, error) {
+	rows, err := poolFor(ctx).Query(ctx, `
+		SELECT c.relname, pg_get_expr(c.relpartbound, c.oid)
+		FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'transacoes'::regclass`)
+	if err != nil {
+		return nil, err
+	}
+	var partitions []transactionPartition
+	for rows.Next() {
+		var name, bound string
+		if err := rows.Scan(&name, &bound); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		// a partição padrão não tem limite e nunca é removida
+		match := partitionBoundPattern.FindStringSubmatch(bound)
+		if match == nil {
+			continue
+		}
+		until, err := time.Parse(time.DateTime, match[1])
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("partition %s: %w", name, err)
+		}
+		partitions = append(partitions, transactionPartition{name: name, until: until})
+	}
+	return partitions, rows.Err()
+}
+
+func createPartition(ctx context.Context, from time.Time) error {
+	name := pgx.Identifier{from.Format("transacoes_2006_01")}.Sanitize()
+	_, err := poolFor(ctx).Exec(ctx, fmt.Sprintf(
+		"CREATE UNLOGGED TABLE IF NOT EXISTS %s PARTITION OF transacoes FOR VALUES FROM ('%s') TO ('%s')",
+		name, from.Format(time.DateTime), from.AddDate(0, 1, 0).Format(time.DateTime)))
+	if err != nil {
+		return fmt.Errorf("creating partition %s: %w", name, err)
+	}
+	return nil
+}
+
+// dropPartition remove a partição levando o efeito das suas transações para
+// saldo_arquivado, como o arquivamento, para que a reconciliação continue
+// fechando. No modo eventsourcing a partição espera até estar toda projetada.
+func dropPartition(ctx context.Context, p transactionPartition) error {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	name := pgx.Identifier{p.name}.Sanitize()
+	if concurrencyMode == concurrencyEventSourcing {
+		var pending bool
+		err := tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM `+name+` t JOIN clientes c ON c.id = t.cliente_id
+			WHERE t.id > c.projetado_ate)`).Scan(&pending)
+		if err != nil || pending {
+			return err
+		}
+	}
+
+	statements := []string{
+		"ALTER TABLE transacoes DETACH PARTITION " + name,
+		`UPDATE clientes c SET saldo_arquivado = c.saldo_arquivado + a.total
+			FROM (SELECT cliente_id, SUM(CASE WHEN tipo = 'c' THEN valor ELSE -valor END) AS total
+				FROM ` + name + ` GROUP BY cliente_id) a
+			WHERE c.id = a.cliente_id`,
+		"DROP TABLE " + name,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("dropping partition %s: %w", name, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	log.Printf("Dropped partition %s with transactions until %s", p.name, p.until.Format(time.DateOnly))
+	return nil
+}
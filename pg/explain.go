@@ -0,0 +1,7 @@
+package pg
+
+// Textos das consultas geradas cujo plano o app registra na subida
+const (
+	ListRecentTransactionsSQL = listRecentTransactions
+	GetBalanceSQL             = getBalance
+)
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem representa o corpo das respostas de erro no formato
+// application/problem+json (RFC 9457). Codigo e Erros são membros de
+// extensão com o código específico do erro e as falhas de cada campo.
+type Problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance"`
+	Codigo   string      `json:"codigo,omitempty"`
+	Erros    []ErroCampo `json:"erros,omitempty"`
+}
+
+// sendProblem completa os campos padrão do problema e o envia com o status
+// informado. Problemas com Codigo recebem um type próprio em /problemas/;
+// os demais usam about:blank, cujo title é a descrição do status HTTP. As
+// mensagens seguem o idioma da requisição (localizationMiddleware).
+func sendProblem(c fiber.Ctx, problem Problem) error {
+	problem.complete(c.OriginalURL(), localeFrom(c))
+	return c.Status(problem.Status).JSON(problem, problemContentType)
+}
+
+// complete preenche type, title e instance e traduz as mensagens para locale
+func (problem *Problem) complete(instance, locale string) {
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+		if problem.Codigo != "" {
+			problem.Type = "/problemas/" + strings.ToLower(strings.ReplaceAll(problem.Codigo, "_", "-"))
+		}
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	if problem.Instance == "" {
+		problem.Instance = instance
+	}
+	localizeProblem(locale, problem)
+}
+
+// problemMiddleware converte as respostas de erro enviadas com c.SendStatus,
+// cujo corpo é só a descrição do status, em problem+json, para que todos os
+// handlers sigam o mesmo formato.
+func problemMiddleware(c fiber.Ctx) error {
+	if err := c.Next(); err != nil {
+		return err
+	}
+	status := c.Response().StatusCode()
+	if status < fiber.StatusBadRequest {
+		return nil
+	}
+	if body := c.Response().Body(); len(body) > 0 && string(body) != http.StatusText(status) {
+		return nil
+	}
+	return sendProblem(c, Problem{Status: status})
+}
+
+// problemErrorHandler substitui o fiber.DefaultErrorHandler para os erros
+// retornados pelos handlers e pelo próprio fiber (rota inexistente, corpo
+// inválido). Detalhes de erros internos não são expostos ao cliente.
+func problemErrorHandler(c fiber.Ctx, err error) error {
+	problem := Problem{Status: fiber.StatusInternalServerError}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		problem.Status = fiberErr.Code
+		if fiberErr.Message != http.StatusText(fiberErr.Code) {
+			problem.Detail = fiberErr.Message
+		}
+	}
+	return sendProblem(c, problem)
+}
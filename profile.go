@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// PerfilCliente é o cadastro do cliente. Documento é o CPF ou CNPJ só com os
+// dígitos; documento e email são opcionais, mas únicos entre os clientes.
+type PerfilCliente struct {
+	ID        int       `json:"id"`
+	Nome      string    `json:"nome"`
+	Documento *string   `json:"documento,omitempty"`
+	Email     *string   `json:"email,omitempty"`
+	Limite    Centavos  `json:"limite"`
+	CriadoEm  Timestamp `json:"criado_em"`
+}
+
+// PerfilClienteRequest é o corpo de PATCH /clientes/:id: só os campos
+// presentes são alterados.
+type PerfilClienteRequest struct {
+	Nome      *string `json:"nome" validate:"omitnil,min=1,max=50"`
+	Documento *string `json:"documento" validate:"omitnil,documento"`
+	Email     *string `json:"email" validate:"omitnil,email,max=254"`
+}
+
+// normalizeDocument remove a pontuação de um CPF ou CNPJ formatado
+func normalizeDocument(document string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '-', '/', ' ':
+			return -1
+		}
+		return r
+	}, document)
+}
+
+// validDocument aceita CPFs (11 dígitos) e CNPJs (14 dígitos) com os
+// dígitos verificadores corretos, formatados ou não
+func validDocument(document string) bool {
+	digits := normalizeDocument(document)
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	switch len(digits) {
+	case 11:
+		return !repeatedDigits(digits) &&
+			checkDigit(digits[:9], []int{10, 9, 8, 7, 6, 5, 4, 3, 2}) == digits[9] &&
+			checkDigit(digits[:10], []int{11, 10, 9, 8, 7, 6, 5, 4, 3, 2}) == digits[10]
+	case 14:
+		return !repeatedDigits(digits) &&
+			checkDigit(digits[:12], []int{5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}) == digits[12] &&
+			checkDigit(digits[:13], []int{6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}) == digits[13]
+	}
+	return false
+}
+
+// checkDigit calcula um dígito verificador do CPF e do CNPJ (módulo 11)
+func checkDigit(digits string, weights []int) byte {
+	sum := 0
+	for i, weight := range weights {
+		sum += int(digits[i]-'0') * weight
+	}
+	rest := sum % 11
+	if rest < 2 {
+		return '0'
+	}
+	return byte('0' + 11 - rest)
+}
+
+// repeatedDigits recusa 111.111.111-11 e afins, que passam no módulo 11
+func repeatedDigits(digits string) bool {
+	return strings.Count(digits, digits[:1]) == len(digits)
+}
+
+func handleGetClient(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	ctx := c.UserContext()
+	client, err := getClient(ctx, poolFor(ctx), clientId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(client)
+}
+
+func getClient(ctx context.Context, db dbtx, clientId int) (PerfilCliente, error) {
+	var client PerfilCliente
+	err := db.QueryRow(ctx, `
+		SELECT id, nome, documento, email, limite, criado_em
+		FROM clientes WHERE id = $1`, clientId).
+		Scan(&client.ID, &client.Nome, &client.Documento, &client.Email, &client.Limite, &client.CriadoEm)
+	return client, err
+}
+
+// handlePatchClient altera nome, documento e email do cliente. A unicidade de
+// documento e email é verificada antes do UPDATE para responder 409 com o
+// campo em conflito; os índices únicos cobrem as alterações concorrentes.
+func handlePatchClient(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	profile := new(PerfilClienteRequest)
+	if err := bindJSON(c, profile); err != nil {
+		return sendBindError(c, err)
+	}
+	if profile.Documento != nil {
+		document := normalizeDocument(*profile.Documento)
+		profile.Documento = &document
+	}
+	if profile.Email != nil {
+		email := strings.TrimSpace(*profile.Email)
+		profile.Email = &email
+	}
+
+	ctx := c.UserContext()
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	defer tx.Rollback(ctx)
+
+	if profile.Documento != nil {
+		taken, err := profileFieldTaken(ctx, tx, clientId, "documento = $2", *profile.Documento)
+		if err != nil {
+			return c.SendStatus(fiber.ErrInternalServerError.Code)
+		}
+		if taken {
+			return sendDocumentConflict(c)
+		}
+	}
+	if profile.Email != nil {
+		taken, err := profileFieldTaken(ctx, tx, clientId, "lower(email) = lower($2)", *profile.Email)
+		if err != nil {
+			return c.SendStatus(fiber.ErrInternalServerError.Code)
+		}
+		if taken {
+			return sendEmailConflict(c)
+		}
+	}
+
+	var client PerfilCliente
+	err = tx.QueryRow(ctx, `
+		UPDATE clientes SET
+			nome = COALESCE($2, nome),
+			documento = COALESCE($3, documento),
+			email = COALESCE($4, email)
+		WHERE id = $1
+		RETURNING id, nome, documento, email, limite, criado_em`,
+		clientId, profile.Nome, profile.Documento, profile.Email).
+		Scan(&client.ID, &client.Nome, &client.Documento, &client.Email, &client.Limite, &client.CriadoEm)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		if pgErr.ConstraintName == "indice_clientes_email" {
+			return sendEmailConflict(c)
+		}
+		return sendDocumentConflict(c)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(client)
+}
+
+func profileFieldTaken(ctx context.Context, db dbtx, clientId int, condition string, value string) (bool, error) {
+	var taken bool
+	err := db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM clientes WHERE id <> $1 AND "+condition+")",
+		clientId, value).Scan(&taken)
+	return taken, err
+}
+
+func sendDocumentConflict(c fiber.Ctx) error {
+	return sendProblem(c, Problem{
+		Status: fiber.StatusConflict,
+		Codigo: "DOCUMENTO_EM_USO",
+		Detail: "documento já cadastrado para outro cliente",
+	})
+}
+
+func sendEmailConflict(c fiber.Ctx) error {
+	return sendProblem(c, Problem{
+		Status: fiber.StatusConflict,
+		Codigo: "EMAIL_EM_USO",
+		Detail: "email já cadastrado para outro cliente",
+	})
+}
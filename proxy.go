@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// proxy distribui as requisições entre as instâncias de PROXY_UPSTREAMS
+// (host:porta separados por vírgula) em round-robin, no lugar do nginx. Uma
+// requisição só é reenviada para a instância seguinte se não chegou a sair,
+// porque POST /transacoes não é idempotente. As respostas são repassadas em
+// streaming, então SSE e export funcionam através do proxy.
+func proxy(args []string) error {
+	flags := flag.NewFlagSet("proxy", flag.ExitOnError)
+	addr := flags.String("addr", ":9999", "address to listen on")
+	flags.Parse(args)
+
+	var upstreams []*fasthttp.HostClient
+	var names []string
+	for _, upstream := range strings.Split(getEnv("PROXY_UPSTREAMS", ""), ",") {
+		upstream = strings.TrimSpace(upstream)
+		if upstream == "" {
+			continue
+		}
+		names = append(names, upstream)
+		upstreams = append(upstreams, &fasthttp.HostClient{
+			Addr:                      upstream,
+			MaxConns:                  getEnvInt("PROXY_MAX_CONNS", 512),
+			MaxIdemponentCallAttempts: 1,
+			ReadTimeout:               getEnvDuration("PROXY_READ_TIMEOUT", 0),
+			WriteTimeout:              getEnvDuration("PROXY_WRITE_TIMEOUT", 5*time.Second),
+			MaxConnWaitTimeout:        getEnvDuration("PROXY_CONN_WAIT_TIMEOUT", time.Second),
+			StreamResponseBody:        true,
+			NoDefaultUserAgentHeader:  true,
+			DisablePathNormalizing:    true,
+		})
+	}
+	if len(upstreams) == 0 {
+		return errors.New("PROXY_UPSTREAMS is empty")
+	}
+
+	var next atomic.Uint64
+	handler := func(ctx *fasthttp.RequestCtx) {
+		ctx.Request.Header.Set("X-Forwarded-For", ctx.RemoteIP().String())
+
+		start := next.Add(1)
+		var err error
+		for i := range upstreams {
+			upstream := upstreams[(start+uint64(i))%uint64(len(upstreams))]
+			if err = upstream.Do(&ctx.Request, &ctx.Response); err == nil || !notSent(err) {
+				break
+			}
+		}
+		if err != nil {
+			log.Print("Error proxying request: ", err)
+			ctx.Response.Reset()
+			ctx.Error(fasthttp.StatusMessage(fasthttp.StatusBadGateway), fasthttp.StatusBadGateway)
+		}
+	}
+
+	server := &fasthttp.Server{
+		Handler:           handler,
+		Name:              "rinha-proxy",
+		ReadTimeout:       getEnvDuration("READ_TIMEOUT", 5*time.Second),
+		IdleTimeout:       getEnvDuration("IDLE_TIMEOUT", time.Minute),
+		StreamRequestBody: true,
+		// o limite de corpo fica com as instâncias
+		MaxRequestBodySize: getEnvInt("PROXY_BODY_LIMIT", 64<<20),
+	}
+	log.Printf("Proxying %s to %s", *addr, strings.Join(names, ", "))
+	if err := server.ListenAndServe(*addr); err != nil {
+		return fmt.Errorf("proxy: %w", err)
+	}
+	return nil
+}
+
+// notSent informa se o erro aconteceu antes de a requisição ser enviada à
+// instância, quando é seguro tentar a próxima
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, fasthttp.ErrNoFreeConns) ||
+		errors.Is(err, fasthttp.ErrDialTimeout) ||
+		errors.As(err, &opErr) && opErr.Op == "dial"
+}
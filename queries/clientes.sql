@@ -0,0 +1,46 @@
+-- name: GetBalance :one
+SELECT saldo, limite FROM clientes WHERE id = $1;
+
+-- name: GetLedgerBalance :one
+-- saldo no modo eventsourcing: o saldo projetado mais o efeito das
+-- transações que o projetor ainda não aplicou, no mesmo snapshot
+SELECT (c.saldo + COALESCE((
+	SELECT SUM(CASE WHEN t.tipo = 'c' THEN t.valor ELSE -t.valor END)
+	FROM transacoes t
+	WHERE t.cliente_id = c.id AND t.id > c.projetado_ate), 0))::bigint AS saldo,
+	c.limite
+FROM clientes c WHERE c.id = $1;
+
+-- name: GetReservedBalance :one
+SELECT saldo, limite, reservado FROM clientes WHERE id = $1;
+
+-- name: LockReservedBalance :one
+SELECT saldo, limite, reservado FROM clientes WHERE id = $1 FOR UPDATE;
+
+-- name: GetVersionedBalance :one
+SELECT saldo, limite, reservado, versao FROM clientes WHERE id = $1;
+
+-- name: SetVersionedBalance :one
+-- a escrita do modo optimistic: só acontece se ninguém mudou o cliente
+-- desde a leitura da versão
+UPDATE clientes SET saldo = @saldo, versao = versao + 1, ultima_seq = ultima_seq + 1
+WHERE id = @id AND versao = @versao
+RETURNING ultima_seq;
+
+-- name: ApplyBalanceDelta :one
+-- a escrita relativa de applyAndRecord, que refaz a conferência do limite
+UPDATE clientes SET saldo = saldo + @delta, ultima_seq = ultima_seq + 1
+WHERE id = @id AND saldo + @delta >= reservado - limite
+RETURNING saldo, ultima_seq;
+
+-- name: LockClientAdvisory :exec
+-- o schema entra na chave porque os TENANTS dividem o mesmo banco
+SELECT pg_advisory_xact_lock(hashtext(current_schema()), @cliente_id::integer);
+
+-- name: SkipReconcileTrigger :exec
+-- faz o gatilho de reconciliação ignorar os inserts da transação atual
+SELECT set_config('rinha.saldo_aplicado', 'on', true);
+
+-- name: NextSeq :one
+-- a posição no extrato do modo eventsourcing, cujo saldo fica com o projetor
+UPDATE clientes SET ultima_seq = ultima_seq + 1 WHERE id = $1 RETURNING ultima_seq;
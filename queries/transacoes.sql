@@ -0,0 +1,72 @@
+-- name: InsertTransaction :one
+-- o gatilho reconcile_amount_trigger atualiza o saldo e levanta P0001
+-- quando o débito excede o limite
+INSERT INTO transacoes
+(valor, tipo, descricao, cliente_id, categoria, fraude_decisao, fraude_regra, metadata)
+VALUES (@valor, @tipo, @descricao, @cliente_id, NULLIF(@categoria::text, ''),
+	NULLIF(@fraude_decisao::text, ''), NULLIF(@fraude_regra::text, ''), @metadata)
+RETURNING id;
+
+-- name: InsertLedgerEntry :one
+-- a transação de uma estratégia que já atualizou o saldo, com o gatilho
+-- desligado por SkipReconcileTrigger
+INSERT INTO transacoes
+(valor, tipo, descricao, cliente_id, categoria, saldo_apos, seq, fraude_decisao, fraude_regra, metadata)
+VALUES (@valor, @tipo, @descricao, @cliente_id, NULLIF(@categoria::text, ''), @saldo_apos::bigint, @seq::bigint,
+	NULLIF(@fraude_decisao::text, ''), NULLIF(@fraude_regra::text, ''), @metadata)
+RETURNING id;
+
+-- name: InsertTransactionCTE :one
+-- o modo cte: o UPDATE só atinge o cliente se o limite permitir, e o INSERT
+-- é alimentado pela linha que ele devolveu
+WITH aplicado AS (
+	SELECT set_config('rinha.saldo_aplicado', 'on', true)
+), atualizado AS (
+	UPDATE clientes SET saldo = saldo + @delta, ultima_seq = ultima_seq + 1
+	FROM aplicado
+	WHERE clientes.id = @cliente_id AND saldo + @delta >= reservado - limite
+	RETURNING saldo, limite, ultima_seq
+), inserido AS (
+	INSERT INTO transacoes
+	(valor, tipo, descricao, cliente_id, categoria, saldo_apos, seq, fraude_decisao, fraude_regra, metadata)
+	SELECT @valor, @tipo, @descricao, @cliente_id, NULLIF(@categoria::text, ''), saldo, ultima_seq,
+		NULLIF(@fraude_decisao::text, ''), NULLIF(@fraude_regra::text, ''), @metadata::jsonb
+	FROM atualizado
+	RETURNING id
+)
+SELECT inserido.id, atualizado.saldo, atualizado.limite FROM inserido, atualizado;
+
+-- name: InsertTransactionCheck :one
+-- o modo check: o InsertTransactionCTE sem a condição do limite, que fica a
+-- cargo de saldo_dentro_do_limite
+WITH aplicado AS (
+	SELECT set_config('rinha.saldo_aplicado', 'on', true)
+), atualizado AS (
+	UPDATE clientes SET saldo = saldo + @delta, ultima_seq = ultima_seq + 1
+	FROM aplicado
+	WHERE clientes.id = @cliente_id
+	RETURNING saldo, limite, ultima_seq
+), inserido AS (
+	INSERT INTO transacoes
+	(valor, tipo, descricao, cliente_id, categoria, saldo_apos, seq, fraude_decisao, fraude_regra, metadata)
+	SELECT @valor, @tipo, @descricao, @cliente_id, NULLIF(@categoria::text, ''), saldo, ultima_seq,
+		NULLIF(@fraude_decisao::text, ''), NULLIF(@fraude_regra::text, ''), @metadata::jsonb
+	FROM atualizado
+	RETURNING id
+)
+SELECT inserido.id, atualizado.saldo, atualizado.limite FROM inserido, atualizado;
+
+-- name: ListRecentTransactions :many
+-- o extrato sem filtros, o caminho quente
+SELECT id, valor, tipo, descricao, categoria, realizada_em, saldo_apos, seq, estorno_de, valor_estornado, metadata
+FROM transacoes WHERE cliente_id = $1 ORDER BY realizada_em DESC, seq DESC LIMIT 10;
+
+-- name: GetTransaction :one
+SELECT id, valor, tipo, descricao, categoria, realizada_em, saldo_apos, seq, estorno_de, valor_estornado, metadata
+FROM transacoes WHERE cliente_id = $1 AND id = $2;
+
+-- name: SumTransactionsAfter :one
+-- efeito das transações posteriores, para o saldo das transações gravadas
+-- antes da coluna saldo_apos
+SELECT COALESCE(SUM(CASE WHEN tipo = 'c' THEN valor ELSE -valor END), 0)::bigint
+FROM transacoes WHERE cliente_id = $1 AND id > $2;
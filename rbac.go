@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Papéis das rotas administrativas: leitura só consulta (GET), operador
+// também faz as operações do dia a dia (bloquear clientes, reconciliar,
+// reenviar notificações) e admin pode tudo, inclusive o que mexe na
+// configuração ou nos dados em massa, marcado nas rotas com
+// requireRole(roleAdmin). A credencial vai no Authorization: Bearer e pode
+// ser:
+//
+//   - ADMIN_TOKEN: o token de sempre, com papel admin
+//   - ADMIN_CHAVES: chaves de API com papel, "chave:papel" separadas por
+//     vírgula (ex.: "k1:operador,k2:leitura")
+//   - um JWT HS256 assinado com ADMIN_JWT_SEGREDO, com o papel na claim
+//     papel; exp, se houver, é respeitada
+//
+// As recusas são registradas no log com a rota, o papel e o papel exigido,
+// nunca com a credencial.
+
+type role int
+
+const (
+	roleNone role = iota
+	roleReadOnly
+	roleOperator
+	roleAdmin
+)
+
+func (r role) String() string {
+	switch r {
+	case roleReadOnly:
+		return "leitura"
+	case roleOperator:
+		return "operador"
+	case roleAdmin:
+		return "admin"
+	}
+	return "nenhum"
+}
+
+func parseRole(name string) role {
+	switch strings.TrimSpace(name) {
+	case "leitura":
+		return roleReadOnly
+	case "operador":
+		return roleOperator
+	case "admin":
+		return roleAdmin
+	}
+	return roleNone
+}
+
+// adminKeys são as chaves de ADMIN_CHAVES, relidas a cada reload
+var adminKeys atomic.Pointer[map[string]role]
+
+func init() {
+	onReload(func() {
+		keys := map[string]role{}
+		for _, entry := range strings.Split(getEnv("ADMIN_CHAVES", ""), ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			key, name, _ := strings.Cut(entry, ":")
+			if r := parseRole(name); r != roleNone && key != "" {
+				keys[key] = r
+			} else {
+				log.Printf("Invalid ADMIN_CHAVES entry with role %q, ignoring", name)
+			}
+		}
+		adminKeys.Store(&keys)
+	})
+}
+
+type roleKey struct{}
+
+// credentialRole devolve o papel da credencial, ou roleNone se ela não vale;
+// o exp dos JWTs é comparado com o relógio da App de ctx
+func credentialRole(ctx context.Context, credential string) role {
+	if token := getEnv("ADMIN_TOKEN", ""); token != "" &&
+		subtle.ConstantTimeCompare([]byte(credential), []byte(token)) == 1 {
+		return roleAdmin
+	}
+	for key, r := range *adminKeys.Load() {
+		if subtle.ConstantTimeCompare([]byte(credential), []byte(key)) == 1 {
+			return r
+		}
+	}
+	if secret := getEnv("ADMIN_JWT_SEGREDO", ""); secret != "" && strings.Count(credential, ".") == 2 {
+		return jwtRole(credential, []byte(secret), nowFor(ctx))
+	}
+	return roleNone
+}
+
+// jwtRole valida um JWT HS256 e devolve o papel da claim papel
+func jwtRole(token string, secret []byte, now time.Time) role {
+	header, rest, _ := strings.Cut(token, ".")
+	payload, signature, _ := strings.Cut(rest, ".")
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(header + "." + payload))
+	provided, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(provided, mac.Sum(nil)) {
+		return roleNone
+	}
+
+	var decodedHeader struct {
+		Alg string `json:"alg"`
+	}
+	if data, err := base64.RawURLEncoding.DecodeString(header); err != nil || jsonUnmarshal(data, &decodedHeader) != nil || decodedHeader.Alg != "HS256" {
+		return roleNone
+	}
+	var claims struct {
+		Papel string `json:"papel"`
+		Exp   *int64 `json:"exp"`
+	}
+	if data, err := base64.RawURLEncoding.DecodeString(payload); err != nil || jsonUnmarshal(data, &claims) != nil {
+		return roleNone
+	}
+	if claims.Exp != nil && now.Unix() >= *claims.Exp {
+		return roleNone
+	}
+	return parseRole(claims.Papel)
+}
+
+// methodRole é o papel mínimo de uma rota administrativa pelo método
+func methodRole(method string) role {
+	if method == fiber.MethodGet || method == fiber.MethodHead {
+		return roleReadOnly
+	}
+	return roleOperator
+}
+
+// requireRole exige ao menos o papel required nas rotas em que é usado,
+// depois de adminAuth
+func requireRole(required role) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if current, _ := c.Locals(roleKey{}).(role); current < required {
+			return denyRole(c, current, required)
+		}
+		return c.Next()
+	}
+}
+
+func denyRole(c fiber.Ctx, current, required role) error {
+	log.Printf("Admin access denied: %s %s with role %s, requires %s", c.Method(), c.Path(), current, required)
+	return c.SendStatus(fiber.StatusForbidden)
+}
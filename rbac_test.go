@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// signJWT monta um JWT com o header e as claims dados, assinado com
+// HMAC-SHA256 e secret
+func signJWT(header, claims string, secret []byte) string {
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTRole(t *testing.T) {
+	secret := []byte("segredo")
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+
+	tests := []struct {
+		name  string
+		token string
+		want  role
+	}{
+		{"papel válido", signJWT(hs256, `{"papel":"operador"}`, secret), roleOperator},
+		{"exp no futuro", signJWT(hs256, `{"papel":"admin","exp":1709294401}`, secret), roleAdmin},
+		{"exp vencido", signJWT(hs256, `{"papel":"admin","exp":1709294400}`, secret), roleNone},
+		{"assinatura de outro segredo", signJWT(hs256, `{"papel":"admin"}`, []byte("outro")), roleNone},
+		{"assinatura inválida", signJWT(hs256, `{"papel":"admin"}`, secret) + "x", roleNone},
+		{"alg none", signJWT(`{"alg":"none"}`, `{"papel":"admin"}`, secret), roleNone},
+		{"alg HS512", signJWT(`{"alg":"HS512"}`, `{"papel":"admin"}`, secret), roleNone},
+		{"papel desconhecido", signJWT(hs256, `{"papel":"root"}`, secret), roleNone},
+		{"sem papel", signJWT(hs256, `{}`, secret), roleNone},
+		{"claims malformadas", signJWT(hs256, `{"papel":`, secret), roleNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jwtRole(tt.token, secret, now); got != tt.want {
+				t.Errorf("jwtRole = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAdminRoles passa as credenciais de cada papel pelo adminAuth e pelo
+// requireRole, com o exp do JWT comparado ao relógio da App
+func TestAdminRoles(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "")
+	t.Setenv("ADMIN_JWT_SEGREDO", "segredo")
+	previous := adminKeys.Load()
+	adminKeys.Store(&map[string]role{"k-leitura": roleReadOnly, "k-operador": roleOperator, "k-admin": roleAdmin})
+	t.Cleanup(func() { adminKeys.Store(previous) })
+
+	clock := &fakeClock{now: time.Unix(1709294400, 0)}
+	a := &App{clock: clock}
+	app := fiber.New()
+	app.Use(a.contextMiddleware)
+	admin := app.Group("/admin", adminAuth)
+	ok := func(c fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) }
+	admin.Get("/consulta", ok)
+	admin.Post("/operacao", ok)
+	admin.Post("/config", ok, requireRole(roleAdmin))
+
+	expiring := signJWT(`{"alg":"HS256"}`, `{"papel":"admin","exp":1709294460}`, []byte("segredo"))
+	tests := []struct {
+		name       string
+		credential string
+		method     string
+		path       string
+		// now é o instante do relógio da App; zero mantém o anterior
+		now    time.Time
+		status int
+	}{
+		{"sem credencial", "", "GET", "/admin/consulta", time.Time{}, fiber.StatusUnauthorized},
+		{"credencial desconhecida", "k-outra", "GET", "/admin/consulta", time.Time{}, fiber.StatusUnauthorized},
+		{"leitura consulta", "k-leitura", "GET", "/admin/consulta", time.Time{}, fiber.StatusNoContent},
+		{"leitura tenta POST", "k-leitura", "POST", "/admin/operacao", time.Time{}, fiber.StatusForbidden},
+		{"operador faz POST", "k-operador", "POST", "/admin/operacao", time.Time{}, fiber.StatusNoContent},
+		{"operador tenta rota admin", "k-operador", "POST", "/admin/config", time.Time{}, fiber.StatusForbidden},
+		{"admin na rota admin", "k-admin", "POST", "/admin/config", time.Time{}, fiber.StatusNoContent},
+		{"JWT antes do exp", expiring, "POST", "/admin/config", time.Unix(1709294459, 0), fiber.StatusNoContent},
+		{"JWT depois do exp", expiring, "POST", "/admin/config", time.Unix(1709294460, 0), fiber.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.now.IsZero() {
+				clock.Set(tt.now)
+			}
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.credential != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.credential)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+var (
+	reconciliationRuns          = expvar.NewInt("reconciliation_runs")
+	reconciliationDiscrepancies = expvar.NewInt("reconciliation_discrepancies")
+	reconciliationCorrections   = expvar.NewInt("reconciliation_corrections")
+)
+
+// Divergencia representa um cliente cujo saldo registrado difere do saldo
+// recalculado a partir das transações
+type Divergencia struct {
+	ClienteID       int      `json:"cliente_id"`
+	SaldoRegistrado Centavos `json:"saldo_registrado"`
+	SaldoCalculado  Centavos `json:"saldo_calculado"`
+	Diferenca       Centavos `json:"diferenca"`
+	Corrigida       bool     `json:"corrigida"`
+}
+
+// Reconciliacao representa o resultado de uma verificação dos saldos
+type Reconciliacao struct {
+	ExecutadaEm         time.Time     `json:"executada_em"`
+	ClientesVerificados int           `json:"clientes_verificados"`
+	Divergencias        []Divergencia `json:"divergencias"`
+}
+
+// reconcileBalances recalcula o saldo de cada cliente como saldo_arquivado
+// mais a soma das transações em transacoes, lendo tudo no mesmo snapshot.
+// Com fix, cada divergência é corrigida em uma transação própria que
+// bloqueia o cliente e recalcula o saldo antes de gravá-lo, para não
+// sobrescrever escritas concorrentes à verificação.
+func reconcileBalances(ctx context.Context, fix bool) (Reconciliacao, error) {
+	report := Reconciliacao{ExecutadaEm: nowFor(ctx), Divergencias: []Divergencia{}}
+
+	// no modo eventsourcing o saldo só cobre as transações já projetadas
+	join := "t.cliente_id = c.id"
+	if concurrencyMode == concurrencyEventSourcing {
+		join += " AND t.id <= c.projetado_ate"
+	}
+	rows, err := poolFor(ctx).Query(ctx, `
+		SELECT c.id, c.saldo,
+			c.saldo_arquivado + COALESCE(SUM(CASE WHEN t.tipo = 'c' THEN t.valor ELSE -t.valor END), 0)
+		FROM clientes c
+		LEFT JOIN transacoes t ON `+join+`
+		GROUP BY c.id
+		ORDER BY c.id`)
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		var d Divergencia
+		if err := rows.Scan(&d.ClienteID, &d.SaldoRegistrado, &d.SaldoCalculado); err != nil {
+			rows.Close()
+			return report, err
+		}
+		report.ClientesVerificados++
+		if d.SaldoRegistrado != d.SaldoCalculado {
+			d.Diferenca = d.SaldoRegistrado - d.SaldoCalculado
+			report.Divergencias = append(report.Divergencias, d)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	reconciliationRuns.Add(1)
+	reconciliationDiscrepancies.Add(int64(len(report.Divergencias)))
+	for i := range report.Divergencias {
+		d := &report.Divergencias[i]
+		log.Printf("Balance drift for client %d: registered %s, computed %s",
+			d.ClienteID, d.SaldoRegistrado, d.SaldoCalculado)
+		if !fix {
+			continue
+		}
+		if concurrencyMode == concurrencyEventSourcing {
+			err = rebuildClientProjection(ctx, d.ClienteID)
+			d.Corrigida = err == nil
+		} else {
+			err = fixBalance(ctx, d)
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func fixBalance(ctx context.Context, d *Divergencia) error {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var registered Centavos
+	err = tx.QueryRow(ctx, "SELECT saldo FROM clientes WHERE id = $1 FOR UPDATE", d.ClienteID).
+		Scan(&registered)
+	if err != nil {
+		return err
+	}
+
+	var computed Centavos
+	err = tx.QueryRow(ctx, `
+		UPDATE clientes c SET
+			saldo = c.saldo_arquivado + COALESCE((
+				SELECT SUM(CASE WHEN tipo = 'c' THEN valor ELSE -valor END)
+				FROM transacoes WHERE cliente_id = c.id), 0),
+			versao = versao + 1
+		WHERE id = $1
+		RETURNING saldo`, d.ClienteID).Scan(&computed)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	d.Corrigida = registered != computed
+	if d.Corrigida {
+		reconciliationCorrections.Add(1)
+		log.Printf("Corrected balance of client %d from %s to %s", d.ClienteID, registered, computed)
+	}
+	return nil
+}
+
+// runReconciler verifica os saldos periodicamente (RECONCILIACAO_INTERVALO),
+// corrigindo as divergências quando RECONCILIACAO_CORRIGIR estiver ativo.
+func runReconciler(ctx context.Context, interval time.Duration, fix bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := reconcileBalances(ctx, fix); err != nil && !errors.Is(err, context.Canceled) {
+				log.Print("Error reconciling balances: ", err)
+			}
+		}
+	}
+}
+
+// handleReconciliation verifica os saldos sob demanda; o GET só reporta as
+// divergências e o POST também as corrige.
+func handleReconciliation(c fiber.Ctx) error {
+	report, err := reconcileBalances(c.UserContext(), c.Method() == fiber.MethodPost)
+	if err != nil {
+		log.Print("Error reconciling balances: ", err)
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(report)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+var panics = expvar.NewInt("panics")
+
+// recoverMiddleware transforma um panic no handler em 500, registrando a
+// stack no log e no Sentry, em vez de deixar o fasthttp derrubar a conexão. Os writers
+// de corpo em streaming (SSE, export) rodam depois do handler e não são
+// cobertos.
+func recoverMiddleware(c fiber.Ctx) (err error) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		panics.Add(1)
+		log.Printf("Panic handling %s %s: %v\n%s", c.Method(), c.OriginalURL(), recovered, debug.Stack())
+		reportPanic(c, recovered)
+		c.Response().ResetBody()
+		err = sendProblem(c, Problem{Status: fiber.StatusInternalServerError})
+	}()
+	return c.Next()
+}
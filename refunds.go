@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrEstornoCredito      = errors.New("só débitos podem ser estornados")
+	ErrEstornoDuplicado    = errors.New("transação já estornada por inteiro")
+	ErrEstornoAcimaDoValor = errors.New("estorno maior que o valor ainda não estornado")
+)
+
+// EstornoRequest é o corpo opcional do estorno; sem valor, estorna tudo o
+// que ainda não foi estornado
+type EstornoRequest struct {
+	Valor Centavos `json:"valor" validate:"omitempty,gt=0"`
+}
+
+// Estorno é o crédito criado para compensar um débito
+type Estorno struct {
+	ID        int64    `json:"id"`
+	EstornoDe int64    `json:"estorno_de"`
+	Valor     Centavos `json:"valor"`
+	Saldo     Centavos `json:"saldo"`
+	Limite    Centavos `json:"limite"`
+}
+
+// handleRefundTransaction estorna um débito, total ou parcialmente, com um
+// crédito que guarda em estorno_de o débito original. O débito original
+// acumula o valor estornado em valor_estornado, e o extrato mostra os dois
+// campos para ligar o par.
+func handleRefundTransaction(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	transactionId, err := strconv.ParseInt(c.Params("tx_id"), 10, 64)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	request := new(EstornoRequest)
+	if len(c.Body()) > 0 {
+		if err := bindJSON(c, request); err != nil {
+			return sendBindError(c, err)
+		}
+	}
+
+	ctx := c.UserContext()
+	refund, err := refundTransaction(ctx, clientId, transactionId, request.Valor)
+	statements.Invalidate(statementClient{tenant: tenantFrom(ctx), clientId: clientId})
+	switch {
+	case errors.Is(err, ErrTransacaoNaoEncontrada):
+		return sendProblem(c, Problem{
+			Status: fiber.StatusNotFound,
+			Codigo: "TRANSACAO_NAO_ENCONTRADA",
+			Detail: err.Error(),
+		})
+	case errors.Is(err, ErrEstornoDuplicado):
+		return sendProblem(c, Problem{
+			Status: fiber.StatusConflict,
+			Codigo: "ESTORNO_DUPLICADO",
+			Detail: err.Error(),
+		})
+	case errors.Is(err, ErrEstornoCredito), errors.Is(err, ErrEstornoAcimaDoValor):
+		return sendProblem(c, Problem{
+			Status: fiber.StatusUnprocessableEntity,
+			Codigo: "ESTORNO_INVALIDO",
+			Detail: err.Error(),
+		})
+	case errors.Is(err, ErrClienteBloqueado):
+		return sendProblem(c, Problem{
+			Status: fiber.StatusForbidden,
+			Codigo: "CLIENTE_BLOQUEADO",
+			Detail: err.Error(),
+		})
+	case err != nil:
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.Status(fiber.StatusCreated).JSON(refund)
+}
+
+// refundTransaction bloqueia o débito original com FOR UPDATE, para que
+// estornos concorrentes do mesmo débito não passem juntos do valor dele
+func refundTransaction(ctx context.Context, clientId int, transactionId int64, amount Centavos) (Estorno, error) {
+	refund := Estorno{EstornoDe: transactionId}
+
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return refund, err
+	}
+	defer tx.Rollback(ctx)
+
+	var original struct {
+		valor, estornado Centavos
+		tipo, categoria  string
+	}
+	err = tx.QueryRow(ctx, `
+		SELECT valor, tipo, COALESCE(categoria, ''), valor_estornado
+		FROM transacoes WHERE cliente_id = $1 AND id = $2
+		FOR UPDATE`, clientId, transactionId).
+		Scan(&original.valor, &original.tipo, &original.categoria, &original.estornado)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return refund, ErrTransacaoNaoEncontrada
+	}
+	if err != nil {
+		return refund, err
+	}
+	if original.tipo != "d" {
+		return refund, ErrEstornoCredito
+	}
+	remaining := original.valor - original.estornado
+	if remaining <= 0 {
+		return refund, ErrEstornoDuplicado
+	}
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount > remaining {
+		return refund, ErrEstornoAcimaDoValor
+	}
+
+	credit := &TransacaoRequest{
+		Valor:     amount,
+		Tipo:      "c",
+		Descricao: "estorno",
+		Categoria: original.categoria,
+	}
+	balance, err := createTransaction(ctx, tx, clientId, credit)
+	if err != nil {
+		return refund, err
+	}
+	_, err = tx.Exec(ctx, "UPDATE transacoes SET estorno_de = $2 WHERE id = $1", credit.ID, transactionId)
+	if err != nil {
+		return refund, err
+	}
+	_, err = tx.Exec(ctx, "UPDATE transacoes SET valor_estornado = valor_estornado + $2 WHERE id = $1", transactionId, amount)
+	if err != nil {
+		return refund, err
+	}
+
+	refund.ID = credit.ID
+	refund.Valor = amount
+	refund.Saldo = balance.Saldo
+	refund.Limite = balance.Limite
+	if err := tx.Commit(ctx); err != nil {
+		return refund, err
+	}
+	forgetCachedBalance(ctx, clientId)
+	return refund, nil
+}
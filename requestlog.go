@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// requestLogEnabled instala requestLogMiddleware (REQUEST_LOG_ENABLED). Com
+// ele, ACCESS_LOG_TAXA (entre 0 e 1) é a fração das requisições registradas
+// no log e toda requisição mais lenta que LENTAS_LIMIAR é guardada por
+// inteiro, com cabeçalhos e corpos, nas últimas LENTAS_CAPACIDADE posições
+// de /debug/slow no servidor de diagnóstico. As duas configurações são
+// recarregáveis.
+var requestLogEnabled = getEnvBool("REQUEST_LOG_ENABLED", false)
+
+type requestLogConfig struct {
+	sampleRate    float64
+	slowThreshold time.Duration
+}
+
+var requestLog atomic.Pointer[requestLogConfig]
+
+func init() {
+	onReload(func() {
+		requestLog.Store(&requestLogConfig{
+			sampleRate:    getEnvFloat("ACCESS_LOG_TAXA", 0),
+			slowThreshold: getEnvDuration("LENTAS_LIMIAR", 0),
+		})
+	})
+}
+
+// slowBodyLimit limita os corpos guardados de cada requisição lenta
+const slowBodyLimit = 4 << 10
+
+// redactedHeaders não são guardados nas requisições lentas
+var redactedHeaders = map[string]bool{
+	fiber.HeaderAuthorization: true,
+	fiber.HeaderCookie:        true,
+	fiber.HeaderSetCookie:     true,
+}
+
+// RequisicaoLenta é uma requisição capturada por ter passado de LENTAS_LIMIAR
+type RequisicaoLenta struct {
+	Inicio               time.Time         `json:"inicio"`
+	DuracaoMs            float64           `json:"duracao_ms"`
+	Metodo               string            `json:"metodo"`
+	URI                  string            `json:"uri"`
+	Status               int               `json:"status"`
+	CabecalhosRequisicao map[string]string `json:"cabecalhos_requisicao"`
+	CorpoRequisicao      string            `json:"corpo_requisicao,omitempty"`
+	CabecalhosResposta   map[string]string `json:"cabecalhos_resposta"`
+	CorpoResposta        string            `json:"corpo_resposta,omitempty"`
+	Erro                 string            `json:"erro,omitempty"`
+}
+
+type slowRing struct {
+	mu    sync.Mutex
+	items []RequisicaoLenta
+	next  int
+}
+
+var slowRequests = &slowRing{items: make([]RequisicaoLenta, 0, max(getEnvInt("LENTAS_CAPACIDADE", 100), 1))}
+
+func (r *slowRing) Add(request RequisicaoLenta) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.items) < cap(r.items) {
+		r.items = append(r.items, request)
+		return
+	}
+	r.items[r.next] = request
+	r.next = (r.next + 1) % len(r.items)
+}
+
+// Snapshot devolve as requisições guardadas, da mais recente para a mais antiga
+func (r *slowRing) Snapshot() []RequisicaoLenta {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshot := make([]RequisicaoLenta, 0, len(r.items))
+	for i := len(r.items) - 1; i >= 0; i-- {
+		snapshot = append(snapshot, r.items[(r.next+i)%len(r.items)])
+	}
+	return snapshot
+}
+
+// requestLogMiddleware fica depois de methodMiddleware e devolve o erro do
+// handler sem tratá-lo, para não esconder o 405 do roteador; o status de uma
+// requisição com erro é o do fiber.Error.
+func requestLogMiddleware(c fiber.Ctx) error {
+	config := requestLog.Load()
+	if config.sampleRate <= 0 && config.slowThreshold <= 0 {
+		return c.Next()
+	}
+
+	start := time.Now()
+	err := c.Next()
+	elapsed := time.Since(start)
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		status = fiber.StatusInternalServerError
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			status = fiberErr.Code
+		}
+	}
+
+	if config.sampleRate > 0 && rand.Float64() < config.sampleRate {
+		log.Printf("%s %s %d %s %dB %s", c.Method(), c.OriginalURL(), status,
+			elapsed.Round(time.Microsecond), len(c.Response().Body()), c.IP())
+	}
+	if config.slowThreshold > 0 && elapsed >= config.slowThreshold {
+		slowRequests.Add(captureRequest(c, start, elapsed, status, err))
+	}
+	return err
+}
+
+// captureRequest copia os dados da requisição, já que os buffers do
+// fasthttp são reaproveitados depois que o handler retorna
+func captureRequest(c fiber.Ctx, start time.Time, elapsed time.Duration, status int, err error) RequisicaoLenta {
+	request := RequisicaoLenta{
+		Inicio:               start.UTC(),
+		DuracaoMs:            float64(elapsed.Microseconds()) / 1000,
+		Metodo:               strings.Clone(c.Method()),
+		URI:                  strings.Clone(c.OriginalURL()),
+		Status:               status,
+		CabecalhosRequisicao: map[string]string{},
+		CabecalhosResposta:   map[string]string{},
+	}
+	if err != nil {
+		request.Erro = err.Error()
+	}
+
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		if !redactedHeaders[string(key)] {
+			request.CabecalhosRequisicao[string(key)] = string(value)
+		}
+	})
+	c.Response().Header.VisitAll(func(key, value []byte) {
+		if !redactedHeaders[string(key)] {
+			request.CabecalhosResposta[string(key)] = string(value)
+		}
+	})
+	if !c.Request().IsBodyStream() {
+		request.CorpoRequisicao = truncateBody(c.Request().Body())
+	}
+	// respostas em streaming (SSE, export) não têm o corpo em memória
+	if !c.Response().IsBodyStream() {
+		request.CorpoResposta = truncateBody(c.Response().Body())
+	}
+	return request
+}
+
+func truncateBody(body []byte) string {
+	if len(body) > slowBodyLimit {
+		return string(body[:slowBodyLimit]) + "…"
+	}
+	return string(body)
+}
+
+func handleSlowRequests(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(slowRequests.Snapshot()); err != nil {
+		log.Print("Error writing slow requests: ", err)
+	}
+}
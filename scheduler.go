@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// AgendamentoRequest representa a estrutura de dados de uma requisição de transação agendada
+type AgendamentoRequest struct {
+	TransacaoRequest
+	ExecutarEm  *time.Time `json:"executar_em"`
+	Recorrencia string     `json:"recorrencia"`
+}
+
+// Agendamento representa uma transação futura ou recorrente
+type Agendamento struct {
+	ID              int       `json:"id"`
+	Valor           Centavos  `json:"valor"`
+	Tipo            string    `json:"tipo"`
+	Descricao       string    `json:"descricao"`
+	Categoria       string    `json:"categoria,omitempty"`
+	Recorrencia     string    `json:"recorrencia,omitempty"`
+	ProximaExecucao time.Time `json:"proxima_execucao"`
+	Ativo           bool      `json:"ativo"`
+	// ParcelamentoID e Parcela identificam as parcelas de createInstallments
+	ParcelamentoID *int `json:"parcelamento_id,omitempty"`
+	Parcela        *int `json:"parcela,omitempty"`
+}
+
+func handleScheduleTransaction(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	request := new(AgendamentoRequest)
+	if err := bindJSON(c, request); err != nil {
+		return sendBindError(c, err)
+	}
+	if request.Parcelas > 1 {
+		return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+	}
+
+	now := nowFor(c.UserContext())
+	var next time.Time
+	switch {
+	case request.ExecutarEm != nil:
+		next = request.ExecutarEm.UTC()
+		if !next.After(now) {
+			return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+		}
+		if request.Recorrencia != "" {
+			if _, err := parseCron(request.Recorrencia); err != nil {
+				return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+			}
+		}
+	case request.Recorrencia != "":
+		schedule, err := parseCron(request.Recorrencia)
+		if err != nil {
+			return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+		}
+		next = schedule.Next(now)
+		if next.IsZero() {
+			return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+		}
+	default:
+		return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+	}
+
+	response := Agendamento{
+		Valor:           request.Valor,
+		Tipo:            string(request.Tipo),
+		Descricao:       request.Descricao,
+		Categoria:       request.Categoria,
+		Recorrencia:     request.Recorrencia,
+		ProximaExecucao: next,
+		Ativo:           true,
+	}
+	err = poolFor(c.UserContext()).QueryRow(c.UserContext(), `
+		INSERT INTO agendamentos
+		(cliente_id, valor, tipo, descricao, categoria, recorrencia, proxima_execucao)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)
+		RETURNING id`,
+		clientId,
+		request.Valor,
+		request.Tipo,
+		request.Descricao,
+		request.Categoria,
+		request.Recorrencia,
+		next).Scan(&response.ID)
+	if isPgError(err, "23503") {
+		return c.SendStatus(fiber.ErrUnprocessableEntity.Code)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// runScheduler executa periodicamente os agendamentos vencidos. Cada execução
+// é registrada em agendamento_execucoes na mesma transação que insere a
+// transação do cliente, de modo que uma nova tentativa após falha nunca
+// aplica o mesmo débito duas vezes.
+func runScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := executeDueSchedules(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Print("Error executing schedules: ", err)
+			}
+		}
+	}
+}
+
+func executeDueSchedules(ctx context.Context) error {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, cliente_id, valor, tipo, descricao, COALESCE(categoria, ''), COALESCE(recorrencia, ''), proxima_execucao,
+			parcelamento_id, parcela
+		FROM agendamentos
+		WHERE ativo AND proxima_execucao <= $1
+		ORDER BY proxima_execucao
+		LIMIT 100
+		FOR UPDATE SKIP LOCKED`, nowFor(ctx).UTC())
+	if err != nil {
+		return err
+	}
+
+	type dueSchedule struct {
+		Agendamento
+		clientId int
+	}
+	var due []dueSchedule
+	for rows.Next() {
+		var s dueSchedule
+		err = rows.Scan(&s.ID, &s.clientId, &s.Valor, &s.Tipo, &s.Descricao, &s.Categoria, &s.Recorrencia, &s.ProximaExecucao,
+			&s.ParcelamentoID, &s.Parcela)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	type executed struct {
+		clientId    int
+		transaction *TransacaoRequest
+		balance     Balance
+	}
+	var applied []executed
+	for _, s := range due {
+		transaction, balance, err := executeSchedule(ctx, tx, s.Agendamento, s.clientId)
+		if err != nil {
+			return err
+		}
+		if transaction != nil {
+			applied = append(applied, executed{s.clientId, transaction, balance})
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	for _, e := range applied {
+		// a escrita não passou pelo Storage, então o saldo em cache do
+		// Redis também fica velho
+		forgetCachedBalance(ctx, e.clientId)
+		transactionApplied(ctx, e.clientId, e.transaction, e.balance)
+	}
+	return nil
+}
+
+// executeSchedule executa o agendamento s dentro de tx e devolve a
+// transação inserida e o saldo depois dela, ou nil quando a execução já
+// estava registrada ou foi rejeitada
+func executeSchedule(ctx context.Context, tx pgx.Tx, s Agendamento, clientId int) (*TransacaoRequest, Balance, error) {
+	var applied *TransacaoRequest
+	var balance Balance
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO agendamento_execucoes (agendamento_id, agendado_para, status)
+		VALUES ($1, $2, 'pendente')
+		ON CONFLICT DO NOTHING`, s.ID, s.ProximaExecucao)
+	if err != nil {
+		return nil, balance, err
+	}
+
+	if tag.RowsAffected() == 1 {
+		status := "ok"
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return nil, balance, err
+		}
+		transaction := &TransacaoRequest{
+			Valor:     s.Valor,
+			Tipo:      Tipo(s.Tipo),
+			Descricao: s.Descricao,
+			Categoria: s.Categoria,
+		}
+		balance, err = createTransaction(ctx, sp, clientId, transaction)
+		if err == nil && s.ParcelamentoID != nil {
+			err = recordInstallment(ctx, sp, transaction.ID, *s.ParcelamentoID, *s.Parcela)
+		}
+		if err != nil {
+			sp.Rollback(ctx)
+			status = "rejeitada"
+			log.Printf("Schedule %d rejected: %v", s.ID, err)
+		} else if err := sp.Commit(ctx); err != nil {
+			return nil, balance, err
+		}
+		if status == "ok" {
+			applied = transaction
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE agendamento_execucoes SET status = $3
+			WHERE agendamento_id = $1 AND agendado_para = $2`, s.ID, s.ProximaExecucao, status)
+		if err != nil {
+			return nil, balance, err
+		}
+	}
+
+	var next time.Time
+	if s.Recorrencia != "" {
+		schedule, err := parseCron(s.Recorrencia)
+		if err == nil {
+			next = schedule.Next(s.ProximaExecucao)
+		}
+	}
+	if next.IsZero() {
+		_, err = tx.Exec(ctx, "UPDATE agendamentos SET ativo = FALSE WHERE id = $1", s.ID)
+	} else {
+		_, err = tx.Exec(ctx, "UPDATE agendamentos SET proxima_execucao = $2 WHERE id = $1", s.ID, next)
+	}
+	return applied, balance, err
+}
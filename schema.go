@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// jsonSchemaEnabled valida o corpo das requisições contra os JSON Schemas de
+// schemas/ antes do Bind (JSON_SCHEMA_ENABLED). As regras dos structs
+// continuam valendo depois dele.
+var jsonSchemaEnabled atomic.Bool
+
+func init() {
+	onReload(func() {
+		jsonSchemaEnabled.Store(getEnvBool("JSON_SCHEMA_ENABLED", false))
+	})
+}
+
+type schemaError struct {
+	Codigo   string `json:"codigo"`
+	Mensagem string `json:"mensagem"`
+}
+
+// requestSchema é um schema compilado com os códigos e mensagens declarados
+// em x-erros, por palavra-chave, no objeto e em cada propriedade. Só objetos
+// sem aninhamento são suportados.
+type requestSchema struct {
+	schema     *jsonschema.Schema
+	errors     map[string]schemaError
+	properties map[string]map[string]schemaError
+}
+
+var requestSchemas = loadRequestSchemas()
+
+func loadRequestSchemas() map[string]*requestSchema {
+	entries, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	schemas := make(map[string]*requestSchema, len(entries))
+	for _, entry := range entries {
+		data, err := schemaFiles.ReadFile("schemas/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		url := "mem://schemas/" + entry.Name()
+		if err := compiler.AddResource(url, bytes.NewReader(data)); err != nil {
+			panic(err)
+		}
+		compiled, err := compiler.Compile(url)
+		if err != nil {
+			panic(err)
+		}
+
+		var annotations struct {
+			Erros      map[string]schemaError `json:"x-erros"`
+			Properties map[string]struct {
+				Erros map[string]schemaError `json:"x-erros"`
+			} `json:"properties"`
+		}
+		if err := json.Unmarshal(data, &annotations); err != nil {
+			panic(err)
+		}
+		schema := &requestSchema{
+			schema:     compiled,
+			errors:     annotations.Erros,
+			properties: make(map[string]map[string]schemaError, len(annotations.Properties)),
+		}
+		for name, property := range annotations.Properties {
+			schema.properties[name] = property.Erros
+		}
+		schemas[strings.TrimSuffix(entry.Name(), ".json")] = schema
+	}
+	return schemas
+}
+
+// validateSchema valida o corpo contra schemas/<name>.json quando
+// JSON_SCHEMA_ENABLED está ligado, respondendo 422 com todos os campos
+// inválidos.
+func validateSchema(name string) fiber.Handler {
+	schema, ok := requestSchemas[name]
+	if !ok {
+		panic("unknown request schema " + name)
+	}
+
+	return func(c fiber.Ctx) error {
+		if ok, err := schema.check(fiberExchange{c}); !ok {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// check é o validateSchema das duas pilhas: devolve false quando já
+// respondeu com o erro do corpo
+func (s *requestSchema) check(x exchange) (bool, error) {
+	if !jsonSchemaEnabled.Load() {
+		return true, nil
+	}
+	if !isJSONMediaType(x.Header(fiber.HeaderContentType)) {
+		return false, sendProblemTo(x, bindErrorProblem(errTipoConteudo, x.Body()))
+	}
+
+	// números permanecem json.Number, sem perder precisão em float64
+	decoder := json.NewDecoder(bytes.NewReader(x.Body()))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return false, sendProblemTo(x, bindErrorProblem(err, x.Body()))
+	}
+
+	err := s.schema.Validate(document)
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		return false, sendProblemTo(x, fieldErrorsProblem(s.fieldErrors(document, validationErr)))
+	}
+	return err == nil, err
+}
+
+func (s *requestSchema) fieldErrors(document any, err *jsonschema.ValidationError) []ErroCampo {
+	object, _ := document.(map[string]any)
+	var fieldErrors []ErroCampo
+	add := func(field string, declared map[string]schemaError, keyword, message string) {
+		fieldError := ErroCampo{Campo: field, Codigo: "CAMPO_INVALIDO", Mensagem: message}
+		if declared, ok := declared[keyword]; ok {
+			fieldError.Codigo = declared.Codigo
+			fieldError.Mensagem = declared.Mensagem
+		}
+		fieldErrors = append(fieldErrors, fieldError)
+	}
+
+	var walk func(err *jsonschema.ValidationError)
+	walk = func(err *jsonschema.ValidationError) {
+		for _, cause := range err.Causes {
+			walk(cause)
+		}
+		if len(err.Causes) > 0 {
+			return
+		}
+
+		keyword := path.Base(err.KeywordLocation)
+		switch {
+		case keyword == "required":
+			for _, name := range s.schema.Required {
+				if _, ok := object[name]; !ok {
+					add(name, s.properties[name], keyword, name+" é obrigatório")
+				}
+			}
+		case keyword == "additionalProperties":
+			for name := range object {
+				if _, ok := s.schema.Properties[name]; !ok {
+					add(name, s.errors, keyword, err.Message)
+				}
+			}
+		case err.InstanceLocation == "":
+			add("", s.errors, keyword, err.Message)
+		default:
+			field := strings.TrimPrefix(err.InstanceLocation, "/")
+			add(field, s.properties[field], keyword, err.Message)
+		}
+	}
+	walk(err)
+
+	sort.SliceStable(fieldErrors, func(i, j int) bool {
+		return fieldErrors[i].Campo < fieldErrors[j].Campo
+	})
+	return fieldErrors
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Verificação do schema na subida (SCHEMA_VERIFICAR, ligada por padrão): o
+// script.sql embutido é executado num schema temporário, numa transação
+// desfeita, e as colunas (tipo e NOT NULL), os índices e as constraints
+// (CHECK, chaves primárias, únicas e estrangeiras) que ele cria são
+// comparados com os do schema em uso. Uma diferença encerra a subida com um
+// relatório no estilo diff, em vez de erros de Scan no meio do teste; o que
+// o schema em uso tem a mais (partições, índices criados por INDICES_CRIAR)
+// é ignorado. Sem permissão para criar o schema, a verificação só é
+// registrada no log.
+
+// partitionedSchemaDifferences são as diferenças esperadas depois do
+// subcomando partition: a chave primária inclui realizada_em e o CHECK da
+// descrição fica só na partição legada
+var partitionedSchemaDifferences = map[string]bool{
+	"index transacoes_pkey":                            true,
+	"constraint transacoes.transacoes_pkey":            true,
+	"constraint transacoes.transacoes_descricao_check": true,
+}
+
+// checkSchema verifica o schema de cada tenant e encerra o processo se algum
+// diferir do script.sql
+func checkSchema(ctx context.Context) {
+	failed := false
+	for _, ctx := range tenantContexts(ctx) {
+		report, err := verifySchema(ctx)
+		if err != nil {
+			log.Printf("Error verifying schema for tenant %q: %v", tenantFrom(ctx), err)
+			continue
+		}
+		if len(report) > 0 {
+			log.Printf("Schema for tenant %q differs from script.sql (- expected, + found):\n%s",
+				tenantFrom(ctx), strings.Join(report, "\n"))
+			failed = true
+		}
+	}
+	if failed {
+		log.Fatal("Schema verification failed; apply the migrations or set SCHEMA_VERIFICAR=false")
+	}
+}
+
+// verifySchema devolve as linhas do relatório de diferenças, vazio quando o
+// schema em uso tem tudo o que o script.sql cria
+func verifySchema(ctx context.Context) ([]string, error) {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// o schema em uso é lido antes de trocar o search_path, para que as
+	// definições das chaves estrangeiras venham sem o nome do schema
+	var current string
+	if err := tx.QueryRow(ctx, "SELECT current_schema()").Scan(&current); err != nil {
+		return nil, err
+	}
+	found, err := describeSchema(ctx, tx, current)
+	if err != nil {
+		return nil, err
+	}
+	partitioned, err := isPartitioned(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var expectedSchema string
+	if err := tx.QueryRow(ctx, "SELECT 'rinha_esperado_' || pg_backend_pid()").Scan(&expectedSchema); err != nil {
+		return nil, err
+	}
+	for _, statement := range []string{
+		"CREATE SCHEMA " + expectedSchema,
+		"SET LOCAL search_path TO " + expectedSchema,
+		schemaScript,
+	} {
+		if _, err := tx.Exec(ctx, statement); err != nil {
+			return nil, fmt.Errorf("creating expected schema: %w", err)
+		}
+	}
+	expected, err := describeSchema(ctx, tx, expectedSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var report []string
+	for _, key := range keys {
+		definition, ok := found[key]
+		switch {
+		case ok && definition == expected[key]:
+		case partitioned && partitionedSchemaDifferences[key]:
+		case !ok:
+			report = append(report, "- "+key+": "+expected[key])
+		default:
+			report = append(report, "- "+key+": "+expected[key], "+ "+key+": "+definition)
+		}
+	}
+	return report, nil
+}
+
+// describeSchema lista as colunas, os índices e as constraints do schema,
+// com as definições sem o nome do schema
+func describeSchema(ctx context.Context, tx pgx.Tx, schema string) (map[string]string, error) {
+	description := map[string]string{}
+	queries := []string{
+		`SELECT 'column ' || c.relname || '.' || a.attname,
+			format_type(a.atttypid, a.atttypmod) || CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relkind IN ('r', 'p') AND a.attnum > 0 AND NOT a.attisdropped`,
+		`SELECT 'index ' || indexname, indexdef FROM pg_indexes WHERE schemaname = $1`,
+		`SELECT 'constraint ' || c.relname || '.' || con.conname, pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND con.contype IN ('c', 'f', 'p', 'u')`,
+	}
+	qualified := strings.NewReplacer(" ON ONLY "+schema+".", " ON ", " ON "+schema+".", " ON ")
+	for _, query := range queries {
+		rows, err := tx.Query(ctx, query, schema)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var key, definition string
+			if err := rows.Scan(&key, &definition); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			description[key] = qualified.Replace(definition)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(description) == 0 {
+		return nil, errors.New("schema " + schema + " has no tables")
+	}
+	return description, nil
+}
@@ -3,21 +3,170 @@
 CREATE UNLOGGED TABLE clientes (
 	id SERIAL PRIMARY KEY,
 	nome VARCHAR(50) NOT NULL,
-	limite INTEGER NOT NULL,
-        saldo INTEGER DEFAULT 0
+	-- CPF ou CNPJ só com os dígitos, ver profile.go
+	documento VARCHAR(14),
+	email VARCHAR(254),
+	criado_em TIMESTAMP NOT NULL DEFAULT NOW(),
+	limite BIGINT NOT NULL,
+	saldo BIGINT NOT NULL DEFAULT 0,
+	versao BIGINT NOT NULL DEFAULT 0,
+	saldo_arquivado BIGINT NOT NULL DEFAULT 0,
+	projetado_ate BIGINT NOT NULL DEFAULT 0,
+	bloqueado BOOLEAN NOT NULL DEFAULT FALSE,
+	-- soma das autorizações pendentes, que reduzem o limite disponível
+	reservado BIGINT NOT NULL DEFAULT 0,
+	-- posição da última transação no extrato do cliente, ver transacoes.seq
+	ultima_seq BIGINT NOT NULL DEFAULT 0,
+	-- a regra do limite, descontadas as reservas; o modo check de
+	-- CONCURRENCY_MODE depende só dela para recusar débitos
+	CONSTRAINT saldo_dentro_do_limite CHECK (saldo >= reservado - limite)
+);
+
+CREATE UNLOGGED TABLE categorias (
+	nome VARCHAR(30) PRIMARY KEY,
+	descricao text NOT NULL DEFAULT ''
 );
 
 CREATE UNLOGGED TABLE transacoes (
 	id SERIAL PRIMARY KEY,
 	cliente_id INTEGER NOT NULL,
-	valor INTEGER NOT NULL,
+	valor BIGINT NOT NULL,
 	tipo CHAR(1) NOT NULL,
-	descricao text NOT NULL,
+	-- o mesmo limite em bytes de validDescription (description.go)
+	descricao text NOT NULL CHECK (octet_length(descricao) <= 40),
+	categoria VARCHAR(30),
 	realizada_em TIMESTAMP NOT NULL DEFAULT NOW(),
+	saldo_apos BIGINT,
+	-- estornos (refunds.go): o crédito aponta para o débito, que soma o
+	-- valor já estornado
+	estorno_de INTEGER,
+	valor_estornado BIGINT NOT NULL DEFAULT 0,
+	-- ordem da transação entre as do cliente, atribuída junto com a
+	-- atualização do saldo; desempata transações com o mesmo realizada_em
+	seq BIGINT,
+	-- objeto livre do integrador (metadata.go), filtrável no extrato
+	metadata JSONB,
+	fraude_decisao VARCHAR(10),
+	fraude_regra VARCHAR(50),
 	CONSTRAINT fk_clientes_transacoes_id
+		FOREIGN KEY (cliente_id) REFERENCES clientes(id),
+	CONSTRAINT fk_categorias_transacoes_nome
+		FOREIGN KEY (categoria) REFERENCES categorias(nome) ON UPDATE CASCADE
+);
+
+CREATE UNLOGGED TABLE limites_categoria (
+	cliente_id INTEGER NOT NULL,
+	categoria VARCHAR(30) NOT NULL,
+	limite_suave BIGINT,
+	limite_rigido BIGINT,
+	PRIMARY KEY (cliente_id, categoria),
+	CONSTRAINT fk_clientes_limites_categoria_id
+		FOREIGN KEY (cliente_id) REFERENCES clientes(id),
+	CONSTRAINT fk_categorias_limites_categoria_nome
+		FOREIGN KEY (categoria) REFERENCES categorias(nome) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE UNLOGGED TABLE limites_diarios (
+	cliente_id INTEGER PRIMARY KEY,
+	limite BIGINT NOT NULL CHECK (limite >= 0),
+	CONSTRAINT fk_clientes_limites_diarios_id
+		FOREIGN KEY (cliente_id) REFERENCES clientes(id)
+);
+
+-- total debitado por cliente e dia, somado junto com cada débito de
+-- clientes com limite_diario (daily_limits.go)
+CREATE UNLOGGED TABLE debitos_diarios (
+	cliente_id INTEGER NOT NULL,
+	dia DATE NOT NULL,
+	total BIGINT NOT NULL,
+	PRIMARY KEY (cliente_id, dia),
+	CONSTRAINT fk_clientes_debitos_diarios_id
+		FOREIGN KEY (cliente_id) REFERENCES clientes(id)
+);
+
+CREATE UNLOGGED TABLE alertas_saldo (
+	id SERIAL PRIMARY KEY,
+	cliente_id INTEGER NOT NULL,
+	limiar BIGINT NOT NULL,
+	direcao VARCHAR(6) NOT NULL CHECK (direcao IN ('abaixo', 'acima')),
+	intervalo_segundos INTEGER NOT NULL DEFAULT 900,
+	ultimo_disparo TIMESTAMP,
+	CONSTRAINT fk_clientes_alertas_saldo_id
+		FOREIGN KEY (cliente_id) REFERENCES clientes(id)
+);
+
+CREATE UNLOGGED TABLE agendamentos (
+	id SERIAL PRIMARY KEY,
+	cliente_id INTEGER NOT NULL,
+	valor BIGINT NOT NULL,
+	tipo CHAR(1) NOT NULL,
+	descricao text NOT NULL CHECK (octet_length(descricao) <= 40),
+	categoria VARCHAR(30),
+	recorrencia text,
+	proxima_execucao TIMESTAMP NOT NULL,
+	ativo BOOLEAN NOT NULL DEFAULT TRUE,
+	criado_em TIMESTAMP NOT NULL DEFAULT NOW(),
+	parcelamento_id INTEGER,
+	parcela SMALLINT,
+	CONSTRAINT fk_clientes_agendamentos_id
+		FOREIGN KEY (cliente_id) REFERENCES clientes(id),
+	CONSTRAINT fk_categorias_agendamentos_nome
+		FOREIGN KEY (categoria) REFERENCES categorias(nome) ON UPDATE CASCADE
+);
+
+-- autorizações (authorizations.go): enquanto pendentes, o valor fica em
+-- clientes.reservado
+CREATE UNLOGGED TABLE autorizacoes (
+	id SERIAL PRIMARY KEY,
+	cliente_id INTEGER NOT NULL,
+	valor BIGINT NOT NULL,
+	descricao text NOT NULL CHECK (octet_length(descricao) <= 40),
+	categoria VARCHAR(30),
+	status VARCHAR(10) NOT NULL DEFAULT 'pendente'
+		CHECK (status IN ('pendente', 'capturada', 'cancelada', 'expirada')),
+	criada_em TIMESTAMP NOT NULL DEFAULT NOW(),
+	expira_em TIMESTAMP NOT NULL,
+	encerrada_em TIMESTAMP,
+	valor_capturado BIGINT,
+	transacao_id INTEGER,
+	CONSTRAINT fk_clientes_autorizacoes_id
+		FOREIGN KEY (cliente_id) REFERENCES clientes(id),
+	CONSTRAINT fk_categorias_autorizacoes_nome
+		FOREIGN KEY (categoria) REFERENCES categorias(nome) ON UPDATE CASCADE
+);
+
+-- débitos parcelados: a primeira parcela é transacao_id e as demais são
+-- agendamentos com parcelamento_id
+CREATE UNLOGGED TABLE parcelamentos (
+	id SERIAL PRIMARY KEY,
+	cliente_id INTEGER NOT NULL,
+	transacao_id INTEGER NOT NULL,
+	descricao text NOT NULL,
+	valor_total BIGINT NOT NULL,
+	parcelas SMALLINT NOT NULL,
+	criado_em TIMESTAMP NOT NULL DEFAULT NOW(),
+	CONSTRAINT fk_clientes_parcelamentos_id
 		FOREIGN KEY (cliente_id) REFERENCES clientes(id)
 );
 
+CREATE UNLOGGED TABLE transacoes_parcelas (
+	transacao_id INTEGER PRIMARY KEY,
+	parcelamento_id INTEGER NOT NULL,
+	parcela SMALLINT NOT NULL,
+	CONSTRAINT fk_parcelamentos_transacoes_parcelas_id
+		FOREIGN KEY (parcelamento_id) REFERENCES parcelamentos(id)
+);
+
+CREATE UNLOGGED TABLE agendamento_execucoes (
+	agendamento_id INTEGER NOT NULL,
+	agendado_para TIMESTAMP NOT NULL,
+	status text NOT NULL,
+	executado_em TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (agendamento_id, agendado_para),
+	CONSTRAINT fk_agendamentos_execucoes_id
+		FOREIGN KEY (agendamento_id) REFERENCES agendamentos(id)
+);
+
 DO $$
 BEGIN
 	INSERT INTO clientes (nome, limite)
@@ -31,35 +180,178 @@ BEGIN
 END;
 $$;
 
+CREATE UNLOGGED TABLE outbox (
+	id BIGSERIAL PRIMARY KEY,
+	tipo VARCHAR(50) NOT NULL,
+	cliente_id INTEGER NOT NULL,
+	dados JSONB NOT NULL,
+	criado_em TIMESTAMP NOT NULL DEFAULT NOW(),
+	publicado_em TIMESTAMP
+);
+
+CREATE UNLOGGED TABLE feature_flags (
+	nome VARCHAR(50) PRIMARY KEY,
+	ativo BOOLEAN NOT NULL,
+	atualizado_em TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNLOGGED TABLE bloqueios_descricao (
+	id SERIAL PRIMARY KEY,
+	padrao VARCHAR(200) NOT NULL UNIQUE,
+	-- sem regex, o padrão é um termo comparado palavra a palavra
+	regex BOOLEAN NOT NULL DEFAULT FALSE,
+	criado_em TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNLOGGED TABLE estatisticas_minuto (
+	cliente_id INTEGER NOT NULL,
+	minuto BIGINT NOT NULL,
+	instancia VARCHAR(255) NOT NULL,
+	transacoes BIGINT NOT NULL,
+	debitos BIGINT NOT NULL,
+	soma_debitos BIGINT NOT NULL,
+	maior_debito BIGINT NOT NULL,
+	PRIMARY KEY (cliente_id, minuto, instancia)
+);
+
+-- partidas dobradas (PARTIDAS_DOBRADAS, ver ledger.go): cada transação gera
+-- uma entrada na conta do cliente e outra, de sinal oposto, na conta da casa
+CREATE UNLOGGED TABLE contas (
+	id SERIAL PRIMARY KEY,
+	-- NULL na conta da casa
+	cliente_id INTEGER UNIQUE,
+	nome VARCHAR(50) NOT NULL,
+	CONSTRAINT fk_clientes_contas_id
+		FOREIGN KEY (cliente_id) REFERENCES clientes(id)
+);
+
+INSERT INTO contas (id, nome) VALUES (0, 'casa');
+INSERT INTO contas (cliente_id, nome) SELECT id, 'cliente ' || id FROM clientes;
+
+CREATE UNLOGGED TABLE entradas (
+	id BIGSERIAL PRIMARY KEY,
+	transacao_id INTEGER NOT NULL,
+	conta_id INTEGER NOT NULL,
+	-- positivo aumenta o saldo da conta
+	valor BIGINT NOT NULL,
+	realizada_em TIMESTAMP NOT NULL,
+	CONSTRAINT fk_contas_entradas_id
+		FOREIGN KEY (conta_id) REFERENCES contas(id)
+);
+
+-- extratos mensais (statement_webhooks.go): o fechamento do mês grava o
+-- extrato no blob store e a notificação para o webhook do cliente
+CREATE UNLOGGED TABLE webhooks_extrato (
+	cliente_id INTEGER PRIMARY KEY,
+	url TEXT NOT NULL,
+	segredo VARCHAR(64) NOT NULL,
+	criado_em TIMESTAMP NOT NULL DEFAULT NOW(),
+	CONSTRAINT fk_clientes_webhooks_extrato_id
+		FOREIGN KEY (cliente_id) REFERENCES clientes(id)
+);
+
+CREATE UNLOGGED TABLE extratos_mensais (
+	id SERIAL PRIMARY KEY,
+	cliente_id INTEGER NOT NULL,
+	-- primeiro dia do mês fechado
+	periodo DATE NOT NULL,
+	chave TEXT NOT NULL,
+	criado_em TIMESTAMP NOT NULL DEFAULT NOW(),
+	UNIQUE (cliente_id, periodo),
+	CONSTRAINT fk_clientes_extratos_mensais_id
+		FOREIGN KEY (cliente_id) REFERENCES clientes(id)
+);
+
+CREATE UNLOGGED TABLE notificacoes_extrato (
+	id SERIAL PRIMARY KEY,
+	extrato_id INTEGER NOT NULL,
+	-- pendente, entregue ou falhou
+	status VARCHAR(10) NOT NULL DEFAULT 'pendente',
+	tentativas INTEGER NOT NULL DEFAULT 0,
+	proxima_tentativa TIMESTAMP NOT NULL DEFAULT NOW(),
+	ultimo_erro TEXT,
+	entregue_em TIMESTAMP,
+	CONSTRAINT fk_extratos_mensais_notificacoes_id
+		FOREIGN KEY (extrato_id) REFERENCES extratos_mensais(id)
+);
+
+-- encerramentos de conta (closure.go); o cliente é anonimizado em
+-- anonimizar_em
+CREATE UNLOGGED TABLE encerramentos (
+	cliente_id INTEGER PRIMARY KEY,
+	solicitado_em TIMESTAMP NOT NULL,
+	anonimizar_em TIMESTAMP NOT NULL,
+	anonimizado_em TIMESTAMP,
+	exportacao TEXT,
+	CONSTRAINT fk_clientes_encerramentos_id
+		FOREIGN KEY (cliente_id) REFERENCES clientes(id)
+);
+
 -- criando indices
-CREATE INDEX indice_transacoes_1 ON transacoes (cliente_id, realizada_em DESC) WHERE cliente_id = 1;
-CREATE INDEX indice_transacoes_2 ON transacoes (cliente_id, realizada_em DESC) WHERE cliente_id = 2;
-CREATE INDEX indice_transacoes_3 ON transacoes (cliente_id, realizada_em DESC) WHERE cliente_id = 3;
-CREATE INDEX indice_transacoes_4 ON transacoes (cliente_id, realizada_em DESC) WHERE cliente_id = 4;
-CREATE INDEX indice_transacoes_5 ON transacoes (cliente_id, realizada_em DESC) WHERE cliente_id = 5;
+CREATE INDEX indice_transacoes_1 ON transacoes (cliente_id, realizada_em DESC, seq DESC) WHERE cliente_id = 1;
+CREATE INDEX indice_transacoes_2 ON transacoes (cliente_id, realizada_em DESC, seq DESC) WHERE cliente_id = 2;
+CREATE INDEX indice_transacoes_3 ON transacoes (cliente_id, realizada_em DESC, seq DESC) WHERE cliente_id = 3;
+CREATE INDEX indice_transacoes_4 ON transacoes (cliente_id, realizada_em DESC, seq DESC) WHERE cliente_id = 4;
+CREATE INDEX indice_transacoes_5 ON transacoes (cliente_id, realizada_em DESC, seq DESC) WHERE cliente_id = 5;
+
+CREATE INDEX indice_transacoes_seq ON transacoes (cliente_id, seq) WHERE seq IS NOT NULL;
+CREATE INDEX indice_transacoes_categoria ON transacoes (cliente_id, categoria) WHERE categoria IS NOT NULL;
+CREATE INDEX indice_transacoes_metadata ON transacoes USING GIN (metadata jsonb_path_ops) WHERE metadata IS NOT NULL;
+CREATE INDEX indice_agendamentos_pendentes ON agendamentos (proxima_execucao) WHERE ativo;
+CREATE INDEX indice_outbox_pendentes ON outbox (id) WHERE publicado_em IS NULL;
+CREATE INDEX indice_alertas_saldo_cliente ON alertas_saldo (cliente_id);
+CREATE INDEX indice_notificacoes_extrato_pendentes ON notificacoes_extrato (proxima_tentativa) WHERE status = 'pendente';
+CREATE UNIQUE INDEX indice_clientes_documento ON clientes (documento);
+CREATE UNIQUE INDEX indice_clientes_email ON clientes (lower(email));
+CREATE INDEX indice_clientes_nome ON clientes (lower(nome) text_pattern_ops);
+CREATE INDEX indice_entradas_transacao ON entradas (transacao_id);
+CREATE INDEX indice_entradas_conta ON entradas (conta_id);
+CREATE INDEX indice_autorizacoes_cliente ON autorizacoes (cliente_id, id DESC);
+CREATE INDEX indice_autorizacoes_pendentes ON autorizacoes (expira_em) WHERE status = 'pendente';
+CREATE INDEX indice_parcelamentos_cliente ON parcelamentos (cliente_id);
+CREATE INDEX indice_agendamentos_parcelamento ON agendamentos (parcelamento_id) WHERE parcelamento_id IS NOT NULL;
+CREATE INDEX indice_transacoes_parcelas_parcelamento ON transacoes_parcelas (parcelamento_id);
+CREATE INDEX indice_encerramentos_pendentes ON encerramentos (anonimizar_em) WHERE anonimizado_em IS NULL;
 
 -- criando gatilhos para atualizar o saldo
 CREATE OR REPLACE FUNCTION reconcile_amount_trigger_function()
 RETURNS TRIGGER LANGUAGE plpgsql AS $$
 
 DECLARE
-	oldsaldo INT;
-	oldlimite INT;
+	oldsaldo BIGINT;
+	piso BIGINT;
+	delta BIGINT;
 
 BEGIN
 
-	SELECT saldo, limite INTO oldsaldo, oldlimite
+	-- estratégias de concorrência que atualizam o saldo na aplicação marcam a transação
+	IF current_setting('rinha.saldo_aplicado', true) = 'on' THEN
+		RETURN NEW;
+	END IF;
+
+	-- as autorizações pendentes reduzem o limite disponível; piso é o menor
+	-- saldo que a constraint saldo_dentro_do_limite aceita
+	SELECT saldo, reservado - limite INTO oldsaldo, piso
 	FROM clientes c 
 	WHERE id = NEW.cliente_id;
 
+	-- o gatilho roda antes do insert para gravar saldo_apos, então o sinal
+	-- do débito fica em delta e valor continua positivo
+	delta = NEW.valor;
 	IF NEW.tipo = 'd' and new.valor > 0 THEN
-		NEW.valor = NEW.valor * -1;
-		IF oldsaldo + NEW.valor + oldlimite < 0 THEN
+		delta = NEW.valor * -1;
+		IF oldsaldo + delta < piso THEN
 			RAISE EXCEPTION 'limite excedido';
 		END IF;
 	END IF;
 
-	UPDATE clientes SET saldo = saldo + NEW.valor WHERE id = NEW.cliente_id AND SALDO + NEW.VALOR + oldlimite > 0;
+	UPDATE clientes SET saldo = saldo + delta, ultima_seq = ultima_seq + 1
+	WHERE id = NEW.cliente_id AND saldo + delta >= reservado - limite
+	RETURNING saldo, ultima_seq INTO NEW.saldo_apos, NEW.seq;
+	-- um débito concorrente pode ter consumido o limite depois do SELECT
+	IF NOT FOUND THEN
+		RAISE EXCEPTION 'limite excedido';
+	END IF;
 RETURN NEW;
 
 END;
@@ -67,7 +359,76 @@ END;
 $$;
 
 CREATE TRIGGER reconcile_amount_trigger
-AFTER INSERT ON transacoes
+BEFORE INSERT ON transacoes
 FOR EACH ROW
 EXECUTE FUNCTION reconcile_amount_trigger_function();
 
+-- clientes bloqueados não recebem transações em nenhuma estratégia de
+-- concorrência; o gatilho roda antes de reconcile_amount_trigger pela ordem alfabética
+CREATE OR REPLACE FUNCTION bloqueio_trigger_function()
+RETURNS TRIGGER LANGUAGE plpgsql AS $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM clientes WHERE id = NEW.cliente_id AND bloqueado) THEN
+		RAISE EXCEPTION 'cliente bloqueado' USING ERRCODE = 'RB001';
+	END IF;
+	RETURN NEW;
+END;
+$$;
+
+CREATE TRIGGER bloqueio_trigger
+BEFORE INSERT ON transacoes
+FOR EACH ROW
+EXECUTE FUNCTION bloqueio_trigger_function();
+
+-- as partidas são gravadas depois do insert, em qualquer estratégia de
+-- concorrência, quando a conexão foi aberta com rinha.partidas_dobradas
+CREATE OR REPLACE FUNCTION partidas_trigger_function()
+RETURNS TRIGGER LANGUAGE plpgsql AS $$
+DECLARE
+	conta INTEGER;
+	delta BIGINT;
+BEGIN
+	IF current_setting('rinha.partidas_dobradas', true) IS DISTINCT FROM 'on' THEN
+		RETURN NULL;
+	END IF;
+
+	SELECT id INTO conta FROM contas WHERE cliente_id = NEW.cliente_id;
+	IF NOT FOUND THEN
+		INSERT INTO contas (cliente_id, nome) VALUES (NEW.cliente_id, 'cliente ' || NEW.cliente_id)
+		ON CONFLICT (cliente_id) DO NOTHING;
+		SELECT id INTO conta FROM contas WHERE cliente_id = NEW.cliente_id;
+	END IF;
+
+	delta = NEW.valor;
+	IF NEW.tipo = 'd' THEN
+		delta = -NEW.valor;
+	END IF;
+	INSERT INTO entradas (transacao_id, conta_id, valor, realizada_em)
+	VALUES (NEW.id, conta, delta, NEW.realizada_em), (NEW.id, 0, -delta, NEW.realizada_em);
+	RETURN NULL;
+END;
+$$;
+
+CREATE TRIGGER partidas_trigger
+AFTER INSERT ON transacoes
+FOR EACH ROW
+EXECUTE FUNCTION partidas_trigger_function();
+
+-- invariante das partidas dobradas: as entradas de cada transação somam
+-- zero, verificado no commit
+CREATE OR REPLACE FUNCTION partidas_balanceadas_function()
+RETURNS TRIGGER LANGUAGE plpgsql AS $$
+BEGIN
+	IF (SELECT SUM(valor) FROM entradas WHERE transacao_id = NEW.transacao_id) <> 0 THEN
+		RAISE EXCEPTION 'partidas desbalanceadas na transação %', NEW.transacao_id USING ERRCODE = 'RB002';
+	END IF;
+	RETURN NULL;
+END;
+$$;
+
+CREATE CONSTRAINT TRIGGER partidas_balanceadas
+AFTER INSERT ON entradas
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW
+EXECUTE FUNCTION partidas_balanceadas_function();
+
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Server-Timing (SERVER_TIMING_ENABLED, para depuração): cada resposta traz
+// o header Server-Timing com o tempo gasto em cada etapa da requisição, em
+// milissegundos, para o ajuste com o teste de carga ver onde vai o p99 sem
+// um profiler:
+//
+//	Server-Timing: parse;dur=0.041, validate;dur=0.012, db;dur=1.304, encode;dur=0.006, total;dur=1.452
+//
+// parse e validate são as duas metades do bindJSON; db soma as chamadas ao
+// Storage (inclusive a espera por conexão e o Redis), e não vê as rotas
+// exclusivas do Postgres, que usam o pool direto; encode é a serialização
+// da resposta nas rotas de transação e de extrato. As etapas que a rota não
+// passou ficam de fora.
+
+var serverTimingEnabled = getEnvBool("SERVER_TIMING_ENABLED", false)
+
+const (
+	timingParse = iota
+	timingValidate
+	timingDB
+	timingEncode
+	timingStages
+)
+
+var timingNames = [timingStages]string{"parse", "validate", "db", "encode"}
+
+// serverTiming acumula a duração das etapas de uma requisição. As somas são
+// atômicas porque com a fila de escritas o Storage é chamado no worker.
+type serverTiming struct {
+	durations [timingStages]atomic.Int64
+}
+
+type serverTimingKey struct{}
+
+// timingFrom devolve a medição da requisição, ou nil sem Server-Timing
+func timingFrom(ctx context.Context) *serverTiming {
+	timing, _ := ctx.Value(serverTimingKey{}).(*serverTiming)
+	return timing
+}
+
+// Start começa a medir stage e devolve a função que encerra a medição; num
+// serverTiming nil não mede nada
+func (t *serverTiming) Start(stage int) func() {
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		t.durations[stage].Add(int64(time.Since(start)))
+	}
+}
+
+func (t *serverTiming) header(total time.Duration) string {
+	var header strings.Builder
+	for stage, name := range timingNames {
+		if d := t.durations[stage].Load(); d > 0 {
+			header.WriteString(name)
+			header.WriteString(";dur=")
+			header.WriteString(formatTimingMillis(time.Duration(d)))
+			header.WriteString(", ")
+		}
+	}
+	header.WriteString("total;dur=")
+	header.WriteString(formatTimingMillis(total))
+	return header.String()
+}
+
+func formatTimingMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 3, 64)
+}
+
+func serverTimingMiddleware(c fiber.Ctx) error {
+	start := time.Now()
+	timing := &serverTiming{}
+	c.SetUserContext(context.WithValue(c.UserContext(), serverTimingKey{}, timing))
+	err := c.Next()
+	c.Set("Server-Timing", timing.header(time.Since(start)))
+	return err
+}
+
+// timedStorage decora o Storage medindo as chamadas na etapa db
+type timedStorage struct {
+	Storage
+}
+
+func (s timedStorage) CreateTransaction(ctx context.Context, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	defer timingFrom(ctx).Start(timingDB)()
+	return s.Storage.CreateTransaction(ctx, clientId, transaction)
+}
+
+func (s timedStorage) GetBalance(ctx context.Context, clientId int) (Balance, error) {
+	defer timingFrom(ctx).Start(timingDB)()
+	return s.Storage.GetBalance(ctx, clientId)
+}
+
+func (s timedStorage) ListTransactions(ctx context.Context, clientId int, filter TransactionFilter) ([]Transacao, error) {
+	defer timingFrom(ctx).Start(timingDB)()
+	return s.Storage.ListTransactions(ctx, clientId, filter)
+}
+
+func (s timedStorage) GetTransaction(ctx context.Context, clientId int, transactionId int64) (Transacao, error) {
+	defer timingFrom(ctx).Start(timingDB)()
+	return s.Storage.GetTransaction(ctx, clientId, transactionId)
+}
+
+func (s timedStorage) Summary(ctx context.Context, clientId int, from, to time.Time) (Resumo, error) {
+	defer timingFrom(ctx).Start(timingDB)()
+	return s.Storage.Summary(ctx, clientId, from, to)
+}
+
+func (s timedStorage) Statement(ctx context.Context, clientId int, filter TransactionFilter) (Extrato, error) {
+	defer timingFrom(ctx).Start(timingDB)()
+	return s.Storage.Statement(ctx, clientId, filter)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// Simulação de transação (POST /clientes/:id/transacoes/simular), a
+// verificação prévia de um checkout: o corpo é o de POST /transacoes e a
+// resposta diz se a transação seria aceita agora e o saldo que ela deixaria,
+// sem gravar nada nem reservar limite. Com o Postgres, além do saldo e do
+// limite (descontadas as autorizações pendentes), entram o bloqueio do
+// cliente, as regras de fraude e os limites de categoria e diário; com
+// parcelas, é simulada a primeira parcela, a única aplicada na hora.
+
+// SimulacaoTransacao é o corpo da resposta de POST /transacoes/simular
+type SimulacaoTransacao struct {
+	Aprovada bool `json:"aprovada"`
+	// Codigo e Motivo explicam a recusa, com os códigos de POST /transacoes
+	Codigo             string   `json:"codigo,omitempty"`
+	Motivo             string   `json:"motivo,omitempty"`
+	SaldoProjetado     Centavos `json:"saldo_projetado"`
+	Limite             Centavos `json:"limite"`
+	LimiteUtilizadoPct float64  `json:"limite_utilizado_pct"`
+	// Fraude é a decisão das regras de fraude, quando configuradas
+	Fraude string `json:"fraude,omitempty"`
+}
+
+func handleSimulateTransaction(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	transaction := new(TransacaoRequest)
+	if err := bindJSON(c, transaction); err != nil {
+		return sendBindError(c, err)
+	}
+
+	balance, decision, err := simulateTransaction(c.UserContext(), clientId, transaction)
+	response := SimulacaoTransacao{
+		Aprovada:           err == nil,
+		SaldoProjetado:     balance.Saldo,
+		Limite:             balance.Limite,
+		LimiteUtilizadoPct: limitUtilization(balance),
+		Fraude:             decision.Acao,
+	}
+	if err != nil {
+		problem, ok := transactionProblem(err)
+		if !ok {
+			return c.SendStatus(fiber.ErrInternalServerError.Code)
+		}
+		localizeProblem(localeFrom(c), &problem)
+		response.Codigo, response.Motivo = problem.Codigo, problem.Detail
+	}
+	return c.JSON(response)
+}
+
+// simulateTransaction devolve o saldo que a transação deixaria e a decisão
+// das regras de fraude; a recusa vem como o erro que a escrita devolveria,
+// com o saldo atual
+func simulateTransaction(ctx context.Context, clientId int, transaction *TransacaoRequest) (Balance, FraudDecision, error) {
+	decision := FraudDecision{}
+	if transaction.Parcelas > 1 {
+		if !installmentsEnabled || poolFor(ctx) == nil {
+			return Balance{}, decision, ErrParcelamentoIndisponivel
+		}
+		if transaction.Tipo != TipoDebito {
+			return Balance{}, decision, ErrParcelasApenasDebito
+		}
+		if transaction.Valor < Centavos(transaction.Parcelas) {
+			return Balance{}, decision, ErrParcelasAcimaDoValor
+		}
+		installments := Centavos(transaction.Parcelas)
+		transaction.Valor = transaction.Valor/installments + transaction.Valor%installments
+	}
+
+	pool := poolFor(ctx)
+	if pool == nil {
+		balance, err := storageFor(ctx).GetBalance(ctx, clientId)
+		if err != nil {
+			return balance, decision, err
+		}
+		projected, err := projectBalance(balance, transaction)
+		return projected, decision, err
+	}
+
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return Balance{}, decision, err
+	}
+	defer tx.Rollback(ctx)
+
+	balance, err := getBalance(ctx, tx, clientId)
+	if err != nil {
+		return balance, decision, err
+	}
+	var blocked bool
+	err = tx.QueryRow(ctx, "SELECT reservado, bloqueado FROM clientes WHERE id = $1", clientId).
+		Scan(&balance.Reservado, &blocked)
+	if err != nil {
+		return balance, decision, err
+	}
+	if blocked {
+		return balance, decision, ErrClienteBloqueado
+	}
+
+	if fraudChecker != nil {
+		if decision, err = fraudChecker.Check(ctx, tx, clientId, transaction); err != nil {
+			return balance, decision, err
+		}
+		if decision.Acao == fraudReject {
+			return balance, decision, fmt.Errorf("%w (regra %s)", ErrFraudeSuspeita, decision.Regra)
+		}
+	}
+
+	if transaction.Tipo == TipoDebito && transaction.Categoria != "" {
+		var hardLimit *Centavos
+		err := tx.QueryRow(ctx, `
+			SELECT limite_rigido FROM limites_categoria
+			WHERE cliente_id = $1 AND categoria = $2`, clientId, transaction.Categoria).Scan(&hardLimit)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return balance, decision, err
+		}
+		if hardLimit != nil {
+			spent, err := monthlyCategorySpending(ctx, tx, clientId, transaction.Categoria)
+			if err != nil {
+				return balance, decision, err
+			}
+			if spent+transaction.Valor > *hardLimit {
+				return balance, decision, ErrLimiteCategoriaExcedido
+			}
+		}
+	}
+
+	if transaction.Tipo == TipoDebito && dailyLimitsEnabled {
+		limit, err := dailyLimit(ctx, tx, clientId)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return balance, decision, err
+		}
+		if err == nil && limit.DebitadoHoje+transaction.Valor > limit.Limite {
+			return balance, decision, ErrLimiteDiarioExcedido
+		}
+	}
+
+	projected, err := projectBalance(balance, transaction)
+	return projected, decision, err
+}
+
+// projectBalance aplica a transação ao saldo, como as estratégias que
+// validam o limite na aplicação
+func projectBalance(balance Balance, transaction *TransacaoRequest) (Balance, error) {
+	if transaction.Tipo == TipoDebito {
+		if balance.Saldo-balance.Reservado-transaction.Valor < -balance.Limite {
+			return balance, ErrLimiteExcedido
+		}
+		balance.Saldo -= transaction.Valor
+		return balance, nil
+	}
+	balance.Saldo += transaction.Valor
+	return balance, nil
+}
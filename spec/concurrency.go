@@ -0,0 +1,96 @@
+package spec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"rinha-de-backend-2024-q1/client"
+)
+
+// RunConcurrency dispara ao mesmo tempo débitos e créditos contra clientID,
+// como a fase de escrita do teste da rinha, e confere que o saldo final é o
+// inicial mais o efeito das transações aceitas, que nenhum saldo
+// devolvido passou do limite e que o extrato concorda. Com várias
+// instâncias atrás do balanceador, é o teste de que elas não perdem
+// escritas umas das outras.
+func RunConcurrency(ctx context.Context, baseURL string, clientID, workers, perWorker int) []Result {
+	api := client.New(baseURL, client.WithRetries(3, 20*time.Millisecond))
+	return []Result{{
+		Name: fmt.Sprintf("%d transações concorrentes mantêm o saldo consistente", workers*perWorker),
+		Err:  runConcurrentTransactions(ctx, api, clientID, workers, perWorker),
+	}}
+}
+
+func runConcurrentTransactions(ctx context.Context, api *client.Client, clientID, workers, perWorker int) error {
+	before, err := api.ObterExtrato(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	// débitos bem maiores que os créditos para que parte deles bata no limite
+	valor := max(before.Saldo.Limite/int64(workers*perWorker)*4, 1)
+
+	var mu sync.Mutex
+	var applied int64
+	var errs []error
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				transacao := client.Transacao{Valor: valor, Tipo: "d", Descricao: "conc"}
+				if i%4 == 0 {
+					transacao = client.Transacao{Valor: valor / 2, Tipo: "c", Descricao: "conc"}
+				}
+				created, err := api.CriarTransacao(ctx, clientID, transacao)
+				mu.Lock()
+				switch {
+				case err == nil && created.Saldo < -created.Limite:
+					errs = append(errs, fmt.Errorf("saldo %d abaixo do limite %d", created.Saldo, created.Limite))
+				case err == nil && transacao.Tipo == "d":
+					applied -= transacao.Valor
+				case err == nil:
+					applied += transacao.Valor
+				case !isRefusal(err):
+					errs = append(errs, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return fmt.Errorf("%d falhas, a primeira: %w", len(errs), errs[0])
+	}
+
+	after, err := api.ObterExtrato(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if want := before.Saldo.Total + applied; after.Saldo.Total != want {
+		return fmt.Errorf("saldo final %d, esperado %d", after.Saldo.Total, want)
+	}
+	if after.Saldo.Total < -after.Saldo.Limite {
+		return fmt.Errorf("saldo final %d abaixo do limite %d", after.Saldo.Total, after.Saldo.Limite)
+	}
+	// devolve o saldo para as próximas execuções
+	if applied < 0 {
+		_, err = api.CriarTransacao(ctx, clientID, client.Transacao{Valor: -applied, Tipo: "c", Descricao: "conc"})
+	}
+	return err
+}
+
+// isRefusal diz se err é uma recusa da API, que garante que a transação não
+// foi gravada: limite excedido, conflito de concorrência ou sobrecarga
+func isRefusal(err error) bool {
+	var apiErr *client.Erro
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Status == http.StatusUnprocessableEntity ||
+		apiErr.Codigo == "CONFLITO_CONCORRENCIA" || apiErr.Codigo == "SOBRECARGA"
+}
@@ -0,0 +1,277 @@
+// Package spec verifica o contrato oficial da API da rinha de backend
+// 2024-Q1 contra um servidor em execução, independente do armazenamento
+// usado: códigos de status para cada corpo inválido, rejeição por limite e
+// ordem do extrato.
+//
+// As verificações alteram o saldo do cliente usado, mas não dependem do saldo
+// inicial.
+package spec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"rinha-de-backend-2024-q1/client"
+)
+
+// Result é o resultado de uma verificação; Err é nil quando ela passou
+type Result struct {
+	Name string
+	Err  error
+}
+
+type check struct {
+	name string
+	run  func(ctx context.Context, r *runner) error
+}
+
+type runner struct {
+	baseURL  string
+	clientID int
+	http     *http.Client
+	api      *client.Client
+}
+
+// Run executa todas as verificações, em ordem, contra baseURL usando o
+// cliente clientID. O id seguinte ao último cliente da rinha (6) deve não
+// existir.
+func Run(ctx context.Context, baseURL string, clientID int) []Result {
+	return RunWithClient(ctx, &http.Client{Timeout: 5 * time.Second}, baseURL, clientID)
+}
+
+// RunWithClient é o Run com as requisições feitas por httpClient, como um
+// cujo Transport atende a API no próprio processo
+func RunWithClient(ctx context.Context, httpClient *http.Client, baseURL string, clientID int) []Result {
+	r := &runner{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		clientID: clientID,
+		http:     httpClient,
+		api:      client.New(baseURL, client.WithHTTPClient(httpClient), client.WithRetries(0, 0)),
+	}
+
+	checks := append([]check{}, transactionChecks...)
+	checks = append(checks, invalidPayloadChecks()...)
+	checks = append(checks, statementChecks...)
+
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		results = append(results, Result{Name: check.name, Err: check.run(ctx, r)})
+	}
+	return results
+}
+
+var transactionChecks = []check{
+	{"crédito devolve saldo e limite", func(ctx context.Context, r *runner) error {
+		before, err := r.api.ObterExtrato(ctx, r.clientID)
+		if err != nil {
+			return err
+		}
+		created, err := r.api.CriarTransacao(ctx, r.clientID, client.Transacao{Valor: 1000, Tipo: "c", Descricao: "spec"})
+		if err != nil {
+			return err
+		}
+		if created.Saldo != before.Saldo.Total+1000 {
+			return fmt.Errorf("saldo %d, esperado %d", created.Saldo, before.Saldo.Total+1000)
+		}
+		if created.Limite != before.Saldo.Limite {
+			return fmt.Errorf("limite %d, esperado %d", created.Limite, before.Saldo.Limite)
+		}
+		return nil
+	}},
+	{"débito devolve saldo e limite", func(ctx context.Context, r *runner) error {
+		before, err := r.api.ObterExtrato(ctx, r.clientID)
+		if err != nil {
+			return err
+		}
+		created, err := r.api.CriarTransacao(ctx, r.clientID, client.Transacao{Valor: 1000, Tipo: "d", Descricao: "spec"})
+		if err != nil {
+			return err
+		}
+		if created.Saldo != before.Saldo.Total-1000 {
+			return fmt.Errorf("saldo %d, esperado %d", created.Saldo, before.Saldo.Total-1000)
+		}
+		return nil
+	}},
+	{"débito acima do limite responde 422 sem alterar o saldo", func(ctx context.Context, r *runner) error {
+		before, err := r.api.ObterExtrato(ctx, r.clientID)
+		if err != nil {
+			return err
+		}
+		valor := before.Saldo.Total + before.Saldo.Limite + 1
+		status, _, err := r.post(ctx, r.clientID, fmt.Sprintf(`{"valor": %d, "tipo": "d", "descricao": "spec"}`, valor))
+		if err != nil {
+			return err
+		}
+		if status != http.StatusUnprocessableEntity {
+			return fmt.Errorf("status %d, esperado 422", status)
+		}
+		after, err := r.api.ObterExtrato(ctx, r.clientID)
+		if err != nil {
+			return err
+		}
+		if after.Saldo.Total != before.Saldo.Total {
+			return fmt.Errorf("saldo mudou de %d para %d", before.Saldo.Total, after.Saldo.Total)
+		}
+		return nil
+	}},
+	{"débito que usa todo o limite é aceito", func(ctx context.Context, r *runner) error {
+		before, err := r.api.ObterExtrato(ctx, r.clientID)
+		if err != nil {
+			return err
+		}
+		valor := before.Saldo.Total + before.Saldo.Limite
+		if valor <= 0 {
+			return nil
+		}
+		created, err := r.api.CriarTransacao(ctx, r.clientID, client.Transacao{Valor: valor, Tipo: "d", Descricao: "spec"})
+		if err != nil {
+			return err
+		}
+		if created.Saldo != -created.Limite {
+			return fmt.Errorf("saldo %d, esperado %d", created.Saldo, -created.Limite)
+		}
+		// devolve o saldo para as próximas verificações
+		_, err = r.api.CriarTransacao(ctx, r.clientID, client.Transacao{Valor: valor, Tipo: "c", Descricao: "spec"})
+		return err
+	}},
+	{"cliente inexistente responde 404 em transacoes", func(ctx context.Context, r *runner) error {
+		status, _, err := r.post(ctx, 6, `{"valor": 1, "tipo": "c", "descricao": "spec"}`)
+		if err != nil {
+			return err
+		}
+		return expectStatus(status, http.StatusNotFound)
+	}},
+}
+
+// invalidPayloadChecks cobre os corpos que a rinha envia esperando 422; o
+// teste oficial também aceita 400
+func invalidPayloadChecks() []check {
+	payloads := []struct {
+		name string
+		body string
+	}{
+		{"valor fracionário", `{"valor": 1.2, "tipo": "d", "descricao": "spec"}`},
+		{"valor negativo", `{"valor": -1, "tipo": "c", "descricao": "spec"}`},
+		{"valor zero", `{"valor": 0, "tipo": "c", "descricao": "spec"}`},
+		{"valor texto", `{"valor": "1", "tipo": "c", "descricao": "spec"}`},
+		{"valor ausente", `{"tipo": "c", "descricao": "spec"}`},
+		{"tipo inválido", `{"valor": 1, "tipo": "x", "descricao": "spec"}`},
+		{"tipo nulo", `{"valor": 1, "tipo": null, "descricao": "spec"}`},
+		{"descricao vazia", `{"valor": 1, "tipo": "c", "descricao": ""}`},
+		{"descricao nula", `{"valor": 1, "tipo": "c", "descricao": null}`},
+		{"descricao longa", `{"valor": 1, "tipo": "c", "descricao": "12345678901"}`},
+		{"json inválido", `{"valor": 1,`},
+	}
+
+	checks := make([]check, 0, len(payloads))
+	for _, payload := range payloads {
+		checks = append(checks, check{"corpo com " + payload.name + " é rejeitado", func(ctx context.Context, r *runner) error {
+			status, _, err := r.post(ctx, r.clientID, payload.body)
+			if err != nil {
+				return err
+			}
+			if status != http.StatusUnprocessableEntity && status != http.StatusBadRequest {
+				return fmt.Errorf("status %d, esperado 422 ou 400", status)
+			}
+			return nil
+		}})
+	}
+	return checks
+}
+
+var statementChecks = []check{
+	{"extrato traz as últimas 10 transações, da mais recente para a mais antiga", func(ctx context.Context, r *runner) error {
+		for i := 0; i < 11; i++ {
+			descricao := "spec" + strconv.Itoa(i)
+			if _, err := r.api.CriarTransacao(ctx, r.clientID, client.Transacao{Valor: 1, Tipo: "c", Descricao: descricao}); err != nil {
+				return err
+			}
+		}
+		statement, err := r.api.ObterExtrato(ctx, r.clientID)
+		if err != nil {
+			return err
+		}
+		if len(statement.UltimasTransacoes) != 10 {
+			return fmt.Errorf("%d transações, esperado 10", len(statement.UltimasTransacoes))
+		}
+		for i, transaction := range statement.UltimasTransacoes {
+			if want := "spec" + strconv.Itoa(10-i); transaction.Descricao != want {
+				return fmt.Errorf("transação %d com descricao %q, esperado %q", i, transaction.Descricao, want)
+			}
+			if i > 0 && transaction.RealizadaEm.After(statement.UltimasTransacoes[i-1].RealizadaEm) {
+				return fmt.Errorf("transação %d realizada depois da anterior", i)
+			}
+		}
+		return nil
+	}},
+	{"extrato traz saldo, limite e data do extrato", func(ctx context.Context, r *runner) error {
+		status, body, err := r.get(ctx, "/clientes/"+strconv.Itoa(r.clientID)+"/extrato")
+		if err != nil {
+			return err
+		}
+		if err := expectStatus(status, http.StatusOK); err != nil {
+			return err
+		}
+		var statement struct {
+			Saldo map[string]json.RawMessage `json:"saldo"`
+		}
+		if err := json.Unmarshal(body, &statement); err != nil {
+			return err
+		}
+		for _, field := range []string{"total", "limite", "data_extrato"} {
+			if _, ok := statement.Saldo[field]; !ok {
+				return fmt.Errorf("saldo sem o campo %s", field)
+			}
+		}
+		return nil
+	}},
+	{"cliente inexistente responde 404 no extrato", func(ctx context.Context, r *runner) error {
+		status, _, err := r.get(ctx, "/clientes/6/extrato")
+		if err != nil {
+			return err
+		}
+		return expectStatus(status, http.StatusNotFound)
+	}},
+}
+
+func expectStatus(status, want int) error {
+	if status != want {
+		return fmt.Errorf("status %d, esperado %d", status, want)
+	}
+	return nil
+}
+
+func (r *runner) post(ctx context.Context, clientID int, body string) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		r.baseURL+"/clientes/"+strconv.Itoa(clientID)+"/transacoes", bytes.NewBufferString(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return r.do(req)
+}
+
+func (r *runner) get(ctx context.Context, path string) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.baseURL+path, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	return r.do(req)
+}
+
+func (r *runner) do(req *http.Request) (int, []byte, error) {
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"rinha-de-backend-2024-q1/spec"
+)
+
+// TestSpec roda as verificações do contrato da rinha (pacote spec) contra a
+// API no próprio processo, sobre o SQLite, nas duas pilhas HTTP
+func TestSpec(t *testing.T) {
+	for _, stack := range []string{httpStackFiber, httpStackNetHTTP} {
+		t.Run(stack, func(t *testing.T) {
+			httpClient, baseURL := testClient(sqliteTestApp(t), stack)
+			for _, result := range spec.RunWithClient(context.Background(), httpClient, baseURL, 1) {
+				t.Run(result.Name, func(t *testing.T) {
+					if result.Err != nil {
+						t.Error(result.Err)
+					}
+				})
+			}
+		})
+	}
+}
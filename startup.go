@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Relatório de inicialização (GET /admin/startup e uma linha no log quando a
+// API começa a atender). Mostra quanto tempo levou cada etapa da subida, as
+// migrações aplicadas, o estado do aquecimento e a configuração em uso, para
+// explicar por que uma instância demorou a ficar saudável num deploy.
+
+// StartupReport é o corpo de GET /admin/startup
+type StartupReport struct {
+	// Instancia é o hostname, que no docker-compose identifica o container
+	Instancia  string    `json:"instancia"`
+	IniciadoEm Timestamp `json:"iniciado_em"`
+	// ProntoEm é o instante em que a API começou a atender
+	ProntoEm    *Timestamp    `json:"pronto_em,omitempty"`
+	DuracaoMs   int64         `json:"duracao_ms"`
+	Etapas      []StartupStep `json:"etapas"`
+	Migracoes   []string      `json:"migracoes"`
+	Aquecimento StartupWarmth `json:"aquecimento"`
+	// Config lista as variáveis definidas e lidas na subida, com os
+	// segredos ocultos
+	Config map[string]string `json:"config"`
+}
+
+type StartupStep struct {
+	Nome      string `json:"nome"`
+	DuracaoMs int64  `json:"duracao_ms"`
+	Erro      string `json:"erro,omitempty"`
+}
+
+type StartupWarmth struct {
+	Pools           []StartupPool `json:"pools"`
+	ExtratoCache    bool          `json:"extrato_cache"`
+	ExtratosEmCache int           `json:"extratos_em_cache"`
+}
+
+type StartupPool struct {
+	Tenant  string `json:"tenant,omitempty"`
+	Abertas int32  `json:"abertas"`
+	Minimo  int32  `json:"minimo"`
+	// Aquecido diz se as conexões passaram por warmUpPools
+	Aquecido bool `json:"aquecido"`
+}
+
+// startupRecorder acumula as etapas e migrações da subida do processo
+type startupRecorder struct {
+	mu         sync.Mutex
+	started    time.Time
+	ready      time.Time
+	steps      []StartupStep
+	migrations []string
+	warmed     bool
+}
+
+var startup = &startupRecorder{started: time.Now()}
+
+// configKeysRead guarda as variáveis consultadas por lookupEnv
+var configKeysRead sync.Map
+
+// startupConfigKeys são as variáveis lidas direto do ambiente, fora de
+// lookupEnv
+var startupConfigKeys = []string{"CONFIG_FILE", "POSTGRES_HOST", "POSTGRES_USER", "POSTGRES_DB", "POSTGRES_PASSWORD"}
+
+// secretConfigWords marcam as variáveis cujo valor não aparece no relatório
+var secretConfigWords = []string{"PASSWORD", "SECRET", "SEGREDO", "TOKEN", "KEY", "CHAVES", "SENHA", "DSN"}
+
+// step marca o início de uma etapa; a função devolvida a encerra
+func (r *startupRecorder) step(name string) func(err error) {
+	start := time.Now()
+	return func(err error) {
+		step := StartupStep{Nome: name, DuracaoMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			step.Erro = err.Error()
+		}
+		r.mu.Lock()
+		r.steps = append(r.steps, step)
+		r.mu.Unlock()
+	}
+}
+
+func (r *startupRecorder) migration(description string) {
+	r.mu.Lock()
+	r.migrations = append(r.migrations, description)
+	r.mu.Unlock()
+}
+
+func (r *startupRecorder) markWarmed() {
+	r.mu.Lock()
+	r.warmed = true
+	r.mu.Unlock()
+}
+
+func (r *startupRecorder) markReady() {
+	r.mu.Lock()
+	r.ready = time.Now()
+	r.mu.Unlock()
+}
+
+// report monta o relatório com o estado atual de a
+func (r *startupRecorder) report(a *App) StartupReport {
+	r.mu.Lock()
+	hostname, _ := os.Hostname()
+	report := StartupReport{
+		Instancia:  hostname,
+		IniciadoEm: Timestamp{r.started},
+		Etapas:     append([]StartupStep{}, r.steps...),
+		Migracoes:  append([]string{}, r.migrations...),
+	}
+	if !r.ready.IsZero() {
+		report.ProntoEm = &Timestamp{r.ready}
+		report.DuracaoMs = r.ready.Sub(r.started).Milliseconds()
+	} else {
+		report.DuracaoMs = time.Since(r.started).Milliseconds()
+	}
+	warmed := r.warmed
+	r.mu.Unlock()
+
+	if a.pool != nil {
+		report.Aquecimento.Pools = append(report.Aquecimento.Pools, StartupPool{
+			Abertas:  a.pool.Stat().TotalConns(),
+			Minimo:   a.pool.Config().MinConns,
+			Aquecido: warmed,
+		})
+	}
+	for tenant, pool := range a.tenantPools {
+		report.Aquecimento.Pools = append(report.Aquecimento.Pools, StartupPool{
+			Tenant:   tenant,
+			Abertas:  pool.Stat().TotalConns(),
+			Minimo:   pool.Config().MinConns,
+			Aquecido: warmed,
+		})
+	}
+	sort.Slice(report.Aquecimento.Pools, func(i, j int) bool {
+		return report.Aquecimento.Pools[i].Tenant < report.Aquecimento.Pools[j].Tenant
+	})
+	report.Aquecimento.ExtratoCache = statements.Enabled()
+	report.Aquecimento.ExtratosEmCache = statements.Len()
+	report.Config = startupConfig()
+	return report
+}
+
+// startupConfig devolve as variáveis lidas que têm valor, ocultando os
+// segredos e as credenciais embutidas em URLs
+func startupConfig() map[string]string {
+	config := map[string]string{}
+	add := func(key string) {
+		value := lookupEnv(key)
+		if value == "" {
+			return
+		}
+		config[key] = redactConfigValue(key, value)
+	}
+	configKeysRead.Range(func(key, _ any) bool {
+		add(key.(string))
+		return true
+	})
+	for _, key := range startupConfigKeys {
+		if _, ok := config[key]; !ok {
+			add(key)
+		}
+	}
+	return config
+}
+
+func redactConfigValue(key, value string) string {
+	for _, word := range secretConfigWords {
+		if strings.Contains(key, word) {
+			return "***"
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), "***")
+			return u.String()
+		}
+	}
+	return value
+}
+
+func handleStartupReport(c fiber.Ctx) error {
+	return c.JSON(startup.report(appFrom(c.UserContext())))
+}
+
+// logStartupReport registra o relatório quando a API começa a atender
+func logStartupReport(a *App) {
+	startup.markReady()
+	body, err := jsonMarshal(startup.report(a))
+	if err != nil {
+		a.logger.Print("Error encoding startup report: ", err)
+		return
+	}
+	a.logger.Print("Startup report: ", string(body))
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"rinha-de-backend-2024-q1/pg"
+)
+
+// TransactionFilter reúne os filtros aceitos na listagem de transações de um cliente
+type TransactionFilter struct {
+	Tipo      string
+	Categoria string
+	Desde     *time.Time
+	Ate       *time.Time
+	Limite    int
+	// AntesDe restringe a listagem às transações anteriores ao cursor, na
+	// ordem do extrato (paginação por chave, sem OFFSET)
+	AntesDe *ExtratoCursor
+	// DesdeSeq restringe a listagem às transações de seq maior, em ordem
+	// crescente de seq (ver feed.go)
+	DesdeSeq *int64
+	// Metadata é o objeto JSON, montado por metadataFilter, que a metadata
+	// das transações deve conter
+	Metadata string
+	// SemTotais dispensa o cálculo dos totais por categoria, com a flag
+	// totais_por_categoria desligada
+	SemTotais bool
+}
+
+// ExtratoCursor é a posição de uma transação na ordem do extrato
+// (realizada_em DESC, seq DESC), no formato "<realizada_em>,<seq>" de
+// ?antes_de e de proxima_pagina
+type ExtratoCursor struct {
+	RealizadaEm time.Time
+	Seq         int64
+}
+
+func (c ExtratoCursor) String() string {
+	return c.RealizadaEm.UTC().Format(time.RFC3339Nano) + "," + strconv.FormatInt(c.Seq, 10)
+}
+
+func ParseExtratoCursor(value string) (ExtratoCursor, error) {
+	realizadaEm, seq, found := strings.Cut(value, ",")
+	if !found {
+		return ExtratoCursor{}, errors.New("cursor inválido: esperado <realizada_em>,<seq>")
+	}
+	var cursor ExtratoCursor
+	var err error
+	if cursor.RealizadaEm, err = time.Parse(time.RFC3339Nano, realizadaEm); err != nil {
+		return ExtratoCursor{}, errors.New("cursor inválido: realizada_em deve estar no formato RFC 3339")
+	}
+	if cursor.Seq, err = strconv.ParseInt(seq, 10, 64); err != nil || cursor.Seq < 0 {
+		return ExtratoCursor{}, errors.New("cursor inválido: seq deve ser um inteiro não negativo")
+	}
+	return cursor, nil
+}
+
+// pageLimit é o tamanho da página pedida, entre 1 e 100 (padrão 10)
+func (f TransactionFilter) pageLimit() int {
+	if f.Limite <= 0 || f.Limite > 100 {
+		return 10
+	}
+	return f.Limite
+}
+
+// orderBy é a ordem da listagem: a do extrato ou, com DesdeSeq, a de seq
+func (f TransactionFilter) orderBy() string {
+	if f.DesdeSeq != nil {
+		return " ORDER BY seq"
+	}
+	return " ORDER BY realizada_em DESC, seq DESC"
+}
+
+// nextPageCursor devolve o cursor da página seguinte quando transactions
+// preenche a página; transações sem seq (anteriores à coluna) não servem
+// de cursor e encerram a paginação
+func nextPageCursor(transactions []Transacao, filter TransactionFilter) *string {
+	if len(transactions) == 0 || len(transactions) < filter.pageLimit() {
+		return nil
+	}
+	last := transactions[len(transactions)-1]
+	if last.Seq == nil {
+		return nil
+	}
+	cursor := ExtratoCursor{RealizadaEm: last.RealizadaEm.Time, Seq: *last.Seq}.String()
+	return &cursor
+}
+
+// statementFields interpreta ?campos do extrato, uma lista separada por
+// vírgulas de saldo e ultimas_transacoes; vazio são as duas partes
+func statementFields(value string) (balance, transactions bool, err error) {
+	if value == "" {
+		return true, true, nil
+	}
+	for _, field := range strings.Split(value, ",") {
+		switch strings.TrimSpace(field) {
+		case "saldo":
+			balance = true
+		case "ultimas_transacoes":
+			transactions = true
+		default:
+			return false, false, errors.New("campos deve listar saldo ou ultimas_transacoes")
+		}
+	}
+	return balance, transactions, nil
+}
+
+func getBalance(ctx context.Context, db dbtx, clientId int) (Balance, error) {
+	queries := pg.New(db)
+	if concurrencyMode == concurrencyEventSourcing {
+		row, err := queries.GetLedgerBalance(ctx, int32(clientId))
+		return Balance{Saldo: Centavos(row.Saldo), Limite: Centavos(row.Limite)}, err
+	}
+	row, err := queries.GetBalance(ctx, int32(clientId))
+	return Balance{Saldo: Centavos(row.Saldo), Limite: Centavos(row.Limite)}, err
+}
+
+func listTransactions(ctx context.Context, db dbtx, clientId int, filter TransactionFilter) ([]Transacao, error) {
+	if filter == (TransactionFilter{}) {
+		rows, err := pg.New(db).ListRecentTransactions(ctx, int32(clientId))
+		if err != nil {
+			return nil, err
+		}
+		transactions := make([]Transacao, 0, len(rows))
+		for _, row := range rows {
+			transactions = append(transactions, recentTransaction(row))
+		}
+		return transactions, nil
+	}
+
+	var query strings.Builder
+	args := []any{clientId}
+	query.WriteString(`
+		SELECT id, valor, tipo, descricao, categoria, realizada_em, saldo_apos, seq, estorno_de, NULLIF(valor_estornado, 0), metadata
+		FROM transacoes WHERE cliente_id = $1`)
+
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		query.WriteString(" AND ")
+		query.WriteString(strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if filter.Tipo != "" {
+		addCondition("tipo = ?", filter.Tipo)
+	}
+	if filter.Categoria != "" {
+		addCondition("categoria = ?", filter.Categoria)
+	}
+	if filter.Metadata != "" {
+		addCondition("metadata @> ?::jsonb", filter.Metadata)
+	}
+	if filter.Desde != nil {
+		addCondition("realizada_em >= ?", filter.Desde.UTC())
+	}
+	if filter.Ate != nil {
+		addCondition("realizada_em < ?", filter.Ate.UTC())
+	}
+	if filter.AntesDe != nil {
+		// a comparação por linha usa o índice (cliente_id, realizada_em DESC, seq DESC)
+		args = append(args, filter.AntesDe.RealizadaEm.UTC(), filter.AntesDe.Seq)
+		query.WriteString(" AND (realizada_em, seq) < ($" + strconv.Itoa(len(args)-1) + ", $" + strconv.Itoa(len(args)) + ")")
+	}
+
+	if filter.DesdeSeq != nil {
+		addCondition("seq > ?", *filter.DesdeSeq)
+	}
+
+	limit := filter.pageLimit()
+	query.WriteString(filter.orderBy() + " LIMIT " + strconv.Itoa(limit))
+
+	rows, err := db.Query(ctx, query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.AppendRows(make([]Transacao, 0, limit), rows, scanTransaction)
+}
+
+func scanTransaction(row pgx.CollectableRow) (Transacao, error) {
+	var transaction Transacao
+	err := row.Scan(
+		&transaction.ID,
+		&transaction.Valor,
+		&transaction.Tipo,
+		&transaction.Descricao,
+		&transaction.Categoria,
+		&transaction.RealizadaEm,
+		&transaction.SaldoApos,
+		&transaction.Seq,
+		&transaction.EstornoDe,
+		&transaction.ValorEstornado,
+		&transaction.Metadata,
+	)
+	return transaction, err
+}
+
+// recentTransaction converte a linha gerada pelo sqlc; valor_estornado zero
+// vira nil, como o NULLIF das consultas montadas à mão
+func recentTransaction(row pg.ListRecentTransactionsRow) Transacao {
+	transaction := Transacao{
+		ID:          int64(row.ID),
+		Valor:       Centavos(row.Valor),
+		Tipo:        row.Tipo,
+		Descricao:   row.Descricao,
+		Categoria:   row.Categoria,
+		RealizadaEm: Timestamp{row.RealizadaEm},
+		Seq:         row.Seq,
+		Metadata:    row.Metadata,
+	}
+	if row.SaldoApos != nil {
+		saldoApos := Centavos(*row.SaldoApos)
+		transaction.SaldoApos = &saldoApos
+	}
+	if row.EstornoDe != nil {
+		estornoDe := int64(*row.EstornoDe)
+		transaction.EstornoDe = &estornoDe
+	}
+	if row.ValorEstornado != 0 {
+		valorEstornado := Centavos(row.ValorEstornado)
+		transaction.ValorEstornado = &valorEstornado
+	}
+	return transaction
+}
+
+// getTransaction lê uma transação do cliente. Transações gravadas antes da
+// coluna saldo_apos têm o saldo calculado descontando do saldo atual o
+// efeito das transações posteriores (de id maior), o que exige que as
+// leituras compartilhem o mesmo snapshot.
+func getTransaction(ctx context.Context, db dbtx, clientId int, transactionId int64) (Transacao, error) {
+	queries := pg.New(db)
+	row, err := queries.GetTransaction(ctx, pg.GetTransactionParams{
+		ClienteID: int32(clientId),
+		ID:        int32(transactionId),
+	})
+	transaction := recentTransaction(pg.ListRecentTransactionsRow(row))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return transaction, ErrTransacaoNaoEncontrada
+	}
+	if err != nil || transaction.SaldoApos != nil {
+		return transaction, err
+	}
+
+	later, err := queries.SumTransactionsAfter(ctx, pg.SumTransactionsAfterParams{
+		ClienteID: int32(clientId),
+		ID:        int32(transactionId),
+	})
+	if err != nil {
+		return transaction, err
+	}
+	balance, err := getBalance(ctx, db, clientId)
+	if err != nil {
+		return transaction, err
+	}
+	saldoApos := balance.Saldo - Centavos(later)
+	transaction.SaldoApos = &saldoApos
+	return transaction, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statementCache guarda o JSON já renderizado do extrato de cada cliente por
+// EXTRATO_CACHE_TTL. As escritas da instância invalidam o cliente na hora; as
+// de outras instâncias aparecem no máximo após o TTL. TTL zero desabilita o
+// cache.
+type statementCache struct {
+	// ttl é lido sem o mutex, para que o cache desabilitado não custe nada
+	ttl     atomic.Int64
+	mu      sync.Mutex
+	clients map[statementClient]*cachedStatements
+}
+
+type statementClient struct {
+	tenant   string
+	clientId int
+}
+
+// cachedStatements guarda os extratos de um cliente por variante.
+// generation muda a cada invalidação, para descartar renderizações iniciadas
+// antes de uma escrita.
+type cachedStatements struct {
+	generation uint64
+	entries    map[statementVariant]cachedStatement
+}
+
+// statementVariant distingue os extratos de um mesmo cliente: o filtro de
+// categoria e se a flag totais_por_categoria estava ligada
+type statementVariant struct {
+	category string
+	totals   bool
+}
+
+type cachedStatement struct {
+	body         []byte
+	lastModified time.Time
+	expires      time.Time
+}
+
+var statements = &statementCache{clients: make(map[statementClient]*cachedStatements)}
+
+func init() {
+	onReload(func() {
+		statements.SetTTL(getEnvDuration("EXTRATO_CACHE_TTL", 0))
+	})
+}
+
+// SetTTL troca o TTL dos próximos extratos e descarta os já guardados
+func (c *statementCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl.Store(int64(max(ttl, 0)))
+	for _, cached := range c.clients {
+		cached.generation++
+		clear(cached.entries)
+	}
+}
+
+func (c *statementCache) Enabled() bool {
+	return c.ttl.Load() > 0
+}
+
+// Len conta os extratos guardados
+func (c *statementCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, cached := range c.clients {
+		n += len(cached.entries)
+	}
+	return n
+}
+
+func (c *statementCache) client(key statementClient) *cachedStatements {
+	cached := c.clients[key]
+	if cached == nil {
+		cached = &cachedStatements{entries: make(map[statementVariant]cachedStatement)}
+		c.clients[key] = cached
+	}
+	return cached
+}
+
+// Get devolve o extrato em cache ou, na falta dele, a geração a ser
+// informada em Set.
+func (c *statementCache) Get(key statementClient, variant statementVariant) (cachedStatement, uint64, bool) {
+	if !c.Enabled() {
+		return cachedStatement{}, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := c.client(key)
+	entry, ok := cached.entries[variant]
+	if ok && time.Now().Before(entry.expires) {
+		return entry, cached.generation, true
+	}
+	return cachedStatement{}, cached.generation, false
+}
+
+func (c *statementCache) Set(key statementClient, variant statementVariant, generation uint64, body []byte, lastModified time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := c.client(key)
+	ttl := time.Duration(c.ttl.Load())
+	if ttl <= 0 || cached.generation != generation {
+		return
+	}
+	cached.entries[variant] = cachedStatement{body: body, lastModified: lastModified, expires: time.Now().Add(ttl)}
+}
+
+func (c *statementCache) Invalidate(key statementClient) {
+	if !c.Enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := c.client(key)
+	cached.generation++
+	clear(cached.entries)
+}
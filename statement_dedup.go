@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"strconv"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Agrupamento de extratos (EXTRATO_AGRUPAR): extratos do mesmo cliente, com
+// os mesmos filtros, pedidos enquanto outro igual ainda está no banco
+// recebem o resultado dessa leitura em vez de fazer a sua. Na fase de
+// extratos do teste, quando muitas requisições chegam juntas para os mesmos
+// cinco clientes, isso corta as leituras repetidas. Um extrato agrupado
+// pode não ver uma escrita confirmada depois do início da leitura
+// compartilhada, a mesma janela de duas requisições concorrentes. A
+// leitura não é cancelada se quem a iniciou desistir; os extratos que
+// dividiram uma leitura são contados em statement_dedup.shared no
+// /debug/vars.
+
+var statementDedupEnabled = getEnvBool("EXTRATO_AGRUPAR", false)
+
+var (
+	statementReads  singleflight.Group
+	statementDedups = expvar.NewMap("statement_dedup")
+)
+
+// sharedStatement lê o extrato pelo Storage, compartilhando a leitura com
+// os pedidos iguais em andamento
+func sharedStatement(ctx context.Context, clientId int, filter TransactionFilter) (Extrato, error) {
+	if !statementDedupEnabled {
+		return storageFor(ctx).Statement(ctx, clientId, filter)
+	}
+
+	key := tenantFrom(ctx) + "\x00" + strconv.Itoa(clientId) + "\x00" + filter.Categoria + "\x00" + filter.Metadata
+	if filter.AntesDe != nil {
+		key += "\x00" + filter.AntesDe.String()
+	}
+	if filter.SemTotais {
+		key += "\x00sem-totais"
+	}
+	result := statementReads.DoChan(key, func() (any, error) {
+		readCtx := context.WithoutCancel(ctx)
+		return storageFor(readCtx).Statement(readCtx, clientId, filter)
+	})
+	select {
+	case <-ctx.Done():
+		return Extrato{}, ctx.Err()
+	case r := <-result:
+		if r.Shared {
+			statementDedups.Add("shared", 1)
+		}
+		if r.Err != nil {
+			return Extrato{}, r.Err
+		}
+		return r.Val.(Extrato), nil
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// Extratos mensais com notificação por webhook (EXTRATO_MENSAL_ENABLED, só
+// com o Postgres). Depois da virada do mês, runStatementNotifier grava o
+// extrato do mês fechado de cada cliente com webhook cadastrado no destino
+// EXTRATOS_PATH ou EXTRATOS_S3_* e avisa o cliente com um POST assinado
+// (postSignedWebhook) contendo um link temporário para o arquivo. As
+// entregas que falham são repetidas com backoff exponencial até
+// EXTRATO_WEBHOOK_TENTATIVAS vezes, e o estado de cada uma fica em
+// GET /admin/notificacoes/extrato.
+var statementWebhooksEnabled = getEnvBool("EXTRATO_MENSAL_ENABLED", false)
+
+// StatementWebhookConfig define a frequência do fechamento e das entregas e
+// os links enviados nas notificações
+type StatementWebhookConfig struct {
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	LinkTTL     time.Duration
+	// BaseURL e LinkSecret montam os links assinados de /extratos/ quando o
+	// destino é um diretório local; no S3 os links são pré-assinados
+	BaseURL    string
+	LinkSecret string
+}
+
+func loadStatementWebhookConfig() StatementWebhookConfig {
+	return StatementWebhookConfig{
+		Interval:    getEnvDuration("EXTRATO_MENSAL_INTERVALO", time.Minute),
+		BatchSize:   getEnvInt("EXTRATO_MENSAL_LOTE", 50),
+		MaxAttempts: getEnvInt("EXTRATO_WEBHOOK_TENTATIVAS", 8),
+		LinkTTL:     getEnvDuration("EXTRATOS_LINK_VALIDADE", 24*time.Hour),
+		BaseURL:     strings.TrimSuffix(getEnv("EXTRATOS_URL_BASE", "http://localhost:8080"), "/"),
+		LinkSecret:  getEnv("EXTRATOS_LINK_SEGREDO", ""),
+	}
+}
+
+type WebhookExtratoRequest struct {
+	URL string `json:"url" validate:"required,http_url,max=2048"`
+	// Segredo assina as notificações; sem ele, um é gerado e devolvido
+	Segredo string `json:"segredo,omitempty" validate:"omitempty,min=16,max=64"`
+}
+
+type WebhookExtrato struct {
+	URL string `json:"url"`
+	// Segredo só é devolvido no cadastro
+	Segredo  string    `json:"segredo,omitempty"`
+	CriadoEm Timestamp `json:"criado_em"`
+}
+
+// ExtratoMensal é o arquivo gravado no fechamento do mês
+type ExtratoMensal struct {
+	ClienteID  int         `json:"cliente_id"`
+	Resumo     Resumo      `json:"resumo"`
+	Transacoes []Transacao `json:"transacoes"`
+}
+
+// ExtratoDisponivel são os dados do evento extrato_disponivel
+type ExtratoDisponivel struct {
+	Periodo  string    `json:"periodo"`
+	URL      string    `json:"url"`
+	ExpiraEm Timestamp `json:"expira_em"`
+}
+
+// NotificacaoExtrato é o estado de entrega de uma notificação
+type NotificacaoExtrato struct {
+	ID               int        `json:"id"`
+	ClienteID        int        `json:"cliente_id"`
+	Periodo          string     `json:"periodo"`
+	Status           string     `json:"status"`
+	Tentativas       int        `json:"tentativas"`
+	ProximaTentativa *time.Time `json:"proxima_tentativa,omitempty"`
+	UltimoErro       *string    `json:"ultimo_erro,omitempty"`
+	EntregueEm       *time.Time `json:"entregue_em,omitempty"`
+}
+
+func handleGetStatementWebhook(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	var webhook WebhookExtrato
+	err = poolFor(c.UserContext()).QueryRow(c.UserContext(), `
+		SELECT url, criado_em FROM webhooks_extrato WHERE cliente_id = $1`, clientId).
+		Scan(&webhook.URL, &webhook.CriadoEm)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(webhook)
+}
+
+// handleSetStatementWebhook cadastra ou troca o webhook do cliente. O
+// segredo é devolvido só aqui; quem o perder cadastra o webhook de novo.
+func handleSetStatementWebhook(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	request := new(WebhookExtratoRequest)
+	if err := bindJSON(c, request); err != nil {
+		return sendBindError(c, err)
+	}
+	if request.Segredo == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return c.SendStatus(fiber.ErrInternalServerError.Code)
+		}
+		request.Segredo = hex.EncodeToString(secret)
+	}
+
+	webhook := WebhookExtrato{URL: request.URL, Segredo: request.Segredo}
+	err = poolFor(c.UserContext()).QueryRow(c.UserContext(), `
+		INSERT INTO webhooks_extrato (cliente_id, url, segredo) VALUES ($1, $2, $3)
+		ON CONFLICT (cliente_id) DO UPDATE SET url = EXCLUDED.url, segredo = EXCLUDED.segredo, criado_em = NOW()
+		RETURNING criado_em`,
+		clientId, request.URL, request.Segredo).Scan(&webhook.CriadoEm)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(webhook)
+}
+
+func handleDeleteStatementWebhook(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	tag, err := poolFor(c.UserContext()).Exec(c.UserContext(), `
+		DELETE FROM webhooks_extrato WHERE cliente_id = $1`, clientId)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if tag.RowsAffected() == 0 {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleListStatementNotifications lista as últimas 100 notificações, com os
+// filtros ?status= e ?cliente_id=
+func handleListStatementNotifications(c fiber.Ctx) error {
+	var clientId *int
+	if value := c.Query("cliente_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		clientId = &id
+	}
+	var status *string
+	if value := c.Query("status"); value != "" {
+		status = &value
+	}
+
+	rows, err := poolFor(c.UserContext()).Query(c.UserContext(), `
+		SELECT n.id, e.cliente_id, to_char(e.periodo, 'YYYY-MM'), n.status, n.tentativas,
+			CASE WHEN n.status = 'pendente' THEN n.proxima_tentativa END, n.ultimo_erro, n.entregue_em
+		FROM notificacoes_extrato n
+		JOIN extratos_mensais e ON e.id = n.extrato_id
+		WHERE ($1::int IS NULL OR e.cliente_id = $1) AND ($2::text IS NULL OR n.status = $2)
+		ORDER BY n.id DESC
+		LIMIT 100`, clientId, status)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	notifications, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (NotificacaoExtrato, error) {
+		var n NotificacaoExtrato
+		err := row.Scan(&n.ID, &n.ClienteID, &n.Periodo, &n.Status, &n.Tentativas,
+			&n.ProximaTentativa, &n.UltimoErro, &n.EntregueEm)
+		return n, err
+	})
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(notifications)
+}
+
+// handleRetryStatementNotification volta uma notificação para a fila, com
+// as tentativas zeradas
+func handleRetryStatementNotification(c fiber.Ctx) error {
+	id, err := c.ParamsInt("notificacao_id")
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	tag, err := poolFor(c.UserContext()).Exec(c.UserContext(), `
+		UPDATE notificacoes_extrato
+		SET status = 'pendente', tentativas = 0, proxima_tentativa = NOW()
+		WHERE id = $1`, id)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	if tag.RowsAffected() == 0 {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// handleDownloadStatement serve os extratos de um destino local pelos links
+// assinados de statementLink
+func handleDownloadStatement(store localBlobStore, config StatementWebhookConfig) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		key := c.Params("*")
+		expires, err := strconv.ParseInt(c.Query("expira"), 10, 64)
+		if err != nil || strings.Contains(key, "..") || config.LinkSecret == "" {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		signature, err := hex.DecodeString(c.Query("assinatura"))
+		if err != nil || subtle.ConstantTimeCompare(signature, signStatementLink(config.LinkSecret, key, expires)) != 1 {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+		if nowFor(c.UserContext()).Unix() > expires {
+			return c.SendStatus(fiber.StatusGone)
+		}
+
+		f, err := os.Open(store.Location(key))
+		if errors.Is(err, os.ErrNotExist) {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		if err != nil {
+			return c.SendStatus(fiber.ErrInternalServerError.Code)
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.SendStream(f)
+	}
+}
+
+func signStatementLink(secret, key string, expires int64) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(key + "." + strconv.FormatInt(expires, 10)))
+	return mac.Sum(nil)
+}
+
+// statementLink monta o link temporário do extrato: pré-assinado pelo S3 ou,
+// no destino local, assinado com EXTRATOS_LINK_SEGREDO para /extratos/
+func statementLink(ctx context.Context, store blobStore, config StatementWebhookConfig, key string) (string, time.Time, error) {
+	expires := nowFor(ctx).Add(config.LinkTTL).Truncate(time.Second)
+	if presigner, ok := store.(blobPresigner); ok {
+		link, err := presigner.PresignedURL(ctx, key, config.LinkTTL)
+		return link, expires, err
+	}
+	if config.LinkSecret == "" {
+		return "", expires, errors.New("EXTRATOS_LINK_SEGREDO must be set to link local statements")
+	}
+	signature := signStatementLink(config.LinkSecret, key, expires.Unix())
+	link := config.BaseURL + "/extratos/" + key +
+		"?expira=" + strconv.FormatInt(expires.Unix(), 10) +
+		"&assinatura=" + hex.EncodeToString(signature)
+	return link, expires, nil
+}
+
+// runStatementNotifier fecha os extratos do mês anterior e entrega as
+// notificações pendentes a cada config.Interval
+func runStatementNotifier(ctx context.Context, store blobStore, config StatementWebhookConfig) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := closeMonthlyStatements(ctx, store); err != nil && !errors.Is(err, context.Canceled) {
+				log.Print("Error closing monthly statements: ", err)
+			}
+			for {
+				delivered, err := deliverStatementNotifications(ctx, store, config)
+				if err != nil {
+					if !errors.Is(err, context.Canceled) {
+						log.Print("Error delivering statement notifications: ", err)
+					}
+					break
+				}
+				if delivered < config.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// closeMonthlyStatements gera o extrato do mês anterior para os clientes
+// com webhook que ainda não o têm
+func closeMonthlyStatements(ctx context.Context, store blobStore) error {
+	now := nowFor(ctx).UTC()
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, -1, 0)
+
+	rows, err := poolFor(ctx).Query(ctx, `
+		SELECT w.cliente_id FROM webhooks_extrato w
+		WHERE NOT EXISTS (
+			SELECT 1 FROM extratos_mensais e WHERE e.cliente_id = w.cliente_id AND e.periodo = $1
+		)`, from)
+	if err != nil {
+		return err
+	}
+	clients, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+	for _, clientId := range clients {
+		if err := closeMonthlyStatement(ctx, store, clientId, from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeMonthlyStatement grava o extrato de [from, to) e, na mesma transação
+// que o registra em extratos_mensais, a notificação pendente. Um arquivo
+// gravado por um fechamento que falhou no commit é sobrescrito no próximo.
+func closeMonthlyStatement(ctx context.Context, store blobStore, clientId int, from, to time.Time) error {
+	summary, err := storageFor(ctx).Summary(ctx, clientId, from, to)
+	if err != nil {
+		return err
+	}
+	summary.Periodo = from.Format("2006-01")
+
+	rows, err := poolFor(ctx).Query(ctx, `
+		SELECT id, valor, tipo, descricao, categoria, realizada_em, saldo_apos, seq, estorno_de, NULLIF(valor_estornado, 0), metadata
+		FROM transacoes WHERE cliente_id = $1 AND realizada_em >= $2 AND realizada_em < $3
+		ORDER BY realizada_em, seq`, clientId, from, to)
+	if err != nil {
+		return err
+	}
+	transactions, err := pgx.AppendRows([]Transacao{}, rows, scanTransaction)
+	if err != nil {
+		return err
+	}
+	body, err := jsonMarshal(ExtratoMensal{ClienteID: clientId, Resumo: summary, Transacoes: transactions})
+	if err != nil {
+		return err
+	}
+
+	key := tenantKeyPrefix(ctx) + "extratos/" + strconv.Itoa(clientId) + "/" + summary.Periodo + ".json"
+	if err := store.Put(ctx, key, bytes.NewReader(body), int64(len(body)), fiber.MIMEApplicationJSON); err != nil {
+		return err
+	}
+
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, `
+		WITH extrato AS (
+			INSERT INTO extratos_mensais (cliente_id, periodo, chave) VALUES ($1, $2, $3)
+			ON CONFLICT (cliente_id, periodo) DO NOTHING
+			RETURNING id
+		)
+		INSERT INTO notificacoes_extrato (extrato_id) SELECT id FROM extrato`,
+		clientId, from, key)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// deliverStatementNotifications entrega um lote de notificações vencidas. O
+// link vai novo em cada tentativa, para não chegar já expirado; o id da
+// notificação vai em Idempotency-Key para o destino descartar repetições.
+// Notificações de clientes que removeram o webhook ficam pendentes até um
+// novo cadastro.
+func deliverStatementNotifications(ctx context.Context, store blobStore, config StatementWebhookConfig) (int, error) {
+	tx, err := poolFor(ctx).Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	type pending struct {
+		id, attempts, clientId int
+		period                 time.Time
+		key, url, secret       string
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT n.id, n.tentativas, e.cliente_id, e.periodo, e.chave, w.url, w.segredo
+		FROM notificacoes_extrato n
+		JOIN extratos_mensais e ON e.id = n.extrato_id
+		JOIN webhooks_extrato w ON w.cliente_id = e.cliente_id
+		WHERE n.status = 'pendente' AND n.proxima_tentativa <= NOW()
+		ORDER BY n.proxima_tentativa
+		LIMIT $1
+		FOR UPDATE OF n SKIP LOCKED`, config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	notifications, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (pending, error) {
+		var n pending
+		err := row.Scan(&n.id, &n.attempts, &n.clientId, &n.period, &n.key, &n.url, &n.secret)
+		return n, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, n := range notifications {
+		link, expires, err := statementLink(ctx, store, config, n.key)
+		if err == nil {
+			err = postSignedWebhook(ctx, n.url, n.secret, Evento{
+				ID:        int64(n.id),
+				Tipo:      "extrato_disponivel",
+				Tenant:    tenantFrom(ctx),
+				ClienteID: n.clientId,
+				Dados:     ExtratoDisponivel{Periodo: n.period.Format("2006-01"), URL: link, ExpiraEm: Timestamp{expires}},
+				CriadoEm:  nowFor(ctx),
+			})
+		}
+		if err == nil {
+			_, err = tx.Exec(ctx, `
+				UPDATE notificacoes_extrato
+				SET status = 'entregue', tentativas = tentativas + 1, entregue_em = NOW(), ultimo_erro = NULL
+				WHERE id = $1`, n.id)
+		} else {
+			status := "pendente"
+			if n.attempts+1 >= config.MaxAttempts {
+				status = "falhou"
+			}
+			backoff := min(time.Minute<<n.attempts, 6*time.Hour)
+			_, err = tx.Exec(ctx, `
+				UPDATE notificacoes_extrato
+				SET status = $2, tentativas = tentativas + 1, ultimo_erro = $3,
+					proxima_tentativa = NOW() + make_interval(secs => $4)
+				WHERE id = $1`, n.id, status, err.Error(), backoff.Seconds())
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(notifications), tx.Commit(ctx)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/sync/errgroup"
+)
+
+var ErrLimiteExcedido = errors.New("limite excedido")
+
+var ErrTransacaoNaoEncontrada = errors.New("transação não encontrada")
+
+// Storage abstrai o armazenamento usado pelas rotas principais da API
+// (transações e extrato). Os recursos auxiliares — agendamentos, categorias,
+// exports e backups — dependem do Postgres e só são habilitados com STORAGE=postgres.
+type Storage interface {
+	CreateTransaction(ctx context.Context, clientId int, transaction *TransacaoRequest) (Balance, error)
+	GetBalance(ctx context.Context, clientId int) (Balance, error)
+	ListTransactions(ctx context.Context, clientId int, filter TransactionFilter) ([]Transacao, error)
+	// GetTransaction devolve uma transação do cliente com o saldo logo após
+	// ela, ou ErrTransacaoNaoEncontrada.
+	GetTransaction(ctx context.Context, clientId int, transactionId int64) (Transacao, error)
+	// Summary agrega as transações do cliente em [from, to) e calcula o
+	// saldo ao fim do período.
+	Summary(ctx context.Context, clientId int, from, to time.Time) (Resumo, error)
+	// Statement lê saldo, transações e totais por categoria em um único
+	// snapshot, para que o extrato nunca misture estados de escritas concorrentes.
+	Statement(ctx context.Context, clientId int, filter TransactionFilter) (Extrato, error)
+	// ClientIDs lista os ids da tabela clientes, ver clientDirectory
+	ClientIDs(ctx context.Context) ([]int, error)
+}
+
+// Extrato reúne os dados do extrato de um cliente lidos no mesmo snapshot
+type Extrato struct {
+	Saldo      Balance
+	Transacoes []Transacao
+	Totais     []TotalCategoria
+	// Parcelamentos só é lido com PARCELAMENTO_ENABLED
+	Parcelamentos []Parcelamento
+}
+
+type postgresStorage struct {
+	pool *pgxpool.Pool
+}
+
+// db devolve o pool do tenant da requisição, ou o pool padrão sem tenancy
+func (s postgresStorage) db(ctx context.Context) *pgxpool.Pool {
+	if pool := appFrom(ctx).tenantPools[tenantFrom(ctx)]; pool != nil {
+		return pool
+	}
+	return s.pool
+}
+
+func (s postgresStorage) CreateTransaction(ctx context.Context, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	return createTransaction(ctx, s.db(ctx), clientId, transaction)
+}
+
+func (s postgresStorage) GetBalance(ctx context.Context, clientId int) (Balance, error) {
+	return getBalance(ctx, s.db(ctx), clientId)
+}
+
+func (s postgresStorage) ClientIDs(ctx context.Context) ([]int, error) {
+	rows, err := s.db(ctx).Query(ctx, "SELECT id FROM clientes")
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int])
+}
+
+func (s postgresStorage) ListTransactions(ctx context.Context, clientId int, filter TransactionFilter) ([]Transacao, error) {
+	return listTransactions(ctx, s.db(ctx), clientId, filter)
+}
+
+func (s postgresStorage) GetTransaction(ctx context.Context, clientId int, transactionId int64) (Transacao, error) {
+	tx, err := s.db(ctx).BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return Transacao{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	transaction, err := getTransaction(ctx, tx, clientId, transactionId)
+	if err != nil {
+		return transaction, err
+	}
+	return transaction, tx.Commit(ctx)
+}
+
+// parallelStatementEnabled (EXTRATO_PARALELO) divide as consultas do extrato
+// entre duas conexões, ver parallelStatement. Com DB_SIMPLE_PROTOCOL (atrás
+// do pgbouncer em modo transação) fica desligado: o snapshot exportado só
+// vale na conexão do servidor que o exportou, e o pgbouncer pode entregar
+// outra.
+var (
+	parallelStatementEnabled = getEnvBool("EXTRATO_PARALELO", false)
+	parallelStatementWait    = getEnvDuration("EXTRATO_PARALELO_ESPERA", 5*time.Millisecond)
+	parallelStatements       = expvar.NewMap("parallel_statement")
+)
+
+// errNoSpareConn indica que o pool não tinha as duas conexões do extrato
+// paralelo a tempo
+var errNoSpareConn = errors.New("no spare connection for the parallel statement")
+
+func (s postgresStorage) Statement(ctx context.Context, clientId int, filter TransactionFilter) (Extrato, error) {
+	if parallelStatementEnabled && !simpleProtocol {
+		statement, err := s.parallelStatement(ctx, clientId, filter)
+		if !errors.Is(err, errNoSpareConn) {
+			parallelStatements.Add("parallel", 1)
+			return statement, err
+		}
+		parallelStatements.Add("fallback", 1)
+	}
+	return s.serialStatement(ctx, clientId, filter)
+}
+
+func (s postgresStorage) serialStatement(ctx context.Context, clientId int, filter TransactionFilter) (Extrato, error) {
+	var statement Extrato
+
+	tx, err := s.db(ctx).BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return statement, err
+	}
+	defer tx.Rollback(ctx)
+
+	if statement.Saldo, err = getBalance(ctx, tx, clientId); err != nil {
+		return statement, err
+	}
+	if statement.Transacoes, err = listTransactions(ctx, tx, clientId, filter); err != nil {
+		return statement, err
+	}
+	if !filter.SemTotais {
+		if statement.Totais, err = categoryTotals(ctx, tx, clientId, filter.Categoria); err != nil {
+			return statement, err
+		}
+	}
+	if installmentsEnabled {
+		if statement.Parcelamentos, err = openInstallments(ctx, tx, clientId); err != nil {
+			return statement, err
+		}
+	}
+	return statement, tx.Commit(ctx)
+}
+
+// acquirePair pega as duas conexões do extrato paralelo antes de abrir
+// qualquer transação, esperando no máximo parallelStatementWait por elas.
+// Quem segura uma conexão nunca espera indefinidamente pela outra, então
+// extratos simultâneos não esgotam o pool entre si; sem as duas a tempo,
+// devolve errNoSpareConn e o extrato segue pelo caminho serial.
+func acquirePair(ctx context.Context, pool *pgxpool.Pool) (*pgxpool.Conn, *pgxpool.Conn, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, parallelStatementWait)
+	defer cancel()
+	first, err := pool.Acquire(waitCtx)
+	if err != nil {
+		return nil, nil, acquireError(ctx, err)
+	}
+	second, err := pool.Acquire(waitCtx)
+	if err != nil {
+		first.Release()
+		return nil, nil, acquireError(ctx, err)
+	}
+	return first, second, nil
+}
+
+func acquireError(ctx context.Context, err error) error {
+	if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		return errNoSpareConn
+	}
+	return err
+}
+
+// parallelStatement lê o saldo e os totais numa transação e as transações e
+// os parcelamentos noutra, ao mesmo tempo. A segunda adota o snapshot
+// exportado pela primeira (pg_export_snapshot), então o extrato continua
+// vindo de um único estado do banco; o custo é uma segunda conexão do pool
+// por extrato.
+func (s postgresStorage) parallelStatement(ctx context.Context, clientId int, filter TransactionFilter) (Extrato, error) {
+	var statement Extrato
+	options := pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}
+
+	first, second, err := acquirePair(ctx, s.db(ctx))
+	if err != nil {
+		return statement, err
+	}
+	defer first.Release()
+	defer second.Release()
+
+	tx, err := first.BeginTx(ctx, options)
+	if err != nil {
+		return statement, err
+	}
+	defer tx.Rollback(ctx)
+	var snapshot string
+	if err := tx.QueryRow(ctx, "SELECT pg_export_snapshot()").Scan(&snapshot); err != nil {
+		return statement, err
+	}
+
+	shared, err := second.BeginTx(ctx, options)
+	if err != nil {
+		return statement, err
+	}
+	defer shared.Rollback(ctx)
+	if _, err := shared.Exec(ctx, "SET TRANSACTION SNAPSHOT '"+snapshot+"'"); err != nil {
+		return statement, err
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		var err error
+		if statement.Saldo, err = getBalance(groupCtx, tx, clientId); err != nil {
+			return err
+		}
+		if !filter.SemTotais {
+			statement.Totais, err = categoryTotals(groupCtx, tx, clientId, filter.Categoria)
+		}
+		return err
+	})
+	group.Go(func() error {
+		var err error
+		if statement.Transacoes, err = listTransactions(groupCtx, shared, clientId, filter); err != nil {
+			return err
+		}
+		if installmentsEnabled {
+			statement.Parcelamentos, err = openInstallments(groupCtx, shared, clientId)
+		}
+		return err
+	})
+	if err := group.Wait(); err != nil {
+		return statement, err
+	}
+	return statement, tx.Commit(ctx)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// unlockScript só remove o bloqueio se ele ainda pertencer a quem o adquiriu,
+// para que um bloqueio expirado e readquirido por outra instância não seja
+// liberado por engano.
+var unlockScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0`)
+
+// redisStorage decora outro Storage com um cache de saldo compartilhado
+// entre as instâncias e um bloqueio distribuído por cliente (SET NX com TTL)
+// nas escritas. O banco continua sendo a fonte da verdade: falhas do Redis
+// só fazem a requisição seguir direto para o Storage decorado.
+type redisStorage struct {
+	Storage
+	client *redis.Client
+
+	// durações recarregáveis, em nanossegundos
+	cacheTTL    atomic.Int64
+	lockTTL     atomic.Int64
+	lockTimeout atomic.Int64
+}
+
+func newRedisStorage(ctx context.Context, inner Storage, url string) (*redisStorage, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(options)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	s := &redisStorage{Storage: inner, client: client}
+	onReload(func() {
+		s.cacheTTL.Store(int64(getEnvDuration("REDIS_CACHE_TTL", time.Second)))
+		s.lockTTL.Store(int64(getEnvDuration("REDIS_LOCK_TTL", 2*time.Second)))
+		s.lockTimeout.Store(int64(getEnvDuration("REDIS_LOCK_TIMEOUT", time.Second)))
+	})
+	return s, nil
+}
+
+func (s *redisStorage) key(ctx context.Context, kind string, clientId int) string {
+	return "rinha:" + tenantKeyPrefix(ctx) + kind + ":" + strconv.Itoa(clientId)
+}
+
+func (s *redisStorage) GetBalance(ctx context.Context, clientId int) (Balance, error) {
+	if !featureEnabled(ctx, "redis_cache") {
+		return s.Storage.GetBalance(ctx, clientId)
+	}
+	key := s.key(ctx, "saldo", clientId)
+	values, err := s.client.HMGet(ctx, key, "saldo", "limite").Result()
+	if err == nil && values[0] != nil && values[1] != nil {
+		saldo, errSaldo := strconv.ParseInt(values[0].(string), 10, 64)
+		limite, errLimite := strconv.ParseInt(values[1].(string), 10, 64)
+		if errSaldo == nil && errLimite == nil {
+			return Balance{Saldo: Centavos(saldo), Limite: Centavos(limite)}, nil
+		}
+	}
+
+	balance, err := s.Storage.GetBalance(ctx, clientId)
+	if err != nil {
+		return balance, err
+	}
+	s.cacheBalance(ctx, clientId, balance)
+	return balance, nil
+}
+
+func (s *redisStorage) CreateTransaction(ctx context.Context, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	unlock, err := s.lock(ctx, clientId)
+	if errors.Is(err, ErrConflitoConcorrencia) || ctx.Err() != nil {
+		return Balance{}, err
+	}
+	if err != nil {
+		log.Print("Error locking client in redis, continuing without lock: ", err)
+		unlock = func() {}
+	}
+	defer unlock()
+
+	balance, err := s.Storage.CreateTransaction(ctx, clientId, transaction)
+	if err != nil {
+		return balance, err
+	}
+	// atualizado ainda com o bloqueio, então a ordem das escritas no cache
+	// acompanha a ordem das escritas no banco
+	s.cacheBalance(ctx, clientId, balance)
+	return balance, nil
+}
+
+func (s *redisStorage) cacheBalance(ctx context.Context, clientId int, balance Balance) {
+	key := s.key(ctx, "saldo", clientId)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "saldo", balance.Saldo.Int64String(), "limite", balance.Limite.Int64String())
+		pipe.PExpire(ctx, key, time.Duration(s.cacheTTL.Load()))
+		return nil
+	})
+	if err != nil {
+		log.Print("Error caching balance in redis: ", err)
+	}
+}
+
+// forgetCachedBalance apaga o saldo em cache do cliente depois de uma escrita
+// que foi direto ao banco, sem passar pelo Storage (estornos, capturas,
+// parcelamentos e agendamentos); a próxima leitura o busca no banco
+func forgetCachedBalance(ctx context.Context, clientId int) {
+	s := appFrom(ctx).redis
+	if s == nil {
+		return
+	}
+	if err := s.client.Del(ctx, s.key(ctx, "saldo", clientId)).Err(); err != nil {
+		log.Print("Error removing cached balance from redis: ", err)
+	}
+}
+
+// lock adquire o bloqueio do cliente, tentando novamente até lockTimeout.
+// Esgotado o prazo, devolve ErrConflitoConcorrencia.
+func (s *redisStorage) lock(ctx context.Context, clientId int) (func(), error) {
+	key := s.key(ctx, "lock", clientId)
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	value := hex.EncodeToString(token)
+
+	deadline := time.Now().Add(time.Duration(s.lockTimeout.Load()))
+	for wait := time.Millisecond; ; wait = min(wait*2, 50*time.Millisecond) {
+		acquired, err := s.client.SetNX(ctx, key, value, time.Duration(s.lockTTL.Load())).Result()
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			return func() {
+				if err := unlockScript.Run(context.Background(), s.client, []string{key}, value).Err(); err != nil {
+					log.Print("Error unlocking client in redis: ", err)
+				}
+			}, nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return nil, ErrConflitoConcorrencia
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// TestRefundForgetsCachedBalance confere que o estorno, que grava direto no
+// banco, não deixa no Redis o saldo de antes dele
+func TestRefundForgetsCachedBalance(t *testing.T) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		t.Skip("REDIS_URL not set")
+	}
+	app := postgresTestApp(t)
+	ctx := withApp(context.Background(), app)
+	var err error
+	if app.redis, err = newRedisStorage(ctx, app.storage, url); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.redis.client.Close() })
+	app.storage = app.redis
+
+	debit := &TransacaoRequest{Valor: 100, Tipo: TipoDebito, Descricao: "cache"}
+	if _, err := app.storage.CreateTransaction(ctx, 1, debit); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.storage.GetBalance(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	refund, err := refundTransaction(ctx, 1, debit.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := app.storage.GetBalance(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Saldo != refund.Saldo {
+		t.Errorf("balance %d after the refund, want %d", balance.Saldo, refund.Saldo)
+	}
+}
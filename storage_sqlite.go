@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS clientes (
+	id INTEGER PRIMARY KEY,
+	nome TEXT NOT NULL,
+	limite INTEGER NOT NULL,
+	saldo INTEGER NOT NULL DEFAULT 0,
+	ultima_seq INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS categorias (
+	nome TEXT PRIMARY KEY,
+	descricao TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS transacoes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	cliente_id INTEGER NOT NULL REFERENCES clientes(id),
+	valor INTEGER NOT NULL,
+	tipo TEXT NOT NULL,
+	descricao TEXT NOT NULL CHECK (length(CAST(descricao AS BLOB)) <= 40),
+	categoria TEXT REFERENCES categorias(nome),
+	realizada_em INTEGER NOT NULL,
+	saldo_apos INTEGER,
+	seq INTEGER,
+	metadata TEXT
+);
+
+CREATE INDEX IF NOT EXISTS indice_transacoes_cliente ON transacoes (cliente_id, realizada_em DESC);
+
+INSERT OR IGNORE INTO clientes (id, nome, limite) VALUES
+	(1, 'o barato sai caro', 1000 * 100),
+	(2, 'zan corp ltda', 800 * 100),
+	(3, 'les cruders', 10000 * 100),
+	(4, 'padaria joia de cocaia', 100000 * 100),
+	(5, 'kid mais', 5000 * 100);
+`
+
+// sqliteStorage implementa Storage sobre SQLite (modernc.org/sqlite, sem
+// cgo) em modo WAL, para rodar a API sem nenhum serviço externo. As
+// transações de escrita usam BEGIN IMMEDIATE, então débitos concorrentes
+// do mesmo cliente são serializados pelo próprio SQLite.
+type sqliteStorage struct {
+	db *sql.DB
+}
+
+// sqlQuerier é satisfeita por *sql.DB e *sql.Tx
+type sqlQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func newSQLiteStorage(ctx context.Context, path string) (*sqliteStorage, error) {
+	dsn := "file:" + path +
+		"?_pragma=journal_mode(WAL)" +
+		"&_pragma=busy_timeout(5000)" +
+		"&_pragma=foreign_keys(1)" +
+		"&_pragma=synchronous(NORMAL)" +
+		"&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// bancos criados antes das colunas saldo_apos, seq e metadata
+	for _, alter := range []string{
+		"ALTER TABLE transacoes ADD COLUMN saldo_apos INTEGER",
+		"ALTER TABLE transacoes ADD COLUMN seq INTEGER",
+		"ALTER TABLE clientes ADD COLUMN ultima_seq INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE transacoes ADD COLUMN metadata TEXT",
+	} {
+		_, err = db.ExecContext(ctx, alter)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			db.Close()
+			return nil, err
+		}
+		if err == nil {
+			startup.migration("sqlite: " + alter)
+		}
+	}
+	// depende da coluna seq, então vem depois das alterações
+	if _, err := db.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS indice_transacoes_seq ON transacoes (cliente_id, seq) WHERE seq IS NOT NULL"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStorage{db: db}, nil
+}
+
+func (s *sqliteStorage) CreateTransaction(ctx context.Context, clientId int, transaction *TransacaoRequest) (Balance, error) {
+	var balance Balance
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return balance, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, "SELECT saldo, limite FROM clientes WHERE id = ?", clientId).
+		Scan(&balance.Saldo, &balance.Limite)
+	if err != nil {
+		return balance, err
+	}
+
+	balance.Saldo, err = applyToBalance(balance, transaction)
+	if err != nil {
+		return balance, err
+	}
+
+	var seq int64
+	err = tx.QueryRowContext(ctx, "UPDATE clientes SET saldo = ?, ultima_seq = ultima_seq + 1 WHERE id = ? RETURNING ultima_seq",
+		balance.Saldo, clientId).Scan(&seq)
+	if err != nil {
+		return balance, err
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO transacoes (valor, tipo, descricao, cliente_id, categoria, realizada_em, saldo_apos, seq, metadata)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?, NULLIF(?, ''))`,
+		transaction.Valor,
+		transaction.Tipo,
+		transaction.Descricao,
+		clientId,
+		transaction.Categoria,
+		nowFor(ctx).UnixMicro(),
+		balance.Saldo,
+		seq,
+		string(transaction.Metadata))
+	if err != nil {
+		return balance, err
+	}
+	if transaction.ID, err = result.LastInsertId(); err != nil {
+		return balance, err
+	}
+	return balance, tx.Commit()
+}
+
+func (s *sqliteStorage) GetBalance(ctx context.Context, clientId int) (Balance, error) {
+	return sqliteBalance(ctx, s.db, clientId)
+}
+
+func (s *sqliteStorage) ClientIDs(ctx context.Context) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM clientes")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *sqliteStorage) ListTransactions(ctx context.Context, clientId int, filter TransactionFilter) ([]Transacao, error) {
+	return sqliteTransactions(ctx, s.db, clientId, filter)
+}
+
+func (s *sqliteStorage) GetTransaction(ctx context.Context, clientId int, transactionId int64) (Transacao, error) {
+	var transaction Transacao
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return transaction, err
+	}
+	defer tx.Rollback()
+
+	var category, metadata sql.NullString
+	var realizadaEm int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, valor, tipo, descricao, categoria, realizada_em, saldo_apos, seq, metadata
+		FROM transacoes WHERE cliente_id = ? AND id = ?`,
+		clientId, transactionId).Scan(
+		&transaction.ID,
+		&transaction.Valor,
+		&transaction.Tipo,
+		&transaction.Descricao,
+		&category,
+		&realizadaEm,
+		&transaction.SaldoApos,
+		&transaction.Seq,
+		&metadata,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return transaction, ErrTransacaoNaoEncontrada
+	}
+	if err != nil {
+		return transaction, err
+	}
+	if category.Valid {
+		transaction.Categoria = &category.String
+	}
+	if metadata.Valid {
+		transaction.Metadata = json.RawMessage(metadata.String)
+	}
+	transaction.RealizadaEm = Timestamp{time.UnixMicro(realizadaEm).UTC()}
+	if transaction.SaldoApos != nil {
+		return transaction, tx.Commit()
+	}
+
+	// transações gravadas antes da coluna saldo_apos
+	var later Centavos
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(CASE WHEN tipo = 'c' THEN valor ELSE -valor END), 0)
+		FROM transacoes WHERE cliente_id = ? AND id > ?`,
+		clientId, transactionId).Scan(&later)
+	if err != nil {
+		return transaction, err
+	}
+	balance, err := sqliteBalance(ctx, tx, clientId)
+	if err != nil {
+		return transaction, err
+	}
+	saldoApos := balance.Saldo - later
+	transaction.SaldoApos = &saldoApos
+	return transaction, tx.Commit()
+}
+
+func (s *sqliteStorage) Summary(ctx context.Context, clientId int, from, to time.Time) (Resumo, error) {
+	var summary Resumo
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return summary, err
+	}
+	defer tx.Rollback()
+
+	var later Centavos
+	err = tx.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(CASE WHEN tipo = 'c' AND realizada_em < ?2 THEN valor END), 0),
+			COALESCE(SUM(CASE WHEN tipo = 'd' AND realizada_em < ?2 THEN valor END), 0),
+			COALESCE(MAX(CASE WHEN realizada_em < ?2 THEN valor END), 0),
+			COUNT(CASE WHEN realizada_em < ?2 THEN 1 END),
+			COALESCE(SUM(CASE WHEN realizada_em < ?2 THEN 0 WHEN tipo = 'c' THEN valor ELSE -valor END), 0)
+		FROM transacoes
+		WHERE cliente_id = ?3 AND realizada_em >= ?1`,
+		from.UnixMicro(), to.UnixMicro(), clientId).Scan(
+		&summary.Creditos,
+		&summary.Debitos,
+		&summary.MaiorTransacao,
+		&summary.Quantidade,
+		&later,
+	)
+	if err != nil {
+		return summary, err
+	}
+	balance, err := sqliteBalance(ctx, tx, clientId)
+	if err != nil {
+		return summary, err
+	}
+	summary.SaldoFinal = balance.Saldo - later
+	return summary, tx.Commit()
+}
+
+func (s *sqliteStorage) Statement(ctx context.Context, clientId int, filter TransactionFilter) (Extrato, error) {
+	var statement Extrato
+
+	// transações somente leitura usam BEGIN DEFERRED, mantendo um snapshot do WAL
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return statement, err
+	}
+	defer tx.Rollback()
+
+	if statement.Saldo, err = sqliteBalance(ctx, tx, clientId); err != nil {
+		return statement, err
+	}
+	if statement.Transacoes, err = sqliteTransactions(ctx, tx, clientId, filter); err != nil {
+		return statement, err
+	}
+	if !filter.SemTotais {
+		if statement.Totais, err = sqliteCategoryTotals(ctx, tx, clientId, filter.Categoria); err != nil {
+			return statement, err
+		}
+	}
+	return statement, tx.Commit()
+}
+
+func sqliteBalance(ctx context.Context, q sqlQuerier, clientId int) (Balance, error) {
+	var balance Balance
+	err := q.QueryRowContext(ctx, "SELECT saldo, limite FROM clientes WHERE id = ?", clientId).
+		Scan(&balance.Saldo, &balance.Limite)
+	return balance, err
+}
+
+func sqliteTransactions(ctx context.Context, q sqlQuerier, clientId int, filter TransactionFilter) ([]Transacao, error) {
+	var query strings.Builder
+	args := []any{clientId}
+	query.WriteString(`
+		SELECT id, valor, tipo, descricao, categoria, realizada_em, saldo_apos, seq, metadata
+		FROM transacoes WHERE cliente_id = ?`)
+	if filter.Tipo != "" {
+		query.WriteString(" AND tipo = ?")
+		args = append(args, filter.Tipo)
+	}
+	if filter.Categoria != "" {
+		query.WriteString(" AND categoria = ?")
+		args = append(args, filter.Categoria)
+	}
+	keys, values := metadataFilterPairs(filter.Metadata)
+	for i, key := range keys {
+		query.WriteString(" AND json_extract(metadata, ?) = ?")
+		args = append(args, `$."`+key+`"`, values[i])
+	}
+	if filter.Desde != nil {
+		query.WriteString(" AND realizada_em >= ?")
+		args = append(args, filter.Desde.UnixMicro())
+	}
+	if filter.Ate != nil {
+		query.WriteString(" AND realizada_em < ?")
+		args = append(args, filter.Ate.UnixMicro())
+	}
+	if filter.AntesDe != nil {
+		query.WriteString(" AND (realizada_em, seq) < (?, ?)")
+		args = append(args, filter.AntesDe.RealizadaEm.UnixMicro(), filter.AntesDe.Seq)
+	}
+	if filter.DesdeSeq != nil {
+		query.WriteString(" AND seq > ?")
+		args = append(args, *filter.DesdeSeq)
+	}
+
+	limit := filter.pageLimit()
+	if filter.DesdeSeq != nil {
+		query.WriteString(filter.orderBy())
+	} else {
+		query.WriteString(" ORDER BY realizada_em DESC, seq DESC, id DESC")
+	}
+	query.WriteString(" LIMIT " + strconv.Itoa(limit))
+
+	rows, err := q.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := make([]Transacao, 0, limit)
+	for rows.Next() {
+		var transaction Transacao
+		var category, metadata sql.NullString
+		var realizadaEm int64
+		err := rows.Scan(
+			&transaction.ID,
+			&transaction.Valor,
+			&transaction.Tipo,
+			&transaction.Descricao,
+			&category,
+			&realizadaEm,
+			&transaction.SaldoApos,
+			&transaction.Seq,
+			&metadata,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if category.Valid {
+			transaction.Categoria = &category.String
+		}
+		if metadata.Valid {
+			transaction.Metadata = json.RawMessage(metadata.String)
+		}
+		transaction.RealizadaEm = Timestamp{time.UnixMicro(realizadaEm).UTC()}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, rows.Err()
+}
+
+func sqliteCategoryTotals(ctx context.Context, q sqlQuerier, clientId int, category string) ([]TotalCategoria, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT categoria,
+			COALESCE(SUM(CASE WHEN tipo = 'c' THEN valor END), 0),
+			COALESCE(SUM(CASE WHEN tipo = 'd' THEN valor END), 0)
+		FROM transacoes
+		WHERE cliente_id = ? AND categoria IS NOT NULL AND (? = '' OR categoria = ?)
+		GROUP BY categoria
+		ORDER BY categoria`, clientId, category, category)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []TotalCategoria{}
+	for rows.Next() {
+		var total TotalCategoria
+		if err := rows.Scan(&total.Categoria, &total.Creditos, &total.Debitos); err != nil {
+			return nil, err
+		}
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// BenchmarkStatement compara a latência do extrato serial com a do
+// EXTRATO_PARALELO num cliente com transações suficientes para encher a
+// página:
+//
+//	POSTGRES_HOST=localhost ... go test -run '^$' -bench Statement
+func BenchmarkStatement(b *testing.B) {
+	app := postgresTestApp(b)
+	ctx := withApp(context.Background(), app)
+	storage := postgresStorage{pool: app.pool}
+	for i := 0; i < 20; i++ {
+		transaction := &TransacaoRequest{Valor: 1, Tipo: TipoCredito, Descricao: "bench"}
+		if _, err := storage.CreateTransaction(ctx, 1, transaction); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	statements := map[string]func(context.Context, int, TransactionFilter) (Extrato, error){
+		"serial":   storage.serialStatement,
+		"paralelo": storage.parallelStatement,
+	}
+	for name, statement := range statements {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := statement(ctx, 1, TransactionFilter{Limite: 10}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
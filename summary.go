@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// Resumo agrega as transações de um cliente em um mês
+type Resumo struct {
+	Periodo        string   `json:"periodo"`
+	Creditos       Centavos `json:"creditos"`
+	Debitos        Centavos `json:"debitos"`
+	SaldoFinal     Centavos `json:"saldo_final"`
+	MaiorTransacao Centavos `json:"maior_transacao"`
+	Quantidade     int      `json:"quantidade"`
+}
+
+// summaryQuery agrega o período [$2, $3) e, na mesma varredura, o efeito das
+// transações posteriores, que descontado do saldo atual dá o saldo no fim do
+// período.
+const summaryQuery = `
+	SELECT
+		COALESCE(SUM(valor) FILTER (WHERE tipo = 'c' AND realizada_em < $3), 0),
+		COALESCE(SUM(valor) FILTER (WHERE tipo = 'd' AND realizada_em < $3), 0),
+		COALESCE(MAX(valor) FILTER (WHERE realizada_em < $3), 0),
+		COUNT(*) FILTER (WHERE realizada_em < $3),
+		COALESCE(SUM(CASE WHEN tipo = 'c' THEN valor ELSE -valor END) FILTER (WHERE realizada_em >= $3), 0)
+	FROM transacoes
+	WHERE cliente_id = $1 AND realizada_em >= $2`
+
+func (s postgresStorage) Summary(ctx context.Context, clientId int, from, to time.Time) (Resumo, error) {
+	var summary Resumo
+
+	tx, err := s.db(ctx).BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return summary, err
+	}
+	defer tx.Rollback(ctx)
+
+	var later Centavos
+	err = tx.QueryRow(ctx, summaryQuery, clientId, from.UTC(), to.UTC()).Scan(
+		&summary.Creditos,
+		&summary.Debitos,
+		&summary.MaiorTransacao,
+		&summary.Quantidade,
+		&later,
+	)
+	if err != nil {
+		return summary, err
+	}
+	balance, err := getBalance(ctx, tx, clientId)
+	if err != nil {
+		return summary, err
+	}
+	summary.SaldoFinal = balance.Saldo - later
+	return summary, tx.Commit(ctx)
+}
+
+func handleSummary(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	from := nowFor(c.UserContext())
+	from = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if period := c.Query("periodo"); period != "" {
+		from, err = time.Parse("2006-01", period)
+		if err != nil {
+			return sendProblem(c, Problem{
+				Status: fiber.StatusBadRequest,
+				Codigo: "PERIODO_INVALIDO",
+				Detail: "periodo deve estar no formato AAAA-MM",
+			})
+		}
+	}
+
+	summary, err := storageFor(c.UserContext()).Summary(c.UserContext(), clientId, from, from.AddDate(0, 1, 0))
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	summary.Periodo = from.Format("2006-01")
+	return c.JSON(summary)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// tenantHeader seleciona o tenant da requisição; sem ele, o primeiro rótulo
+// do Host (acme.rinha.local → acme) é usado quando corresponde a um tenant.
+const tenantHeader = "X-Tenant"
+
+var tenantNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+//go:embed script.sql
+var schemaScript string
+
+type tenantKey struct{}
+
+func withTenant(ctx context.Context, tenant string) context.Context {
+	if tenant == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func tenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// poolFor devolve o pool do tenant associado ao contexto, ou o pool padrão
+// da App
+func poolFor(ctx context.Context) *pgxpool.Pool {
+	app := appFrom(ctx)
+	if pool := app.tenantPools[tenantFrom(ctx)]; pool != nil {
+		return pool
+	}
+	return app.pool
+}
+
+// tenantContexts devolve um contexto por tenant configurado, para os jobs em
+// background percorrerem todos os schemas, ou só ctx sem tenancy.
+func tenantContexts(ctx context.Context) []context.Context {
+	tenantPools := appFrom(ctx).tenantPools
+	if len(tenantPools) == 0 {
+		return []context.Context{ctx}
+	}
+	contexts := make([]context.Context, 0, len(tenantPools))
+	for tenant := range tenantPools {
+		contexts = append(contexts, withTenant(ctx, tenant))
+	}
+	return contexts
+}
+
+// tenantKeyPrefix isola os objetos gravados por backups e arquivamento de
+// cada tenant no mesmo destino.
+func tenantKeyPrefix(ctx context.Context) string {
+	if tenant := tenantFrom(ctx); tenant != "" {
+		return tenant + "/"
+	}
+	return ""
+}
+
+// configureTenants cria o schema de cada tenant listado em TENANTS que ainda
+// não exista, aplicando o mesmo script.sql do schema padrão, e abre um pool
+// por tenant a partir da configuração base, cada um com o search_path fixado
+// no schema do tenant. Sem tenancy, o mapa fica vazio e todas as consultas
+// usam o pool padrão.
+func configureTenants(ctx context.Context, pool *pgxpool.Pool, base *pgxpool.Config) (map[string]*pgxpool.Pool, error) {
+	tenantPools := map[string]*pgxpool.Pool{}
+	for _, tenant := range strings.Split(getEnv("TENANTS", ""), ",") {
+		tenant = strings.TrimSpace(tenant)
+		if tenant == "" {
+			continue
+		}
+		if !tenantNamePattern.MatchString(tenant) {
+			return nil, fmt.Errorf("invalid tenant name %q", tenant)
+		}
+		if err := provisionTenant(ctx, pool, tenant); err != nil {
+			return nil, fmt.Errorf("provisioning tenant %s: %w", tenant, err)
+		}
+
+		config := base.Copy()
+		config.ConnConfig.RuntimeParams["search_path"] = pgx.Identifier{tenant}.Sanitize()
+		tenantPool, err := pgxpool.NewWithConfig(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		tenantPools[tenant] = tenantPool
+		log.Print("Tenant enabled: ", tenant)
+	}
+	return tenantPools, nil
+}
+
+func provisionTenant(ctx context.Context, pool *pgxpool.Pool, tenant string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// evita que duas instâncias subindo juntas criem o mesmo schema
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('rinha.tenants'))"); err != nil {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1)", tenant).
+		Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	schema := pgx.Identifier{tenant}.Sanitize()
+	if _, err := tx.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "SET LOCAL search_path TO "+schema); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, schemaScript); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	startup.migration("schema do tenant " + tenant + " criado")
+	return nil
+}
+
+var invalidTenantProblem = Problem{
+	Status: fiber.StatusBadRequest,
+	Codigo: "TENANT_INVALIDO",
+	Detail: "informe um tenant válido no header " + tenantHeader + " ou no subdomínio",
+}
+
+// tenantMiddleware associa a requisição ao tenant do header X-Tenant ou do
+// subdomínio, recusando tenants desconhecidos.
+func tenantMiddleware(c fiber.Ctx) error {
+	tenant := requestTenant(c.Get(tenantHeader), c.Hostname())
+	if _, ok := appFrom(c.UserContext()).tenantPools[tenant]; !ok {
+		return sendProblem(c, invalidTenantProblem)
+	}
+	c.SetUserContext(withTenant(c.UserContext(), tenant))
+	return c.Next()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+var requestTimeouts = expvar.NewMap("request_timeouts")
+
+// routeTimeout limita o tempo total da rota: o contexto repassado ao banco é
+// cancelado no prazo e, se o handler falhou depois dele, a resposta vira
+// 503. Uma resposta 2xx que termina logo após o prazo é mantida, porque a
+// escrita já foi confirmada no banco. O prazo de cada
+// rota vem de TIMEOUT_<ROTA>, com TIMEOUT como padrão; zero desabilita.
+//
+// O fasthttp não permite responder enquanto o handler ainda usa o contexto
+// da requisição, então o limite depende de o handler respeitar o
+// cancelamento, como fazem todas as consultas do pgx.
+func routeTimeout(route string) fiber.Handler {
+	timeout := routeTimeoutFor(route)
+	if timeout <= 0 {
+		return func(c fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	return func(c fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) || !failedByDeadline(c, err) {
+			return err
+		}
+		requestTimeouts.Add(route, 1)
+		c.Response().ResetBody()
+		return sendProblem(c, timeoutProblem(timeout))
+	}
+}
+
+func routeTimeoutFor(route string) time.Duration {
+	return getEnvDuration("TIMEOUT_"+route, getEnvDuration("TIMEOUT", 0))
+}
+
+func timeoutProblem(timeout time.Duration) Problem {
+	return Problem{
+		Status: fiber.StatusServiceUnavailable,
+		Codigo: "TEMPO_ESGOTADO",
+		Detail: "a requisição excedeu o limite de " + timeout.String(),
+	}
+}
+
+// failedByDeadline diz se o handler terminou em erro depois do prazo: um
+// erro devolvido (o do pgx traz context.DeadlineExceeded) ou uma resposta
+// fora de 2xx, como a 500 do sendQueryError
+func failedByDeadline(c fiber.Ctx, err error) bool {
+	if err != nil {
+		return true
+	}
+	return !successStatus(c.Response().StatusCode())
+}
+
+func successStatus(status int) bool {
+	return status >= fiber.StatusOK && status < fiber.StatusMultipleChoices
+}
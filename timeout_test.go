@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofiber/fiber/v3"
+)
+
+func TestRouteTimeout(t *testing.T) {
+	t.Setenv("TIMEOUT_TESTE", "10ms")
+	app := fiber.New(fiber.Config{ErrorHandler: problemErrorHandler})
+	slow := func(status int) fiber.Handler {
+		return func(c fiber.Ctx) error {
+			<-c.UserContext().Done()
+			return c.SendStatus(status)
+		}
+	}
+	app.Get("/ok", slow(fiber.StatusOK), routeTimeout("TESTE"))
+	app.Get("/erro", slow(fiber.StatusInternalServerError), routeTimeout("TESTE"))
+	app.Get("/cancelado", func(c fiber.Ctx) error {
+		<-c.UserContext().Done()
+		return c.UserContext().Err()
+	}, routeTimeout("TESTE"))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/ok", fiber.StatusOK},
+		{"/erro", fiber.StatusServiceUnavailable},
+		{"/cancelado", fiber.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.path, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestNetHTTPTimeout(t *testing.T) {
+	t.Setenv("TIMEOUT_TESTE", "10ms")
+	router := chi.NewRouter()
+	router.Use(netHTTPTimeout("TESTE"))
+	slow := func(status int) http.HandlerFunc {
+		return netHTTPHandler(func(x exchange) error {
+			<-x.Context().Done()
+			return x.Send(status, "text/plain", nil)
+		})
+	}
+	router.Get("/ok", slow(http.StatusOK))
+	router.Get("/erro", slow(http.StatusInternalServerError))
+	router.Get("/cancelado", netHTTPHandler(func(x exchange) error {
+		<-x.Context().Done()
+		return x.Context().Err()
+	}))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/ok", http.StatusOK},
+		{"/erro", http.StatusServiceUnavailable},
+		{"/cancelado", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", tt.path, nil))
+		if recorder.Code != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.path, recorder.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Timestamp é um instante serializado em JSON no fuso TIMESTAMP_FUSO
+// (padrão UTC) e com a precisão TIMESTAMP_PRECISAO: s, ms, us ou ns (padrão,
+// o RFC 3339 com nanossegundos do time.Time). As precisões abaixo de ns têm
+// largura fixa, para parsers que não aceitam a fração variável do Go.
+type Timestamp struct {
+	time.Time
+}
+
+type timestampFormat struct {
+	location *time.Location
+	layout   string
+}
+
+var timestampLayouts = map[string]string{
+	"s":  "2006-01-02T15:04:05Z07:00",
+	"ms": "2006-01-02T15:04:05.000Z07:00",
+	"us": "2006-01-02T15:04:05.000000Z07:00",
+	"ns": time.RFC3339Nano,
+}
+
+var currentTimestampFormat atomic.Pointer[timestampFormat]
+
+func init() {
+	onReload(func() {
+		format, err := loadTimestampFormat()
+		if err != nil {
+			log.Print("Error configuring timestamps, keeping the current format: ", err)
+			if currentTimestampFormat.Load() != nil {
+				return
+			}
+			format = &timestampFormat{location: time.UTC, layout: time.RFC3339Nano}
+		}
+		currentTimestampFormat.Store(format)
+	})
+}
+
+func loadTimestampFormat() (*timestampFormat, error) {
+	location, err := time.LoadLocation(getEnv("TIMESTAMP_FUSO", "UTC"))
+	if err != nil {
+		return nil, err
+	}
+	precision := getEnv("TIMESTAMP_PRECISAO", "ns")
+	layout, ok := timestampLayouts[precision]
+	if !ok {
+		return nil, fmt.Errorf("unknown TIMESTAMP_PRECISAO %q", precision)
+	}
+	return &timestampFormat{location: location, layout: layout}, nil
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	format := currentTimestampFormat.Load()
+	b := make([]byte, 0, len(format.layout)+8)
+	b = append(b, '"')
+	b = t.In(format.location).AppendFormat(b, format.layout)
+	return append(b, '"'), nil
+}
+
+func (t *Timestamp) ScanTimestamp(v pgtype.Timestamp) error {
+	if !v.Valid {
+		return fmt.Errorf("cannot scan NULL into Timestamp")
+	}
+	t.Time = v.Time
+	return nil
+}
+
+func (t Timestamp) TimestampValue() (pgtype.Timestamp, error) {
+	return pgtype.Timestamp{Time: t.Time, Valid: true}, nil
+}
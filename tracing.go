@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("rinha-de-backend-2024-q1")
+
+// setupTracing configura o exportador OTLP/HTTP quando OTEL_TRACES_ENABLED
+// estiver ativo. Endpoint, headers e amostragem seguem as variáveis padrão
+// OTEL_EXPORTER_OTLP_* e OTEL_TRACES_SAMPLER* lidas pelo próprio SDK.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if !getEnvBool("OTEL_TRACES_ENABLED", false) {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", getEnv("OTEL_SERVICE_NAME", "rinha-de-backend-2024-q1")),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return provider.Shutdown, nil
+}
+
+// fasthttpHeaderCarrier adapta os headers da requisição para o propagador
+type fasthttpHeaderCarrier struct {
+	c fiber.Ctx
+}
+
+func (h fasthttpHeaderCarrier) Get(key string) string {
+	return h.c.Get(key)
+}
+
+func (h fasthttpHeaderCarrier) Set(key, value string) {
+	h.c.Request().Header.Set(key, value)
+}
+
+func (h fasthttpHeaderCarrier) Keys() []string {
+	var keys []string
+	h.c.Request().Header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// tracingMiddleware abre um span por requisição, continuando o trace
+// recebido nos headers, e o disponibiliza aos handlers via UserContext.
+func tracingMiddleware(c fiber.Ctx) error {
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), fasthttpHeaderCarrier{c})
+	ctx, span := tracer.Start(ctx, c.Method()+" "+c.Path(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", c.Method()),
+			attribute.String("url.path", c.Path()),
+		))
+	defer span.End()
+
+	c.SetUserContext(ctx)
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		if fiberErr, ok := err.(*fiber.Error); ok {
+			status = fiberErr.Code
+		} else {
+			status = fiber.StatusInternalServerError
+		}
+		span.RecordError(err)
+	}
+	route := c.Route().Path
+	span.SetName(c.Method() + " " + route)
+	span.SetAttributes(
+		attribute.String("http.route", route),
+		attribute.Int("http.response.status_code", status),
+	)
+	if status >= 500 {
+		span.SetStatus(codes.Error, strconv.Itoa(status))
+	}
+	return err
+}
+
+// dbTracer cria spans para aquisição de conexões do pool e para cada
+// consulta, separando o tempo de espera por conexão do tempo de execução.
+type dbTracer struct{}
+
+func (dbTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, "db.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", data.SQL),
+		))
+	return ctx
+}
+
+func (dbTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	span.End()
+}
+
+func (dbTracer) TraceAcquireStart(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, "db.pool.acquire")
+	return ctx
+}
+
+func (dbTracer) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.End()
+}
+
+// queryTracers encadeia os tracers configurados no pool, já que o pgx aceita
+// um só
+type queryTracers []pgx.QueryTracer
+
+func (t queryTracers) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	for _, tracer := range t {
+		ctx = tracer.TraceQueryStart(ctx, conn, data)
+	}
+	return ctx
+}
+
+func (t queryTracers) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	for _, tracer := range t {
+		tracer.TraceQueryEnd(ctx, conn, data)
+	}
+}
+
+func (t queryTracers) TraceAcquireStart(ctx context.Context, pool *pgxpool.Pool, data pgxpool.TraceAcquireStartData) context.Context {
+	for _, tracer := range t {
+		if tracer, ok := tracer.(pgxpool.AcquireTracer); ok {
+			ctx = tracer.TraceAcquireStart(ctx, pool, data)
+		}
+	}
+	return ctx
+}
+
+func (t queryTracers) TraceAcquireEnd(ctx context.Context, pool *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	for _, tracer := range t {
+		if tracer, ok := tracer.(pgxpool.AcquireTracer); ok {
+			tracer.TraceAcquireEnd(ctx, pool, data)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// limitUtilization devolve quanto do limite o saldo negativo consome, em
+// porcentagem com duas casas. Saldo positivo não usa o limite; sem limite,
+// qualquer saldo negativo o consome por inteiro.
+func limitUtilization(balance Balance) float64 {
+	if balance.Saldo >= 0 {
+		return 0
+	}
+	if balance.Limite <= 0 {
+		return 100
+	}
+	pct := float64(-balance.Saldo) * 100 / float64(balance.Limite)
+	return math.Round(pct*100) / 100
+}
+
+// UtilizacaoLimite é uma linha do relatório de utilização de limite
+type UtilizacaoLimite struct {
+	ID                 int      `json:"id"`
+	Nome               string   `json:"nome"`
+	Saldo              Centavos `json:"saldo"`
+	Limite             Centavos `json:"limite"`
+	LimiteUtilizadoPct float64  `json:"limite_utilizado_pct"`
+}
+
+// utilizationQuery lista os clientes com saldo negativo que consomem ao
+// menos $1% do limite. No modo eventsourcing o saldo soma as transações
+// ainda não projetadas, como em pg.GetLedgerBalance.
+func utilizationQuery() string {
+	balance := "c.saldo"
+	if concurrencyMode == concurrencyEventSourcing {
+		balance = `c.saldo + COALESCE((
+			SELECT SUM(CASE WHEN t.tipo = 'c' THEN t.valor ELSE -t.valor END)
+			FROM transacoes t
+			WHERE t.cliente_id = c.id AND t.id > c.projetado_ate), 0)`
+	}
+	return `
+		SELECT id, nome, saldo, limite FROM (
+			SELECT c.id, c.nome, ` + balance + ` AS saldo, c.limite FROM clientes c
+		) b
+		WHERE saldo < 0 AND -saldo * 100.0 >= $1 * limite
+		ORDER BY -saldo::float8 / NULLIF(limite, 0) DESC NULLS FIRST, id`
+}
+
+// handleLimitUtilizationReport lista os clientes acima de ?minimo= por cento
+// do limite, ou de LIMITE_UTILIZACAO_RELATORIO quando omitido
+func handleLimitUtilizationReport(c fiber.Ctx) error {
+	minimum := float64(getEnvInt("LIMITE_UTILIZACAO_RELATORIO", 80))
+	if value := c.Query("minimo"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed < 0 {
+			return sendProblem(c, Problem{
+				Status: fiber.StatusBadRequest,
+				Codigo: "MINIMO_INVALIDO",
+				Detail: "minimo deve ser uma porcentagem não negativa",
+			})
+		}
+		minimum = parsed
+	}
+
+	ctx := c.UserContext()
+	rows, err := poolFor(ctx).Query(ctx, utilizationQuery(), minimum)
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	clients, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (UtilizacaoLimite, error) {
+		var client UtilizacaoLimite
+		err := row.Scan(&client.ID, &client.Nome, &client.Saldo, &client.Limite)
+		client.LimiteUtilizadoPct = limitUtilization(Balance{Saldo: client.Saldo, Limite: client.Limite})
+		return client, err
+	})
+	if err != nil {
+		return c.SendStatus(fiber.ErrInternalServerError.Code)
+	}
+	return c.JSON(clients)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-playground/locales/pt_BR"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	pt_translations "github.com/go-playground/validator/v10/translations/pt_BR"
+	"github.com/gofiber/fiber/v3"
+)
+
+// ErroCampo representa a falha de validação de um campo da requisição
+type ErroCampo struct {
+	Campo    string `json:"campo"`
+	Codigo   string `json:"codigo"`
+	Mensagem string `json:"mensagem"`
+}
+
+// maxTransactionAmount é o maior valor aceito em uma transação (VALOR_MAXIMO,
+// em centavos), para rejeitar valores absurdos antes de chegar ao banco.
+var maxTransactionAmount atomic.Int64
+
+func init() {
+	onReload(func() {
+		maxTransactionAmount.Store(int64(loadMaxTransactionAmount()))
+	})
+}
+
+func loadMaxTransactionAmount() Centavos {
+	const fallback = Centavos(100_000_000_000)
+	value, err := ParseCentavos(getEnv("VALOR_MAXIMO", fallback.Int64String()))
+	if err != nil || value <= 0 {
+		log.Printf("Invalid VALOR_MAXIMO, using %d", fallback)
+		return fallback
+	}
+	return value
+}
+
+// fieldErrorCodes associa campo e regra de validação a um código de erro
+// específico; combinações ausentes usam CAMPO_INVALIDO.
+var fieldErrorCodes = map[string]string{
+	"valor.required":      "VALOR_NAO_POSITIVO",
+	"valor.gt":            "VALOR_NAO_POSITIVO",
+	"valor.valor_maximo":  "VALOR_ACIMA_DO_MAXIMO",
+	"tipo.required":       "TIPO_INVALIDO",
+	"tipo.oneof":          "TIPO_INVALIDO",
+	"tipo.tipo":           "TIPO_INVALIDO",
+	"page.min":            "PAGINA_INVALIDA",
+	"por_pagina.min":      "PAGINA_INVALIDA",
+	"por_pagina.max":      "PAGINA_INVALIDA",
+	"desde_seq.required":  "FILTRO_INVALIDO",
+	"desde_seq.min":       "FILTRO_INVALIDO",
+	"limite.min":          "PAGINA_INVALIDA",
+	"limite.max":          "PAGINA_INVALIDA",
+	"descricao.required":  "DESCRICAO_INVALIDA",
+	"descricao.max":       "DESCRICAO_INVALIDA",
+	"descricao.descricao": "DESCRICAO_INVALIDA",
+	"parcelas.min":        "PARCELAS_INVALIDAS",
+	"parcelas.max":        "PARCELAS_INVALIDAS",
+	"nome.min":            "NOME_INVALIDO",
+	"nome.max":            "NOME_INVALIDO",
+	"documento.documento": "DOCUMENTO_INVALIDO",
+	"email.email":         "EMAIL_INVALIDO",
+	"email.max":           "EMAIL_INVALIDO",
+	"metadata.metadata":   "METADATA_INVALIDO",
+	"url.required":        "URL_INVALIDA",
+	"url.http_url":        "URL_INVALIDA",
+	"url.max":             "URL_INVALIDA",
+	"segredo.min":         "SEGREDO_INVALIDO",
+	"segredo.max":         "SEGREDO_INVALIDO",
+	// filtro de descrições, ver denylist.go
+	"descricao.descricao_permitida": "DESCRICAO_BLOQUEADA",
+	"padrao.required":               "PADRAO_INVALIDO",
+	"padrao.max":                    "PADRAO_INVALIDO",
+}
+
+func fieldErrorCode(fieldErr validator.FieldError) string {
+	if code, ok := fieldErrorCodes[fieldErr.Field()+"."+fieldErr.Tag()]; ok {
+		return code
+	}
+	return "CAMPO_INVALIDO"
+}
+
+// structValidator integra o go-playground/validator ao Bind do fiber, com
+// mensagens traduzidas para português e nomes de campo iguais aos do JSON.
+type structValidator struct {
+	validate   *validator.Validate
+	translator ut.Translator
+}
+
+func newStructValidator() *structValidator {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			// os parâmetros de consulta de bindQuery
+			name = field.Tag.Get("query")
+		}
+		return name
+	})
+
+	locale := pt_BR.New()
+	translator, _ := ut.New(locale, locale).GetTranslator("pt_BR")
+	if err := pt_translations.RegisterDefaultTranslations(validate, translator); err != nil {
+		panic(err)
+	}
+
+	validate.RegisterValidation("valor_maximo", func(fl validator.FieldLevel) bool {
+		return fl.Field().Int() <= maxTransactionAmount.Load()
+	})
+	validate.RegisterTranslation("valor_maximo", translator,
+		func(ut ut.Translator) error {
+			return ut.Add("valor_maximo", "{0} deve ser no máximo {1}", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("valor_maximo", fe.Field(), strconv.FormatInt(maxTransactionAmount.Load(), 10))
+			return t
+		})
+
+	validate.RegisterValidation("tipo", func(fl validator.FieldLevel) bool {
+		return Tipo(fl.Field().String()).Valid()
+	})
+	validate.RegisterTranslation("tipo", translator,
+		func(ut ut.Translator) error {
+			return ut.Add("tipo", "{0} deve ser c (crédito) ou d (débito)", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("tipo", fe.Field())
+			return t
+		})
+
+	validate.RegisterValidation("documento", func(fl validator.FieldLevel) bool {
+		return validDocument(fl.Field().String())
+	})
+	validate.RegisterTranslation("documento", translator,
+		func(ut ut.Translator) error {
+			return ut.Add("documento", "{0} deve ser um CPF ou CNPJ válido", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("documento", fe.Field())
+			return t
+		})
+
+	validate.RegisterValidation("descricao", func(fl validator.FieldLevel) bool {
+		return validDescription(fl.Field().String())
+	})
+	validate.RegisterTranslation("descricao", translator,
+		func(ut ut.Translator) error {
+			return ut.Add("descricao", "{0} não pode ter caracteres de controle nem passar de {1} bytes", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("descricao", fe.Field(), strconv.Itoa(descricaoMaxBytes))
+			return t
+		})
+
+	validate.RegisterValidation("descricao_permitida", func(fl validator.FieldLevel) bool {
+		return allowedDescription(fl.Field().String())
+	})
+	validate.RegisterTranslation("descricao_permitida", translator,
+		func(ut ut.Translator) error {
+			return ut.Add("descricao_permitida", "{0} contém um termo bloqueado", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("descricao_permitida", fe.Field())
+			return t
+		})
+
+	validate.RegisterValidation("metadata", func(fl validator.FieldLevel) bool {
+		return validMetadata(fl.Field().Bytes())
+	})
+	validate.RegisterTranslation("metadata", translator,
+		func(ut ut.Translator) error {
+			return ut.Add("metadata", "{0} deve ser um objeto JSON de no máximo {1} bytes", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("metadata", fe.Field(), strconv.FormatInt(maxMetadataSize.Load(), 10))
+			return t
+		})
+	return &structValidator{validate: validate, translator: translator}
+}
+
+func (v *structValidator) Engine() any {
+	return v.validate
+}
+
+// ValidateStruct normaliza os corpos que implementam normalizer antes de
+// validá-los
+func (v *structValidator) ValidateStruct(out any) error {
+	if n, ok := out.(normalizer); ok {
+		n.normalize()
+	}
+	return v.validate.Struct(out)
+}
+
+var errTipoConteudo = errors.New("o corpo deve ser enviado como application/json")
+
+// bindJSON decodifica e valida o corpo da requisição, que deve ser JSON.
+// Outros tipos de conteúdo são recusados com errTipoConteudo.
+func bindJSON(c fiber.Ctx, out any) error {
+	return decodeJSON(c.UserContext(), string(c.Request().Header.ContentType()), c.Body(), out)
+}
+
+// decodeJSON é o bindJSON fora do fiber: as mesmas etapas do Bind().JSON,
+// com o decoder de json_*.go e o structValidator, medidas em separado para o
+// Server-Timing
+func decodeJSON(ctx context.Context, contentType string, body []byte, out any) error {
+	if !isJSONMediaType(contentType) {
+		return errTipoConteudo
+	}
+	timing := timingFrom(ctx)
+	done := timing.Start(timingParse)
+	err := jsonUnmarshal(body, out)
+	done()
+	if err != nil {
+		return err
+	}
+	defer timing.Start(timingValidate)()
+	return structValidatorInstance.ValidateStruct(out)
+}
+
+// isJSONContentType aceita application/json e os tipos +json, com ou sem
+// parâmetros como charset
+func isJSONContentType(c fiber.Ctx) bool {
+	return isJSONMediaType(string(c.Request().Header.ContentType()))
+}
+
+func isJSONMediaType(contentType string) bool {
+	mime, _, _ := strings.Cut(contentType, ";")
+	mime = strings.ToLower(strings.TrimSpace(mime))
+	return mime == fiber.MIMEApplicationJSON ||
+		strings.HasPrefix(mime, "application/") && strings.HasSuffix(mime, "+json")
+}
+
+// sendBindError responde 415 quando o corpo não é JSON, 400 quando o JSON
+// está malformado e 422 quando ele é válido mas o conteúdo não: com o
+// detalhamento dos campos inválidos quando a falha veio da validação, ou com
+// a mensagem do decoder caso contrário.
+func sendBindError(c fiber.Ctx, err error) error {
+	return sendProblem(c, bindErrorProblem(err, c.Body()))
+}
+
+// bindErrorProblem é o problema de sendBindError para um corpo body
+func bindErrorProblem(err error, body []byte) Problem {
+	response := Problem{
+		Status: fiber.StatusUnprocessableEntity,
+		Codigo: "REQUISICAO_INVALIDA",
+		Detail: err.Error(),
+	}
+
+	var validationErrors validator.ValidationErrors
+	switch {
+	case errors.Is(err, errTipoConteudo):
+		response.Status = fiber.StatusUnsupportedMediaType
+		response.Codigo = "TIPO_CONTEUDO_NAO_SUPORTADO"
+		return response
+	case !errors.As(err, &validationErrors) && !json.Valid(body):
+		// o erro de sintaxe depende do decoder (json_*.go), então o corpo é
+		// conferido de novo com o encoding/json
+		response.Status = fiber.StatusBadRequest
+		response.Codigo = "JSON_INVALIDO"
+		response.Detail = "o corpo não é um JSON válido: " + err.Error()
+		return response
+	case errors.Is(err, ErrValorOverflow):
+		response.Codigo = "VALOR_ACIMA_DO_MAXIMO"
+	case errors.Is(err, ErrValorFracionario):
+		response.Codigo = "VALOR_FRACIONARIO"
+	}
+
+	if errors.As(err, &validationErrors) {
+		return fieldErrorsProblem(translateFieldErrors(validationErrors))
+	}
+	return response
+}
+
+// sendQueryError responde 400 aos parâmetros de consulta de bindQuery que
+// não puderam ser convertidos ou não passaram na validação
+func sendQueryError(c fiber.Ctx, err error) error {
+	return sendProblem(c, queryErrorProblem(err))
+}
+
+func queryErrorProblem(err error) Problem {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		response := fieldErrorsProblem(translateFieldErrors(validationErrors))
+		response.Status = fiber.StatusBadRequest
+		if len(response.Erros) > 1 {
+			response.Codigo = "FILTRO_INVALIDO"
+		}
+		return response
+	}
+	return Problem{
+		Status: fiber.StatusBadRequest,
+		Codigo: "FILTRO_INVALIDO",
+		Detail: err.Error(),
+	}
+}
+
+func translateFieldErrors(validationErrors validator.ValidationErrors) []ErroCampo {
+	translator := structValidatorInstance.translator
+	fieldErrors := make([]ErroCampo, 0, len(validationErrors))
+	for _, fieldErr := range validationErrors {
+		fieldErrors = append(fieldErrors, ErroCampo{
+			Campo:    fieldErr.Field(),
+			Codigo:   fieldErrorCode(fieldErr),
+			Mensagem: fieldErr.Translate(translator),
+		})
+	}
+	return fieldErrors
+}
+
+// sendFieldErrors responde 422 com o detalhamento dos campos inválidos. Com
+// um único campo, o código e a mensagem dele viram os do problema.
+func sendFieldErrors(c fiber.Ctx, fieldErrors []ErroCampo) error {
+	return sendProblem(c, fieldErrorsProblem(fieldErrors))
+}
+
+func fieldErrorsProblem(fieldErrors []ErroCampo) Problem {
+	response := Problem{
+		Status: fiber.StatusUnprocessableEntity,
+		Codigo: "VALIDACAO",
+		Detail: "um ou mais campos são inválidos",
+		Erros:  fieldErrors,
+	}
+	if len(fieldErrors) == 1 {
+		response.Codigo = fieldErrors[0].Codigo
+		response.Detail = fieldErrors[0].Mensagem
+	}
+	return response
+}
+
+var structValidatorInstance = newStructValidator()
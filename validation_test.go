@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTransactionValorBoundaries(t *testing.T) {
+	previous := maxTransactionAmount.Load()
+	maxTransactionAmount.Store(100_000)
+	t.Cleanup(func() { maxTransactionAmount.Store(previous) })
+
+	tests := []struct {
+		name   string
+		valor  string
+		status int
+		codigo string
+	}{
+		{"zero", "0", 422, "VALOR_NAO_POSITIVO"},
+		{"negativo", "-1", 422, "VALOR_NAO_POSITIVO"},
+		{"mínimo", "1", 0, ""},
+		{"máximo", "100000", 0, ""},
+		{"máximo mais um", "100001", 422, "VALOR_ACIMA_DO_MAXIMO"},
+		{"fora do int64", "9223372036854775808", 422, "VALOR_ACIMA_DO_MAXIMO"},
+		{"fracionário", "1.5", 422, "VALOR_FRACIONARIO"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte(`{"valor": ` + tt.valor + `, "tipo": "c", "descricao": "teste"}`)
+			var transaction TransacaoRequest
+			err := decodeJSON(context.Background(), "application/json", body, &transaction)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("valor %s rejected: %v", tt.valor, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("valor %s accepted", tt.valor)
+			}
+			problem := bindErrorProblem(err, body)
+			if problem.Status != tt.status || problem.Codigo != tt.codigo {
+				t.Errorf("valor %s: %d %s, want %d %s", tt.valor, problem.Status, problem.Codigo, tt.status, tt.codigo)
+			}
+		})
+	}
+}
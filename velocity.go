@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/jackc/pgx/v5"
+)
+
+// As estatísticas de velocidade são contadas em memória, por minuto, a cada
+// transação aceita por POST /transacoes nesta instância, e cobrem as últimas
+// 24 horas. Com o Postgres, cada instância grava seus minutos em
+// estatisticas_minuto a cada ESTATISTICAS_INTERVALO, e o endpoint soma os
+// das demais instâncias aos da memória; ao subir, a instância recarrega os
+// seus. Transações agendadas e importadas não entram na conta.
+const (
+	velocityMinutes = 24 * 60
+	// velocityRateMinutes é a janela da taxa de transações por minuto
+	velocityRateMinutes = 5
+)
+
+type minuteBucket struct {
+	minute      int64
+	transacoes  int64
+	debitos     int64
+	somaDebitos Centavos
+	maiorDebito Centavos
+	dirty       bool
+}
+
+type clientVelocity struct {
+	mu      sync.Mutex
+	buckets [velocityMinutes]minuteBucket
+}
+
+// bucket devolve o balde do minuto, reaproveitando o de 24 horas atrás
+func (v *clientVelocity) bucket(minute int64) *minuteBucket {
+	b := &v.buckets[minute%velocityMinutes]
+	if b.minute != minute {
+		*b = minuteBucket{minute: minute}
+	}
+	return b
+}
+
+type velocityTracker struct {
+	mu      sync.Mutex
+	clients map[statementClient]*clientVelocity
+}
+
+var velocity = &velocityTracker{clients: make(map[statementClient]*clientVelocity)}
+
+func (t *velocityTracker) client(key statementClient) *clientVelocity {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v := t.clients[key]
+	if v == nil {
+		v = &clientVelocity{}
+		t.clients[key] = v
+	}
+	return v
+}
+
+func (t *velocityTracker) Record(tenant string, clientId int, transaction *TransacaoRequest, at time.Time) {
+	v := t.client(statementClient{tenant: tenant, clientId: clientId})
+	v.mu.Lock()
+	b := v.bucket(at.Unix() / 60)
+	b.transacoes++
+	if transaction.Tipo == "d" {
+		b.debitos++
+		b.somaDebitos += transaction.Valor
+		b.maiorDebito = max(b.maiorDebito, transaction.Valor)
+	}
+	b.dirty = true
+	v.mu.Unlock()
+}
+
+// velocityTotals soma os baldes das janelas do endpoint
+type velocityTotals struct {
+	recentes    int64
+	transacoes  int64
+	debitos     int64
+	somaDebitos Centavos
+	maiorDebito Centavos
+}
+
+func (t *velocityTracker) totals(tenant string, clientId int, now time.Time) velocityTotals {
+	var totals velocityTotals
+	t.mu.Lock()
+	v := t.clients[statementClient{tenant: tenant, clientId: clientId}]
+	t.mu.Unlock()
+	if v == nil {
+		return totals
+	}
+
+	current := now.Unix() / 60
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for i := range v.buckets {
+		b := &v.buckets[i]
+		if b.minute <= current-velocityMinutes || b.minute > current {
+			continue
+		}
+		if b.minute > current-velocityRateMinutes {
+			totals.recentes += b.transacoes
+		}
+		totals.transacoes += b.transacoes
+		totals.debitos += b.debitos
+		totals.somaDebitos += b.somaDebitos
+		totals.maiorDebito = max(totals.maiorDebito, b.maiorDebito)
+	}
+	return totals
+}
+
+// EstatisticasCliente são as estatísticas das últimas 24 horas. A taxa por
+// minuto é a média dos últimos 5 minutos.
+type EstatisticasCliente struct {
+	TransacoesPorMinuto float64  `json:"transacoes_por_minuto"`
+	Transacoes24h       int64    `json:"transacoes_24h"`
+	Debitos24h          int64    `json:"debitos_24h"`
+	DebitoMedio24h      Centavos `json:"debito_medio_24h"`
+	DebitoMaximo24h     Centavos `json:"debito_maximo_24h"`
+}
+
+func handleClientStatistics(c fiber.Ctx) error {
+	clientId, err := clientIdParam(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	ctx := c.UserContext()
+	now := nowFor(ctx)
+	totals := velocity.totals(tenantFrom(ctx), clientId, now)
+	if poolFor(ctx) != nil {
+		others, err := persistedVelocity(ctx, clientId, now)
+		if err != nil {
+			return c.SendStatus(fiber.ErrInternalServerError.Code)
+		}
+		totals.recentes += others.recentes
+		totals.transacoes += others.transacoes
+		totals.debitos += others.debitos
+		totals.somaDebitos += others.somaDebitos
+		totals.maiorDebito = max(totals.maiorDebito, others.maiorDebito)
+	}
+
+	statistics := EstatisticasCliente{
+		TransacoesPorMinuto: float64(totals.recentes) / velocityRateMinutes,
+		Transacoes24h:       totals.transacoes,
+		Debitos24h:          totals.debitos,
+		DebitoMaximo24h:     totals.maiorDebito,
+	}
+	if totals.debitos > 0 {
+		statistics.DebitoMedio24h = totals.somaDebitos / Centavos(totals.debitos)
+	}
+	return c.JSON(statistics)
+}
+
+// persistedVelocity soma os minutos gravados pelas outras instâncias
+func persistedVelocity(ctx context.Context, clientId int, now time.Time) (velocityTotals, error) {
+	var totals velocityTotals
+	current := now.Unix() / 60
+	err := poolFor(ctx).QueryRow(ctx, `
+		SELECT COALESCE(SUM(transacoes) FILTER (WHERE minuto > $3), 0)::bigint,
+			COALESCE(SUM(transacoes), 0)::bigint, COALESCE(SUM(debitos), 0)::bigint,
+			COALESCE(SUM(soma_debitos), 0)::bigint, COALESCE(MAX(maior_debito), 0)
+		FROM estatisticas_minuto
+		WHERE cliente_id = $1 AND instancia <> $2 AND minuto > $4`,
+		clientId, hostname, current-velocityRateMinutes, current-velocityMinutes).
+		Scan(&totals.recentes, &totals.transacoes, &totals.debitos, &totals.somaDebitos, &totals.maiorDebito)
+	return totals, err
+}
+
+// runVelocityPersister grava periodicamente os minutos alterados do tenant
+// e remove os que saíram da janela de 24 horas
+func runVelocityPersister(ctx context.Context, interval time.Duration) {
+	if err := loadVelocity(ctx); err != nil {
+		log.Print("Error loading velocity statistics: ", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// grava o que faltou antes de encerrar
+			persistVelocity(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+			persistVelocity(ctx)
+		}
+	}
+}
+
+func loadVelocity(ctx context.Context) error {
+	rows, err := poolFor(ctx).Query(ctx, `
+		SELECT cliente_id, minuto, transacoes, debitos, soma_debitos, maior_debito
+		FROM estatisticas_minuto
+		WHERE instancia = $1 AND minuto > $2`, hostname, time.Now().Unix()/60-velocityMinutes)
+	if err != nil {
+		return err
+	}
+	tenant := tenantFrom(ctx)
+	for rows.Next() {
+		var clientId int
+		var loaded minuteBucket
+		err := rows.Scan(&clientId, &loaded.minute, &loaded.transacoes, &loaded.debitos, &loaded.somaDebitos, &loaded.maiorDebito)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		v := velocity.client(statementClient{tenant: tenant, clientId: clientId})
+		v.mu.Lock()
+		b := v.bucket(loaded.minute)
+		b.transacoes += loaded.transacoes
+		b.debitos += loaded.debitos
+		b.somaDebitos += loaded.somaDebitos
+		b.maiorDebito = max(b.maiorDebito, loaded.maiorDebito)
+		v.mu.Unlock()
+	}
+	return rows.Err()
+}
+
+type dirtyBucket struct {
+	clientId int
+	bucket   minuteBucket
+}
+
+func persistVelocity(ctx context.Context) {
+	tenant := tenantFrom(ctx)
+	var dirty []dirtyBucket
+	velocity.mu.Lock()
+	for key, v := range velocity.clients {
+		if key.tenant != tenant {
+			continue
+		}
+		v.mu.Lock()
+		for i := range v.buckets {
+			if v.buckets[i].dirty {
+				v.buckets[i].dirty = false
+				dirty = append(dirty, dirtyBucket{clientId: key.clientId, bucket: v.buckets[i]})
+			}
+		}
+		v.mu.Unlock()
+	}
+	velocity.mu.Unlock()
+
+	batch := &pgx.Batch{}
+	for _, d := range dirty {
+		b := d.bucket
+		batch.Queue(`
+			INSERT INTO estatisticas_minuto (cliente_id, minuto, instancia, transacoes, debitos, soma_debitos, maior_debito)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (cliente_id, minuto, instancia) DO UPDATE SET
+				transacoes = EXCLUDED.transacoes, debitos = EXCLUDED.debitos,
+				soma_debitos = EXCLUDED.soma_debitos, maior_debito = EXCLUDED.maior_debito`,
+			d.clientId, b.minute, hostname, b.transacoes, b.debitos, b.somaDebitos, b.maiorDebito)
+	}
+	batch.Queue("DELETE FROM estatisticas_minuto WHERE minuto <= $1", time.Now().Unix()/60-velocityMinutes)
+
+	if err := poolFor(ctx).SendBatch(ctx, batch).Close(); err != nil {
+		if !errors.Is(err, context.Canceled) {
+			log.Print("Error persisting velocity statistics: ", err)
+		}
+		// os minutos voltam a ser gravados na próxima rodada
+		for _, d := range dirty {
+			v := velocity.client(statementClient{tenant: tenant, clientId: d.clientId})
+			v.mu.Lock()
+			if b := &v.buckets[d.bucket.minute%velocityMinutes]; b.minute == d.bucket.minute {
+				b.dirty = true
+			}
+			v.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Versionamento da API. As rotas públicas (/clientes e /categorias) são
+// registradas em /v1 e, como aliases, nos caminhos sem versão usados pelo
+// teste da rinha, que continuam com o formato da v1 mas respondem com
+// Deprecation e Sunset e um Link para o caminho em /v1.
+//
+// A versão é escolhida pelo prefixo do caminho. Nos caminhos sem versão o
+// cliente pode pedir uma versão com Accept: application/vnd.rinha.v<N>+json;
+// sem isso vale a v1, para que o teste da rinha nunca mude de formato. Uma
+// nova versão é um novo prefixo em apiVersions, e os handlers que mudam de
+// formato escolhem a resposta por apiVersion(c).
+var apiVersions = []int{1}
+
+const legacyAPIVersion = 1
+
+var (
+	// API_LEGADO_DEPRECACAO e API_LEGADO_SUNSET são datas (2006-01-02)
+	legacyDeprecation = legacyHeaderDate("API_LEGADO_DEPRECACAO", "2026-10-14", func(t time.Time) string {
+		return "@" + strconv.FormatInt(t.Unix(), 10)
+	})
+	legacySunset = legacyHeaderDate("API_LEGADO_SUNSET", "2027-04-01", func(t time.Time) string {
+		return t.Format(http.TimeFormat)
+	})
+)
+
+func legacyHeaderDate(key, fallback string, format func(time.Time) string) string {
+	value := getEnv(key, fallback)
+	if value == "" {
+		return ""
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		t, _ = time.Parse(time.DateOnly, fallback)
+	}
+	return format(t)
+}
+
+// versionPrefix devolve o prefixo /v<N> do caminho e a versão, ou zero
+func versionPrefix(path string) (string, int) {
+	if len(path) < 3 || path[1] != 'v' {
+		return "", 0
+	}
+	end := strings.IndexByte(path[1:], '/') + 1
+	if end == 0 {
+		end = len(path)
+	}
+	version, err := strconv.Atoi(path[2:end])
+	if err != nil {
+		return "", 0
+	}
+	return path[:end], version
+}
+
+func isVersionedResource(path string) bool {
+	return path == "/clientes" || strings.HasPrefix(path, "/clientes/") || path == "/categorias" || strings.HasPrefix(path, "/categorias/")
+}
+
+// apiVersionMiddleware marca os caminhos legados como depreciados e recusa
+// com 406 as versões pedidas por Accept que não existem
+func apiVersionMiddleware(c fiber.Ctx) error {
+	if ok, err := checkAPIVersion(fiberExchange{c}, c.Path()); !ok {
+		return err
+	}
+	return c.Next()
+}
+
+// checkAPIVersion é o apiVersionMiddleware das duas pilhas: devolve false
+// quando já respondeu com o 406
+func checkAPIVersion(x exchange, path string) (bool, error) {
+	if !isVersionedResource(path) {
+		return true, nil
+	}
+
+	if version, ok := acceptedVersion(x.Header(fiber.HeaderAccept)); ok && !supportedVersion(version) {
+		return false, sendProblemTo(x, Problem{
+			Status: fiber.StatusNotAcceptable,
+			Codigo: "VERSAO_NAO_SUPORTADA",
+			Detail: "versão " + strconv.Itoa(version) + " da API não existe",
+		})
+	}
+
+	if legacyDeprecation != "" {
+		x.SetHeader("Deprecation", legacyDeprecation)
+	}
+	if legacySunset != "" {
+		x.SetHeader("Sunset", legacySunset)
+	}
+	x.SetHeader(fiber.HeaderLink, "</v1"+path+`>; rel="successor-version"`)
+	return true, nil
+}
+
+// acceptedVersion lê a versão de Accept: application/vnd.rinha.v<N>+json
+func acceptedVersion(accept string) (int, bool) {
+	start := strings.Index(accept, "application/vnd.rinha.v")
+	if start < 0 {
+		return 0, false
+	}
+	rest := accept[start+len("application/vnd.rinha.v"):]
+	end := strings.Index(rest, "+json")
+	if end < 0 {
+		return 0, false
+	}
+	version, err := strconv.Atoi(rest[:end])
+	return version, err == nil
+}
+
+func supportedVersion(version int) bool {
+	for _, supported := range apiVersions {
+		if version == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// apiVersion é a versão da API usada para responder a requisição
+func apiVersion(c fiber.Ctx) int {
+	if _, version := versionPrefix(c.Path()); version > 0 {
+		return version
+	}
+	if version, ok := acceptedVersion(c.Get(fiber.HeaderAccept)); ok {
+		return version
+	}
+	return legacyAPIVersion
+}
+
+// versionedPath prefixa path com a versão do caminho da requisição, para
+// cabeçalhos Location e links que apontam para outras rotas da API
+func versionedPath(c fiber.Ctx, path string) string {
+	prefix, _ := versionPrefix(c.Path())
+	return prefix + path
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// warmUpPools abre as DB_MIN_CONNS conexões de cada pool antes de a API
+// começar a atender e prepara nelas as consultas do caminho quente, para que
+// os primeiros segundos do teste de carga não paguem o handshake com o
+// Postgres nem o parse das consultas.
+func warmUpPools(ctx context.Context) {
+	start := time.Now()
+	for _, ctx := range tenantContexts(ctx) {
+		if err := warmUpPool(ctx, poolFor(ctx)); err != nil {
+			log.Printf("Error warming up pool %q: %v", tenantKeyPrefix(ctx), err)
+		}
+	}
+	log.Printf("Database pools warmed up in %s", time.Since(start).Round(time.Millisecond))
+}
+
+func warmUpPool(ctx context.Context, pool *pgxpool.Pool) error {
+	conns := make([]*pgxpool.Conn, 0, pool.Config().MinConns)
+	defer func() {
+		for _, conn := range conns {
+			conn.Release()
+		}
+	}()
+	// as conexões ficam presas até o fim, então cada Acquire abre uma nova
+	for len(conns) < cap(conns) {
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+	}
+	// sem prepared statements não há o que preparar, e atrás do pgbouncer
+	// as consultas não ficariam na conexão do servidor
+	if simpleProtocol {
+		return nil
+	}
+	for _, conn := range conns {
+		if err := primeStatements(ctx, conn.Conn()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// primeStatements executa as leituras do extrato e uma escrita dentro de uma
+// transação desfeita. Com o modo padrão do pgx (QueryExecModeCacheStatement)
+// cada consulta fica preparada no cache da conexão, que sobrevive ao rollback.
+func primeStatements(ctx context.Context, conn *pgx.Conn) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	const clientId = 1
+	if _, err := getBalance(ctx, tx, clientId); err != nil {
+		return err
+	}
+	if _, err := listTransactions(ctx, tx, clientId, TransactionFilter{}); err != nil {
+		return err
+	}
+	if _, err := categoryTotals(ctx, tx, clientId, ""); err != nil {
+		return err
+	}
+	_, err = createTransaction(ctx, tx, clientId, &TransacaoRequest{Valor: 1, Tipo: "c", Descricao: "warmup"})
+	return err
+}
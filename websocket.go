@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v3"
+	"github.com/valyala/fasthttp"
+)
+
+// API WebSocket (GET /ws) para clientes interativos. Cada mensagem é um
+// objeto JSON com acao:
+//
+//	{"acao":"assinar","cliente_id":1}     saldo atual e a cada transação
+//	{"acao":"cancelar","cliente_id":1}    encerra a assinatura
+//	{"acao":"saldo","cliente_id":1}       saldo atual, uma vez
+//	{"acao":"transacao","id":"a1","cliente_id":1,"transacao":{...}}
+//
+// A transação tem o corpo de POST /transacoes e passa pelas mesmas
+// validações e pelo mesmo submitTransaction. As respostas trazem o id da
+// mensagem; as recusas vêm em erro, com o problema que o REST devolveria e
+// as mensagens no idioma do Accept-Language da conexão. Como no SSE, as
+// atualizações de saldo são as das transações feitas nesta instância, e
+// cada uma traz o saldo completo.
+
+const (
+	wsMaxSubscriptions = 10
+	wsReadLimit        = 64 << 10
+	wsWriteTimeout     = 5 * time.Second
+	wsPingInterval     = 30 * time.Second
+	// wsPongWait é quanto a conexão pode ficar sem mensagens nem pongs
+	wsPongWait = 2 * wsPingInterval
+)
+
+var wsUpgrader = websocket.FastHTTPUpgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// o /ws não usa cookies, então não há sessão a proteger de outras origens
+	CheckOrigin: func(*fasthttp.RequestCtx) bool { return true },
+}
+
+// MensagemWS é uma mensagem do cliente no /ws
+type MensagemWS struct {
+	Acao      string            `json:"acao"`
+	ID        string            `json:"id,omitempty"`
+	ClienteID int               `json:"cliente_id"`
+	Transacao *TransacaoRequest `json:"transacao,omitempty"`
+}
+
+// EventoWS é uma mensagem do servidor no /ws: saldo, transacao, cancelado
+// ou erro
+type EventoWS struct {
+	Evento      string   `json:"evento"`
+	ID          string   `json:"id,omitempty"`
+	ClienteID   int      `json:"cliente_id,omitempty"`
+	TransacaoID int64    `json:"transacao_id,omitempty"`
+	Saldo       *Balance `json:"saldo,omitempty"`
+	// Pendente marca a transação aceita no diário local (202 no REST)
+	Pendente bool     `json:"pendente,omitempty"`
+	Erro     *Problem `json:"erro,omitempty"`
+}
+
+// balanceHub entrega aos assinantes do /ws os saldos deixados pelas
+// transações da instância
+type balanceHub struct {
+	eventHub
+	// subscriptions evita o lock e a alocação do evento quando não há
+	// assinantes, o caso do teste
+	subscriptions atomic.Int64
+}
+
+var balanceUpdates = &balanceHub{eventHub: eventHub{subscribers: make(map[eventTopic]map[chan Evento]struct{})}}
+
+func (h *balanceHub) Subscribe(tenant string, clientId int) (<-chan Evento, func()) {
+	h.subscriptions.Add(1)
+	ch, unsubscribe := h.eventHub.Subscribe(tenant, clientId)
+	return ch, func() {
+		unsubscribe()
+		h.subscriptions.Add(-1)
+	}
+}
+
+func (h *balanceHub) Notify(ctx context.Context, clientId int, balance Balance) {
+	if h.subscriptions.Load() == 0 {
+		return
+	}
+	h.broadcast(Evento{Tipo: "saldo", Tenant: tenantFrom(ctx), ClienteID: clientId, Dados: balance, CriadoEm: nowFor(ctx)})
+}
+
+func handleWebSocket(c fiber.Ctx) error {
+	if !websocket.FastHTTPIsWebSocketUpgrade(c.Context()) {
+		return c.SendStatus(fiber.StatusUpgradeRequired)
+	}
+	session := &wsSession{
+		ctx:           c.UserContext(),
+		locale:        localeFrom(c),
+		out:           make(chan EventoWS, 64),
+		subscriptions: make(map[int]func()),
+	}
+	return wsUpgrader.Upgrade(c.Context(), func(conn *websocket.Conn) {
+		session.conn = conn
+		session.run()
+	})
+}
+
+// wsSession é uma conexão do /ws. Só a goroutine de escrita escreve na
+// conexão; as demais enviam por out.
+type wsSession struct {
+	ctx    context.Context
+	conn   *websocket.Conn
+	locale string
+	out    chan EventoWS
+	// subscriptions é usado só pela goroutine de leitura
+	subscriptions map[int]func()
+}
+
+func (s *wsSession) run() {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	defer func() {
+		for _, unsubscribe := range s.subscriptions {
+			unsubscribe()
+		}
+	}()
+	go s.writeLoop(ctx, cancel)
+
+	s.conn.SetReadLimit(wsReadLimit)
+	s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) && ctx.Err() == nil {
+				log.Print("Error reading WebSocket message: ", err)
+			}
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var message MensagemWS
+		if err := jsonUnmarshal(data, &message); err != nil {
+			s.sendError(ctx, "", decodeProblem(err))
+			continue
+		}
+		s.handle(ctx, message)
+	}
+}
+
+// decodeProblem é o problema de uma mensagem que não pôde ser decodificada;
+// os erros de valor são os de POST /transacoes
+func decodeProblem(err error) Problem {
+	switch {
+	case errors.Is(err, ErrValorOverflow):
+		return Problem{Status: fiber.StatusUnprocessableEntity, Codigo: "VALOR_ACIMA_DO_MAXIMO", Detail: err.Error()}
+	case errors.Is(err, ErrValorFracionario):
+		return Problem{Status: fiber.StatusUnprocessableEntity, Codigo: "VALOR_FRACIONARIO", Detail: err.Error()}
+	}
+	return Problem{
+		Status: fiber.StatusBadRequest,
+		Codigo: "JSON_INVALIDO",
+		Detail: "a mensagem não é um JSON válido: " + err.Error(),
+	}
+}
+
+// writeLoop envia as mensagens de out e os pings; um erro de escrita encerra
+// a sessão
+func (s *wsSession) writeLoop(ctx context.Context, cancel context.CancelFunc) {
+	defer s.conn.Close()
+	defer cancel()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteTimeout))
+			return
+		case event := <-s.out:
+			s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			data, err := jsonMarshal(event)
+			if err != nil {
+				log.Print("Error encoding WebSocket message: ", err)
+				continue
+			}
+			if err := s.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *wsSession) send(ctx context.Context, event EventoWS) {
+	select {
+	case s.out <- event:
+	case <-ctx.Done():
+	}
+}
+
+func (s *wsSession) sendError(ctx context.Context, id string, problem Problem) {
+	problem.complete("/ws", s.locale)
+	s.send(ctx, EventoWS{Evento: "erro", ID: id, Erro: &problem})
+}
+
+func (s *wsSession) handle(ctx context.Context, message MensagemWS) {
+	var handler func(context.Context, MensagemWS)
+	switch message.Acao {
+	case "assinar":
+		handler = s.subscribe
+	case "cancelar":
+		handler = s.unsubscribe
+	case "saldo":
+		handler = s.sendBalance
+	case "transacao":
+		handler = s.submit
+	default:
+		s.sendError(ctx, message.ID, Problem{
+			Status: fiber.StatusBadRequest,
+			Codigo: "ACAO_INVALIDA",
+			Detail: "acao deve ser assinar, cancelar, saldo ou transacao",
+		})
+		return
+	}
+	if clientExists(ctx, message.ClienteID) != nil {
+		s.sendError(ctx, message.ID, Problem{Status: fiber.StatusNotFound, Detail: errClienteNaoExiste.Error()})
+		return
+	}
+	handler(ctx, message)
+}
+
+func (s *wsSession) subscribe(ctx context.Context, message MensagemWS) {
+	if _, ok := s.subscriptions[message.ClienteID]; !ok {
+		if len(s.subscriptions) >= wsMaxSubscriptions {
+			s.sendError(ctx, message.ID, Problem{
+				Status: fiber.StatusTooManyRequests,
+				Codigo: "ASSINATURAS_EXCEDIDAS",
+				Detail: "a conexão já assina o máximo de clientes",
+			})
+			return
+		}
+		ch, unsubscribe := balanceUpdates.Subscribe(tenantFrom(ctx), message.ClienteID)
+		subscriptionCtx, cancel := context.WithCancel(ctx)
+		s.subscriptions[message.ClienteID] = func() {
+			cancel()
+			unsubscribe()
+		}
+		go func() {
+			for {
+				select {
+				case <-subscriptionCtx.Done():
+					return
+				case event := <-ch:
+					balance := event.Dados.(Balance)
+					s.send(subscriptionCtx, EventoWS{Evento: "saldo", ClienteID: event.ClienteID, Saldo: &balance})
+				}
+			}
+		}()
+	}
+	s.sendBalance(ctx, message)
+}
+
+func (s *wsSession) unsubscribe(ctx context.Context, message MensagemWS) {
+	if unsubscribe, ok := s.subscriptions[message.ClienteID]; ok {
+		unsubscribe()
+		delete(s.subscriptions, message.ClienteID)
+	}
+	s.send(ctx, EventoWS{Evento: "cancelado", ID: message.ID, ClienteID: message.ClienteID})
+}
+
+func (s *wsSession) sendBalance(ctx context.Context, message MensagemWS) {
+	balance, err := storageFor(ctx).GetBalance(ctx, message.ClienteID)
+	if err != nil {
+		s.sendError(ctx, message.ID, Problem{Status: fiber.StatusInternalServerError})
+		return
+	}
+	s.send(ctx, EventoWS{Evento: "saldo", ID: message.ID, ClienteID: message.ClienteID, Saldo: &balance})
+}
+
+func (s *wsSession) submit(ctx context.Context, message MensagemWS) {
+	transaction := message.Transacao
+	if transaction == nil {
+		s.sendError(ctx, message.ID, Problem{
+			Status: fiber.StatusUnprocessableEntity,
+			Codigo: "REQUISICAO_INVALIDA",
+			Detail: "transacao é obrigatório",
+		})
+		return
+	}
+	if err := structValidatorInstance.ValidateStruct(transaction); err != nil {
+		var validationErrors validator.ValidationErrors
+		if errors.As(err, &validationErrors) {
+			s.sendError(ctx, message.ID, fieldErrorsProblem(translateFieldErrors(validationErrors)))
+			return
+		}
+		s.sendError(ctx, message.ID, Problem{Status: fiber.StatusUnprocessableEntity, Codigo: "REQUISICAO_INVALIDA", Detail: err.Error()})
+		return
+	}
+
+	balance, err := submitTransaction(ctx, message.ClienteID, transaction)
+	if err != nil {
+		problem, ok := transactionProblem(err)
+		if !ok {
+			problem = Problem{Status: fiber.StatusUnprocessableEntity}
+		}
+		s.sendError(ctx, message.ID, problem)
+		return
+	}
+	s.send(ctx, EventoWS{
+		Evento:      "transacao",
+		ID:          message.ID,
+		ClienteID:   message.ClienteID,
+		TransacaoID: transaction.ID,
+		Saldo:       &balance,
+		Pendente:    transaction.Pendente,
+	})
+}
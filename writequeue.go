@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"math/rand/v2"
+	"time"
+)
+
+// Fila de escritas (ESCRITAS_WORKERS > 0): as transações passam por uma fila
+// limitada consumida por um número fixo de workers, em vez de disputarem
+// direto as conexões do pool. Com a fila cheia, a escrita é recusada na hora
+// com 503 (SOBRECARGA); acima de ESCRITAS_FILA_ALERTA da capacidade, cada
+// escrita espera antes de entrar na fila um atraso aleatório que cresce até
+// ESCRITAS_ATRASO_MAX perto do limite, espaçando as rajadas. Os workers não
+// devem passar do tamanho do pool do Postgres, ou voltam a esperar por
+// conexão. A profundidade e os contadores ficam em write_queue no
+// /debug/vars.
+//
+//   - ESCRITAS_WORKERS: workers; zero (o padrão) desliga a fila
+//   - ESCRITAS_FILA: capacidade da fila, 4 por worker por padrão
+//   - ESCRITAS_FILA_ALERTA: fração da capacidade a partir da qual há atraso
+//   - ESCRITAS_ATRASO_MAX: maior atraso, com a fila quase cheia
+
+var ErrFilaEscritasCheia = errors.New("fila de escritas cheia, tente novamente")
+
+var writeQueueStats = expvar.NewMap("write_queue")
+
+var writes = newWriteQueue(
+	getEnvInt("ESCRITAS_WORKERS", 0),
+	getEnvInt("ESCRITAS_FILA", 4*getEnvInt("ESCRITAS_WORKERS", 0)),
+	getEnvFloat("ESCRITAS_FILA_ALERTA", 0.8),
+	getEnvDuration("ESCRITAS_ATRASO_MAX", 10*time.Millisecond),
+)
+
+type writeQueue struct {
+	jobs chan writeJob
+	// alertAt é a profundidade a partir da qual as escritas são atrasadas
+	alertAt  int
+	maxDelay time.Duration
+}
+
+type writeJob struct {
+	ctx      context.Context
+	run      func(context.Context) (Balance, error)
+	queuedAt time.Time
+	done     chan writeResult
+}
+
+type writeResult struct {
+	balance Balance
+	err     error
+}
+
+// newWriteQueue inicia os workers; sem workers, devolve nil e as escritas
+// rodam na goroutine da requisição
+func newWriteQueue(workers, size int, alert float64, maxDelay time.Duration) *writeQueue {
+	if workers <= 0 {
+		return nil
+	}
+	size = max(size, 1)
+	q := &writeQueue{
+		jobs:     make(chan writeJob, size),
+		alertAt:  min(max(int(alert*float64(size)), 0), size),
+		maxDelay: maxDelay,
+	}
+	writeQueueStats.Set("profundidade", expvar.Func(func() any { return len(q.jobs) }))
+	writeQueueStats.Set("capacidade", expvar.Func(func() any { return size }))
+	writeQueueStats.Set("workers", expvar.Func(func() any { return workers }))
+	for range workers {
+		go q.work()
+	}
+	return q
+}
+
+// Do executa run num worker e espera o resultado. A fila cheia devolve
+// ErrFilaEscritasCheia sem esperar; uma escrita que já entrou na fila é
+// sempre esperada, para que o chamador não veja a transação pela metade.
+func (q *writeQueue) Do(ctx context.Context, run func(context.Context) (Balance, error)) (Balance, error) {
+	if q == nil {
+		return run(ctx)
+	}
+
+	if delay := q.delay(len(q.jobs)); delay > 0 {
+		writeQueueStats.Add("atrasadas", 1)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return Balance{}, ctx.Err()
+		}
+	}
+
+	job := writeJob{ctx: ctx, run: run, queuedAt: time.Now(), done: make(chan writeResult, 1)}
+	select {
+	case q.jobs <- job:
+	default:
+		writeQueueStats.Add("recusadas", 1)
+		return Balance{}, ErrFilaEscritasCheia
+	}
+	result := <-job.done
+	return result.balance, result.err
+}
+
+// delay é o atraso de uma escrita com a fila em depth: zero abaixo do
+// alerta e, acima dele, aleatório até uma fração de maxDelay proporcional
+// ao quanto a fila passou do alerta
+func (q *writeQueue) delay(depth int) time.Duration {
+	if depth < q.alertAt || q.maxDelay <= 0 {
+		return 0
+	}
+	ceiling := q.maxDelay * time.Duration(depth-q.alertAt+1) / time.Duration(cap(q.jobs)-q.alertAt+1)
+	return rand.N(max(ceiling, 1)) + 1
+}
+
+func (q *writeQueue) work() {
+	for job := range q.jobs {
+		writeQueueStats.Add("espera_us", time.Since(job.queuedAt).Microseconds())
+		// quem desistiu enquanto esperava na fila não ocupa uma conexão
+		if err := job.ctx.Err(); err != nil {
+			job.done <- writeResult{err: err}
+			continue
+		}
+		balance, err := job.run(job.ctx)
+		writeQueueStats.Add("executadas", 1)
+		job.done <- writeResult{balance: balance, err: err}
+	}
+}